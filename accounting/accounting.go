@@ -0,0 +1,432 @@
+// Package accounting records gas delegator pays for TXs by identity, associated address, API key and method,
+// and enforces quotas of TXs and wei before delegated writes are sent
+package accounting
+
+import (
+	encodingJson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+// For environment arguments
+const (
+	// AccountingStore is a location of store for usage, refer to store.Open
+	AccountingStore = "ACCOUNTING_STORE"
+	// QuotaFile is a path of JSON quotas, no quota is enforced if empty
+	QuotaFile = "QUOTA_FILE"
+)
+
+// For accounting store
+const (
+	// DefaultAccountingStorePath is a LevelDB path for usage
+	DefaultAccountingStorePath = "accounting"
+	// DefaultAccountingStoreTable is a DynamoDB table for usage used in AWS lambda
+	DefaultAccountingStoreTable = "Accounting"
+)
+
+// Scopes usage is recorded by
+const (
+	ScopeEIN     = "ein"
+	ScopeMetaID  = "meta_id"
+	ScopeAddress = "address"
+	ScopeAPIKey  = "api_key"
+)
+
+// Scopes are every scope in order
+var Scopes = []string{ScopeEIN, ScopeMetaID, ScopeAddress, ScopeAPIKey}
+
+// CodeQuota is the error code of a quota exceeded
+const CodeQuota int32 = -32632
+
+// addressParams are members of params naming the associated address of a request, the first given is used
+var addressParams = []string{"associated_address", "approving_address", "address_to_remove", "new_associated_address", "from"}
+
+// Quota limits TXs and wei used in a UTC day and month, 0 or empty for no limit
+type Quota struct {
+	DailyTxs   uint64 `json:"daily_txs,omitempty"`
+	MonthlyTxs uint64 `json:"monthly_txs,omitempty"`
+	DailyWei   string `json:"daily_wei,omitempty"`
+	MonthlyWei string `json:"monthly_wei,omitempty"`
+}
+
+// limit is a quota of a period
+type limit struct {
+	period string
+	txs    uint64
+	wei    *big.Int
+}
+
+// limits returns limits of the day and the month of now
+func (q *Quota) limits(now time.Time) []limit {
+	dailyWei, _ := new(big.Int).SetString(q.DailyWei, 10)
+	monthlyWei, _ := new(big.Int).SetString(q.MonthlyWei, 10)
+	return []limit{
+		{day(now), q.DailyTxs, dailyWei},
+		{month(now), q.MonthlyTxs, monthlyWei},
+	}
+}
+
+// Quotas are quotas of each scope, APIKeys override APIKey for the keys
+type Quotas struct {
+	EIN     *Quota            `json:"ein,omitempty"`
+	MetaID  *Quota            `json:"meta_id,omitempty"`
+	Address *Quota            `json:"address,omitempty"`
+	APIKey  *Quota            `json:"api_key,omitempty"`
+	APIKeys map[string]*Quota `json:"api_keys,omitempty"`
+}
+
+// ParseQuotas returns quotas of the JSON, failing for a malformed wei
+func ParseQuotas(b []byte) (*Quotas, error) {
+	q := new(Quotas)
+	if err := encodingJson.Unmarshal(b, q); err != nil {
+		return nil, err
+	}
+	quotas := []*Quota{q.EIN, q.MetaID, q.Address, q.APIKey}
+	for _, quota := range q.APIKeys {
+		quotas = append(quotas, quota)
+	}
+	for _, quota := range quotas {
+		if quota == nil {
+			continue
+		}
+		for _, wei := range []string{quota.DailyWei, quota.MonthlyWei} {
+			if v, ok := new(big.Int).SetString(wei, 10); wei != "" && (!ok || v.Sign() < 0) {
+				return nil, fmt.Errorf("accounting: invalid wei %q", wei)
+			}
+		}
+	}
+	return q, nil
+}
+
+// of returns the quota of the scope for the ID, nil if none
+func (q *Quotas) of(scope, id string) *Quota {
+	switch scope {
+	case ScopeEIN:
+		return q.EIN
+	case ScopeMetaID:
+		return q.MetaID
+	case ScopeAddress:
+		return q.Address
+	case ScopeAPIKey:
+		if quota, ok := q.APIKeys[id]; ok {
+			return quota
+		}
+		return q.APIKey
+	}
+	return nil
+}
+
+// QuotaError is a request over a quota
+type QuotaError struct {
+	Scope  string `json:"scope"`
+	ID     string `json:"id"`
+	Period string `json:"period"`
+	// Unit is txs or wei
+	Unit  string `json:"unit"`
+	Limit string `json:"limit"`
+	Used  string `json:"used"`
+}
+
+// ErrorCode returns the code of the quota exceeded
+func (e *QuotaError) ErrorCode() int32 { return CodeQuota }
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("Quota of %s %s is exceeded, %s of %s %s used in %s", e.Scope, e.ID, e.Used, e.Limit, e.Unit, e.Period)
+}
+
+// ErrorData returns the quota exceeded
+func (e *QuotaError) ErrorData() interface{} { return e }
+
+// Labels are who a TX is sent for
+type Labels struct {
+	EIN     string `json:"ein,omitempty"`
+	MetaID  string `json:"meta_id,omitempty"`
+	Address string `json:"address,omitempty"`
+	APIKey  string `json:"api_key,omitempty"`
+}
+
+// ID returns the label of the scope
+func (l *Labels) ID(scope string) string {
+	switch scope {
+	case ScopeEIN:
+		return l.EIN
+	case ScopeMetaID:
+		return l.MetaID
+	case ScopeAddress:
+		return l.Address
+	case ScopeAPIKey:
+		return l.APIKey
+	}
+	return ""
+}
+
+// LabelsOf returns labels of the request from its API key and named params
+func LabelsOf(req json.RPCRequest) *Labels {
+	labels := &Labels{APIKey: req.APIKey}
+	if len(req.Params) != 1 {
+		return labels
+	}
+	obj, ok := req.Params[0].(map[string]interface{})
+	if !ok {
+		return labels
+	}
+	if ein, ok := new(big.Int).SetString(fmt.Sprint(obj["ein"]), 0); ok && ein.Sign() > 0 {
+		labels.EIN = ein.String()
+	}
+	labels.MetaID = addressOf(obj["meta_id"])
+	for _, param := range addressParams {
+		if labels.Address = addressOf(obj[param]); labels.Address != "" {
+			break
+		}
+	}
+	return labels
+}
+
+// addressOf returns the address of a param in lower case, empty if not an address
+func addressOf(v interface{}) string {
+	s, ok := v.(string)
+	if !ok || !common.IsHexAddress(s) {
+		return ""
+	}
+	return strings.ToLower(common.HexToAddress(s).Hex())
+}
+
+var (
+	// requests are metered requests being handled, by request ID
+	requests sync.Map
+	// methods are metered methods
+	methods = make(map[string]bool)
+	// einResolver finds EIN of the associated address of a request
+	einResolver EINResolver
+)
+
+// request is a metered request being handled
+type request struct {
+	labels *Labels
+	// reserved are keys of TXs reserved against quotas and not sent yet, in order of TXs sent
+	reserved []string
+}
+
+// EINResolver returns EIN of the associated address, empty if unknown
+type EINResolver func(reqID uint64, address string) string
+
+// RegisterMethods sets methods sending TXs to be labeled and checked against quotas
+// It should be called in init of the package serving the methods
+func RegisterMethods(names ...string) {
+	for _, name := range names {
+		methods[name] = true
+	}
+}
+
+// RegisterEINResolver sets the resolver of EIN for requests giving an associated address only
+// It should be called in init of the package serving identities
+func RegisterEINResolver(fn EINResolver) {
+	einResolver = fn
+}
+
+// label labels the request of a metered method, nil if not metered
+func label(reqID uint64, req json.RPCRequest) *request {
+	if !methods[req.Method] {
+		return nil
+	}
+	r := &request{labels: LabelsOf(req)}
+	requests.Store(reqID, r)
+	return r
+}
+
+// Begin labels the request of a metered method, and returns QuotaError if a quota of its labels is exceeded.
+// Its TX is reserved against quotas until it is final, or until End if no TX is sent.
+// End should be called when the request is handled
+func Begin(reqID uint64, req json.RPCRequest) error {
+	r := label(reqID, req)
+	if r == nil {
+		return nil
+	}
+	l := GetInstance()
+	if l == nil {
+		return nil
+	} else if l.quotaErr != nil {
+		return l.quotaErr
+	} else if l.quotas == nil {
+		return nil
+	}
+	labels := r.labels
+	if labels.EIN == "" && labels.Address != "" && l.quotas.EIN != nil && einResolver != nil {
+		labels.EIN = einResolver(reqID, labels.Address)
+	}
+	if err := l.Check(labels); err != nil {
+		return err
+	}
+	key := requestReservation(reqID)
+	if err := l.reserve(key, labels); err != nil {
+		return err
+	}
+	r.reserved = []string{key}
+	return nil
+}
+
+// Reserve checks quotas for more TXs the request sends after the one reserved by Begin, and reserves them
+// until they are sent or End. It returns QuotaError if a quota is exceeded, TXs reserved before are kept
+func Reserve(reqID uint64, txs int) error {
+	v, ok := requests.Load(reqID)
+	if !ok {
+		return nil
+	}
+	r := v.(*request)
+	l := GetInstance()
+	if l == nil || l.quotas == nil {
+		return nil
+	}
+	for i := 0; i < txs; i++ {
+		if err := l.Check(r.labels); err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s/%d", requestReservation(reqID), len(r.reserved))
+		if err := l.reserve(key, r.labels); err != nil {
+			return err
+		}
+		r.reserved = append(r.reserved, key)
+	}
+	return nil
+}
+
+// Hold keeps the TX reserved by the request for the job queued instead of sending it,
+// so that it counts against quotas until the job runs. Refer to Resume
+func Hold(reqID uint64, jobID string) {
+	v, ok := requests.Load(reqID)
+	if !ok {
+		return
+	}
+	r := v.(*request)
+	if l := GetInstance(); l != nil && len(r.reserved) > 0 {
+		if err := l.move(r.reserved[0], jobReservation(jobID)); err != nil {
+			log.Warnf("Failed to hold the TX reserved for job %s: %v", jobID, err)
+			return
+		}
+		r.reserved = r.reserved[1:]
+	}
+}
+
+// Resume labels the request running the job without checking quotas, with the TX held for the job.
+// End should be called when the request is handled
+func Resume(reqID uint64, req json.RPCRequest, jobID string) *Labels {
+	r := label(reqID, req)
+	if r == nil {
+		return nil
+	}
+	if l := GetInstance(); l != nil {
+		if res := l.reservation(jobReservation(jobID)); res != nil {
+			*r.labels = res.Labels
+			r.reserved = []string{jobReservation(jobID)}
+		}
+	}
+	return r.labels
+}
+
+// End drops labels of the request, and releases its TXs reserved if not sent
+func End(reqID uint64) {
+	v, ok := requests.Load(reqID)
+	if !ok {
+		return
+	}
+	requests.Delete(reqID)
+	if r := v.(*request); len(r.reserved) > 0 {
+		if l := GetInstance(); l != nil {
+			for _, key := range r.reserved {
+				l.release(key)
+			}
+		}
+	}
+}
+
+// Ledger keeps usage in a store
+//
+//	tx/<hash>                          labels of a TX sent, until it is final
+//	usage/<period>/<scope>/<id>/<method>  usage in a UTC day or month
+//	entry/<day>/<hash>                 TX final
+//	reserved/<request or job>/<id>     labels of a TX reserved by a request or a job, until it is sent
+//	pending/<scope>/<id>               TXs reserved or sent but not final, and the most wei they can use
+type Ledger struct {
+	store  store.Store
+	quotas *Quotas
+	// quotaErr is the error of the quota file, failing metered requests
+	quotaErr error
+
+	// mu guards pruned
+	mu     sync.Mutex
+	pruned string
+}
+
+var (
+	// For singleton
+	instance *Ledger
+	once     sync.Once
+)
+
+func init() {
+	tracker.OnTrack(func(rec *tracker.Record) {
+		if r, ok := requests.Load(rec.RequestID); ok {
+			if l := GetInstance(); l != nil {
+				l.track(rec, r.(*request))
+			}
+		}
+	})
+	tracker.OnFinal(func(rec *tracker.Record) {
+		if l := GetInstance(); l != nil {
+			l.settle(rec)
+		}
+	})
+}
+
+// GetInstance returns the ledger with the store given by ACCOUNTING_STORE,
+// or DynamoDB in AWS lambda and LevelDB otherwise, and quotas of QUOTA_FILE
+// It returns nil if the store is not available
+func GetInstance() *Ledger {
+	once.Do(func() {
+		location := os.Getenv(AccountingStore)
+		if location == "" {
+			if os.Getenv(crypto.IsAwsLambda) != "" {
+				location = store.DynamoDBScheme + DefaultAccountingStoreTable
+			} else {
+				location = DefaultAccountingStorePath
+			}
+		}
+		s, err := store.Open(location)
+		if err != nil {
+			log.Error("Failed to open accounting store, usage is not recorded: ", err)
+			return
+		}
+		log.Info("Accounting store is set to ", location)
+		instance = NewLedger(s, nil)
+		if path := os.Getenv(QuotaFile); path != "" {
+			b, err := ioutil.ReadFile(path)
+			if err == nil {
+				instance.quotas, err = ParseQuotas(b)
+			}
+			if err != nil {
+				log.Error("Failed to load quotas, metered methods are rejected: ", err)
+				instance.quotaErr = err
+				return
+			}
+			log.Info("Quotas are loaded from ", path)
+		}
+	})
+	return instance
+}
+
+// NewLedger returns the ledger keeping usage in the store, quotas are optional
+func NewLedger(s store.Store, quotas *Quotas) *Ledger {
+	return &Ledger{store: s, quotas: quotas}
+}
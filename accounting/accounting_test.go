@@ -0,0 +1,222 @@
+package accounting
+
+import (
+	"bytes"
+	encodingJson "encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+const (
+	address = "0x1111111111111111111111111111111111111111"
+	metaID  = "0x2222222222222222222222222222222222222222"
+)
+
+// decodeParams returns params of the JSON as decoded from a request
+func decodeParams(t *testing.T, params string) []interface{} {
+	var obj map[string]interface{}
+	d := encodingJson.NewDecoder(strings.NewReader(params))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil {
+		t.Fatal(err)
+	}
+	return []interface{}{obj}
+}
+
+func TestLabelsOf(t *testing.T) {
+	for _, c := range []struct {
+		params string
+		labels Labels
+	}{
+		{`{"ein": 12, "associated_address": "` + strings.ToUpper(address[2:]) + `"}`, Labels{EIN: "12", Address: address, APIKey: "key"}},
+		{`{"ein": "0x0c", "approving_address": "` + address + `", "from": "` + metaID + `"}`, Labels{EIN: "12", Address: address, APIKey: "key"}},
+		{`{"meta_id": "` + metaID + `", "from": "` + address + `"}`, Labels{MetaID: metaID, Address: address, APIKey: "key"}},
+		{`{"ein": 0, "meta_id": "0x1234"}`, Labels{APIKey: "key"}},
+	} {
+		req := json.RPCRequest{Method: "create_identity", Params: decodeParams(t, c.params), APIKey: "key"}
+		if labels := LabelsOf(req); *labels != c.labels {
+			t.Errorf("LabelsOf(%s) = %+v, want %+v", c.params, *labels, c.labels)
+		}
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	q, err := ParseQuotas([]byte(`{"api_key": {"daily_txs": 1}, "api_keys": {"partner": {"monthly_wei": "100"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota := q.of(ScopeAPIKey, "partner"); quota == nil || quota.MonthlyWei != "100" {
+		t.Errorf("Quota of partner = %+v", quota)
+	}
+	if quota := q.of(ScopeAPIKey, "other"); quota == nil || quota.DailyTxs != 1 {
+		t.Errorf("Quota of other key = %+v", quota)
+	}
+	if q.of(ScopeEIN, "1") != nil {
+		t.Error("EIN has a quota not given")
+	}
+	for _, config := range []string{`{"ein": {"daily_wei": "1e18"}}`, `{"api_keys": {"a": {"monthly_wei": "-1"}}}`} {
+		if _, err := ParseQuotas([]byte(config)); err == nil {
+			t.Errorf("ParseQuotas(%s) succeeds, want error", config)
+		}
+	}
+}
+
+func TestLedger(t *testing.T) {
+	quotas, _ := ParseQuotas([]byte(`{"ein": {"daily_txs": 2}, "api_key": {"monthly_wei": "1000"}}`))
+	l := NewLedger(store.NewMemoryStore(), quotas)
+	labels := &Labels{Address: address, APIKey: "key"}
+
+	// Labels are kept while the TX is pending, EIN found by the decoder is added
+	sent := &tracker.Record{Hash: "0xAA", Method: "create_identity", GasPrice: "2", SentAt: time.Now().Unix()}
+	l.track(sent, &request{labels: labels})
+	mined := *sent
+	mined.Status, mined.GasUsed, mined.EIN = tracker.StatusMined, 300, "12"
+	l.settle(&mined)
+
+	// Dropped TXs use nothing
+	l.track(&tracker.Record{Hash: "0xbb", Method: "add_key_delegated"}, &request{labels: labels})
+	l.settle(&tracker.Record{Hash: "0xbb", Method: "add_key_delegated", Status: tracker.StatusDropped})
+	if _, err := l.store.Get(txKey("0xbb")); err != store.ErrNotFound {
+		t.Error("Labels of the TX final are kept")
+	}
+
+	if err := l.Check(&Labels{EIN: "12", APIKey: "key"}); err != nil {
+		t.Fatal("Quota is exceeded by a TX: ", err)
+	}
+	reverted := &tracker.Record{Hash: "0xcc", Method: "add_key_delegated", EIN: "12", Status: tracker.StatusReverted, GasUsed: 200, GasPrice: "2"}
+	l.settle(reverted)
+
+	usages, err := l.Usage(day(time.Now()), ScopeEIN, "12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 2 || usages[0].Method != "add_key_delegated" || usages[0].Txs != 1 || usages[1].GasUsed != 300 || usages[1].Wei != "600" {
+		t.Errorf("Usage of EIN = %+v", usages)
+	}
+	if usages, _ := l.Usage(month(time.Now()), ScopeAddress, ""); len(usages) != 1 || usages[0].ID != address {
+		t.Errorf("Usage of addresses = %+v", usages)
+	}
+
+	err = l.Check(&Labels{EIN: "12"})
+	if qErr, ok := err.(*QuotaError); !ok || qErr.Scope != ScopeEIN || qErr.Unit != "txs" || qErr.Used != "2" {
+		t.Errorf("Check of EIN = %v, want daily txs exceeded", err)
+	}
+	if err := l.Check(&Labels{EIN: "13", APIKey: "key"}); err != nil {
+		t.Errorf("Check of API key = %v, want 600 of 1000 wei", err)
+	}
+	l.settle(&tracker.Record{Hash: "0xdd", Method: "create_identity", Status: tracker.StatusMined, GasUsed: 200, GasPrice: "2"})
+	l.track(&tracker.Record{Hash: "0xee", Method: "create_identity"}, &request{labels: labels})
+	l.settle(&tracker.Record{Hash: "0xee", Method: "create_identity", Status: tracker.StatusMined, GasUsed: 200, GasPrice: "2"})
+	if err, ok := l.Check(&Labels{APIKey: "key"}).(*QuotaError); !ok || err.Unit != "wei" || err.Used != "1000" {
+		t.Errorf("Check of API key = %v, want monthly wei exceeded", err)
+	}
+
+	var b bytes.Buffer
+	if err := l.ExportCSV(&b, month(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 5 || lines[0] != strings.Join(csvHeader, ",") {
+		t.Fatalf("CSV = %s", b.String())
+	}
+	if !strings.Contains(b.String(), ",0xAA,create_identity,mined,12,,"+address+",key,300,2,600") {
+		t.Errorf("CSV has no entry of the TX mined: %s", b.String())
+	}
+	if err := l.ExportCSV(&b, "2020-1"); err == nil {
+		t.Error("ExportCSV succeeds for a malformed period")
+	}
+}
+
+func TestPending(t *testing.T) {
+	quotas, _ := ParseQuotas([]byte(`{"api_key": {"daily_txs": 2, "daily_wei": "1000"}}`))
+	l := NewLedger(store.NewMemoryStore(), quotas)
+	labels := &Labels{APIKey: "key"}
+
+	// TX reserved by a request is sent, with the most wei it can use
+	if err := l.reserve(requestReservation(1), labels); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(labels); err != nil {
+		t.Fatal("Quota is exceeded by a TX reserved: ", err)
+	}
+	l.track(&tracker.Record{Hash: "0xaa", Method: "create_identity", Gas: 100, GasPrice: "2"}, &request{labels: labels, reserved: []string{requestReservation(1)}})
+	if txs, wei, _ := l.pending(ScopeAPIKey, "key"); txs != 1 || wei.String() != "200" {
+		t.Errorf("Pending of the TX sent = %d txs, %v wei", txs, wei)
+	}
+
+	// TX held for a job queued counts until the job runs
+	l.reserve(requestReservation(2), labels)
+	if err := l.move(requestReservation(2), jobReservation("job")); err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := l.Check(labels).(*QuotaError); !ok || err.Unit != "txs" || err.Used != "2" {
+		t.Errorf("Check with TXs pending = %v, want daily txs exceeded", err)
+	}
+	if r := l.reservation(jobReservation("job")); r == nil || r.APIKey != "key" {
+		t.Errorf("Reservation of the job = %+v", r)
+	}
+	l.release(jobReservation("job"))
+	if err := l.Check(labels); err != nil {
+		t.Errorf("Check after the job sent no TX = %v", err)
+	}
+
+	// TX final uses gas used instead
+	l.settle(&tracker.Record{Hash: "0xaa", Method: "create_identity", Status: tracker.StatusMined, GasUsed: 50, GasPrice: "2"})
+	if txs, wei, _ := l.pending(ScopeAPIKey, "key"); txs != 0 || wei.Sign() != 0 {
+		t.Errorf("Pending after the TX is final = %d txs, %v wei", txs, wei)
+	}
+
+	// TX in flight counts the most wei it can use
+	other := &Labels{APIKey: "other"}
+	l.reserve(requestReservation(3), other)
+	l.track(&tracker.Record{Hash: "0xbb", Method: "create_identity", Gas: 500, GasPrice: "2"}, &request{labels: other, reserved: []string{requestReservation(3)}})
+	if err, ok := l.Check(other).(*QuotaError); !ok || err.Unit != "wei" || err.Used != "1000" {
+		t.Errorf("Check with wei pending = %v, want daily wei exceeded", err)
+	}
+
+	// Reservations of requests never ended are released
+	l.reserve(requestReservation(4), labels)
+	l.pruneReserved(time.Now().Add(requestTimeout + time.Second))
+	if txs, _, _ := l.pending(ScopeAPIKey, "key"); txs != 0 {
+		t.Errorf("Pending after prune = %d txs", txs)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	quotas, _ := ParseQuotas([]byte(`{"api_key": {"daily_txs": 3}}`))
+	once.Do(func() {})
+	defer func(l *Ledger) { instance = l }(instance)
+	instance = NewLedger(store.NewMemoryStore(), quotas)
+	RegisterMethods("approve_batch")
+	req := json.RPCRequest{Method: "approve_batch", Params: decodeParams(t, `{}`), APIKey: "key"}
+
+	// Request sending TXs reserves each of them before sending
+	if err := Begin(1, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reserve(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := Reserve(1, 2).(*QuotaError); !ok || err.Unit != "txs" || err.Used != "3" {
+		t.Errorf("Reserve over quota = %v, want daily txs exceeded", err)
+	}
+	if txs, _, _ := instance.pending(ScopeAPIKey, "key"); txs != 3 {
+		t.Errorf("Pending of TXs reserved = %d txs", txs)
+	}
+
+	// TXs sent use up reservations in order, End releases the rest
+	v, _ := requests.Load(uint64(1))
+	instance.track(&tracker.Record{Hash: "0xaa", Method: "approve_batch", Gas: 100, GasPrice: "1"}, v.(*request))
+	if r := instance.reservation(requestReservation(1)); r != nil {
+		t.Error("Reservation of the first TX is kept after sent")
+	}
+	End(1)
+	if txs, _, _ := instance.pending(ScopeAPIKey, "key"); txs != 1 {
+		t.Errorf("Pending after End = %d txs, want the TX sent", txs)
+	}
+}
@@ -0,0 +1,171 @@
+package accounting
+
+import (
+	encodingJson "encoding/json"
+	"math/big"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+// TXs reserved by requests or jobs, and TXs sent but not final, count against quotas.
+// A TX counts as one and the most wei it can use, gas limit times gas price, until it is final
+
+// A TX reserved by a request is released after this long, if the request never ended
+var requestTimeout = time.Hour
+
+// reservation is labels of a TX reserved
+type reservation struct {
+	Labels
+	At int64 `json:"at"`
+}
+
+func requestReservation(reqID uint64) string {
+	return "request/" + strconv.FormatUint(reqID, 10)
+}
+
+func jobReservation(jobID string) string {
+	return "job/" + jobID
+}
+
+func reservedKey(key string) string {
+	return "reserved/" + key
+}
+
+func pendingKey(scope, id string) string {
+	return "pending/" + scope + "/" + url.PathEscape(id)
+}
+
+// reserve counts a TX of the labels as pending, kept under the key until it is sent or released
+func (l *Ledger) reserve(key string, labels *Labels) error {
+	b, _ := encodingJson.Marshal(&reservation{Labels: *labels, At: time.Now().Unix()})
+	if err := l.store.Put(reservedKey(key), b); err != nil {
+		return err
+	}
+	l.addPending(labels, 1, nil)
+	return nil
+}
+
+// reservation returns the TX reserved under the key, nil if none
+func (l *Ledger) reservation(key string) *reservation {
+	b, err := l.store.Get(reservedKey(key))
+	if err != nil {
+		return nil
+	}
+	r := new(reservation)
+	if encodingJson.Unmarshal(b, r) != nil {
+		return nil
+	}
+	return r
+}
+
+// move keeps the TX reserved under the key from, under the key to
+func (l *Ledger) move(from, to string) error {
+	b, err := l.store.Get(reservedKey(from))
+	if err != nil {
+		return err
+	}
+	if err := l.store.Put(reservedKey(to), b); err != nil {
+		return err
+	}
+	return l.store.Delete(reservedKey(from))
+}
+
+// release drops the TX reserved under the key, which is not sent
+func (l *Ledger) release(key string) {
+	if r := l.reservation(key); r != nil {
+		l.addPending(&r.Labels, -1, nil)
+	}
+	l.store.Delete(reservedKey(key))
+}
+
+// sent moves the TX reserved under the key to the TX sent, adding the most wei it can use
+func (l *Ledger) sent(key string, labels *Labels, wei *big.Int) {
+	l.addPending(labels, 0, wei)
+	l.store.Delete(reservedKey(key))
+}
+
+// addPending adds TXs and wei to pending usage of the labels, not below zero
+func (l *Ledger) addPending(labels *Labels, txs int64, wei *big.Int) {
+	for _, scope := range Scopes {
+		id := labels.ID(scope)
+		if id == "" {
+			continue
+		}
+		key := pendingKey(scope, id)
+		err := l.store.Update(key, func(old []byte) ([]byte, error) {
+			u := new(Usage)
+			if old != nil {
+				encodingJson.Unmarshal(old, u)
+			}
+			if n := int64(u.Txs) + txs; n > 0 {
+				u.Txs = uint64(n)
+			} else {
+				u.Txs = 0
+			}
+			total, ok := new(big.Int).SetString(u.Wei, 10)
+			if !ok {
+				total = new(big.Int)
+			}
+			if wei != nil {
+				total.Add(total, wei)
+			}
+			if total.Sign() < 0 || u.Txs == 0 {
+				total.SetInt64(0)
+			}
+			u.Wei = total.String()
+			return encodingJson.Marshal(u)
+		})
+		if err != nil {
+			log.Warnf("Failed to update pending usage %s: %v", key, err)
+		}
+	}
+}
+
+// pending returns TXs and wei pending of the ID in the scope
+func (l *Ledger) pending(scope, id string) (uint64, *big.Int, error) {
+	b, err := l.store.Get(pendingKey(scope, id))
+	if err == store.ErrNotFound {
+		return 0, new(big.Int), nil
+	} else if err != nil {
+		return 0, nil, err
+	}
+	u := new(Usage)
+	encodingJson.Unmarshal(b, u)
+	wei, ok := new(big.Int).SetString(u.Wei, 10)
+	if !ok {
+		wei = new(big.Int)
+	}
+	return u.Txs, wei, nil
+}
+
+// maxWei returns the most wei the TX can use
+func maxWei(rec *tracker.Record) *big.Int {
+	wei, ok := new(big.Int).SetString(rec.GasPrice, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return wei.Mul(wei, new(big.Int).SetUint64(rec.Gas))
+}
+
+// pruneReserved releases TXs reserved by requests never ended and by jobs never run
+func (l *Ledger) pruneReserved(now time.Time) {
+	var keys []string
+	for prefix, timeout := range map[string]time.Duration{"request/": requestTimeout, "job/": staleTimeout} {
+		staleAt := now.Add(-timeout).Unix()
+		l.store.Iterate(reservedKey(prefix), func(key string, value []byte) bool {
+			r := new(reservation)
+			if encodingJson.Unmarshal(value, r) != nil || r.At < staleAt {
+				keys = append(keys, key[len(reservedKey("")):])
+			}
+			return true
+		})
+	}
+	for _, key := range keys {
+		l.release(key)
+	}
+}
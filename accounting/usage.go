@@ -0,0 +1,353 @@
+package accounting
+
+import (
+	"encoding/csv"
+	encodingJson "encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+// Formats of periods in UTC
+const (
+	dayFormat   = "2006-01-02"
+	monthFormat = "2006-01"
+)
+
+var (
+	// Daily usage and entries are removed after this long, monthly usage is kept
+	retention = 400 * 24 * time.Hour
+	// Labels of a TX not final for this long are removed
+	staleTimeout = 7 * 24 * time.Hour
+)
+
+// tracked is labels of a TX sent, kept until it is final
+type tracked struct {
+	Labels
+	SentAt int64 `json:"sent_at"`
+	// Reserved is true if the TX counts as pending against quotas, with the most wei it can use
+	Reserved bool   `json:"reserved,omitempty"`
+	Wei      string `json:"wei,omitempty"`
+}
+
+// unreserve removes the TX from pending usage
+func (l *Ledger) unreserve(t *tracked) {
+	if !t.Reserved {
+		return
+	}
+	wei, ok := new(big.Int).SetString(t.Wei, 10)
+	if !ok {
+		wei = new(big.Int)
+	}
+	l.addPending(&t.Labels, -1, wei.Neg(wei))
+}
+
+// Usage is TXs, gas and wei of a method used by an ID of the scope in the period
+type Usage struct {
+	Period  string `json:"period"`
+	Scope   string `json:"scope"`
+	ID      string `json:"id"`
+	Method  string `json:"method"`
+	Txs     uint64 `json:"txs"`
+	GasUsed uint64 `json:"gas_used"`
+	Wei     string `json:"wei"`
+}
+
+// Entry is a TX mined or reverted, with gas delegator paid
+type Entry struct {
+	Hash   string `json:"hash"`
+	Method string `json:"method"`
+	Labels
+	Status   string `json:"status"`
+	GasUsed  uint64 `json:"gas_used"`
+	GasPrice string `json:"gas_price"`
+	Wei      string `json:"wei"`
+	SentAt   int64  `json:"sent_at"`
+	FinalAt  int64  `json:"final_at"`
+}
+
+func day(t time.Time) string {
+	return t.UTC().Format(dayFormat)
+}
+
+func month(t time.Time) string {
+	return t.UTC().Format(monthFormat)
+}
+
+// ValidPeriod returns true if the period is a UTC day (2006-01-02) or month (2006-01)
+func ValidPeriod(period string) bool {
+	if _, err := time.Parse(dayFormat, period); err == nil {
+		return true
+	}
+	_, err := time.Parse(monthFormat, period)
+	return err == nil
+}
+
+func txKey(hash string) string {
+	return "tx/" + strings.ToLower(hash)
+}
+
+// usagePrefix returns the prefix of usage keys, scope and id are optional
+func usagePrefix(period, scope, id string) string {
+	prefix := "usage/" + period + "/"
+	if scope != "" {
+		prefix += scope + "/"
+		if id != "" {
+			prefix += url.PathEscape(id) + "/"
+		}
+	}
+	return prefix
+}
+
+func entryKey(day, hash string) string {
+	return "entry/" + day + "/" + strings.ToLower(hash)
+}
+
+// track keeps labels of the TX sent for a metered request, the TX reserved by the request is the TX
+func (l *Ledger) track(rec *tracker.Record, r *request) {
+	t := &tracked{Labels: *r.labels, SentAt: rec.SentAt}
+	if len(r.reserved) > 0 {
+		wei := maxWei(rec)
+		t.Reserved, t.Wei = true, wei.String()
+		l.sent(r.reserved[0], r.labels, wei)
+		r.reserved = r.reserved[1:]
+	}
+	b, _ := encodingJson.Marshal(t)
+	if err := l.store.Put(txKey(rec.Hash), b); err != nil {
+		log.Warnf("Failed to keep labels of %s: %v", rec.Hash, err)
+	}
+}
+
+// settle records usage of the TX final with its labels, a TX dropped uses nothing
+func (l *Ledger) settle(rec *tracker.Record) {
+	t := new(tracked)
+	if b, err := l.store.Get(txKey(rec.Hash)); err == nil {
+		encodingJson.Unmarshal(b, t)
+		defer l.store.Delete(txKey(rec.Hash))
+		l.unreserve(t)
+	}
+	labels := &t.Labels
+	if rec.Status != tracker.StatusMined && rec.Status != tracker.StatusReverted {
+		return
+	}
+	// Decoders find EIN and MetaID created by the TX
+	if labels.EIN == "" {
+		labels.EIN = rec.EIN
+	}
+	if labels.MetaID == "" {
+		labels.MetaID = addressOf(rec.MetaID)
+	}
+
+	now := time.Now()
+	wei, ok := new(big.Int).SetString(rec.GasPrice, 10)
+	if !ok {
+		wei = new(big.Int)
+	}
+	wei.Mul(wei, new(big.Int).SetUint64(rec.GasUsed))
+	entry := &Entry{
+		Hash:     rec.Hash,
+		Method:   rec.Method,
+		Labels:   *labels,
+		Status:   rec.Status,
+		GasUsed:  rec.GasUsed,
+		GasPrice: rec.GasPrice,
+		Wei:      wei.String(),
+		SentAt:   rec.SentAt,
+		FinalAt:  now.Unix(),
+	}
+	b, _ := encodingJson.Marshal(entry)
+	if err := l.store.Put(entryKey(day(now), rec.Hash), b); err != nil {
+		log.Warnf("Failed to record usage of %s: %v", rec.Hash, err)
+	}
+	for _, period := range []string{day(now), month(now)} {
+		for _, scope := range Scopes {
+			if id := labels.ID(scope); id != "" {
+				l.add(usagePrefix(period, scope, id)+rec.Method, rec.GasUsed, wei)
+			}
+		}
+	}
+	l.prune(now)
+}
+
+// add adds a TX of the gas and wei to the usage
+func (l *Ledger) add(key string, gas uint64, wei *big.Int) {
+	err := l.store.Update(key, func(old []byte) ([]byte, error) {
+		u := new(Usage)
+		if old != nil {
+			encodingJson.Unmarshal(old, u)
+		}
+		total, ok := new(big.Int).SetString(u.Wei, 10)
+		if !ok {
+			total = new(big.Int)
+		}
+		u.Txs++
+		u.GasUsed += gas
+		u.Wei = total.Add(total, wei).String()
+		return encodingJson.Marshal(u)
+	})
+	if err != nil {
+		log.Warnf("Failed to update usage %s: %v", key, err)
+	}
+}
+
+// Usage returns usage of methods in the period, scope and id are optional to narrow it
+func (l *Ledger) Usage(period, scope, id string) ([]*Usage, error) {
+	var usages []*Usage
+	prefix := usagePrefix(period, scope, id)
+	err := l.store.Iterate(prefix, func(key string, value []byte) bool {
+		parts := strings.Split(strings.TrimPrefix(key, "usage/"+period+"/"), "/")
+		u := new(Usage)
+		if len(parts) != 3 || encodingJson.Unmarshal(value, u) != nil {
+			return true
+		}
+		u.Period = period
+		u.Scope = parts[0]
+		u.ID, _ = url.PathUnescape(parts[1])
+		u.Method = parts[2]
+		usages = append(usages, u)
+		return true
+	})
+	return usages, err
+}
+
+// Check returns QuotaError if the labels have used up a quota in this day or month,
+// TXs reserved or sent but not final are counted with the most wei they can use
+func (l *Ledger) Check(labels *Labels) error {
+	if l.quotas == nil {
+		return nil
+	}
+	now := time.Now()
+	for _, scope := range Scopes {
+		id := labels.ID(scope)
+		quota := l.quotas.of(scope, id)
+		if id == "" || quota == nil {
+			continue
+		}
+		pendingTxs, pendingWei, err := l.pending(scope, id)
+		if err != nil {
+			return err
+		}
+		for _, lim := range quota.limits(now) {
+			if lim.txs == 0 && lim.wei == nil {
+				continue
+			}
+			usages, err := l.Usage(lim.period, scope, id)
+			if err != nil {
+				return err
+			}
+			txs := pendingTxs
+			wei := new(big.Int).Set(pendingWei)
+			for _, u := range usages {
+				txs += u.Txs
+				if w, ok := new(big.Int).SetString(u.Wei, 10); ok {
+					wei.Add(wei, w)
+				}
+			}
+			qErr := &QuotaError{Scope: scope, ID: id, Period: lim.period}
+			if lim.txs != 0 && txs >= lim.txs {
+				qErr.Unit, qErr.Limit, qErr.Used = "txs", strconv.FormatUint(lim.txs, 10), strconv.FormatUint(txs, 10)
+				return qErr
+			}
+			if lim.wei != nil && wei.Cmp(lim.wei) >= 0 {
+				qErr.Unit, qErr.Limit, qErr.Used = "wei", lim.wei.String(), wei.String()
+				return qErr
+			}
+		}
+	}
+	return nil
+}
+
+// Entries returns TXs final in the period in order of days
+func (l *Ledger) Entries(period string) ([]*Entry, error) {
+	if !ValidPeriod(period) {
+		return nil, fmt.Errorf("accounting: invalid period %q", period)
+	}
+	var entries []*Entry
+	err := l.store.Iterate("entry/"+period, func(key string, value []byte) bool {
+		e := new(Entry)
+		if encodingJson.Unmarshal(value, e) == nil {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	return entries, err
+}
+
+// csvHeader is the header of CSV export
+var csvHeader = []string{"final_at", "hash", "method", "status", "ein", "meta_id", "address", "api_key", "gas_used", "gas_price", "wei"}
+
+// ExportCSV writes TXs final in the period as CSV for billing
+func (l *Ledger) ExportCSV(w io.Writer, period string) error {
+	entries, err := l.Entries(period)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, e := range entries {
+		cw.Write([]string{
+			time.Unix(e.FinalAt, 0).UTC().Format(time.RFC3339),
+			e.Hash,
+			e.Method,
+			e.Status,
+			e.EIN,
+			e.MetaID,
+			e.Address,
+			e.APIKey,
+			strconv.FormatUint(e.GasUsed, 10),
+			e.GasPrice,
+			e.Wei,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// prune removes daily usage and entries before the retention, and labels of TXs never final or never sent, once a day
+func (l *Ledger) prune(now time.Time) {
+	today := day(now)
+	l.mu.Lock()
+	if l.pruned == today {
+		l.mu.Unlock()
+		return
+	}
+	l.pruned = today
+	l.mu.Unlock()
+
+	expired := day(now.Add(-retention))
+	var keys []string
+	for _, prefix := range []string{"usage/", "entry/"} {
+		l.store.Iterate(prefix, func(key string, value []byte) bool {
+			period := strings.SplitN(strings.TrimPrefix(key, prefix), "/", 2)[0]
+			if len(period) == len(dayFormat) && period < expired {
+				keys = append(keys, key)
+			}
+			return true
+		})
+	}
+	// TXs not final long after sent are not tracked any more
+	staleAt := now.Add(-staleTimeout).Unix()
+	var stale []*tracked
+	l.store.Iterate("tx/", func(key string, value []byte) bool {
+		t := new(tracked)
+		if encodingJson.Unmarshal(value, t) != nil || t.SentAt < staleAt {
+			keys = append(keys, key)
+			stale = append(stale, t)
+		}
+		return true
+	})
+	for _, key := range keys {
+		l.store.Delete(key)
+	}
+	for _, t := range stale {
+		l.unreserve(t)
+	}
+	l.pruneReserved(now)
+}
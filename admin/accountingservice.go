@@ -0,0 +1,134 @@
+package admin
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/metadium/go-delegator/accounting"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+)
+
+// AccountingExportPath is the admin HTTP path exporting TXs of a period as CSV, ?period=2006-01
+const AccountingExportPath = "/accounting/export"
+
+type accountingUsageParams struct {
+	// Period is a UTC day or month, this month if empty
+	Period string `json:"period"`
+	// Scope and ID narrow usage, optional
+	Scope string `json:"scope"`
+	ID    string `json:"id"`
+}
+
+type accountingExportParams struct {
+	// Period is a UTC day or month, this month if empty
+	Period string `json:"period"`
+}
+
+// getLedger returns the accounting ledger, or the error if not available
+func getLedger() (*accounting.Ledger, *json.RPCError) {
+	l := accounting.GetInstance()
+	if l == nil {
+		return nil, json.NewRPCError(json.ErrCodeServer, "Accounting is not available")
+	}
+	return l, nil
+}
+
+// checkPeriod fills an empty period with this month, and returns the error for a malformed one
+func checkPeriod(period *string) *json.RPCError {
+	if *period == "" {
+		*period = time.Now().UTC().Format("2006-01")
+	}
+	if !accounting.ValidPeriod(*period) {
+		return json.NewRPCError(json.ErrCodeInvalidParams, "period must be a UTC day (2006-01-02) or month (2006-01)")
+	}
+	return nil
+}
+
+// accountingUsage returns usage of each method by scope and ID in a period
+func accountingUsage(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call accountingUsage Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	params := new(accountingUsageParams)
+	if resp.Error = fillParam(params, req.Params); resp.Error != nil {
+		return
+	}
+	if resp.Error = checkPeriod(&params.Period); resp.Error != nil {
+		return
+	}
+	validScope := params.Scope == ""
+	for _, scope := range accounting.Scopes {
+		validScope = validScope || params.Scope == scope
+	}
+	if !validScope || (params.Scope == "" && params.ID != "") {
+		resp.Error = json.NewRPCError(json.ErrCodeInvalidParams, "scope must be one of ein, meta_id, address and api_key, and given with id")
+		return
+	}
+	l, errObj := getLedger()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	usages, err := l.Usage(params.Period, params.Scope, params.ID)
+	if err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	if usages == nil {
+		usages = []*accounting.Usage{}
+	}
+	resp.Result = usages
+	return
+}
+
+// accountingExport returns TXs final in a period as CSV
+func accountingExport(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call accountingExport Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	params := new(accountingExportParams)
+	if resp.Error = fillParam(params, req.Params); resp.Error != nil {
+		return
+	}
+	if resp.Error = checkPeriod(&params.Period); resp.Error != nil {
+		return
+	}
+	l, errObj := getLedger()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	var b bytes.Buffer
+	if err := l.ExportCSV(&b, params.Period); err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	resp.Result = b.String()
+	return
+}
+
+// AccountingExportHandler serves TXs final in the period of the query as a CSV file
+func AccountingExportHandler(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if rpcErr := checkPeriod(&period); rpcErr != nil {
+		http.Error(w, rpcErr.Message, http.StatusBadRequest)
+		return
+	}
+	l, rpcErr := getLedger()
+	if rpcErr != nil {
+		http.Error(w, rpcErr.Message, http.StatusServiceUnavailable)
+		return
+	}
+	var b bytes.Buffer
+	if err := l.ExportCSV(&b, period); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage-`+period+`.csv"`)
+	w.Write(b.Bytes())
+}
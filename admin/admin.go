@@ -0,0 +1,57 @@
+// Package admin manages operator functions for RPC request
+// These functions are served only on the admin listener, not to public clients
+package admin
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	proxyCommon "github.com/metadium/go-delegator/common"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+)
+
+// Forward delivers RPCRequest to predefined function and returns that
+func Forward(req json.RPCRequest) (resp json.RPCResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Internal Error(Panic) : %s", debug.Stack())
+			resp.ID = req.ID
+			resp.Jsonrpc = req.Jsonrpc
+			resp.Error = &json.RPCError{
+				Code:    json.ErrCodeInternal,
+				Message: "Internal Error",
+			}
+		}
+	}()
+	if f, ok := predefinedPaths[req.Method]; ok {
+		requestID := proxyCommon.RandomUint64()
+		return f.(func(uint64, json.RPCRequest) (json.RPCResponse, error))(requestID, req)
+	}
+	err = fmt.Errorf("predefined NOT FOUND")
+
+	return resp, err
+}
+
+// Contains check if given path is in predefined or not
+func Contains(path string) bool {
+	_, ok := predefinedPaths[path]
+	return ok
+}
+
+var predefinedPaths = map[string]interface{}{
+	"admin_node_status":  nodeStatus,
+	"admin_nonce_status": nonceStatus,
+
+	"admin_webhook_subscribe":   webhookSubscribe,
+	"admin_webhook_unsubscribe": webhookUnsubscribe,
+	"admin_webhook_list":        webhookList,
+	"admin_webhook_deliveries":  webhookDeliveries,
+	"admin_webhook_replay":      webhookReplay,
+
+	"admin_policy_status": policyStatus,
+	"admin_policy_reload": policyReload,
+
+	"admin_accounting_usage":  accountingUsage,
+	"admin_accounting_export": accountingExport,
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/policy"
+)
+
+type policyStatusParams struct {
+	// MetaID and Signer are to show their daily gas spent, optional
+	MetaID *common.Address `json:"meta_id"`
+	Signer *common.Address `json:"signer"`
+}
+
+type policyStatusResult struct {
+	*policy.Status
+	// Spent is daily gas spent today by scope, meta_id or signer
+	Spent map[string]uint64 `json:"spent,omitempty"`
+}
+
+// getPolicy returns the policy engine, or the error if no policy file is set
+func getPolicy() (*policy.Engine, *json.RPCError) {
+	p := policy.GetInstance()
+	if p == nil {
+		return nil, json.NewRPCError(json.ErrCodeServer, "Policy is not set")
+	}
+	return p, nil
+}
+
+// policyStatus returns the policy applied, with daily gas of a MetaID or a key if given
+func policyStatus(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call policyStatus Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	params := new(policyStatusParams)
+	if resp.Error = fillParam(params, req.Params); resp.Error != nil {
+		return
+	}
+	p, errObj := getPolicy()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	result := &policyStatusResult{Status: p.Status()}
+	for scope, address := range map[string]*common.Address{"meta_id": params.MetaID, "signer": params.Signer} {
+		if address == nil {
+			continue
+		}
+		spent, err := p.Spent(scope, *address)
+		if err != nil {
+			resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+			return
+		}
+		if result.Spent == nil {
+			result.Spent = make(map[string]uint64)
+		}
+		result.Spent[scope] = spent
+	}
+	resp.Result = result
+	return
+}
+
+// policyReload loads the policy file now, the policy loaded before is kept if it fails
+func policyReload(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call policyReload Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	p, errObj := getPolicy()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	if err := p.Reload(); err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	resp.Result = p.Status()
+	return
+}
@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/rpc"
+)
+
+// nodeStatus returns state of each node in the pool
+func nodeStatus(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call nodeStatus Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	resp.Result = rpc.GetInstance().NodeStatus()
+	return
+}
+
+// nonceStatus returns TX nonces of the delegator in flight and compared with the chain
+func nonceStatus(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call nonceStatus Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	// Nonce manager is initialized with RPC
+	rpc.GetInstance()
+	status, err := crypto.GetInstance().NonceStatus()
+	if err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	resp.Result = status
+	return
+}
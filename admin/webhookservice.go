@@ -0,0 +1,175 @@
+package admin
+
+import (
+	encodingJson "encoding/json"
+
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/webhook"
+)
+
+type webhookIDParams struct {
+	ID string `json:"id"`
+}
+
+type webhookDeliveriesParams struct {
+	// Status is pending or dead, pending if empty
+	Status string `json:"status"`
+}
+
+// fillParam decodes the object of params to p, no params leave p as is
+func fillParam(p interface{}, params []interface{}) *json.RPCError {
+	if len(params) == 0 {
+		return nil
+	}
+	if len(params) != 1 {
+		return json.NewRPCError(json.ErrCodeInvalidParams, "Invalid params.")
+	}
+	b, err := encodingJson.Marshal(params[0])
+	if err == nil {
+		err = encodingJson.Unmarshal(b, p)
+	}
+	if err != nil {
+		return json.NewRPCError(json.ErrCodeInvalidParams, err.Error())
+	}
+	return nil
+}
+
+// getDispatcher returns the webhook dispatcher, or the error if not running
+func getDispatcher() (*webhook.Dispatcher, *json.RPCError) {
+	d := webhook.GetInstance()
+	if d == nil {
+		return nil, json.NewRPCError(json.ErrCodeServer, "Webhook is not available")
+	}
+	return d, nil
+}
+
+// webhookSubscribe keeps a subscription, of which secret is returned only here
+func webhookSubscribe(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call webhookSubscribe Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	sub := new(webhook.Subscription)
+	if resp.Error = fillParam(sub, req.Params); resp.Error != nil {
+		return
+	}
+	if err := sub.Validate(); err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeInvalidParams, err.Error())
+		return
+	}
+	d, errObj := getDispatcher()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	sub, err := d.Subscribe(sub)
+	if err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	resp.Result = sub
+	return
+}
+
+// webhookUnsubscribe removes a subscription
+func webhookUnsubscribe(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call webhookUnsubscribe Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	var params webhookIDParams
+	if resp.Error = fillParam(&params, req.Params); resp.Error != nil {
+		return
+	}
+	d, errObj := getDispatcher()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	if err := d.Unsubscribe(params.ID); err == store.ErrNotFound {
+		resp.Error = json.NewRPCError(json.ErrCodeInvalidParams, "Subscription not found")
+		return
+	} else if err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	resp.Result = true
+	return
+}
+
+// webhookList returns subscriptions without secrets
+func webhookList(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call webhookList Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	d, errObj := getDispatcher()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	subs, err := d.Subscriptions()
+	if err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	resp.Result = subs
+	return
+}
+
+// webhookDeliveries returns pending or dead deliveries
+func webhookDeliveries(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call webhookDeliveries Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	params := webhookDeliveriesParams{Status: webhook.StatusPending}
+	if resp.Error = fillParam(&params, req.Params); resp.Error != nil {
+		return
+	}
+	if params.Status != webhook.StatusPending && params.Status != webhook.StatusDead {
+		resp.Error = json.NewRPCError(json.ErrCodeInvalidParams, "Status must be pending or dead")
+		return
+	}
+	d, errObj := getDispatcher()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	deliveries, err := d.Deliveries(params.Status)
+	if err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	resp.Result = deliveries
+	return
+}
+
+// webhookReplay sends a dead delivery again
+func webhookReplay(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, "Call webhookReplay Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	var params webhookIDParams
+	if resp.Error = fillParam(&params, req.Params); resp.Error != nil {
+		return
+	}
+	d, errObj := getDispatcher()
+	if errObj != nil {
+		resp.Error = errObj
+		return
+	}
+	dl, err := d.Replay(params.ID)
+	if err == store.ErrNotFound {
+		resp.Error = json.NewRPCError(json.ErrCodeInvalidParams, "Dead delivery not found")
+		return
+	} else if err != nil {
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+		return
+	}
+	resp.Result = dl
+	return
+}
@@ -0,0 +1,118 @@
+// Signer is a reference signer daemon keeping delegator keys out of the proxy
+// It serves Clef compatible account_list, account_signTransaction and account_signData
+// over HTTP and Unix socket, so the proxy is tested with external signer offline.
+// Use KMS or HSM backed signer with the same methods in production.
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/log"
+
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// DefaultHTTPAddr is a listen address for signer JSON-RPC over HTTP, keep it private
+	DefaultHTTPAddr = "127.0.0.1:8550"
+)
+
+func help() {
+	fmt.Println("USAGE")
+	fmt.Println("  $> signer [path] [passphrase] -http=[addr] -ipc=[socket path] -chainid=[chain ID]")
+	fmt.Println("  [path] is a key file, a directory of key files or comma-separated list of them")
+	fmt.Println("  [passphrase] is prompted if omitted, KEY_PATH and KEY_PASSPHRASE are used if set")
+	fmt.Println("  -http: listen address, default " + DefaultHTTPAddr + ", empty to disable")
+	fmt.Println("  -ipc: path of Unix socket, disabled if omitted")
+	fmt.Println("  -chainid: reject TXs of other chain IDs, TXs without a chain ID are signed as homestead")
+	fmt.Println("  Then run proxy with SIGNER_URL=http://[addr] or SIGNER_URL=[socket path]")
+}
+
+func main() {
+	var path, passphrase string
+	httpAddr, ipcPath := DefaultHTTPAddr, ""
+	var chainID *big.Int
+	var args []string
+	for _, val := range os.Args[1:] {
+		if !strings.HasPrefix(val, "-") {
+			args = append(args, val)
+			continue
+		}
+		arg := strings.SplitN(val, "=", 2)
+		if len(arg) < 2 {
+			continue
+		}
+		switch arg[0] {
+		case "-http":
+			httpAddr = arg[1]
+		case "-ipc":
+			ipcPath = arg[1]
+		case "-chainid":
+			var ok bool
+			if chainID, ok = new(big.Int).SetString(arg[1], 0); !ok {
+				help()
+				log.Panic("Invalid chain ID: ", arg[1])
+			}
+		}
+	}
+
+	if path = os.Getenv(crypto.Path); path != "" {
+		passphrase = os.Getenv(crypto.Passphrase)
+		os.Setenv(crypto.Path, "")
+		os.Setenv(crypto.Passphrase, "")
+	} else if len(args) > 0 && args[0] != "help" {
+		path = args[0]
+		if len(args) > 1 {
+			passphrase = args[1]
+		} else {
+			fmt.Printf("Passphrase: ")
+			fmt.Scanln(&passphrase)
+		}
+	} else {
+		help()
+		log.Panic("Please refer above help")
+	}
+	if httpAddr == "" && ipcPath == "" {
+		help()
+		log.Panic("Either -http or -ipc is needed")
+	}
+
+	signers, err := crypto.LoadKeystoreSigners(path, passphrase)
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	if len(signers) == 0 {
+		log.Panic("No key json in ", path)
+	}
+	for _, s := range signers {
+		log.Info("Signer account: ", s.Address().Hex())
+	}
+
+	srv := ethrpc.NewServer()
+	if err := srv.RegisterName("account", crypto.NewSignerService(signers, chainID)); err != nil {
+		log.Panic(err.Error())
+	}
+
+	errc := make(chan error, 2)
+	if ipcPath != "" {
+		os.Remove(ipcPath)
+		l, err := net.Listen("unix", ipcPath)
+		if err != nil {
+			log.Panic(err.Error())
+		}
+		os.Chmod(ipcPath, 0600)
+		log.Info("Signer listening on ", ipcPath)
+		go func() { errc <- srv.ServeListener(l) }()
+	}
+	if httpAddr != "" {
+		log.Info("Signer listening on http://", httpAddr)
+		go func() { errc <- http.ListenAndServe(httpAddr, srv) }()
+	}
+	log.Error(<-errc)
+}
@@ -12,6 +12,8 @@ import (
 	"io/ioutil"
 	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,6 +21,7 @@ import (
 	"github.com/metadium/go-delegator/common"
 	"github.com/metadium/go-delegator/db"
 	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
 
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	ethcommon "github.com/ethereum/go-ethereum/common"
@@ -29,21 +32,17 @@ import (
 
 // Crypto manager
 // It should be initialized first at main
+// It keeps a pool of delegator keys, functions without account use the first key
 type Crypto struct {
-	privKey *ecdsa.PrivateKey
-	address string
-
-	signer  types.Signer
-	chainID *big.Int
-
-	txnonce uint64
+	accounts []*Account
+	// turn rotates accounts equally busy
+	turn uint32
 }
 
 // For singleton
 var (
 	instance       *Crypto
 	once           sync.Once
-	mutex          = &sync.Mutex{}
 	PathChan       = make(chan string)
 	PassphraseChan = make(chan string)
 )
@@ -65,7 +64,27 @@ const (
 	// Path means a location of keyjson in file system
 	Path = "KEY_PATH"
 	// IsAwsLambda decides if served as AWS lambda or not
+	// Key pool is supported only for keys in file system, Lambda uses a key in DB
 	IsAwsLambda = "AWS_LAMBDA"
+	// NonceStore is a location of store for TX nonces, refer to store.Open
+	// Use DynamoDB to share nonces between delegator processes using the same key
+	NonceStore = "NONCE_STORE"
+	// SignerURL is an endpoint of external signer, http(s) URL or path of Unix socket
+	// If given, keys are kept by the signer and KEY_PATH is not needed, refer to cmd/signer
+	SignerURL = "SIGNER_URL"
+	// RebroadcastAfter is how long a TX is pending before replaced with higher gas price, such as "5m"
+	RebroadcastAfter = "REBROADCAST_AFTER"
+	// GasPriceCap is the highest gas price in wei of a replacement TX
+	// Default is 10 times the node's gas price
+	GasPriceCap = "GAS_PRICE_CAP"
+)
+
+// For nonce store
+const (
+	// DefaultNonceStorePath is a LevelDB path for TX nonces
+	DefaultNonceStorePath = "nonce"
+	// DefaultNonceStoreTable is a DynamoDB table for TX nonces used in AWS lambda
+	DefaultNonceStoreTable = "Nonce"
 )
 
 // GetInstance returns pointer of Crypto instance
@@ -77,6 +96,21 @@ func GetInstance() *Crypto {
 		return instance
 	}
 
+	// Keys are kept by external signer
+	if endpoint := os.Getenv(SignerURL); endpoint != "" {
+		once.Do(func() {
+			signers, err := NewExternalSigners(endpoint)
+			if err != nil {
+				log.Panic("Failed to get accounts of signer: ", err)
+			}
+			instance = newCrypto(signers)
+			for _, a := range instance.accounts {
+				log.Info("Crypto address is set to ", a.GetAddress(), " of signer ", endpoint)
+			}
+		})
+		return instance
+	}
+
 	// Check channel within the timeout
 	var path string
 	select {
@@ -93,28 +127,62 @@ func GetInstance() *Crypto {
 	once.Do(func() {
 		passphrase := <-PassphraseChan
 
-		var privkey *ecdsa.PrivateKey
-		var addr string
+		var signers []Signer
 		if os.Getenv(IsAwsLambda) != "" {
-			privkey, addr = getPrivateKeyFromDB(passphrase)
+			if signer, err := NewDBSigner(passphrase); err == nil {
+				signers = append(signers, signer)
+			}
 		} else {
-			privkey, addr = getPrivateKeyFromFile(path, passphrase)
+			var err error
+			if signers, err = LoadKeystoreSigners(path, passphrase); err != nil {
+				log.Panic("Failed to parse key json for Crypto: ", err)
+			}
 		}
 
-		if addr == "" {
+		if len(signers) == 0 {
 			log.Panic("Failed to parse key json for Crypto")
 		}
 
-		//fmt.Printf("privkey %s, addr: %s\n", hex.EncodeToString(crypto.FromECDSA(privkey)), addr)
-		log.Info("Crypto address is set to ", addr)
-		instance = &Crypto{
-			privKey: privkey,
-			address: addr,
+		instance = newCrypto(signers)
+		for _, a := range instance.accounts {
+			log.Info("Crypto address is set to ", a.GetAddress())
 		}
 	})
 	return instance
 }
 
+// newCrypto returns Crypto with accounts of the signers
+func newCrypto(signers []Signer) *Crypto {
+	c := &Crypto{}
+	for _, signer := range signers {
+		c.accounts = append(c.accounts, newAccount(signer))
+	}
+	return c
+}
+
+// getKeyFiles returns key json files in the path
+// The path is a file, a directory of files or a comma-separated list of them
+func getKeyFiles(path string) (files []string) {
+	for _, p := range strings.Split(path, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		infos, err := ioutil.ReadDir(p)
+		if err != nil {
+			// Not a directory
+			files = append(files, p)
+			continue
+		}
+		for _, info := range infos {
+			if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+				files = append(files, filepath.Join(p, info.Name()))
+			}
+		}
+	}
+	return
+}
+
 // getPrivateKeyFromDB returns private key and address from DB
 func getPrivateKeyFromDB(passphrase string) (privkey *ecdsa.PrivateKey, addr string) {
 	dbSecretKey := getConfigFromDB(DbSecretKeyPropName)
@@ -146,75 +214,121 @@ func getPrivateKeyFromFile(filepath, passphrase string) (privkey *ecdsa.PrivateK
 
 // InitChainID initializes chain ID
 func (c *Crypto) InitChainID(chainID *big.Int) {
-	if c.chainID == nil {
-		c.chainID = chainID
+	for _, a := range c.accounts {
+		if a.chainID == nil {
+			a.chainID = chainID
+		}
 	}
 }
 
-// InitNonce initailizes TX nonce one time
+// InitNonce initailizes TX nonce of the first account one time
+// It's used only if nonce manager is not initialized
 func (c *Crypto) InitNonce(nonce uint64) {
-	if c.txnonce == 0 {
-		c.txnonce = nonce
-	}
+	c.primary().InitNonce(nonce)
 }
 
-// GetAddress returns an address of Crypto manager
-func (c *Crypto) GetAddress() string {
-	return c.address
+// InitNonce initailizes TX nonce of the account one time
+func (a *Account) InitNonce(nonce uint64) {
+	if atomic.LoadUint64(&a.txnonce) == 0 {
+		atomic.StoreUint64(&a.txnonce, nonce)
+	}
 }
 
-// Sign returns signed message using own private key
-func (c *Crypto) Sign(msg string) string {
-	sig, err := Sign(msg, c.privKey)
+// InitNonceManager initializes nonce manager of every account one time
+// The store is given by NONCE_STORE, or DynamoDB in AWS lambda and LevelDB otherwise
+func (c *Crypto) InitNonceManager(backend NonceBackend) error {
+	if c.primary().nonces != nil {
+		return nil
+	}
+	location := os.Getenv(NonceStore)
+	if location == "" {
+		if os.Getenv(IsAwsLambda) != "" {
+			location = store.DynamoDBScheme + DefaultNonceStoreTable
+		} else {
+			location = DefaultNonceStorePath
+		}
+	}
+	s, err := store.Open(location)
 	if err != nil {
-		return ""
+		return err
+	}
+	managers := make([]*NonceManager, len(c.accounts))
+	for i, a := range c.accounts {
+		if managers[i], err = NewNonceManager(a.address, s, backend, a.SignTx); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	log.Info("Nonce store is set to ", location)
+	after, gasPriceCap := rebroadcastConfig()
+	for i, a := range c.accounts {
+		a.nonces = managers[i]
+		a.nonces.SetRebroadcast(after, gasPriceCap)
+		a.nonces.Start()
+	}
+	return nil
+}
+
+// rebroadcastConfig reads REBROADCAST_AFTER and GAS_PRICE_CAP, zero or nil if not given
+func rebroadcastConfig() (after time.Duration, gasPriceCap *big.Int) {
+	if v := os.Getenv(RebroadcastAfter); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Error("Invalid ", RebroadcastAfter, ", use default: ", v)
+		} else {
+			after = d
+		}
 	}
-	sig[64] += 27
+	if v := os.Getenv(GasPriceCap); v != "" {
+		c, ok := new(big.Int).SetString(v, 10)
+		if !ok || c.Sign() <= 0 {
+			log.Error("Invalid ", GasPriceCap, ", use default: ", v)
+		} else {
+			gasPriceCap = c
+		}
+	}
+	return
+}
 
-	ret := hexutil.Encode(sig)
-	if c.address == "" {
-		addr, err := EcRecover(hexutil.Encode(crypto.Keccak256([]byte(msg))), ret)
+// NonceStatus returns nonces of every account compared with the chain
+func (c *Crypto) NonceStatus() ([]NonceStatus, error) {
+	var ret []NonceStatus
+	for _, a := range c.accounts {
+		if a.nonces == nil {
+			return nil, fmt.Errorf("nonce manager is not initialized")
+		}
+		status, err := a.nonces.Status()
 		if err != nil {
-			c.address = fmt.Sprintf("0x%x", addr)
-			log.Infof("Crypto address is set to %s\n", c.address)
+			return nil, err
 		}
+		ret = append(ret, status)
 	}
-	return ret
+	return ret, nil
+}
+
+// GetAddress returns an address of the first account
+func (c *Crypto) GetAddress() string {
+	return c.primary().GetAddress()
+}
+
+// Sign returns signed message using the key of the first account
+func (c *Crypto) Sign(msg string) string {
+	return c.primary().Sign(msg)
 }
 
-// SignTx returns signed transaction using own private key
+// SignTx returns signed transaction using the key of the first account
 func (c *Crypto) SignTx(tx *types.Transaction) (*types.Transaction, error) {
-	if c.signer != nil {
-		// Nothing to do
-	} else if c.chainID != nil {
-		c.signer = types.NewEIP155Signer(c.chainID)
-	} else {
-		c.signer = types.HomesteadSigner{}
-	}
-	signedTx, err := types.SignTx(tx, c.signer, c.privKey)
-	if err != nil {
-		return nil, fmt.Errorf("tx or private key is not appropriate")
-	}
-	return signedTx, nil
+	return c.primary().SignTx(tx)
 }
 
-// ApplyNonce applies nonce to a given function "f"
+// ApplyNonce applies nonce of the first account to a given function "f"
 // Function description should be func(uint64) (error)
 // If given function returns nil error, increase nonce
 // Meaning of this function's return is either nonce was increased or not
+// With nonce manager, a nonce reached the node is not reused even if "f" fails
+// Use Pick or Account to apply nonce of other accounts in the pool
 func (c *Crypto) ApplyNonce(f interface{}) bool {
-	log.Info("Trying to lock for nonce...")
-	mutex.Lock()
-	defer mutex.Unlock()
-	nonce := atomic.LoadUint64(&c.txnonce)
-	log.Infof("Apply nonce %d to func given", nonce)
-	err := f.(func(uint64) error)(nonce)
-	if err != nil {
-		return false
-	}
-	atomic.AddUint64(&c.txnonce, 1)
-	log.Info("Nonce was increased by one")
-	return true
+	return c.primary().ApplyNonce(f)
 }
 
 // Sign returns signed message using given private key
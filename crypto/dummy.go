@@ -9,10 +9,7 @@ import (
 // GetDummy returns dummy Crypto instance for test
 func GetDummy() *Crypto {
 	privKey, _ := crypto.HexToECDSA("25c317c8d0a63c122073ae52984e8477e7fbc322c93a9457c5579ee6e5a813b3")
-	instance = &Crypto{
-		privKey: privKey,
-		chainID: big.NewInt(127),
-		address: "0xed56062123b0301a9a642f85f2711581bec8d79d",
-	}
+	instance = newCrypto([]Signer{NewKeySigner(privKey)})
+	instance.InitChainID(big.NewInt(127))
 	return instance
 }
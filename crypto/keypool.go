@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/metadium/go-delegator/log"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Account is a delegator key with its own nonce lane
+// TXs of different accounts are sent in parallel
+type Account struct {
+	signer  Signer
+	address string
+
+	chainID *big.Int
+
+	// mu serializes nonces of the account without nonce manager
+	mu      sync.Mutex
+	txnonce uint64
+	nonces  *NonceManager
+
+	// busy is the number of TXs waiting for or holding the nonce lane
+	busy int32
+}
+
+// newAccount returns an account of the signer
+func newAccount(signer Signer) *Account {
+	return &Account{
+		signer:  signer,
+		address: signer.Address().Hex(),
+	}
+}
+
+// GetAddress returns an address of the account
+func (a *Account) GetAddress() string {
+	return a.address
+}
+
+// Busy returns the number of TXs waiting for or holding the nonce lane
+func (a *Account) Busy() int {
+	return int(atomic.LoadInt32(&a.busy))
+}
+
+// Sign returns signed message using the account key
+func (a *Account) Sign(msg string) string {
+	sig, err := a.signer.SignText(crypto.Keccak256([]byte(msg)))
+	if err != nil {
+		log.Error("Failed to sign message: ", err)
+		return ""
+	}
+	return hexutil.Encode(sig)
+}
+
+// SignTx returns signed transaction using the account key
+func (a *Account) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	return a.signTx(tx, a.chainID)
+}
+
+// signTx signs the TX with EIP155 of the chain ID, or homestead if nil
+func (a *Account) signTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signedTx, err := a.signer.SignTx(tx, chainID)
+	if err != nil {
+		log.Error("Failed to sign TX: ", err)
+		return nil, fmt.Errorf("tx or private key is not appropriate")
+	}
+	if a.nonces != nil {
+		// Keep signed TX to send it again if lost
+		a.nonces.track(signedTx)
+	}
+	return signedTx, nil
+}
+
+// TransactionOpts returns TransactOpts to create contract session signed by the account
+func (a *Account) TransactionOpts() *bind.TransactOpts {
+	from := a.signer.Address()
+	return &bind.TransactOpts{
+		From: from,
+		Signer: func(s types.Signer, addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if addr != from {
+				return nil, errors.New("not authorized to sign this account")
+			}
+			// Contract bindings sign with homestead signer
+			return a.signTx(tx, nil)
+		},
+	}
+}
+
+// TransactionOptsContext returns TransactionOpts whose calls to the node are bound to ctx
+func (a *Account) TransactionOptsContext(ctx context.Context) *bind.TransactOpts {
+	opts := a.TransactionOpts()
+	opts.Context = ctx
+	return opts
+}
+
+// ApplyNonce applies nonce of the account to a given function "f"
+// Refer to Crypto.ApplyNonce
+func (a *Account) ApplyNonce(f interface{}) bool {
+	return a.Apply(f.(func(uint64) error)) == nil
+}
+
+// Apply applies nonce of the account to f, and returns the error of f.
+// It returns InflightError if f failed but its TX may be in the pool, so that the TX can still be mined.
+// Contract wrappers return the error as it is, for callers to track the TX by its hash
+func (a *Account) Apply(f func(uint64) error) error {
+	atomic.AddInt32(&a.busy, 1)
+	defer atomic.AddInt32(&a.busy, -1)
+
+	if a.nonces != nil {
+		return a.nonces.apply(f)
+	}
+
+	log.Info("Trying to lock for nonce...")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	nonce := atomic.LoadUint64(&a.txnonce)
+	log.Infof("Apply nonce %d of %s to func given", nonce, a.address)
+	if err := f(nonce); err != nil {
+		return err
+	}
+	atomic.AddUint64(&a.txnonce, 1)
+	log.Info("Nonce was increased by one")
+	return nil
+}
+
+// Accounts returns every account in the key pool, the first is the primary
+func (c *Crypto) Accounts() []*Account {
+	return c.accounts
+}
+
+// Account returns the account of the address to pin a signer, or nil if not in the pool
+func (c *Crypto) Account(address string) *Account {
+	for _, a := range c.accounts {
+		if strings.EqualFold(a.address, address) {
+			return a
+		}
+	}
+	return nil
+}
+
+// Pick returns the least busy account, accounts equally busy take turns
+func (c *Crypto) Pick() *Account {
+	start := int(atomic.AddUint32(&c.turn, 1))
+	var picked *Account
+	for i := range c.accounts {
+		a := c.accounts[(start+i)%len(c.accounts)]
+		if picked == nil || a.Busy() < picked.Busy() {
+			picked = a
+		}
+	}
+	return picked
+}
+
+// primary returns the first account, used by functions without signer
+func (c *Crypto) primary() *Account {
+	return c.accounts[0]
+}
@@ -0,0 +1,118 @@
+package crypto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestCrypto(t *testing.T, n int) *Crypto {
+	var signers []Signer
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, NewKeySigner(key))
+	}
+	return newCrypto(signers)
+}
+
+func TestGetKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"key2", "key1", ".hidden"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("{}"), 0600)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0700)
+
+	files := getKeyFiles(dir + ", test/testkey")
+	expected := []string{filepath.Join(dir, "key1"), filepath.Join(dir, "key2"), "test/testkey"}
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, files)
+	}
+	for i := range files {
+		if files[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, files)
+		}
+	}
+}
+
+func TestKeyPoolPick(t *testing.T) {
+	c := newTestCrypto(t, 3)
+	if c.Account(c.Accounts()[1].GetAddress()) != c.Accounts()[1] || c.Account("0x00") != nil {
+		t.Errorf("Account must return the pinned account in the pool")
+	}
+
+	// Accounts equally busy take turns
+	picked := make(map[*Account]bool)
+	for i := 0; i < 3; i++ {
+		picked[c.Pick()] = true
+	}
+	if len(picked) != 3 {
+		t.Errorf("Idle accounts must take turns, picked %d", len(picked))
+	}
+
+	// Busy account is not picked
+	busy := c.Accounts()[0]
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go busy.ApplyNonce(func(nonce uint64) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	for i := 0; i < 10; i++ {
+		if c.Pick() == busy {
+			t.Fatalf("Busy account must not be picked")
+		}
+	}
+	close(release)
+}
+
+func TestKeyPoolParallel(t *testing.T) {
+	c := newTestCrypto(t, 2)
+
+	// TXs of different accounts don't wait for each other's nonce
+	both := make(chan struct{}, 2)
+	done := make(chan uint64, 2)
+	for _, a := range c.Accounts() {
+		go func(a *Account) {
+			a.ApplyNonce(func(nonce uint64) error {
+				both <- struct{}{}
+				for len(both) < 2 {
+					time.Sleep(time.Millisecond)
+				}
+				done <- nonce
+				return nil
+			})
+		}(a)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Accounts must apply nonces in parallel")
+		}
+	}
+
+	// Nonce of each account is increased separately
+	for _, a := range c.Accounts() {
+		var got uint64
+		a.ApplyNonce(func(nonce uint64) error {
+			got = nonce
+			return nil
+		})
+		if got != 1 {
+			t.Errorf("Expected nonce 1 of %s, got %d", a.GetAddress(), got)
+		}
+	}
+}
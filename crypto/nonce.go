@@ -0,0 +1,618 @@
+package crypto
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ethjson "github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	// Interval of comparing nonces with the chain
+	nonceResyncInterval = 30 * time.Second
+	// A nonce not mined for this long is stuck
+	nonceStuckTimeout = 2 * time.Minute
+	// Gas of a no-op TX, a transfer of zero to itself
+	noopGasLimit uint64 = 21000
+	// A TX pending for this long is replaced with higher gas price
+	rebroadcastAfter = 2 * time.Minute
+	// Gas price is capped at this times the node's gas price if no cap is given
+	defaultGasPriceCapRatio int64 = 10
+)
+
+// replaceHooks are called when a TX in flight is replaced
+var replaceHooks []func(old, replacement *types.Transaction)
+
+// OnReplace registers fn called with a TX in flight and its replacement of the same nonce.
+// It should be called in init, fn is called in resync and should not block
+func OnReplace(fn func(old, replacement *types.Transaction)) {
+	replaceHooks = append(replaceHooks, fn)
+}
+
+// NonceBackend is an ethereum node used by NonceManager
+type NonceBackend interface {
+	// GetTransactionCountAt returns nonce of the address at "latest" or "pending"
+	GetTransactionCountAt(addr, block string) (uint64, error)
+	GetGasPrice() uint64
+	SendRawTransaction(raw []byte) (string, error)
+}
+
+// nonceState is the allocation state shared by processes using the same key
+// Free is a nonce given back below Next, so it's a gap until used again
+type nonceState struct {
+	Next uint64   `json:"next"`
+	Free []uint64 `json:"free,omitempty"`
+}
+
+// InflightNonce is a nonce allocated but not mined yet
+type InflightNonce struct {
+	Nonce uint64 `json:"nonce"`
+	// Hash and Raw are set once the signed TX is sent
+	Hash string `json:"hash,omitempty"`
+	Raw  string `json:"raw,omitempty"`
+	// Since is unix time of allocation or the last send
+	Since int64 `json:"since"`
+	NoOp  bool  `json:"noop,omitempty"`
+	// Replaces is hashes of TXs replaced by this one with lower gas price, the oldest first
+	Replaces []string `json:"replaces,omitempty"`
+}
+
+// NonceStatus is a snapshot of nonces of the delegator
+type NonceStatus struct {
+	Address  string          `json:"address"`
+	Next     uint64          `json:"next"`
+	Latest   uint64          `json:"latest"`
+	Pending  uint64          `json:"pending"`
+	Free     []uint64        `json:"free"`
+	Inflight []InflightNonce `json:"inflight"`
+}
+
+// NonceManager allocates TX nonces of an address.
+// Allocation state and nonces in flight are kept in the store,
+// so they survive restart and can be shared by several processes on one key.
+// Nonces are compared with the chain periodically,
+// a gap is filled by the original TX or a no-op TX,
+// and a TX pending too long is replaced with higher gas price
+type NonceManager struct {
+	address string
+	store   store.Store
+	backend NonceBackend
+	sign    func(*types.Transaction) (*types.Transaction, error)
+
+	// A TX pending for rebroadcastAfter is replaced, gas price is up to gasPriceCap
+	rebroadcastAfter time.Duration
+	gasPriceCap      *big.Int
+
+	// mu serializes allocation and resync in the process
+	mu sync.Mutex
+	// signed keeps the TX signed by the function in Apply
+	signedMu sync.Mutex
+	signed   map[uint64]*types.Transaction
+	// missing is nonces with no record found at the last resync
+	missing map[uint64]bool
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewNonceManager returns a nonce manager synchronized with the chain
+func NewNonceManager(address string, s store.Store, backend NonceBackend, sign func(*types.Transaction) (*types.Transaction, error)) (*NonceManager, error) {
+	m := &NonceManager{
+		address: strings.ToLower(address),
+		store:   s,
+		backend: backend,
+		sign:    sign,
+		signed:  make(map[uint64]*types.Transaction),
+		missing: make(map[uint64]bool),
+		stop:    make(chan struct{}),
+	}
+	if err := m.Resync(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetRebroadcast sets how long a TX is pending before replaced, and the cap of gas price.
+// Zero or nil is the default, rebroadcastAfter and defaultGasPriceCapRatio times the node's gas price
+func (m *NonceManager) SetRebroadcast(after time.Duration, gasPriceCap *big.Int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rebroadcastAfter = after
+	m.gasPriceCap = gasPriceCap
+}
+
+func (m *NonceManager) stateKey() string {
+	return "nonce/" + m.address
+}
+
+func (m *NonceManager) inflightPrefix() string {
+	return "nonce/" + m.address + "/tx/"
+}
+
+func (m *NonceManager) inflightKey(nonce uint64) string {
+	return fmt.Sprintf("%s%020d", m.inflightPrefix(), nonce)
+}
+
+// updateState applies fn to the allocation state atomically
+func (m *NonceManager) updateState(fn func(s *nonceState) error) error {
+	return m.store.Update(m.stateKey(), func(old []byte) ([]byte, error) {
+		var s nonceState
+		if old != nil {
+			if err := json.Unmarshal(old, &s); err != nil {
+				return nil, err
+			}
+		}
+		if err := fn(&s); err != nil {
+			return nil, err
+		}
+		return json.Marshal(s)
+	})
+}
+
+func (m *NonceManager) putInflight(rec InflightNonce) error {
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return m.store.Put(m.inflightKey(rec.Nonce), value)
+}
+
+// reserve allocates the lowest free nonce or the next one
+func (m *NonceManager) reserve() (nonce uint64, err error) {
+	err = m.updateState(func(s *nonceState) error {
+		if len(s.Free) > 0 {
+			nonce, s.Free = s.Free[0], s.Free[1:]
+			return nil
+		}
+		nonce = s.Next
+		s.Next++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	err = m.putInflight(InflightNonce{Nonce: nonce, Since: time.Now().Unix()})
+	return
+}
+
+// release gives back a nonce which never reached the node
+func (m *NonceManager) release(nonce uint64) {
+	err := m.updateState(func(s *nonceState) error {
+		s.Free = append(s.Free, nonce)
+		sort.Slice(s.Free, func(i, j int) bool { return s.Free[i] < s.Free[j] })
+		// Free nonces on top are not gaps
+		for len(s.Free) > 0 && s.Free[len(s.Free)-1] == s.Next-1 {
+			s.Free = s.Free[:len(s.Free)-1]
+			s.Next--
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("nonce: failed to release ", nonce, ": ", err)
+		return
+	}
+	m.store.Delete(m.inflightKey(nonce))
+}
+
+// track remembers a TX signed by the delegator key, so that it can be sent again
+func (m *NonceManager) track(tx *types.Transaction) {
+	m.signedMu.Lock()
+	defer m.signedMu.Unlock()
+	m.signed[tx.Nonce()] = tx
+}
+
+func (m *NonceManager) takeSigned(nonce uint64) *types.Transaction {
+	m.signedMu.Lock()
+	defer m.signedMu.Unlock()
+	tx := m.signed[nonce]
+	delete(m.signed, nonce)
+	return tx
+}
+
+// sent records the nonce is in flight with the TX
+func (m *NonceManager) sent(nonce uint64, tx *types.Transaction) {
+	rec := InflightNonce{Nonce: nonce, Since: time.Now().Unix()}
+	if tx != nil {
+		if raw, err := rlp.EncodeToBytes(tx); err == nil {
+			rec.Hash = tx.Hash().Hex()
+			rec.Raw = hexutil.Encode(raw)
+		}
+	}
+	if err := m.putInflight(rec); err != nil {
+		log.Error("nonce: failed to record ", nonce, ": ", err)
+	}
+}
+
+// InflightError is an error of sending a TX which may have reached the pool,
+// so its nonce is kept in flight and the TX can be mined
+type InflightError struct {
+	// Hash is of the TX signed
+	Hash string
+	Err  error
+}
+
+func (e *InflightError) Error() string {
+	return e.Err.Error()
+}
+
+// Apply applies an allocated nonce to f.
+// If f fails before the TX reaches the node, the nonce is given back.
+// It returns true if f succeeded
+func (m *NonceManager) Apply(f func(uint64) error) bool {
+	return m.apply(f) == nil
+}
+
+// apply applies an allocated nonce to f, and returns the error of f,
+// or InflightError if f failed but the TX may be in the pool
+func (m *NonceManager) apply(f func(uint64) error) error {
+	log.Info("Trying to lock for nonce...")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nonce, err := m.reserve()
+	if err != nil {
+		log.Error("nonce: failed to allocate: ", err)
+		return fmt.Errorf("nonce: failed to allocate: %v", err)
+	}
+	log.Infof("Apply nonce %d to func given", nonce)
+	err = f(nonce)
+	tx := m.takeSigned(nonce)
+	if err == nil {
+		m.sent(nonce, tx)
+		return nil
+	}
+	if tx == nil {
+		// Never signed, so it never reached the node
+		m.release(nonce)
+		return err
+	}
+	if rejected(err) {
+		// The node it was sent to refused it, so it's not spreading from there
+		m.release(nonce)
+		return err
+	}
+	// The TX can be in the pool though sending it failed, e.g. response timeout.
+	// Another node may not know it yet, so it's kept in flight and resync sends it again if missing
+	log.Warnf("nonce: %d is kept in flight, TX may have reached the pool: %v", nonce, err)
+	m.sent(nonce, tx)
+	return &InflightError{Hash: tx.Hash().Hex(), Err: err}
+}
+
+// rejected returns true if err is an error response of the node the TX was sent to,
+// other than the node knowing the TX already
+func rejected(err error) bool {
+	var msg string
+	switch e := err.(type) {
+	case *ethjson.RPCError:
+		msg = e.Message
+	case interface{ ErrorCode() int }:
+		msg = err.Error()
+	default:
+		return false
+	}
+	msg = strings.ToLower(msg)
+	return !strings.Contains(msg, "known transaction") && !strings.Contains(msg, "already known")
+}
+
+// Start resyncs nonces periodically until Stop
+func (m *NonceManager) Start() {
+	go func() {
+		ticker := time.NewTicker(nonceResyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Resync(); err != nil {
+					log.Error("nonce: resync failed: ", err)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops resync
+func (m *NonceManager) Stop() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+// Resync compares nonces with the chain.
+//   - Next is raised to pending nonce, in case of TXs sent by others
+//   - a nonce below latest nonce is mined and forgotten
+//   - a free nonce not in the pool is a gap, filled by a no-op TX
+//   - a nonce in flight but not in the pool is sent again, or replaced by a no-op TX if unknown
+//   - a TX pending for rebroadcastAfter is replaced by the TX with higher gas price,
+//     or sent again if gas price reached the cap
+//   - a nonce in the pool is never replaced by a no-op TX, even if its TX is unknown
+func (m *NonceManager) Resync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest, err := m.backend.GetTransactionCountAt(m.address, "latest")
+	if err != nil {
+		return err
+	}
+	pending, err := m.backend.GetTransactionCountAt(m.address, "pending")
+	if err != nil {
+		return err
+	}
+	if pending < latest {
+		pending = latest
+	}
+
+	// Claim gaps atomically, so that other processes don't fill them too
+	var next uint64
+	var gaps []uint64
+	err = m.updateState(func(s *nonceState) error {
+		gaps = gaps[:0]
+		if s.Next < pending {
+			s.Next = pending
+		}
+		for _, n := range s.Free {
+			if n >= pending {
+				gaps = append(gaps, n)
+			}
+		}
+		s.Free = nil
+		next = s.Next
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	known := make(map[uint64]InflightNonce)
+	err = m.store.Iterate(m.inflightPrefix(), func(key string, value []byte) bool {
+		var rec InflightNonce
+		if json.Unmarshal(value, &rec) == nil {
+			known[rec.Nonce] = rec
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	after := m.rebroadcastAfter
+	if after == 0 {
+		after = rebroadcastAfter
+	}
+	missing := make(map[uint64]bool)
+	for _, n := range gaps {
+		log.Warnf("nonce: %d is a gap, fill it with no-op", n)
+		m.sendNoOp(n, nil)
+		delete(known, n)
+	}
+	if len(gaps) > 0 {
+		// Nonces queued behind the gaps are pending now
+		if filled, err := m.backend.GetTransactionCountAt(m.address, "pending"); err == nil && filled > pending {
+			pending = filled
+		}
+	}
+	for n, rec := range known {
+		stuck := now.Sub(time.Unix(rec.Since, 0)) > nonceStuckTimeout
+		switch {
+		case n < latest:
+			m.store.Delete(m.inflightKey(n))
+		case n >= pending && rec.Raw != "":
+			log.Warnf("nonce: %d is not in the pool, send %s again", n, rec.Hash)
+			m.resend(rec)
+		case n >= pending && stuck:
+			log.Warnf("nonce: %d is not in the pool and unknown, fill it with no-op", n)
+			m.sendNoOp(n, nil)
+		case rec.Raw != "" && now.Sub(time.Unix(rec.Since, 0)) > after:
+			log.Warnf("nonce: %d is pending too long, replace %s with higher gas price", n, rec.Hash)
+			m.bump(rec)
+		}
+	}
+
+	// A nonce allocated without record is a gap if it's still missing at next resync
+	for n := pending; n < next; n++ {
+		if _, ok := known[n]; ok || containsNonce(gaps, n) {
+			continue
+		}
+		if m.missing[n] {
+			log.Warnf("nonce: %d is lost, fill it with no-op", n)
+			m.sendNoOp(n, nil)
+			continue
+		}
+		missing[n] = true
+	}
+	m.missing = missing
+	return nil
+}
+
+func containsNonce(nonces []uint64, nonce uint64) bool {
+	for _, n := range nonces {
+		if n == nonce {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends a raw TX, a TX already known to the node is not an error
+func (m *NonceManager) send(raw []byte) error {
+	respStr, err := m.backend.SendRawTransaction(raw)
+	if err != nil {
+		return err
+	}
+	resp := ethjson.GetRPCResponseFromJSON(respStr)
+	if resp.Error != nil && !strings.Contains(strings.ToLower(resp.Error.Message), "known transaction") {
+		return fmt.Errorf("%s", resp.Error.Message)
+	}
+	return nil
+}
+
+// resend sends the TX in flight again
+func (m *NonceManager) resend(rec InflightNonce) {
+	raw, err := hexutil.Decode(rec.Raw)
+	if err == nil {
+		err = m.send(raw)
+	}
+	if err != nil {
+		log.Error("nonce: failed to send ", rec.Nonce, " again: ", err)
+		return
+	}
+	rec.Since = time.Now().Unix()
+	m.putInflight(rec)
+}
+
+// bumpGasPrice returns gas price enough to replace a TX of gasPrice in the pool,
+// raised by 10% over gasPrice or the node's
+func (m *NonceManager) bumpGasPrice(gasPrice *big.Int) *big.Int {
+	bumped := new(big.Int).SetUint64(m.backend.GetGasPrice())
+	if gasPrice != nil && gasPrice.Cmp(bumped) > 0 {
+		bumped.Set(gasPrice)
+	}
+	bumped.Add(bumped, new(big.Int).Div(bumped, big.NewInt(10)))
+	return bumped.Add(bumped, big.NewInt(1))
+}
+
+// decodeTx returns the TX of raw in hex
+func decodeTx(raw string) (*types.Transaction, error) {
+	b, err := hexutil.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(b, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// bump replaces the TX in flight with the same TX of higher gas price.
+// If gas price reached the cap, the TX is sent again as it is
+func (m *NonceManager) bump(rec InflightNonce) {
+	tx, err := decodeTx(rec.Raw)
+	if err != nil {
+		log.Error("nonce: failed to decode TX ", rec.Nonce, ": ", err)
+		return
+	}
+	gasPriceCap := m.gasPriceCap
+	if gasPriceCap == nil {
+		gasPriceCap = new(big.Int).Mul(new(big.Int).SetUint64(m.backend.GetGasPrice()), big.NewInt(defaultGasPriceCapRatio))
+	}
+	gasPrice := m.bumpGasPrice(tx.GasPrice())
+	if gasPrice.Cmp(gasPriceCap) > 0 {
+		log.Warnf("nonce: gas price of %d reached the cap %v, send %s again", rec.Nonce, gasPriceCap, rec.Hash)
+		m.resend(rec)
+		return
+	}
+
+	var unsigned *types.Transaction
+	if tx.To() == nil {
+		unsigned = types.NewContractCreation(tx.Nonce(), tx.Value(), tx.Gas(), gasPrice, tx.Data())
+	} else {
+		unsigned = types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), gasPrice, tx.Data())
+	}
+	replacement, err := m.sign(unsigned)
+	// Not signed in Apply
+	m.takeSigned(rec.Nonce)
+	if err != nil {
+		log.Error("nonce: failed to sign replacement of ", rec.Nonce, ": ", err)
+		return
+	}
+	raw, err := rlp.EncodeToBytes(replacement)
+	if err == nil {
+		err = m.send(raw)
+	}
+	if err != nil {
+		log.Error("nonce: failed to send replacement of ", rec.Nonce, ": ", err)
+		return
+	}
+	log.Infof("nonce: %s is replaced by %s, gas price %v", rec.Hash, replacement.Hash().Hex(), gasPrice)
+	m.putInflight(InflightNonce{
+		Nonce:    rec.Nonce,
+		Hash:     replacement.Hash().Hex(),
+		Raw:      hexutil.Encode(raw),
+		Since:    time.Now().Unix(),
+		NoOp:     rec.NoOp,
+		Replaces: append(rec.Replaces, rec.Hash),
+	})
+	for _, fn := range replaceHooks {
+		fn(tx, replacement)
+	}
+}
+
+// sendNoOp sends a transfer of zero to itself with the nonce.
+// To replace the TX in the pool, gas price is raised by 10% over prev or the node's
+func (m *NonceManager) sendNoOp(nonce uint64, prev *InflightNonce) {
+	var prevGasPrice *big.Int
+	if prev != nil && prev.Raw != "" {
+		if tx, err := decodeTx(prev.Raw); err == nil {
+			prevGasPrice = tx.GasPrice()
+		}
+	}
+	gasPrice := m.bumpGasPrice(prevGasPrice)
+
+	to := ethcommon.HexToAddress(m.address)
+	tx, err := m.sign(types.NewTransaction(nonce, to, new(big.Int), noopGasLimit, gasPrice, nil))
+	if err != nil {
+		log.Error("nonce: failed to sign no-op ", nonce, ": ", err)
+		return
+	}
+	raw, err := rlp.EncodeToBytes(tx)
+	if err == nil {
+		err = m.send(raw)
+	}
+	if err != nil {
+		log.Error("nonce: failed to send no-op ", nonce, ": ", err)
+		// Keep it in flight, next resync tries again
+		m.putInflight(InflightNonce{Nonce: nonce, Since: time.Now().Unix()})
+		return
+	}
+	m.putInflight(InflightNonce{
+		Nonce: nonce,
+		Hash:  tx.Hash().Hex(),
+		Raw:   hexutil.Encode(raw),
+		Since: time.Now().Unix(),
+		NoOp:  true,
+	})
+}
+
+// Status returns allocation state and nonces in flight
+func (m *NonceManager) Status() (status NonceStatus, err error) {
+	status.Address = m.address
+	if status.Latest, err = m.backend.GetTransactionCountAt(m.address, "latest"); err != nil {
+		return
+	}
+	if status.Pending, err = m.backend.GetTransactionCountAt(m.address, "pending"); err != nil {
+		return
+	}
+	value, err := m.store.Get(m.stateKey())
+	if err != nil && err != store.ErrNotFound {
+		return
+	}
+	var s nonceState
+	if value != nil {
+		if err = json.Unmarshal(value, &s); err != nil {
+			return
+		}
+	}
+	status.Next, status.Free = s.Next, s.Free
+	status.Inflight = []InflightNonce{}
+	err = m.store.Iterate(m.inflightPrefix(), func(key string, value []byte) bool {
+		var rec InflightNonce
+		if json.Unmarshal(value, &rec) == nil {
+			status.Inflight = append(status.Inflight, rec)
+		}
+		return true
+	})
+	return
+}
@@ -0,0 +1,341 @@
+package crypto
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ethjson "github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/store"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// fakeNode keeps TXs of one sender in the pool, mined only by mine()
+// sends counts TXs sent for each nonce, including rejected ones
+type fakeNode struct {
+	mu     sync.Mutex
+	latest uint64
+	pool   map[uint64]*types.Transaction
+	sends  map[uint64]int
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{pool: make(map[uint64]*types.Transaction), sends: make(map[uint64]int)}
+}
+
+func (n *fakeNode) GetTransactionCountAt(addr, block string) (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if block == "latest" {
+		return n.latest, nil
+	}
+	pending := n.latest
+	for n.pool[pending] != nil {
+		pending++
+	}
+	return pending, nil
+}
+
+func (n *fakeNode) GetGasPrice() uint64 {
+	return 1000
+}
+
+func (n *fakeNode) SendRawTransaction(raw []byte) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var tx types.Transaction
+	if err := rlp.DecodeBytes(raw, &tx); err != nil {
+		return "", err
+	}
+	n.sends[tx.Nonce()]++
+	resp := ethjson.RPCResponse{Jsonrpc: "2.0", ID: 1}
+	switch old := n.pool[tx.Nonce()]; {
+	case tx.Nonce() < n.latest:
+		resp.Error = ethjson.NewRPCError(-32000, "nonce too low")
+	case old != nil && old.Hash() == tx.Hash():
+		resp.Error = ethjson.NewRPCError(-32000, "known transaction: "+tx.Hash().Hex())
+	case old != nil && old.GasPrice().Cmp(tx.GasPrice()) >= 0:
+		resp.Error = ethjson.NewRPCError(-32000, "replacement transaction underpriced")
+	default:
+		n.pool[tx.Nonce()] = &tx
+		resp.Result = tx.Hash().Hex()
+	}
+	return resp.String(), nil
+}
+
+// mine includes pending TXs in a block
+func (n *fakeNode) mine() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for n.pool[n.latest] != nil {
+		delete(n.pool, n.latest)
+		n.latest++
+	}
+}
+
+// drop removes a TX from the pool as if the node restarted
+func (n *fakeNode) drop(nonce uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.pool, nonce)
+}
+
+func newTestNonceManager(t *testing.T, s store.Store, node *fakeNode) *NonceManager {
+	c := GetDummy()
+	m, err := NewNonceManager(c.GetAddress(), s, node, c.SignTx)
+	if err != nil {
+		t.Fatalf("Failed to create nonce manager: %v", err)
+	}
+	return m
+}
+
+// sendTx returns a function given to Apply, which signs and sends a TX like contract wrappers
+func sendTx(m *NonceManager, node *fakeNode, sendErr error) func(uint64) error {
+	return func(nonce uint64) error {
+		tx := types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(1000), nil)
+		signed, err := m.sign(tx)
+		if err != nil {
+			return err
+		}
+		m.track(signed)
+		raw, _ := rlp.EncodeToBytes(signed)
+		node.SendRawTransaction(raw)
+		return sendErr
+	}
+}
+
+func applied(m *NonceManager, fail bool) (nonce uint64, ok bool) {
+	ok = m.Apply(func(n uint64) error {
+		nonce = n
+		if fail {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+	return
+}
+
+func TestNonceManagerRestart(t *testing.T) {
+	s := store.NewMemoryStore()
+	node := newFakeNode()
+	m := newTestNonceManager(t, s, node)
+	for i := 0; i < 3; i++ {
+		if !m.Apply(sendTx(m, node, nil)) {
+			t.Fatalf("Failed to apply nonce")
+		}
+	}
+
+	// Restart while TXs are pending, nonces must not be reused
+	m = newTestNonceManager(t, s, node)
+	if nonce, _ := applied(m, false); nonce != 3 {
+		t.Errorf("Expected nonce 3 after restart, got %d", nonce)
+	}
+
+	// Nonces used by others are skipped
+	s2 := store.NewMemoryStore()
+	m = newTestNonceManager(t, s2, node)
+	if nonce, _ := applied(m, false); nonce != 3 {
+		t.Errorf("Expected nonce 3 from pending count, got %d", nonce)
+	}
+}
+
+func TestNonceManagerFailure(t *testing.T) {
+	node := newFakeNode()
+	m := newTestNonceManager(t, store.NewMemoryStore(), node)
+
+	// Failed before reaching the node, nonce is reused
+	if nonce, ok := applied(m, true); ok || nonce != 0 {
+		t.Fatalf("Expected failure with nonce 0, got %d", nonce)
+	}
+	// Failed after reaching the node, nonce is not reused
+	if m.Apply(sendTx(m, node, fmt.Errorf("timeout"))) {
+		t.Fatalf("Expected failure")
+	}
+	if nonce, _ := applied(m, false); nonce != 1 {
+		t.Errorf("Nonce reached the node must not be reused, got %d", nonce)
+	}
+
+	// Failed without reaching the pool, the nonce is kept until resync sends the TX again
+	var lost *types.Transaction
+	err := m.apply(func(nonce uint64) error {
+		lost, _ = m.sign(types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(1000), nil))
+		m.track(lost)
+		return fmt.Errorf("timeout")
+	})
+	if inflight, ok := err.(*InflightError); !ok || inflight.Hash != lost.Hash().Hex() {
+		t.Fatalf("Expected InflightError of the TX, got %v", err)
+	}
+	if nonce, _ := applied(m, false); nonce != 3 {
+		t.Errorf("Nonce of TX which may be in the pool must not be reused, got %d", nonce)
+	}
+	m.Resync()
+	if tx := node.pool[lost.Nonce()]; tx == nil || tx.Hash() != lost.Hash() {
+		t.Errorf("TX kept in flight must be sent again")
+	}
+
+	// Rejected while pending count is above the nonce, as another process on the key sent a TX
+	node = newFakeNode()
+	m = newTestNonceManager(t, store.NewMemoryStore(), node)
+	var rejected *types.Transaction
+	ok := m.Apply(func(nonce uint64) error {
+		other, _ := m.sign(types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(2000), nil))
+		raw, _ := rlp.EncodeToBytes(other)
+		node.SendRawTransaction(raw)
+		if rejected, _ = m.sign(types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(1000), []byte{1})); rejected != nil {
+			m.track(rejected)
+			raw, _ = rlp.EncodeToBytes(rejected)
+			node.SendRawTransaction(raw)
+		}
+		return ethjson.NewRPCError(-32000, "replacement transaction underpriced")
+	})
+	if ok || rejected == nil {
+		t.Fatalf("Expected failure")
+	}
+	if pending, _ := node.GetTransactionCountAt("", "pending"); pending <= rejected.Nonce() {
+		t.Fatalf("Expected pending above %d, got %d", rejected.Nonce(), pending)
+	}
+	if b, err := m.store.Get(m.inflightKey(rejected.Nonce())); err == nil && strings.Contains(string(b), rejected.Hash().Hex()) {
+		t.Errorf("TX rejected must not be kept to be sent again")
+	}
+
+	// A nonce in the pool is left to be mined, though its TX is unknown and stuck
+	node = newFakeNode()
+	m = newTestNonceManager(t, store.NewMemoryStore(), node)
+	m.Apply(sendTx(m, node, nil))
+	m.putInflight(InflightNonce{Nonce: 0, Since: time.Now().Add(-2 * nonceStuckTimeout).Unix()})
+	m.Resync()
+	if node.sends[0] != 1 {
+		t.Errorf("TX in the pool must not be replaced, sent %d times", node.sends[0])
+	}
+}
+
+func TestNonceManagerGap(t *testing.T) {
+	s := store.NewMemoryStore()
+	node := newFakeNode()
+	m1 := newTestNonceManager(t, s, node)
+	m2 := newTestNonceManager(t, s, node)
+
+	// m2 allocates nonce 1 while m1 is sending nonce 0, and nonce 0 fails
+	m1.Apply(func(nonce uint64) error {
+		m2.Apply(sendTx(m2, node, nil))
+		return fmt.Errorf("failed")
+	})
+	if pending, _ := node.GetTransactionCountAt("", "pending"); pending != 0 {
+		t.Fatalf("Expected a gap at 0, pending %d", pending)
+	}
+
+	// The gap is filled with no-op
+	if err := m1.Resync(); err != nil {
+		t.Fatalf("Failed to resync: %v", err)
+	}
+	if pending, _ := node.GetTransactionCountAt("", "pending"); pending != 2 {
+		t.Errorf("Gap must be filled, pending %d", pending)
+	}
+	if tx := node.pool[0]; tx == nil || tx.Value().Sign() != 0 || tx.Gas() != noopGasLimit {
+		t.Errorf("Gap must be filled with no-op")
+	}
+	if nonce, _ := applied(m2, false); nonce != 2 {
+		t.Errorf("Expected nonce 2, got %d", nonce)
+	}
+}
+
+func TestNonceManagerResend(t *testing.T) {
+	after := rebroadcastAfter
+	defer func() { rebroadcastAfter = after }()
+
+	node := newFakeNode()
+	m := newTestNonceManager(t, store.NewMemoryStore(), node)
+	for i := 0; i < 3; i++ {
+		m.Apply(sendTx(m, node, nil))
+	}
+	node.mine()
+	m.Apply(sendTx(m, node, nil))
+	m.Apply(sendTx(m, node, nil))
+
+	// Lost TX is sent again
+	node.drop(3)
+	m.Resync()
+	if node.pool[3] == nil || node.sends[3] != 2 {
+		t.Errorf("Lost TX must be sent again")
+	}
+	status, _ := m.Status()
+	if len(status.Inflight) != 2 || status.Inflight[0].Nonce != 3 {
+		t.Errorf("Mined nonces must be forgotten, got %v", status.Inflight)
+	}
+
+	var replaced []common.Hash
+	OnReplace(func(old, replacement *types.Transaction) {
+		replaced = append(replaced, old.Hash(), replacement.Hash())
+	})
+	defer func() { replaceHooks = nil }()
+
+	// TXs pending too long are replaced with higher gas price
+	rebroadcastAfter = -time.Second
+	prev := node.pool[3].Hash()
+	m.Resync()
+	if node.sends[3] != 3 || node.sends[4] != 3 {
+		t.Errorf("Every pending TX must be replaced, sends %v", node.sends)
+	}
+	if price := node.pool[3].GasPrice().Int64(); price != 1101 {
+		t.Errorf("Expected gas price 1101, got %d", price)
+	}
+	status, _ = m.Status()
+	if rec := status.Inflight[0]; rec.Hash != node.pool[3].Hash().Hex() || len(rec.Replaces) != 1 || rec.Replaces[0] != prev.Hex() {
+		t.Errorf("Replacement must be recorded, got %+v", rec)
+	}
+	if len(replaced) != 4 {
+		t.Errorf("Expected 2 replacements, got %v", replaced)
+	}
+
+	// Gas price reached the cap, TX is sent again as it is
+	m.SetRebroadcast(0, big.NewInt(1200))
+	m.Resync()
+	if node.sends[3] != 4 || node.pool[3].GasPrice().Int64() != 1101 || len(replaced) != 4 {
+		t.Errorf("TX must be sent again at the cap, sends %v", node.sends)
+	}
+}
+
+func TestNonceManagerConcurrent(t *testing.T) {
+	s := store.NewMemoryStore()
+	node := newFakeNode()
+	managers := []*NonceManager{newTestNonceManager(t, s, node), newTestNonceManager(t, s, node)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(m *NonceManager, fail bool) {
+			defer wg.Done()
+			if fail {
+				applied(m, true)
+				return
+			}
+			m.Apply(sendTx(m, node, nil))
+		}(managers[i%2], i%5 == 0)
+	}
+	wg.Wait()
+	managers[0].Resync()
+
+	// Every nonce is used once without gap, a gap left by failure is filled with no-op
+	pending, _ := node.GetTransactionCountAt("", "pending")
+	if int(pending) != len(node.pool) {
+		t.Errorf("Expected TXs without gap, pending %d, pool %d", pending, len(node.pool))
+	}
+	var sent int
+	for nonce, tx := range node.pool {
+		if *tx.To() == (common.Address{}) {
+			sent++
+		}
+		if node.sends[nonce] != 1 {
+			t.Errorf("Nonce %d is sent %d times", nonce, node.sends[nonce])
+		}
+	}
+	if sent != 32 {
+		t.Errorf("Expected 32 TXs sent, got %d", sent)
+	}
+}
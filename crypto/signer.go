@@ -0,0 +1,181 @@
+package crypto
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+// Signer signs with a delegator key, the key may live outside of the process
+type Signer interface {
+	// Address returns the address of the key
+	Address() common.Address
+	// SignTx signs the TX with EIP155 of the chain ID, or homestead if chain ID is nil
+	SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+	// SignText signs keccak256("\x19Ethereum Signed Message:\n"${len(data)}${data}),
+	// V of the signature is 27 or 28
+	SignText(data []byte) ([]byte, error)
+}
+
+// txSigner returns types.Signer for the chain ID
+func txSigner(chainID *big.Int) types.Signer {
+	if chainID == nil {
+		return types.HomesteadSigner{}
+	}
+	return types.NewEIP155Signer(chainID)
+}
+
+// KeySigner is a Signer with a private key in memory
+// The key is loaded from a keystore file or DB
+type KeySigner struct {
+	privKey *ecdsa.PrivateKey
+	address common.Address
+}
+
+// NewKeySigner returns a Signer of the private key
+func NewKeySigner(privKey *ecdsa.PrivateKey) *KeySigner {
+	return &KeySigner{
+		privKey: privKey,
+		address: crypto.PubkeyToAddress(privKey.PublicKey),
+	}
+}
+
+// LoadKeystoreSigners returns Signers of key json files in the path
+// The path is a file, a directory of files or a comma-separated list of them
+func LoadKeystoreSigners(path, passphrase string) ([]Signer, error) {
+	var signers []Signer
+	for _, file := range getKeyFiles(path) {
+		privkey, addr := getPrivateKeyFromFile(file, passphrase)
+		if addr == "" {
+			return nil, fmt.Errorf("Failed to parse key json %s", file)
+		}
+		signers = append(signers, NewKeySigner(privkey))
+	}
+	return signers, nil
+}
+
+// NewDBSigner returns a Signer of the key json encrypted in DB config table
+func NewDBSigner(passphrase string) (*KeySigner, error) {
+	privkey, addr := getPrivateKeyFromDB(passphrase)
+	if addr == "" {
+		return nil, fmt.Errorf("Failed to parse key json in DB")
+	}
+	return NewKeySigner(privkey), nil
+}
+
+// Address returns the address of the key
+func (s *KeySigner) Address() common.Address {
+	return s.address
+}
+
+// SignTx signs the TX
+func (s *KeySigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, txSigner(chainID), s.privKey)
+}
+
+// SignText signs the data as a message
+func (s *KeySigner) SignText(data []byte) ([]byte, error) {
+	sig, err := crypto.Sign(signHash(data), s.privKey)
+	if err != nil {
+		return nil, err
+	}
+	sig[64] += 27
+	return sig, nil
+}
+
+// Timeout of a request to external signer, it may wait for manual approval
+var externalSignerTimeout = 60 * time.Second
+
+// ExternalSigner is a Signer requesting signature to a signer process
+// over HTTP or IPC (Unix socket), with Clef compatible methods,
+// account_list, account_signTransaction and account_signData of "text/plain"
+type ExternalSigner struct {
+	client  *ethrpc.Client
+	address common.Address
+}
+
+// NewExternalSigners returns a Signer for each account of the signer
+// endpoint is a URL such as http://127.0.0.1:8550 or a path of Unix socket
+func NewExternalSigners(endpoint string) ([]Signer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), externalSignerTimeout)
+	defer cancel()
+	client, err := ethrpc.DialContext(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []common.Address
+	if err := client.CallContext(ctx, &addrs, "account_list"); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		client.Close()
+		return nil, fmt.Errorf("No account in signer %s", endpoint)
+	}
+	signers := make([]Signer, len(addrs))
+	for i, addr := range addrs {
+		signers[i] = &ExternalSigner{client: client, address: addr}
+	}
+	return signers, nil
+}
+
+// Address returns the address of the key
+func (s *ExternalSigner) Address() common.Address {
+	return s.address
+}
+
+// SignTx requests signature of the TX, and checks the signed TX is the one requested
+func (s *ExternalSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := SendTxArgs{
+		From:     s.address,
+		To:       tx.To(),
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: (*hexutil.Big)(tx.GasPrice()),
+		Value:    (*hexutil.Big)(tx.Value()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Data:     hexutil.Bytes(tx.Data()),
+		ChainID:  (*hexutil.Big)(chainID),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), externalSignerTimeout)
+	defer cancel()
+	var result SignTransactionResult
+	if err := s.client.CallContext(ctx, &result, "account_signTransaction", args); err != nil {
+		return nil, err
+	}
+
+	signed := new(types.Transaction)
+	if err := rlp.DecodeBytes(result.Raw, signed); err != nil {
+		return nil, err
+	}
+	signer := txSigner(chainID)
+	if signer.Hash(signed) != signer.Hash(tx) {
+		return nil, fmt.Errorf("Signer returned other TX %s", signed.Hash().Hex())
+	}
+	if from, err := types.Sender(signer, signed); err != nil || from != s.address {
+		return nil, fmt.Errorf("Signer returned TX not signed by %s", s.address.Hex())
+	}
+	return signed, nil
+}
+
+// SignText requests signature of the data
+func (s *ExternalSigner) SignText(data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), externalSignerTimeout)
+	defer cancel()
+	var sig hexutil.Bytes
+	if err := s.client.CallContext(ctx, &sig, "account_signData", TextPlain, s.address, hexutil.Bytes(data)); err != nil {
+		return nil, err
+	}
+	if len(sig) != 65 {
+		return nil, fmt.Errorf("signature must be 65 bytes long")
+	}
+	return sig, nil
+}
@@ -0,0 +1,183 @@
+package crypto
+
+import (
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+// otherTxSigner signs a TX other than the one requested
+type otherTxSigner struct {
+	*KeySigner
+}
+
+func (s otherTxSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	other := types.NewTransaction(tx.Nonce()+1, *tx.To(), tx.Value(), tx.Gas(), tx.GasPrice(), tx.Data())
+	return s.KeySigner.SignTx(other, chainID)
+}
+
+func newTestSignerServer(t *testing.T, signers []Signer, chainID *big.Int) *ethrpc.Server {
+	srv := ethrpc.NewServer()
+	if err := srv.RegisterName("account", NewSignerService(signers, chainID)); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func newTestKeySigner(t *testing.T) *KeySigner {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewKeySigner(key)
+}
+
+// testSigner checks TX and message signed by the signer of the key
+func testSigner(t *testing.T, signer Signer, key *KeySigner) {
+	if signer.Address() != key.Address() {
+		t.Fatalf("Expected address %s, got %s", key.Address().Hex(), signer.Address().Hex())
+	}
+
+	to := common.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")
+	tx := types.NewTransaction(7, to, big.NewInt(1), 21000, big.NewInt(1e9), []byte{1, 2, 3})
+	for _, chainID := range []*big.Int{big.NewInt(11), nil} {
+		signed, err := signer.SignTx(tx, chainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from, err := types.Sender(txSigner(chainID), signed)
+		if err != nil || from != key.Address() {
+			t.Errorf("Expected sender %s, got %s, %v", key.Address().Hex(), from.Hex(), err)
+		}
+		if signed.Nonce() != 7 || signed.Protected() != (chainID != nil) {
+			t.Errorf("Signed TX must be the one requested with chain ID %v", chainID)
+		}
+	}
+
+	msg := hexutil.Encode([]byte("metadium"))
+	sig, err := signer.SignText(hexutil.MustDecode(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, err := EcRecover(msg, hexutil.Encode(sig)); err != nil || addr != key.Address() {
+		t.Errorf("Expected recovered address %s, got %s, %v", key.Address().Hex(), addr.Hex(), err)
+	}
+}
+
+func TestKeySigner(t *testing.T) {
+	key := newTestKeySigner(t)
+	testSigner(t, key, key)
+}
+
+func TestLoadKeystoreSigners(t *testing.T) {
+	signers, err := LoadKeystoreSigners("test/testkey", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 {
+		t.Fatalf("Expected 1 signer, got %d", len(signers))
+	}
+	if _, err := LoadKeystoreSigners("test/testkey", "wrong"); err == nil {
+		t.Errorf("Wrong passphrase must fail")
+	}
+}
+
+func TestExternalSignerHTTP(t *testing.T) {
+	key := newTestKeySigner(t)
+	server := httptest.NewServer(newTestSignerServer(t, []Signer{key}, nil))
+	defer server.Close()
+
+	signers, err := NewExternalSigners(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 {
+		t.Fatalf("Expected 1 signer, got %d", len(signers))
+	}
+	testSigner(t, signers[0], key)
+}
+
+func TestExternalSignerIPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "signer.ipc")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []Signer{newTestKeySigner(t), newTestKeySigner(t)}
+	srv := newTestSignerServer(t, keys, nil)
+	go srv.ServeListener(l)
+	defer srv.Stop()
+
+	signers, err := NewExternalSigners(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("Expected 2 signers, got %d", len(signers))
+	}
+	for i := range signers {
+		testSigner(t, signers[i], keys[i].(*KeySigner))
+	}
+
+	// Accounts of the signer work as a key pool
+	c := newCrypto(signers)
+	if c.Account(keys[1].Address().Hex()) == nil {
+		t.Errorf("Signer account must be in the pool")
+	}
+	if addr, err := EcRecover(hexutil.Encode(crypto.Keccak256([]byte("msg"))), c.Sign("msg")); err != nil || addr != keys[0].Address() {
+		t.Errorf("Expected recovered address %s, got %s, %v", keys[0].Address().Hex(), addr.Hex(), err)
+	}
+}
+
+func TestExternalSignerReject(t *testing.T) {
+	key := newTestKeySigner(t)
+	to := common.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")
+	tx := types.NewTransaction(1, to, big.NewInt(0), 21000, big.NewInt(1e9), nil)
+
+	// Chain ID is fixed by the signer
+	server := httptest.NewServer(newTestSignerServer(t, []Signer{key}, big.NewInt(11)))
+	defer server.Close()
+	signers, err := NewExternalSigners(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signers[0].SignTx(tx, big.NewInt(12)); err == nil {
+		t.Errorf("TX of other chain must be rejected")
+	}
+	if _, err := signers[0].SignTx(tx, big.NewInt(11)); err != nil {
+		t.Errorf("TX of the chain must be signed, %v", err)
+	}
+	// Bindings request homestead TXs
+	if signed, err := signers[0].SignTx(tx, nil); err != nil || signed.Protected() {
+		t.Errorf("TX without chain ID must be signed as homestead, %v", err)
+	}
+	opts := newAccount(signers[0]).TransactionOpts()
+	if signed, err := opts.Signer(types.HomesteadSigner{}, key.Address(), tx); err != nil || signed.Protected() {
+		t.Errorf("TX of TransactionOpts must be signed as homestead, %v", err)
+	}
+
+	// TX other than requested is not accepted
+	other := httptest.NewServer(newTestSignerServer(t, []Signer{otherTxSigner{key}}, nil))
+	defer other.Close()
+	if signers, err = NewExternalSigners(other.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signers[0].SignTx(tx, nil); err == nil {
+		t.Errorf("TX other than requested must be rejected")
+	}
+}
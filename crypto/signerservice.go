@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/metadium/go-delegator/log"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// TextPlain is a content type of account_signData for "\x19Ethereum Signed Message" signature
+const TextPlain = "text/plain"
+
+// SendTxArgs is a TX to sign by account_signTransaction
+type SendTxArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Nonce    hexutil.Uint64  `json:"nonce"`
+	Data     hexutil.Bytes   `json:"data"`
+	ChainID  *hexutil.Big    `json:"chainId,omitempty"`
+}
+
+// SignTransactionResult is a result of account_signTransaction
+type SignTransactionResult struct {
+	Raw hexutil.Bytes      `json:"raw"`
+	Tx  *types.Transaction `json:"tx"`
+}
+
+// SignerService serves "account" namespace of external signer with local Signers
+// It's used by the reference signer daemon, cmd/signer
+type SignerService struct {
+	signers map[common.Address]Signer
+	addrs   []common.Address
+	chainID *big.Int
+}
+
+// NewSignerService returns a service of the signers
+// TX with a chain ID is signed only for chainID if given, TX without a chain ID is signed as homestead
+func NewSignerService(signers []Signer, chainID *big.Int) *SignerService {
+	s := &SignerService{
+		signers: make(map[common.Address]Signer),
+		chainID: chainID,
+	}
+	for _, signer := range signers {
+		s.signers[signer.Address()] = signer
+		s.addrs = append(s.addrs, signer.Address())
+	}
+	return s
+}
+
+func (s *SignerService) signer(addr common.Address) (Signer, error) {
+	signer, ok := s.signers[addr]
+	if !ok {
+		return nil, fmt.Errorf("Unknown account %s", addr.Hex())
+	}
+	return signer, nil
+}
+
+// List returns addresses of the signers
+func (s *SignerService) List() []common.Address {
+	return s.addrs
+}
+
+// SignTransaction signs the TX with the key of "from"
+func (s *SignerService) SignTransaction(args SendTxArgs) (*SignTransactionResult, error) {
+	signer, err := s.signer(args.From)
+	if err != nil {
+		return nil, err
+	}
+	// Bindings sign homestead TXs without a chain ID, and ExternalSigner verifies them so
+	chainID := (*big.Int)(args.ChainID)
+	if s.chainID != nil && chainID != nil && chainID.Cmp(s.chainID) != 0 {
+		return nil, fmt.Errorf("Chain ID %v is not allowed", chainID)
+	}
+	if args.GasPrice == nil || args.Value == nil {
+		return nil, fmt.Errorf("gasPrice and value are required")
+	}
+
+	var tx *types.Transaction
+	if args.To == nil {
+		tx = types.NewContractCreation(uint64(args.Nonce), (*big.Int)(args.Value), uint64(args.Gas), (*big.Int)(args.GasPrice), args.Data)
+	} else {
+		tx = types.NewTransaction(uint64(args.Nonce), *args.To, (*big.Int)(args.Value), uint64(args.Gas), (*big.Int)(args.GasPrice), args.Data)
+	}
+	signed, err := signer.SignTx(tx, chainID)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := rlp.EncodeToBytes(signed)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("signer: signed TX %s of %s, nonce %d", signed.Hash().Hex(), args.From.Hex(), args.Nonce)
+	return &SignTransactionResult{Raw: raw, Tx: signed}, nil
+}
+
+// SignData signs the data with the key of the address, only "text/plain" is supported
+func (s *SignerService) SignData(contentType string, addr common.Address, data hexutil.Bytes) (hexutil.Bytes, error) {
+	if contentType != TextPlain {
+		return nil, fmt.Errorf("Content type %s is not supported", contentType)
+	}
+	signer, err := s.signer(addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("signer: signed data of %s", addr.Hex())
+	return signer.SignText(data)
+}
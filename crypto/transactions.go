@@ -64,8 +64,7 @@ func VerifyProof(txs []common.Hash, tr *trie.Trie) (bool, error) {
 }
 
 // GetTransactionOpts returns TransactOpts to create contract session
+// It's signed by the first account, use Account.TransactionOpts for other accounts
 func GetTransactionOpts() *bind.TransactOpts {
-	ins := GetInstance()
-	auth := bind.NewKeyedTransactor(ins.privKey)
-	return auth
+	return GetInstance().primary().TransactionOpts()
 }
@@ -130,3 +130,8 @@ func (d *DynamoDBHelper) UnmarshalMap(in map[string]*dynamodb.AttributeValue, ou
 		log.Error("db: failed to unmarshalMap of dynamoDb output")
 	}
 }
+
+// Client returns DynamoDB client for operations not covered by the helper
+func (d *DynamoDBHelper) Client() *dynamodb.DynamoDB {
+	return d.client
+}
@@ -0,0 +1,83 @@
+// Package did resolves did:meta identifiers of Metadium identities into W3C DID documents
+package did
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Method is the DID method of Metadium identities
+const Method = "meta"
+
+// Networks of did:meta identifiers, mainnet DIDs may omit the network
+const (
+	// Mainnet is the network name of mainnet
+	Mainnet = "mainnet"
+	// Testnet is the network name of testnet
+	Testnet = "testnet"
+)
+
+// Error codes of DID resolution metadata
+const (
+	// ErrInvalidDid means the DID is not a valid did:meta identifier
+	ErrInvalidDid = "invalidDid"
+	// ErrNotFound means the DID does not exist on the network
+	ErrNotFound = "notFound"
+	// ErrMethodNotSupported means the DID method is not meta
+	ErrMethodNotSupported = "methodNotSupported"
+	// ErrInternal means the identity could not be read from the chain
+	ErrInternal = "internalError"
+)
+
+// An EIN is written as 64 hex digits, zero padded
+var specificID = regexp.MustCompile(`^(?:([a-z]+):)?([0-9a-fA-F]{64})$`)
+
+// DID is a parsed did:meta identifier
+type DID struct {
+	Network string
+	EIN     *big.Int
+}
+
+// String returns the DID in the canonical form, mainnet without network
+func (d *DID) String() string {
+	if d.Network == Mainnet {
+		return fmt.Sprintf("did:%s:%064x", Method, d.EIN)
+	}
+	return fmt.Sprintf("did:%s:%s:%064x", Method, d.Network, d.EIN)
+}
+
+// Error is an error of DID resolution with its code in resolution metadata
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Parse parses did:meta:[network:]<EIN in 64 hex digits>
+func Parse(s string) (*DID, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] != "did" || parts[1] == "" {
+		return nil, &Error{ErrInvalidDid, fmt.Sprintf("%q is not a DID", s)}
+	}
+	if parts[1] != Method {
+		return nil, &Error{ErrMethodNotSupported, fmt.Sprintf("DID method %q is not supported", parts[1])}
+	}
+	m := specificID.FindStringSubmatch(parts[2])
+	if m == nil {
+		return nil, &Error{ErrInvalidDid, fmt.Sprintf("%q is not a did:meta identifier", s)}
+	}
+	network := m[1]
+	if network == "" {
+		network = Mainnet
+	}
+	ein, _ := new(big.Int).SetString(m[2], 16)
+	if ein.Sign() == 0 {
+		return nil, &Error{ErrInvalidDid, "EIN must be positive"}
+	}
+	return &DID{Network: network, EIN: ein}, nil
+}
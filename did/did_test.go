@@ -0,0 +1,248 @@
+package did
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethCrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
+	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+)
+
+// timedChain gives the simulated chain block times, 10 seconds a block
+type timedChain struct {
+	*backends.SimulatedBackend
+}
+
+func (c timedChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: number, Time: new(big.Int).Mul(number, big.NewInt(10))}, nil
+}
+
+// fixture is a simulated chain with identities.
+// EIN 1 is of user with a public key and a service key, EIN 2 is destroyed after recovery
+type fixture struct {
+	chain     timedChain
+	registry  common.Address
+	skr, pkr  common.Address
+	user      *ecdsa.PrivateKey
+	service   common.Address
+	destroyed *ecdsa.PrivateKey
+}
+
+func newFixture(t *testing.T) *fixture {
+	f := &fixture{}
+	deployer, _ := ethCrypto.GenerateKey()
+	f.user, _ = ethCrypto.GenerateKey()
+	f.destroyed, _ = ethCrypto.GenerateKey()
+	recovered, _ := ethCrypto.GenerateKey()
+	serviceKey, _ := ethCrypto.GenerateKey()
+	f.service = ethCrypto.PubkeyToAddress(serviceKey.PublicKey)
+
+	balance := new(big.Int).Mul(big.NewInt(1e18), big.NewInt(100))
+	alloc := core.GenesisAlloc{}
+	for _, key := range []*ecdsa.PrivateKey{deployer, f.user, f.destroyed} {
+		alloc[ethCrypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: balance}
+	}
+	f.chain = timedChain{backends.NewSimulatedBackend(alloc)}
+	auth := func(key *ecdsa.PrivateKey) *bind.TransactOpts {
+		return bind.NewKeyedTransactor(key)
+	}
+	must := func(step string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		f.chain.Commit()
+	}
+
+	var err error
+	var ir *identityregistry.Identityregistry
+	f.registry, _, ir, err = identityregistry.DeployIdentityregistry(auth(deployer), f.chain)
+	must("deploy IdentityRegistry", err)
+	var sk *servicekeyresolver.Servicekeyresolver
+	f.skr, _, sk, err = servicekeyresolver.DeployServicekeyresolver(auth(deployer), f.chain, f.registry)
+	must("deploy ServiceKeyResolver", err)
+	var pk *publickeyresolver.Publickeyresolver
+	f.pkr, _, pk, err = publickeyresolver.DeployPublickeyresolver(auth(deployer), f.chain, f.registry)
+	must("deploy PublicKeyResolver", err)
+
+	_, err = ir.CreateIdentity(auth(f.user), ethCrypto.PubkeyToAddress(deployer.PublicKey), nil, []common.Address{f.skr, f.pkr})
+	must("create EIN 1", err)
+	_, err = pk.AddPublicKey(auth(f.user), ethCrypto.FromECDSAPub(&f.user.PublicKey)[1:])
+	must("add public key", err)
+	_, err = sk.AddKey(auth(f.user), f.service, "service")
+	must("add service key", err)
+
+	_, err = ir.CreateIdentity(auth(f.destroyed), ethCrypto.PubkeyToAddress(deployer.PublicKey), nil, nil)
+	must("create EIN 2", err)
+	// recovery is allowed once the recovery timeout has passed since creation of the registry
+	recoveryTimeout, err := ir.RecoveryTimeout(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := new(big.Int).Add(recoveryTimeout, big.NewInt(3600))
+	f.chain.AdjustTime(time.Duration(timestamp.Int64()) * time.Second)
+	f.chain.Commit()
+	newAddress := ethCrypto.PubkeyToAddress(recovered.PublicKey)
+	var data []byte
+	data = append(data, 0x19, 0x00)
+	data = append(data, f.registry.Bytes()...)
+	data = append(data, []byte("I authorize being added to this Identity via recovery.")...)
+	data = append(data, common.LeftPadBytes(big.NewInt(2).Bytes(), 32)...)
+	data = append(data, newAddress.Bytes()...)
+	data = append(data, common.LeftPadBytes(timestamp.Bytes(), 32)...)
+	sig, _ := ethCrypto.Sign(ethCrypto.Keccak256(data), recovered)
+	var r, s [32]byte
+	copy(r[:], sig[:32])
+	copy(s[:], sig[32:64])
+	_, err = ir.TriggerRecovery(auth(deployer), big.NewInt(2), newAddress, sig[64]+27, r, s, timestamp)
+	must("trigger recovery of EIN 2", err)
+	_, err = ir.TriggerDestruction(auth(f.destroyed), big.NewInt(2), nil, nil, true)
+	must("destroy EIN 2", err)
+
+	return f
+}
+
+func (f *fixture) resolver(t *testing.T) *Resolver {
+	resolver, err := NewResolver(Testnet, big.NewInt(12), f.chain, f.registry, []common.Address{f.skr}, []common.Address{f.pkr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver
+}
+
+func TestParse(t *testing.T) {
+	ein := strings.Repeat("0", 60) + "112b"
+	for s, expected := range map[string]string{
+		"did:meta:" + ein:                     "did:meta:" + ein,
+		"did:meta:testnet:" + ein:             "did:meta:testnet:" + ein,
+		"did:meta:mainnet:" + ein:             "did:meta:" + ein,
+		"did:meta:" + ein[1:]:                 ErrInvalidDid,
+		"did:meta:testnet:0x" + ein:           ErrInvalidDid,
+		"did:meta:" + strings.Repeat("0", 64): ErrInvalidDid,
+		"did:ethr:" + ein:                     ErrMethodNotSupported,
+		"meta:" + ein:                         ErrInvalidDid,
+	} {
+		d, err := Parse(s)
+		if err != nil {
+			if code := err.(*Error).Code; code != expected {
+				t.Errorf("Expected %s for %s, got %s", expected, s, code)
+			}
+			continue
+		}
+		if d.String() != expected || d.EIN.Int64() != 0x112b {
+			t.Errorf("Expected %s for %s, got %s", expected, s, d)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	f := newFixture(t)
+	resolver := f.resolver(t)
+	userAddress := ethCrypto.PubkeyToAddress(f.user.PublicKey)
+	id := fmt.Sprintf("did:meta:testnet:%064x", 1)
+
+	result := resolver.Resolve(context.Background(), id)
+	if result.ResolutionMetadata.Error != "" {
+		t.Fatalf("Unexpected error %s: %s", result.ResolutionMetadata.Error, result.ResolutionMetadata.ErrorMessage)
+	}
+	if result.ResolutionMetadata.ContentType != DocumentContentType || result.DocumentMetadata.Deactivated || result.DocumentMetadata.Created == "" {
+		t.Errorf("Unexpected metadata %+v %+v", result.ResolutionMetadata, result.DocumentMetadata)
+	}
+	doc := result.Document
+	if doc.ID != id || len(doc.VerificationMethod) != 3 {
+		t.Fatalf("Unexpected document %+v", doc)
+	}
+	addr, pub, service := doc.VerificationMethod[0], doc.VerificationMethod[1], doc.VerificationMethod[2]
+	if addr.Type != RecoveryMethod || addr.BlockchainAccountID != "eip155:12:"+userAddress.Hex() || addr.Controller != id {
+		t.Errorf("Unexpected associated address %+v", addr)
+	}
+	if pub.Type != VerificationKey || pub.PublicKeyHex != fmt.Sprintf("%x", ethCrypto.FromECDSAPub(&f.user.PublicKey)[1:]) {
+		t.Errorf("Unexpected public key %+v", pub)
+	}
+	if service.Symbol != "service" || service.BlockchainAccountID != "eip155:12:"+f.service.Hex() {
+		t.Errorf("Unexpected service key %+v", service)
+	}
+	if len(doc.Authentication) != 2 || doc.Authentication[0] != addr.ID || doc.Authentication[1] != pub.ID ||
+		len(doc.AssertionMethod) != 1 || doc.AssertionMethod[0] != service.ID {
+		t.Errorf("Unexpected relationships %v %v", doc.Authentication, doc.AssertionMethod)
+	}
+
+	// keys of resolvers not known are not in the document
+	unknown, _ := NewResolver(Testnet, big.NewInt(12), f.chain, f.registry, nil, nil)
+	if doc := unknown.Resolve(context.Background(), id).Document; len(doc.VerificationMethod) != 1 {
+		t.Errorf("Expected associated address only, got %+v", doc.VerificationMethod)
+	}
+
+	destroyed := resolver.Resolve(context.Background(), fmt.Sprintf("did:meta:testnet:%064x", 2))
+	if destroyed.ResolutionMetadata.Error != "" || !destroyed.DocumentMetadata.Deactivated || destroyed.DocumentMetadata.Updated == "" ||
+		len(destroyed.Document.VerificationMethod) != 0 {
+		t.Errorf("Expected deactivated, got %+v %+v", destroyed.Document, destroyed.DocumentMetadata)
+	}
+
+	for s, expected := range map[string]string{
+		fmt.Sprintf("did:meta:testnet:%064x", 3): ErrNotFound,
+		fmt.Sprintf("did:meta:%064x", 1):         ErrNotFound,
+		"did:meta:testnet:1":                     ErrInvalidDid,
+	} {
+		result := resolver.Resolve(context.Background(), s)
+		if result.ResolutionMetadata.Error != expected || result.Document != nil {
+			t.Errorf("Expected %s for %s, got %+v", expected, s, result)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	f := newFixture(t)
+	server := httptest.NewServer(NewHandler(f.resolver(t).Resolve))
+	defer server.Close()
+
+	get := func(id, accept string) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", server.URL+HTTPPath+id, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+
+	id := fmt.Sprintf("did:meta:testnet:%064x", 1)
+	resp, body := get(id, "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != ResultContentType || body["didDocument"] == nil {
+		t.Errorf("Unexpected resolution result %d %v", resp.StatusCode, body)
+	}
+	resp, body = get(id, DocumentContentType)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != DocumentContentType || body["id"] != id {
+		t.Errorf("Unexpected document %d %v", resp.StatusCode, body)
+	}
+
+	for id, status := range map[string]int{
+		fmt.Sprintf("did:meta:testnet:%064x", 2): http.StatusGone,
+		fmt.Sprintf("did:meta:testnet:%064x", 3): http.StatusNotFound,
+		"did:meta:testnet:1":                     http.StatusBadRequest,
+		"did:ethr:0x01":                          http.StatusNotImplemented,
+	} {
+		if resp, _ := get(id, ""); resp.StatusCode != status {
+			t.Errorf("Expected %d for %s, got %d", status, id, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package did
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// JSON-LD contexts of DID documents
+var documentContext = []string{
+	"https://www.w3.org/ns/did/v1",
+	"https://w3id.org/security/suites/secp256k1recovery-2020/v2",
+	"https://w3id.org/security/suites/secp256k1-2019/v1",
+}
+
+// Types of verification methods
+const (
+	// RecoveryMethod is an address verified by recovering signatures
+	RecoveryMethod = "EcdsaSecp256k1RecoveryMethod2020"
+	// VerificationKey is a secp256k1 public key
+	VerificationKey = "EcdsaSecp256k1VerificationKey2019"
+)
+
+// Document is a DID document
+type Document struct {
+	Context            []string             `json:"@context"`
+	ID                 string               `json:"id"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod,omitempty"`
+	Authentication     []string             `json:"authentication,omitempty"`
+	AssertionMethod    []string             `json:"assertionMethod,omitempty"`
+}
+
+// VerificationMethod is a key of the DID.
+// Associated addresses and their public keys authenticate, service keys assert with their symbols
+type VerificationMethod struct {
+	ID                  string `json:"id"`
+	Type                string `json:"type"`
+	Controller          string `json:"controller"`
+	BlockchainAccountID string `json:"blockchainAccountId,omitempty"`
+	PublicKeyHex        string `json:"publicKeyHex,omitempty"`
+	Symbol              string `json:"symbol,omitempty"`
+}
+
+// ServiceKey is a service key of the identity with its symbol
+type ServiceKey struct {
+	Key    common.Address
+	Symbol string
+}
+
+// documentBuilder builds a DID document, keys are added in the order of the identity
+type documentBuilder struct {
+	chainID *big.Int
+	doc     *Document
+}
+
+func newDocumentBuilder(id string, chainID *big.Int) *documentBuilder {
+	return &documentBuilder{chainID: chainID, doc: &Document{Context: documentContext, ID: id}}
+}
+
+// accountID returns CAIP-10 account ID of the address
+func (b *documentBuilder) accountID(address common.Address) string {
+	return fmt.Sprintf("eip155:%v:%s", b.chainID, address.Hex())
+}
+
+func (b *documentBuilder) fragment(kind string, address common.Address) string {
+	return fmt.Sprintf("%s#%s-%s", b.doc.ID, kind, strings.ToLower(address.Hex()))
+}
+
+func (b *documentBuilder) addAssociatedAddress(address common.Address) {
+	id := b.fragment("addr", address)
+	b.doc.VerificationMethod = append(b.doc.VerificationMethod, VerificationMethod{
+		ID:                  id,
+		Type:                RecoveryMethod,
+		Controller:          b.doc.ID,
+		BlockchainAccountID: b.accountID(address),
+	})
+	b.doc.Authentication = append(b.doc.Authentication, id)
+}
+
+func (b *documentBuilder) addPublicKey(address common.Address, publicKey []byte) {
+	id := b.fragment("pubkey", address)
+	b.doc.VerificationMethod = append(b.doc.VerificationMethod, VerificationMethod{
+		ID:           id,
+		Type:         VerificationKey,
+		Controller:   b.doc.ID,
+		PublicKeyHex: strings.TrimPrefix(hexutil.Encode(publicKey), "0x"),
+	})
+	b.doc.Authentication = append(b.doc.Authentication, id)
+}
+
+func (b *documentBuilder) addServiceKey(key ServiceKey) {
+	id := b.fragment("servicekey", key.Key)
+	b.doc.VerificationMethod = append(b.doc.VerificationMethod, VerificationMethod{
+		ID:                  id,
+		Type:                RecoveryMethod,
+		Controller:          b.doc.ID,
+		BlockchainAccountID: b.accountID(key.Key),
+		Symbol:              key.Symbol,
+	})
+	b.doc.AssertionMethod = append(b.doc.AssertionMethod, id)
+}
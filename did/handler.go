@@ -0,0 +1,73 @@
+package did
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPPath is the path of DID resolution over HTTP, followed by the DID
+const HTTPPath = "/1.0/identifiers/"
+
+// ResolveFunc resolves a DID
+type ResolveFunc func(ctx context.Context, did string) *Result
+
+// Response returns the HTTP response of the result.
+// The document only is returned if accept prefers it, the whole result otherwise
+func Response(result *Result, accept string) (body []byte, contentType string, statusCode int) {
+	statusCode = http.StatusOK
+	switch result.ResolutionMetadata.Error {
+	case "":
+		if result.DocumentMetadata.Deactivated {
+			statusCode = http.StatusGone
+		}
+	case ErrInvalidDid:
+		statusCode = http.StatusBadRequest
+	case ErrNotFound:
+		statusCode = http.StatusNotFound
+	case ErrMethodNotSupported:
+		statusCode = http.StatusNotImplemented
+	default:
+		statusCode = http.StatusInternalServerError
+	}
+
+	if result.Document != nil && strings.Contains(accept, DocumentContentType) {
+		body, _ = json.Marshal(result.Document)
+		return body, DocumentContentType, statusCode
+	}
+	body, _ = json.Marshal(result)
+	return body, ResultContentType, statusCode
+}
+
+// PathDID returns the DID in the path of HTTP resolution, false if the path is not
+func PathDID(path string) (string, bool) {
+	if !strings.HasPrefix(path, HTTPPath) {
+		return "", false
+	}
+	did, err := url.PathUnescape(strings.TrimPrefix(path, HTTPPath))
+	if err != nil {
+		return "", false
+	}
+	return did, true
+}
+
+// NewHandler returns a handler of GET HTTPPath + DID
+func NewHandler(resolve ResolveFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		did, ok := PathDID(r.URL.EscapedPath())
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, contentType, statusCode := Response(resolve(r.Context(), did), r.Header.Get("Accept"))
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(statusCode)
+		w.Write(body)
+	})
+}
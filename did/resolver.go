@@ -0,0 +1,255 @@
+package did
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
+	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Content types of DID resolution
+const (
+	// DocumentContentType is the content type of a DID document
+	DocumentContentType = "application/did+ld+json"
+	// ResultContentType is the content type of a DID resolution result
+	ResultContentType = `application/ld+json;profile="https://w3id.org/did-resolution"`
+)
+
+const resultContext = "https://w3id.org/did-resolution/v1"
+
+// Result is a DID resolution result
+type Result struct {
+	Context            string             `json:"@context"`
+	Document           *Document          `json:"didDocument"`
+	ResolutionMetadata ResolutionMetadata `json:"didResolutionMetadata"`
+	DocumentMetadata   DocumentMetadata   `json:"didDocumentMetadata"`
+}
+
+// ResolutionMetadata is metadata of the resolution process
+type ResolutionMetadata struct {
+	ContentType  string `json:"contentType,omitempty"`
+	Retrieved    string `json:"retrieved"`
+	Duration     int64  `json:"duration"`
+	Error        string `json:"error,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+// DocumentMetadata is metadata of the DID document, times are of blocks in RFC3339
+type DocumentMetadata struct {
+	Created     string `json:"created,omitempty"`
+	Updated     string `json:"updated,omitempty"`
+	Deactivated bool   `json:"deactivated,omitempty"`
+}
+
+// headerReader reads block times for document metadata, ethclient.Client implements it
+type headerReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// Resolver resolves DIDs of a network from IdentityRegistry and resolvers on it
+type Resolver struct {
+	network             string
+	chainID             *big.Int
+	backend             bind.ContractBackend
+	registry            *identityregistry.Identityregistry
+	serviceKeyResolvers []common.Address
+	publicKeyResolvers  []common.Address
+}
+
+// NewResolver returns a resolver of DIDs on the network.
+// Keys are read from the resolvers given which the identity has added
+func NewResolver(network string, chainID *big.Int, backend bind.ContractBackend, registry common.Address, serviceKeyResolvers, publicKeyResolvers []common.Address) (*Resolver, error) {
+	instance, err := identityregistry.NewIdentityregistry(registry, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{
+		network:             network,
+		chainID:             chainID,
+		backend:             backend,
+		registry:            instance,
+		serviceKeyResolvers: serviceKeyResolvers,
+		publicKeyResolvers:  publicKeyResolvers,
+	}, nil
+}
+
+// Resolve resolves the DID, errors are reported in the resolution metadata
+func (r *Resolver) Resolve(ctx context.Context, s string) *Result {
+	start := time.Now()
+	result := &Result{Context: resultContext}
+	if err := r.resolve(ctx, s, result); err != nil {
+		result.Document = nil
+		result.DocumentMetadata = DocumentMetadata{}
+		if didErr, ok := err.(*Error); ok {
+			result.ResolutionMetadata.Error = didErr.Code
+		} else {
+			result.ResolutionMetadata.Error = ErrInternal
+		}
+		result.ResolutionMetadata.ErrorMessage = err.Error()
+	} else {
+		result.ResolutionMetadata.ContentType = DocumentContentType
+	}
+	result.ResolutionMetadata.Retrieved = start.UTC().Format(time.RFC3339)
+	result.ResolutionMetadata.Duration = int64(time.Since(start) / time.Millisecond)
+	return result
+}
+
+func (r *Resolver) resolve(ctx context.Context, s string, result *Result) error {
+	d, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	if d.Network != r.network {
+		return &Error{ErrNotFound, fmt.Sprintf("Network %q is not served", d.Network)}
+	}
+	id := d.String()
+
+	opts := &bind.CallOpts{Context: ctx}
+	exists, err := r.registry.IdentityExists(opts, d.EIN)
+	if err != nil {
+		return err
+	}
+	created, err := r.createdAt(ctx, d.EIN)
+	if err != nil {
+		return err
+	}
+	destroyed, err := r.destroyedAt(ctx, d.EIN)
+	if err != nil {
+		return err
+	}
+	if !exists && created == nil {
+		return &Error{ErrNotFound, fmt.Sprintf("Identity %v does not exist", d.EIN)}
+	}
+	result.DocumentMetadata.Created = r.blockTime(ctx, created)
+
+	// A destroyed identity has no associated address and its DID is deactivated
+	var identity identityregistry.Identity
+	if exists && destroyed == nil {
+		identity, err = r.registry.GetIdentity(opts, d.EIN)
+		if err != nil {
+			return err
+		}
+	}
+	builder := newDocumentBuilder(id, r.chainID)
+	result.Document = builder.doc
+	if len(identity.AssociatedAddresses) == 0 {
+		result.DocumentMetadata.Deactivated = true
+		result.DocumentMetadata.Updated = r.blockTime(ctx, destroyed)
+		return nil
+	}
+
+	for _, address := range identity.AssociatedAddresses {
+		builder.addAssociatedAddress(address)
+	}
+	for _, resolver := range identity.Resolvers {
+		if contains(r.publicKeyResolvers, resolver) {
+			if err := r.addPublicKeys(opts, resolver, identity.AssociatedAddresses, builder); err != nil {
+				return err
+			}
+		}
+	}
+	for _, resolver := range identity.Resolvers {
+		if contains(r.serviceKeyResolvers, resolver) {
+			if err := r.addServiceKeys(opts, resolver, d.EIN, builder); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) addPublicKeys(opts *bind.CallOpts, resolver common.Address, addresses []common.Address, builder *documentBuilder) error {
+	instance, err := publickeyresolver.NewPublickeyresolver(resolver, r.backend)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		publicKey, err := instance.GetPublicKey(opts, address)
+		if err != nil {
+			return err
+		}
+		if len(publicKey) > 0 {
+			builder.addPublicKey(address, publicKey)
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) addServiceKeys(opts *bind.CallOpts, resolver common.Address, ein *big.Int, builder *documentBuilder) error {
+	instance, err := servicekeyresolver.NewServicekeyresolver(resolver, r.backend)
+	if err != nil {
+		return err
+	}
+	keys, err := instance.GetKeys(opts, ein)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		symbol, err := instance.GetSymbol(opts, key)
+		if err != nil {
+			return err
+		}
+		builder.addServiceKey(ServiceKey{Key: key, Symbol: symbol})
+	}
+	return nil
+}
+
+// createdAt returns the log of IdentityCreated of the EIN, nil if not created
+func (r *Resolver) createdAt(ctx context.Context, ein *big.Int) (*types.Log, error) {
+	it, err := r.registry.FilterIdentityCreated(&bind.FilterOpts{Context: ctx}, nil, []*big.Int{ein})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var last *types.Log
+	for it.Next() {
+		last = &it.Event.Raw
+	}
+	return last, it.Error()
+}
+
+// destroyedAt returns the log of IdentityDestroyed of the EIN, nil if not destroyed
+func (r *Resolver) destroyedAt(ctx context.Context, ein *big.Int) (*types.Log, error) {
+	it, err := r.registry.FilterIdentityDestroyed(&bind.FilterOpts{Context: ctx}, nil, []*big.Int{ein})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var last *types.Log
+	for it.Next() {
+		last = &it.Event.Raw
+	}
+	return last, it.Error()
+}
+
+// blockTime returns the time of the block of the log, empty if not known
+func (r *Resolver) blockTime(ctx context.Context, l *types.Log) string {
+	reader, ok := r.backend.(headerReader)
+	if l == nil || !ok {
+		return ""
+	}
+	header, err := reader.HeaderByNumber(ctx, new(big.Int).SetUint64(l.BlockNumber))
+	if err != nil {
+		return ""
+	}
+	return time.Unix(header.Time.Int64(), 0).UTC().Format(time.RFC3339)
+}
+
+func contains(addresses []common.Address, address common.Address) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
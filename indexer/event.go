@@ -0,0 +1,226 @@
+package indexer
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
+	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+	"github.com/metadium/go-delegator/metaservice/sc/identity"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Contracts of events
+const (
+	// IdentityRegistry is the contract name of IdentityRegistry events
+	IdentityRegistry = "IdentityRegistry"
+	// ServiceKeyResolver is the contract name of ServiceKeyResolver events
+	ServiceKeyResolver = "ServiceKeyResolver"
+	// PublicKeyResolver is the contract name of PublicKeyResolver events
+	PublicKeyResolver = "PublicKeyResolver"
+	// MetaID is the contract name of MetaID events, only ClaimAdded is indexed
+	MetaID = "MetaID"
+)
+
+// Event is a contract event indexed.
+// Args are named in snake case, addresses and numbers are strings
+type Event struct {
+	Contract    string                 `json:"contract"`
+	Address     string                 `json:"address"`
+	Event       string                 `json:"event"`
+	EIN         string                 `json:"ein"`
+	Args        map[string]interface{} `json:"args"`
+	BlockNumber uint64                 `json:"block_number"`
+	BlockHash   string                 `json:"block_hash"`
+	TxHash      string                 `json:"tx_hash"`
+	LogIndex    uint                   `json:"log_index"`
+	// Subjects are addresses the event is about, looked up by get_address_history
+	Subjects []string `json:"subjects,omitempty"`
+}
+
+// Arguments not indexed by address, delegator addresses are in most events
+var notSubject = map[string]bool{
+	"initiator": true,
+	"provider":  true,
+	"providers": true,
+	"resolvers": true,
+}
+
+// eventSpec is an event of a contract
+type eventSpec struct {
+	contract string
+	event    abi.Event
+}
+
+// decoder decodes logs of contracts by the first topic
+type decoder map[common.Hash]eventSpec
+
+func newDecoder() (decoder, error) {
+	d := make(decoder)
+	for _, c := range []struct {
+		contract   string
+		definition string
+		// events are names of events indexed, every event if empty
+		events []string
+	}{
+		{IdentityRegistry, identityregistry.IdentityregistryABI, nil},
+		{ServiceKeyResolver, servicekeyresolver.ServicekeyresolverABI, nil},
+		{PublicKeyResolver, publickeyresolver.PublickeyresolverABI, nil},
+		{MetaID, identity.IdentityABI, []string{"ClaimAdded"}},
+	} {
+		parsed, err := abi.JSON(strings.NewReader(c.definition))
+		if err != nil {
+			return nil, err
+		}
+		for name, event := range parsed.Events {
+			if len(c.events) == 0 || contains(c.events, name) {
+				d[event.Id()] = eventSpec{c.contract, event}
+			}
+		}
+	}
+	return d, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// EventNames returns names of events indexed, sorted
+func EventNames() []string {
+	d, err := newDecoder()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(d))
+	seen := make(map[string]bool)
+	for _, spec := range d {
+		if !seen[spec.event.Name] {
+			seen[spec.event.Name] = true
+			names = append(names, spec.event.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decode returns the event of the log, nil if not known
+func (d decoder) decode(l types.Log) (*Event, error) {
+	if len(l.Topics) == 0 {
+		return nil, nil
+	}
+	spec, ok := d[l.Topics[0]]
+	if !ok {
+		return nil, nil
+	}
+	values, err := spec.event.Inputs.UnpackValues(l.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := &Event{
+		Contract:    spec.contract,
+		Address:     l.Address.Hex(),
+		Event:       spec.event.Name,
+		Args:        make(map[string]interface{}),
+		BlockNumber: l.BlockNumber,
+		BlockHash:   l.BlockHash.Hex(),
+		TxHash:      l.TxHash.Hex(),
+		LogIndex:    l.Index,
+	}
+	topics := l.Topics[1:]
+	for _, arg := range spec.event.Inputs {
+		var value interface{}
+		if arg.Indexed {
+			if len(topics) == 0 {
+				return nil, fmt.Errorf("indexer: missing topic %s of %s", arg.Name, spec.event.Name)
+			}
+			value = topicValue(arg.Type, topics[0])
+			topics = topics[1:]
+		} else {
+			value = values[0]
+			values = values[1:]
+		}
+
+		switch v := value.(type) {
+		case common.Address:
+			if !notSubject[arg.Name] {
+				ev.Subjects = append(ev.Subjects, v.Hex())
+			}
+		case []common.Address:
+			if !notSubject[arg.Name] {
+				for _, a := range v {
+					ev.Subjects = append(ev.Subjects, a.Hex())
+				}
+			}
+		}
+		if arg.Name == "ein" {
+			ev.EIN = fmt.Sprint(value)
+		}
+		ev.Args[snakeCase(arg.Name)] = jsonValue(value)
+	}
+	return ev, nil
+}
+
+// topicValue returns the value of an indexed argument
+func topicValue(t abi.Type, topic common.Hash) interface{} {
+	switch t.T {
+	case abi.AddressTy:
+		return common.BytesToAddress(topic[12:])
+	case abi.UintTy, abi.IntTy:
+		return new(big.Int).SetBytes(topic[:])
+	case abi.BoolTy:
+		return topic[31] == 1
+	}
+	return topic
+}
+
+// jsonValue returns the value kept as is in JSON
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case common.Address:
+		return v.Hex()
+	case []common.Address:
+		addresses := make([]string, len(v))
+		for i, a := range v {
+			addresses[i] = a.Hex()
+		}
+		return addresses
+	case *big.Int:
+		return v.String()
+	case []byte:
+		return hexutil.Encode(v)
+	case common.Hash:
+		return v.Hex()
+	case bool, string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// snakeCase converts an argument name such as oldRecoveryAddress to old_recovery_address
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,631 @@
+// Package indexer follows events of IdentityRegistry and resolvers, and claims of MetaIDs into a store,
+// for identity history and reverse lookups without an archive node
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
+	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+	"github.com/metadium/go-delegator/rpc"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethCrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+// For environment arguments
+const (
+	// IndexerStore is a location of store for events, refer to store.Open
+	IndexerStore = "INDEXER_STORE"
+	// IndexerStartBlock is the block to backfill events from, 0 if not given
+	IndexerStartBlock = "INDEXER_START_BLOCK"
+)
+
+// DefaultIndexerStorePath is a LevelDB path for events
+const DefaultIndexerStorePath = "events"
+
+var (
+	// Interval to poll new blocks
+	pollInterval = 5 * time.Second
+	// Blocks read by one eth_getLogs
+	batchSize = uint64(1000)
+	// Hashes of blocks this far from the head are kept to find where a reorg forks
+	reorgDepth = uint64(128)
+)
+
+// Backend is a node to read logs and blocks, ethclient.Client implements it
+type Backend interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// Status is the progress of the indexer
+type Status struct {
+	StartBlock uint64 `json:"start_block"`
+	// Head is the last block indexed, nil before the first sync
+	Head   *uint64 `json:"head"`
+	Synced bool    `json:"synced"`
+}
+
+// Indexer keeps events of contracts in a store.
+// Events are kept by block and log index, and indexed by EIN and subject address
+//
+//	head                               last block indexed
+//	block/<number>                     hash of a recent block, to detect reorgs
+//	event/<number>/<log index>         event
+//	ein/<EIN>/<number>/<log index>     key of the event of the EIN
+//	address/<address>/<number>/<index> key of the event about the address
+//	metaid/<address>                   MetaID of which claims are indexed, "found" until claims before the head are read
+type Indexer struct {
+	store     store.Store
+	backend   Backend
+	contracts []common.Address
+	start     uint64
+	decoder   decoder
+
+	// mu keeps queries from reading a reorg in progress
+	mu     sync.RWMutex
+	synced bool
+	stop   chan struct{}
+	once   sync.Once
+}
+
+var (
+	// For singleton
+	instance *Indexer
+	once     sync.Once
+)
+
+// eventHooks are called with events of new blocks once backfill is done
+var eventHooks []func(ev *Event, removed bool)
+
+// OnEvent registers fn called with an event of a new block, or with removed true
+// when a reorg drops an event. Events backfilled are not notified.
+// It should be called in init, fn is called while syncing and should not block
+func OnEvent(fn func(ev *Event, removed bool)) {
+	eventHooks = append(eventHooks, fn)
+}
+
+// claimAddedTopic is the topic of ClaimAdded of MetaID
+var claimAddedTopic = ethCrypto.Keccak256Hash([]byte("ClaimAdded(bytes32,uint256,uint256,address,bytes,bytes,string)"))
+
+func init() {
+	// Claims are indexed for MetaIDs of which delegator TXs are mined, including MetaIDs created
+	tracker.OnFinal(func(rec *tracker.Record) {
+		if rec.Status != tracker.StatusMined || rec.MetaID == "" {
+			return
+		}
+		if ix := GetInstance(); ix != nil {
+			if err := ix.Watch(common.HexToAddress(rec.MetaID)); err != nil {
+				log.Error("Failed to index claims of MetaID: ", err)
+			}
+		}
+	})
+}
+
+// pinner is a Backend of several nodes, which pins one node for a sync pass
+type pinner interface {
+	pin() Backend
+}
+
+// nodeBackend reads from a healthy node of rpc at each call
+type nodeBackend struct{}
+
+// pin returns a client of one healthy node, so that blocks and logs of a pass are of the same chain view
+func (nodeBackend) pin() Backend {
+	return rpc.GetInstance().GetEthClient()
+}
+
+func (nodeBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return rpc.GetInstance().GetEthClient().HeaderByNumber(ctx, number)
+}
+
+func (nodeBackend) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	return rpc.GetInstance().GetEthClient().FilterLogs(ctx, q)
+}
+
+// GetInstance returns the indexer following IdentityRegistry and every resolver,
+// with the store given by INDEXER_STORE, LevelDB by default.
+// It returns nil in AWS lambda, or if the store is not available
+func GetInstance() *Indexer {
+	once.Do(func() {
+		if os.Getenv(crypto.IsAwsLambda) != "" {
+			log.Info("Indexer is not available in AWS lambda")
+			return
+		}
+		location := os.Getenv(IndexerStore)
+		if location == "" {
+			location = DefaultIndexerStorePath
+		}
+		s, err := store.Open(location)
+		if err != nil {
+			log.Error("Failed to open event store, events are not indexed: ", err)
+			return
+		}
+		var start uint64
+		if v := os.Getenv(IndexerStartBlock); v != "" {
+			if start, err = strconv.ParseUint(v, 10, 64); err != nil {
+				log.Errorf("Invalid %s %q, events are indexed from block 0", IndexerStartBlock, v)
+			}
+		}
+		contracts := []common.Address{*identityregistry.GetAddress()}
+		contracts = append(contracts, servicekeyresolver.GetAddressList()...)
+		contracts = append(contracts, publickeyresolver.GetAddressList()...)
+		if instance, err = NewIndexer(s, nodeBackend{}, contracts, start); err != nil {
+			log.Error("Failed to make indexer: ", err)
+			return
+		}
+		log.Info("Event store is set to ", location, ", from block ", start)
+		instance.Start()
+	})
+	return instance
+}
+
+// StartBlock returns the block given by INDEXER_START_BLOCK, 0 if not given or invalid
+func StartBlock() uint64 {
+	start, _ := strconv.ParseUint(os.Getenv(IndexerStartBlock), 10, 64)
+	return start
+}
+
+// NewIndexer returns an indexer of events of the contracts from the start block
+func NewIndexer(s store.Store, backend Backend, contracts []common.Address, start uint64) (*Indexer, error) {
+	d, err := newDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return &Indexer{
+		store:     s,
+		backend:   backend,
+		contracts: contracts,
+		start:     start,
+		decoder:   d,
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+func blockKey(number uint64) string {
+	return fmt.Sprintf("block/%020d", number)
+}
+
+func eventKey(number uint64, index uint) string {
+	return fmt.Sprintf("event/%020d/%06d", number, index)
+}
+
+func einKey(ein string, number uint64, index uint) string {
+	return fmt.Sprintf("ein/%s/%020d/%06d", ein, number, index)
+}
+
+func addressKey(address string, number uint64, index uint) string {
+	return fmt.Sprintf("address/%s/%020d/%06d", strings.ToLower(address), number, index)
+}
+
+func metaIDKey(address common.Address) string {
+	return "metaid/" + strings.ToLower(address.Hex())
+}
+
+// Watch indexes claims of the MetaID, claims added before are read by the next sync
+func (ix *Indexer) Watch(metaID common.Address) error {
+	if _, err := ix.store.Get(metaIDKey(metaID)); err != store.ErrNotFound {
+		return err
+	}
+	return ix.store.Put(metaIDKey(metaID), []byte("found"))
+}
+
+// metaIDs returns MetaIDs of which claims are indexed, and MetaIDs found of which claims are not read yet
+func (ix *Indexer) metaIDs() (watched, found []common.Address, err error) {
+	err = ix.store.Iterate("metaid/", func(key string, value []byte) bool {
+		address := common.HexToAddress(strings.TrimPrefix(key, "metaid/"))
+		if string(value) == "found" {
+			found = append(found, address)
+		} else {
+			watched = append(watched, address)
+		}
+		return true
+	})
+	return
+}
+
+// head returns the last block indexed, false if none
+func (ix *Indexer) head() (uint64, bool, error) {
+	b, err := ix.store.Get("head")
+	if err == store.ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	head, err := strconv.ParseUint(string(b), 10, 64)
+	return head, err == nil, err
+}
+
+// Start syncs periodically
+func (ix *Indexer) Start() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			if err := ix.Sync(context.Background()); err != nil {
+				log.Warn("Failed to index events: ", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ix.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops syncing
+func (ix *Indexer) Stop() {
+	ix.once.Do(func() { close(ix.stop) })
+}
+
+// Sync indexes events up to the latest block, after rolling back blocks reorganized.
+// A pass reads one node, so a node lagging behind others doesn't return logs short of the latest block
+func (ix *Indexer) Sync(ctx context.Context) error {
+	backend := ix.backend
+	if p, ok := backend.(pinner); ok {
+		backend = p.pin()
+	}
+	latest, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	head, ok, err := ix.head()
+	if err != nil {
+		return err
+	}
+	if ok && latest.Number.Uint64() < head {
+		// The node lags behind the head indexed unless its latest block is of other chain,
+		// blocks beyond it are not a reorg
+		b, err := ix.store.Get(blockKey(latest.Number.Uint64()))
+		if err != nil && err != store.ErrNotFound {
+			return err
+		}
+		if err == store.ErrNotFound || string(b) == latest.Hash().Hex() {
+			log.Debugf("Node is at block %d behind indexed head %d, sync is skipped", latest.Number.Uint64(), head)
+			return nil
+		}
+	}
+	if ok {
+		if head, ok, err = ix.checkReorg(ctx, backend, head); err != nil {
+			return err
+		}
+	}
+	next := ix.start
+	if ok {
+		next = head + 1
+	}
+	// Events are notified only while following new blocks
+	ix.mu.RLock()
+	following := ix.synced
+	ix.mu.RUnlock()
+
+	metaIDs, found, err := ix.metaIDs()
+	if err != nil {
+		return err
+	}
+	if len(found) > 0 {
+		// Claims of MetaIDs found are read up to the head, and followed with others after it
+		if ok {
+			if err := ix.backfillClaims(ctx, backend, found, head, following); err != nil {
+				return err
+			}
+		}
+		for _, address := range found {
+			if err := ix.store.Put(metaIDKey(address), []byte("watched")); err != nil {
+				return err
+			}
+		}
+		metaIDs = append(metaIDs, found...)
+	}
+
+	for next <= latest.Number.Uint64() {
+		to := next + batchSize - 1
+		if to > latest.Number.Uint64() {
+			to = latest.Number.Uint64()
+		}
+		// The hash is read before logs, so logs of a later reorg are found by the next check
+		header, err := backend.HeaderByNumber(ctx, new(big.Int).SetUint64(to))
+		if err != nil {
+			return err
+		}
+		logs, err := backend.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(next),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: ix.contracts,
+		})
+		if err != nil {
+			return err
+		}
+		if len(metaIDs) > 0 {
+			claims, err := ix.claimLogs(ctx, backend, metaIDs, next, to)
+			if err != nil {
+				return err
+			}
+			logs = append(logs, claims...)
+			sort.Slice(logs, func(i, j int) bool {
+				if logs[i].BlockNumber != logs[j].BlockNumber {
+					return logs[i].BlockNumber < logs[j].BlockNumber
+				}
+				return logs[i].Index < logs[j].Index
+			})
+		}
+		if err := ix.apply(logs, to, header.Hash(), following); err != nil {
+			return err
+		}
+		next = to + 1
+	}
+
+	ix.mu.Lock()
+	ix.synced = true
+	ix.mu.Unlock()
+	ix.prune(next - 1)
+	return nil
+}
+
+// claimLogs returns ClaimAdded logs of the MetaIDs in the blocks
+func (ix *Indexer) claimLogs(ctx context.Context, backend Backend, metaIDs []common.Address, from, to uint64) ([]types.Log, error) {
+	return backend.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: metaIDs,
+		Topics:    [][]common.Hash{{claimAddedTopic}},
+	})
+}
+
+// backfillClaims keeps claims of the MetaIDs up to the head, notified to hooks if notify is true
+// as they are found after they are added
+func (ix *Indexer) backfillClaims(ctx context.Context, backend Backend, metaIDs []common.Address, head uint64, notify bool) error {
+	for next := ix.start; next <= head; next += batchSize {
+		to := next + batchSize - 1
+		if to > head {
+			to = head
+		}
+		logs, err := ix.claimLogs(ctx, backend, metaIDs, next, to)
+		if err != nil {
+			return err
+		}
+		ix.mu.Lock()
+		applied, err := ix.putLogs(logs)
+		ix.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if notify {
+			notifyEvents(applied, false)
+		}
+	}
+	return nil
+}
+
+// apply keeps events of the logs and moves the head to the block,
+// events are notified to hooks if notify is true
+func (ix *Indexer) apply(logs []types.Log, to uint64, hash common.Hash, notify bool) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	applied, err := ix.putLogs(logs)
+	if err != nil {
+		return err
+	}
+	if err := ix.store.Put(blockKey(to), []byte(hash.Hex())); err != nil {
+		return err
+	}
+	if err := ix.store.Put("head", []byte(strconv.FormatUint(to, 10))); err != nil {
+		return err
+	}
+	if notify {
+		notifyEvents(applied, false)
+	}
+	return nil
+}
+
+// putLogs keeps events of the logs with hashes of their blocks, and returns the events
+func (ix *Indexer) putLogs(logs []types.Log) ([]*Event, error) {
+	var applied []*Event
+	for _, l := range logs {
+		if l.Removed {
+			continue
+		}
+		ev, err := ix.decoder.decode(l)
+		if err != nil {
+			log.Warnf("Failed to decode log %s/%d: %v", l.TxHash.Hex(), l.Index, err)
+			continue
+		}
+		if ev == nil {
+			continue
+		}
+		if err := ix.put(ev); err != nil {
+			return nil, err
+		}
+		if err := ix.store.Put(blockKey(l.BlockNumber), []byte(l.BlockHash.Hex())); err != nil {
+			return nil, err
+		}
+		applied = append(applied, ev)
+	}
+	return applied, nil
+}
+
+func notifyEvents(events []*Event, removed bool) {
+	for _, ev := range events {
+		for _, fn := range eventHooks {
+			fn(ev, removed)
+		}
+	}
+}
+
+func (ix *Indexer) put(ev *Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	key := eventKey(ev.BlockNumber, ev.LogIndex)
+	if err := ix.store.Put(key, b); err != nil {
+		return err
+	}
+	if ev.EIN != "" {
+		if err := ix.store.Put(einKey(ev.EIN, ev.BlockNumber, ev.LogIndex), []byte(key)); err != nil {
+			return err
+		}
+	}
+	for _, address := range ev.Subjects {
+		if err := ix.store.Put(addressKey(address, ev.BlockNumber, ev.LogIndex), []byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReorg rolls back blocks not in the chain any more, and returns the new head
+func (ix *Indexer) checkReorg(ctx context.Context, backend Backend, head uint64) (uint64, bool, error) {
+	b, err := ix.store.Get(blockKey(head))
+	if err != nil && err != store.ErrNotFound {
+		return 0, false, err
+	}
+	if same, err := ix.sameBlock(ctx, backend, head, string(b)); err != nil || same {
+		return head, same, err
+	}
+
+	// Only hashes of batch ends and event blocks are kept, so the chain may fork at any block
+	// after the last kept one of which hash is the same
+	var numbers []uint64
+	ix.store.Iterate("block/", func(key string, value []byte) bool {
+		if n, err := strconv.ParseUint(strings.TrimPrefix(key, "block/"), 10, 64); err == nil {
+			numbers = append(numbers, n)
+		}
+		return true
+	})
+	fork := ix.start
+	matched := false
+	for i := len(numbers) - 1; i >= 0; i-- {
+		h, err := ix.store.Get(blockKey(numbers[i]))
+		if err != nil {
+			return 0, false, err
+		}
+		same, err := ix.sameBlock(ctx, backend, numbers[i], string(h))
+		if err != nil {
+			return 0, false, err
+		}
+		if same {
+			fork = numbers[i] + 1
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		log.Warnf("Reorg is deeper than %d blocks kept", reorgDepth)
+	}
+	log.Infof("Reorg found, events from block %d are indexed again", fork)
+	if err := ix.rollback(fork, numbers); err != nil {
+		return 0, false, err
+	}
+	if fork <= ix.start {
+		return 0, false, nil
+	}
+	return fork - 1, true, nil
+}
+
+// sameBlock returns true if the block of the chain has the hash, false if the chain is shorter
+func (ix *Indexer) sameBlock(ctx context.Context, backend Backend, number uint64, hash string) (bool, error) {
+	header, err := backend.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err == ethereum.NotFound || (err == nil && header == nil) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return header.Hash().Hex() == hash, nil
+}
+
+// rollback removes events and hashes of blocks from the fork
+func (ix *Indexer) rollback(fork uint64, numbers []uint64) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	// Events of blocks of which hashes are pruned are rolled back by a reorg deeper than hashes kept
+	if len(numbers) == 0 || fork < numbers[0] {
+		var older []uint64
+		ix.store.Iterate("event/", func(key string, value []byte) bool {
+			n, err := strconv.ParseUint(strings.SplitN(strings.TrimPrefix(key, "event/"), "/", 2)[0], 10, 64)
+			if err != nil || n < fork {
+				return true
+			}
+			if len(numbers) > 0 && n >= numbers[0] {
+				return false
+			}
+			if len(older) == 0 || older[len(older)-1] != n {
+				older = append(older, n)
+			}
+			return true
+		})
+		numbers = append(older, numbers...)
+	}
+	for _, n := range numbers {
+		if n < fork {
+			continue
+		}
+		var events []*Event
+		ix.store.Iterate(fmt.Sprintf("event/%020d/", n), func(key string, value []byte) bool {
+			ev := new(Event)
+			if json.Unmarshal(value, ev) == nil {
+				events = append(events, ev)
+			}
+			return true
+		})
+		for _, ev := range events {
+			for _, address := range ev.Subjects {
+				ix.store.Delete(addressKey(address, ev.BlockNumber, ev.LogIndex))
+			}
+			if ev.EIN != "" {
+				ix.store.Delete(einKey(ev.EIN, ev.BlockNumber, ev.LogIndex))
+			}
+			if err := ix.store.Delete(eventKey(ev.BlockNumber, ev.LogIndex)); err != nil {
+				return err
+			}
+		}
+		if err := ix.store.Delete(blockKey(n)); err != nil {
+			return err
+		}
+		if ix.synced {
+			notifyEvents(events, true)
+		}
+	}
+	if fork <= ix.start {
+		return ix.store.Delete("head")
+	}
+	return ix.store.Put("head", []byte(strconv.FormatUint(fork-1, 10)))
+}
+
+// prune removes hashes of blocks too old to be reorganized, the head is kept
+func (ix *Indexer) prune(head uint64) {
+	if head < reorgDepth {
+		return
+	}
+	var keys []string
+	ix.store.Iterate("block/", func(key string, value []byte) bool {
+		if key >= blockKey(head-reorgDepth) {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		ix.store.Delete(key)
+	}
+}
@@ -0,0 +1,382 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+	"github.com/metadium/go-delegator/metaservice/sc/identity"
+	"github.com/metadium/go-delegator/store"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	registryAddr = common.HexToAddress("0x1001")
+	skrAddr      = common.HexToAddress("0x1002")
+	provider     = common.HexToAddress("0x2001")
+	recovery     = common.HexToAddress("0x2002")
+	addrA        = common.HexToAddress("0x3001")
+	addrB        = common.HexToAddress("0x3002")
+	addrC        = common.HexToAddress("0x3003")
+	serviceKey   = common.HexToAddress("0x4001")
+)
+
+// fakeChain is a chain of which blocks can be replaced to make a reorg
+type fakeChain struct {
+	mu     sync.Mutex
+	blocks []*types.Header
+	logs   map[uint64][]types.Log
+}
+
+func newFakeChain() *fakeChain {
+	c := &fakeChain{logs: make(map[uint64][]types.Log)}
+	c.blocks = []*types.Header{{Number: big.NewInt(0)}}
+	return c
+}
+
+// mine adds a block with logs after the block number, dropping blocks after it
+func (c *fakeChain) mine(parent uint64, fork string, logs ...types.Log) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks = c.blocks[:parent+1]
+	number := parent + 1
+	header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: c.blocks[parent].Hash(), Extra: []byte(fork)}
+	c.blocks = append(c.blocks, header)
+	for i := range logs {
+		logs[i].BlockNumber = number
+		logs[i].BlockHash = header.Hash()
+		logs[i].Index = uint(i)
+		logs[i].TxHash = common.BytesToHash([]byte(fork + string(rune(i))))
+	}
+	c.logs[number] = logs
+}
+
+func (c *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if number == nil {
+		return c.blocks[len(c.blocks)-1], nil
+	}
+	if number.Uint64() >= uint64(len(c.blocks)) {
+		return nil, ethereum.NotFound
+	}
+	return c.blocks[number.Uint64()], nil
+}
+
+func (c *fakeChain) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var logs []types.Log
+	for n := q.FromBlock.Uint64(); n <= q.ToBlock.Uint64() && n < uint64(len(c.blocks)); n++ {
+		for _, l := range c.logs[n] {
+			if len(q.Topics) > 0 && len(q.Topics[0]) > 0 && l.Topics[0] != q.Topics[0][0] {
+				continue
+			}
+			for _, a := range q.Addresses {
+				if l.Address == a {
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
+
+// eventLog returns a log of the event, indexed arguments are initiator or key, and EIN
+func eventLog(t *testing.T, definition string, address common.Address, name string, subject common.Address, ein int64, values ...interface{}) types.Log {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := parsed.Events[name]
+	data, err := event.Inputs.NonIndexed().Pack(values...)
+	if err != nil {
+		t.Fatalf("Failed to pack %s: %v", name, err)
+	}
+	return types.Log{
+		Address: address,
+		Topics:  []common.Hash{event.Id(), common.BytesToHash(subject.Bytes()), common.BigToHash(big.NewInt(ein))},
+		Data:    data,
+	}
+}
+
+func TestIndexer(t *testing.T) {
+	ir := func(name string, ein int64, values ...interface{}) types.Log {
+		return eventLog(t, identityregistry.IdentityregistryABI, registryAddr, name, provider, ein, values...)
+	}
+	chain := newFakeChain()
+	chain.mine(0, "a", ir("IdentityCreated", 1, recovery, addrA, []common.Address{provider}, []common.Address{skrAddr}, true))
+	chain.mine(1, "a", ir("AssociatedAddressAdded", 1, addrA, addrB, true),
+		eventLog(t, servicekeyresolver.ServicekeyresolverABI, skrAddr, "KeyAdded", serviceKey, 1, "service"))
+	chain.mine(2, "a")
+	chain.mine(3, "a", ir("AssociatedAddressRemoved", 1, addrA, true))
+	chain.mine(4, "a")
+
+	defer func(size uint64) { batchSize = size }(batchSize)
+	batchSize = 2
+	ix, err := NewIndexer(store.NewMemoryStore(), chain, []common.Address{registryAddr, skrAddr}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := ix.Status(); status.Head == nil || *status.Head != 5 || !status.Synced {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	history, _ := ix.History(big.NewInt(1))
+	var names []string
+	for _, ev := range history {
+		names = append(names, ev.Event)
+	}
+	if strings.Join(names, ",") != "IdentityCreated,AssociatedAddressAdded,KeyAdded,AssociatedAddressRemoved" {
+		t.Errorf("Unexpected history %v", names)
+	}
+	created := history[0]
+	if created.EIN != "1" || created.Args["associated_address"] != addrA.Hex() || created.Args["delegated"] != true || created.BlockNumber != 1 {
+		t.Errorf("Unexpected event %+v", created)
+	}
+	if keys, _ := ix.KeyChanges(big.NewInt(1)); len(keys) != 1 || keys[0].Args["symbol"] != "service" || keys[0].Args["key"] != serviceKey.Hex() {
+		t.Errorf("Unexpected key changes %+v", keys)
+	}
+	if events, _ := ix.AddressHistory(provider); len(events) != 0 {
+		t.Errorf("Provider must not be indexed as subject, got %d events", len(events))
+	}
+
+	einOf := func(address common.Address) *big.Int {
+		events, err := ix.AddressHistory(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ein, err := ix.EINOf(address, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ein
+	}
+	if ein := einOf(addrA); ein != nil {
+		t.Errorf("Removed address must have no EIN, got %v", ein)
+	}
+	if ein := einOf(addrB); ein == nil || ein.Int64() != 1 {
+		t.Errorf("Expected EIN 1, got %v", ein)
+	}
+
+	// Events of new blocks are notified after backfill
+	var notified []string
+	defer func(hooks []func(*Event, bool)) { eventHooks = hooks }(eventHooks)
+	OnEvent(func(ev *Event, removed bool) {
+		notified = append(notified, fmt.Sprintf("%s:%v", ev.Event, removed))
+	})
+
+	// Reorg from block 3 drops the removal, and recovery replaces addresses
+	chain.mine(2, "b")
+	chain.mine(3, "b", ir("RecoveryTriggered", 1, []common.Address{addrA, addrB}, addrC))
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := ix.Status(); *status.Head != 4 {
+		t.Errorf("Expected head 4 after reorg, got %d", *status.Head)
+	}
+	history, _ = ix.History(big.NewInt(1))
+	if len(history) != 4 || history[3].Event != "RecoveryTriggered" || history[3].BlockHash != chain.blocks[4].Hash().Hex() {
+		t.Errorf("Unexpected history after reorg %+v", history)
+	}
+	if ein := einOf(addrA); ein != nil {
+		t.Errorf("Recovered address must have no EIN, got %v", ein)
+	}
+	if ein := einOf(addrC); ein == nil || ein.Int64() != 1 {
+		t.Errorf("Expected EIN 1 of new address, got %v", ein)
+	}
+
+	// Logs of the last recovery are read from its block, or from blocks a reorg can change if none is indexed
+	if from, ok, _ := ix.ScanStart(big.NewInt(1), "RecoveryTriggered"); !ok || from != 4 {
+		t.Errorf("Expected scan from the recovery at 4, got %d", from)
+	}
+	if from, ok, _ := ix.ScanStart(big.NewInt(2), "RecoveryTriggered"); !ok || from != 1 {
+		t.Errorf("Expected scan from the start block, got %d", from)
+	}
+	defer func(depth uint64) { reorgDepth = depth }(reorgDepth)
+	reorgDepth = 2
+	if from, _, _ := ix.ScanStart(big.NewInt(2), "RecoveryTriggered"); from != 3 {
+		t.Errorf("Expected scan from 2 blocks before the head, got %d", from)
+	}
+
+	chain.mine(4, "b", ir("IdentityDestroyed", 1, recovery, true))
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ein := einOf(addrC); ein != nil {
+		t.Errorf("Destroyed identity must have no address, got %v", ein)
+	}
+	if s := strings.Join(notified, ","); s != "AssociatedAddressRemoved:true,RecoveryTriggered:false,IdentityDestroyed:false" {
+		t.Errorf("Unexpected notifications %s", s)
+	}
+}
+
+func TestIndexerReorgBetweenKeptBlocks(t *testing.T) {
+	ir := func(name string, ein int64, values ...interface{}) types.Log {
+		return eventLog(t, identityregistry.IdentityregistryABI, registryAddr, name, provider, ein, values...)
+	}
+	chain := newFakeChain()
+	chain.mine(0, "a", ir("IdentityCreated", 1, recovery, addrA, []common.Address{provider}, []common.Address{skrAddr}, true))
+	for n := uint64(1); n < 5; n++ {
+		chain.mine(n, "a")
+	}
+
+	defer func(size uint64) { batchSize = size }(batchSize)
+	batchSize = 2
+	ix, err := NewIndexer(store.NewMemoryStore(), chain, []common.Address{registryAddr}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block 3 has no hash kept, and the new chain adds an event to it
+	chain.mine(2, "b", ir("AssociatedAddressAdded", 1, addrA, addrB, true))
+	chain.mine(3, "b")
+	chain.mine(4, "b")
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	history, _ := ix.History(big.NewInt(1))
+	if len(history) != 2 || history[1].Event != "AssociatedAddressAdded" || history[1].BlockNumber != 3 {
+		t.Errorf("Unexpected history after reorg %+v", history)
+	}
+}
+
+func TestIndexerClaims(t *testing.T) {
+	metaID := common.HexToAddress("0x5001")
+	issuer := common.HexToAddress("0x5002")
+	claim := func(topic int64) types.Log {
+		parsed, err := abi.JSON(strings.NewReader(identity.IdentityABI))
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := parsed.Events["ClaimAdded"]
+		data, err := event.Inputs.NonIndexed().Pack(big.NewInt(1), []byte{1}, []byte{2}, "uri")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return types.Log{
+			Address: metaID,
+			Topics:  []common.Hash{event.Id(), common.BigToHash(big.NewInt(topic)), common.BigToHash(big.NewInt(topic)), common.BytesToHash(issuer.Bytes())},
+			Data:    data,
+		}
+	}
+	chain := newFakeChain()
+	chain.mine(0, "a", claim(1))
+	chain.mine(1, "a")
+
+	ix, err := NewIndexer(store.NewMemoryStore(), chain, []common.Address{registryAddr}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := ix.AddressHistory(issuer); len(events) != 0 {
+		t.Errorf("Claims of MetaID not watched are indexed: %+v", events)
+	}
+
+	var notified []string
+	defer func(hooks []func(*Event, bool)) { eventHooks = hooks }(eventHooks)
+	OnEvent(func(ev *Event, removed bool) {
+		notified = append(notified, fmt.Sprintf("%s:%s", ev.Event, ev.Args["topic"]))
+	})
+
+	// Claims added before MetaID is found are read with claims of new blocks
+	if err := ix.Watch(metaID); err != nil {
+		t.Fatal(err)
+	}
+	chain.mine(2, "a", claim(2))
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events, _ := ix.AddressHistory(issuer)
+	if len(events) != 2 || events[0].Contract != MetaID || events[1].Args["topic"] != "2" {
+		t.Errorf("Unexpected claims %+v", events)
+	}
+	if s := strings.Join(notified, ","); s != "ClaimAdded:1,ClaimAdded:2" {
+		t.Errorf("Unexpected notifications %s", s)
+	}
+}
+
+// nodePool reads each call from the next node, and pins the next node for a pass
+type nodePool struct {
+	nodes []*fakeChain
+	next  int
+}
+
+func (p *nodePool) node() *fakeChain {
+	n := p.nodes[p.next%len(p.nodes)]
+	p.next++
+	return n
+}
+
+func (p *nodePool) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return p.node().HeaderByNumber(ctx, number)
+}
+
+func (p *nodePool) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	return p.node().FilterLogs(ctx, q)
+}
+
+func (p *nodePool) pin() Backend {
+	return p.node()
+}
+
+func TestIndexerLaggingNode(t *testing.T) {
+	ir := func(name string, ein int64, values ...interface{}) types.Log {
+		return eventLog(t, identityregistry.IdentityregistryABI, registryAddr, name, provider, ein, values...)
+	}
+	fresh, lagging := newFakeChain(), newFakeChain()
+	for _, c := range []*fakeChain{fresh, lagging} {
+		c.mine(0, "a", ir("IdentityCreated", 1, recovery, addrA, []common.Address{provider}, []common.Address{skrAddr}, true))
+		c.mine(1, "a")
+	}
+	fresh.mine(2, "a", ir("AssociatedAddressAdded", 1, addrA, addrB, true))
+	fresh.mine(3, "a")
+
+	pool := &nodePool{nodes: []*fakeChain{lagging, fresh}}
+	ix, err := NewIndexer(store.NewMemoryStore(), pool, []common.Address{registryAddr}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Passes read the lagging node, the fresh node and the lagging node again
+	for i, head := range []uint64{2, 4, 4} {
+		if err := ix.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if status, _ := ix.Status(); status.Head == nil || *status.Head != head {
+			t.Errorf("Expected head %d at pass %d, got %+v", head, i, status)
+		}
+		if history, _ := ix.History(big.NewInt(1)); i > 0 && len(history) != 2 {
+			t.Errorf("Expected 2 events at pass %d, got %d", i, len(history))
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"ein":                    "ein",
+		"oldRecoveryAddress":     "old_recovery_address",
+		"oldAssociatedAddresses": "old_associated_addresses",
+		"publicKey":              "public_key",
+	} {
+		if s := snakeCase(name); s != expected {
+			t.Errorf("Expected %s, got %s", expected, s)
+		}
+	}
+}
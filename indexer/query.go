@@ -0,0 +1,162 @@
+package indexer
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Status returns the progress of the indexer
+func (ix *Indexer) Status() (*Status, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	status := &Status{StartBlock: ix.start, Synced: ix.synced}
+	head, ok, err := ix.head()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		status.Head = &head
+	}
+	return status, nil
+}
+
+// events returns events of keys kept under the prefix, in the order of blocks
+func (ix *Indexer) events(prefix string) ([]*Event, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var keys []string
+	err := ix.store.Iterate(prefix, func(key string, value []byte) bool {
+		keys = append(keys, string(value))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*Event, 0, len(keys))
+	for _, key := range keys {
+		b, err := ix.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		ev := new(Event)
+		if err := json.Unmarshal(b, ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// ScanStart returns the block of the last event of the EIN with the name in IdentityRegistry,
+// or the first block a reorg can still change if none is indexed, so that logs from it have the last one.
+// ok is false before the first sync
+func (ix *Indexer) ScanStart(ein *big.Int, event string) (block uint64, ok bool, err error) {
+	// Events indexed after the head is read are above it
+	ix.mu.RLock()
+	head, ok, err := ix.head()
+	ix.mu.RUnlock()
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	events, err := ix.History(ein)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Contract == IdentityRegistry && events[i].Event == event {
+			return events[i].BlockNumber, true, nil
+		}
+	}
+	if head < ix.start+reorgDepth {
+		return ix.start, true, nil
+	}
+	return head + 1 - reorgDepth, true, nil
+}
+
+// History returns events of the EIN from every contract
+func (ix *Indexer) History(ein *big.Int) ([]*Event, error) {
+	return ix.events("ein/" + ein.String() + "/")
+}
+
+// KeyChanges returns events of service keys and public keys of the EIN
+func (ix *Indexer) KeyChanges(ein *big.Int) ([]*Event, error) {
+	events, err := ix.History(ein)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*Event, 0, len(events))
+	for _, ev := range events {
+		if ev.Contract == ServiceKeyResolver || ev.Contract == PublicKeyResolver {
+			changes = append(changes, ev)
+		}
+	}
+	return changes, nil
+}
+
+// AddressHistory returns events about the address, such as association and service key
+func (ix *Indexer) AddressHistory(address common.Address) ([]*Event, error) {
+	return ix.events("address/" + strings.ToLower(address.Hex()) + "/")
+}
+
+// EINOf returns the EIN the address is associated with, nil if none.
+// events are the history of the address
+func (ix *Indexer) EINOf(address common.Address, events []*Event) (*big.Int, error) {
+	var ein string
+	var since *Event
+	for _, ev := range events {
+		if ev.Contract != IdentityRegistry {
+			continue
+		}
+		switch ev.Event {
+		case "IdentityCreated", "AssociatedAddressAdded":
+			// The approving address of AssociatedAddressAdded is already associated
+			if sameAddress(ev.Args["associated_address"], address) || sameAddress(ev.Args["added_address"], address) {
+				ein, since = ev.EIN, ev
+			}
+		case "AssociatedAddressRemoved":
+			if sameAddress(ev.Args["removed_address"], address) {
+				ein, since = "", nil
+			}
+		case "RecoveryTriggered":
+			// Old addresses are removed and the new one is added
+			if sameAddress(ev.Args["new_associated_address"], address) {
+				ein, since = ev.EIN, ev
+			} else if ev.EIN == ein {
+				ein, since = "", nil
+			}
+		}
+	}
+	if ein == "" {
+		return nil, nil
+	}
+
+	// Destruction removes every address of the EIN, without addresses in the event
+	n, _ := new(big.Int).SetString(ein, 10)
+	history, err := ix.History(n)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range history {
+		if ev.Event == "IdentityDestroyed" && after(ev, since) {
+			return nil, nil
+		}
+	}
+	return n, nil
+}
+
+func sameAddress(value interface{}, address common.Address) bool {
+	s, ok := value.(string)
+	return ok && common.HexToAddress(s) == address
+}
+
+// after returns true if ev is later than since
+func after(ev, since *Event) bool {
+	if ev.BlockNumber != since.BlockNumber {
+		return ev.BlockNumber > since.BlockNumber
+	}
+	return ev.LogIndex > since.LogIndex
+}
@@ -0,0 +1,352 @@
+// Package jobs keeps delegated writes requested asynchronously in a durable queue,
+// and sends them by workers draining a queue per nonce lane of delegator keys
+package jobs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	encodingJson "encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+)
+
+// Status of a job
+const (
+	// StatusQueued means the job waits for a worker of its lane
+	StatusQueued = "queued"
+	// StatusSent means the TX of the job is sent and not mined yet
+	StatusSent = "sent"
+	// StatusMined means the TX of the job is mined and succeeded
+	StatusMined = "mined"
+	// StatusFailed means the job failed before the TX was sent, or the TX reverted or dropped
+	StatusFailed = "failed"
+)
+
+// For environment arguments
+const (
+	// JobStore is a location of store for jobs, refer to store.Open
+	JobStore = "JOB_STORE"
+)
+
+// For job store
+const (
+	// DefaultJobStorePath is a LevelDB path for jobs
+	DefaultJobStorePath = "jobs"
+	// DefaultJobStoreTable is a DynamoDB table for jobs used in AWS lambda
+	DefaultJobStoreTable = "Jobs"
+)
+
+var (
+	// Interval to look for jobs queued by other processes sharing the store
+	pollInterval = 2 * time.Second
+	// A job claimed for this long without result is failed, its worker is gone
+	claimTimeout = 15 * time.Minute
+	// Jobs are removed after this long since finished
+	retention = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrSubmitting is returned for an idempotency key of which job is being checked
+	ErrSubmitting = errors.New("jobs: job of the idempotency key is being submitted")
+	// errClaimed is returned when a job is claimed by other worker
+	errClaimed = errors.New("jobs: job is claimed")
+	// errNotQueued is returned when a job is not waiting for a worker
+	errNotQueued = errors.New("jobs: job is not queued")
+)
+
+// Job is a delegated write request queued
+type Job struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+	// Params are kept to run the request, not shown to clients
+	Params     []interface{} `json:"params,omitempty"`
+	ParamsHash string        `json:"params_hash,omitempty"`
+	// IdempotencyKey given by the client returns this job for duplicate submissions
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// APIKey of the client is kept to account the TX, not shown to clients
+	APIKey string `json:"api_key,omitempty"`
+	// Lane is the delegator address sending the TX
+	Lane   string         `json:"lane"`
+	Status string         `json:"status"`
+	TxHash string         `json:"tx_hash,omitempty"`
+	Error  *json.RPCError `json:"error,omitempty"`
+
+	CreatedAt int64 `json:"created_at"`
+	UpdatedAt int64 `json:"updated_at"`
+	// ClaimedAt is when a worker took the job, 0 if not yet
+	ClaimedAt int64 `json:"claimed_at,omitempty"`
+}
+
+// NewJob returns a job of the request with a new ID, key is optional
+func NewJob(method string, params []interface{}, key string) *Job {
+	b, _ := encodingJson.Marshal(params)
+	hash := sha256.Sum256(append([]byte(method+"\n"), b...))
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &Job{
+		// IDs are ordered by time, so jobs of a lane are sent in order
+		ID:             fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(id)),
+		Method:         method,
+		Params:         params,
+		ParamsHash:     hex.EncodeToString(hash[:]),
+		IdempotencyKey: key,
+	}
+}
+
+// Summary returns the job without the request, to be shown to clients
+func (j *Job) Summary() *Job {
+	s := *j
+	s.Params = nil
+	s.ParamsHash = ""
+	s.APIKey = ""
+	return &s
+}
+
+// Final returns true if the status of the job doesn't change any more
+func (j *Job) Final() bool {
+	return j.Status == StatusMined || j.Status == StatusFailed
+}
+
+// Executor runs the request of the job with the key of its lane,
+// and returns the hash of the TX sent or the error of the request
+type Executor func(job *Job) (txHash string, err *json.RPCError)
+
+// executor runs jobs of the queue from GetInstance
+var executor Executor
+
+// RegisterExecutor sets the executor of jobs
+// It should be called in init of the package serving the methods
+func RegisterExecutor(fn Executor) {
+	executor = fn
+}
+
+// Queue keeps jobs in a store
+//
+//	job/<id>             job
+//	lane/<lane>          IDs of jobs waiting for a worker of the lane, in order
+//	key/<key>            ID of the job of an idempotency key
+//
+// Lanes are read by key rather than iterated, which is a full scan in DynamoDB
+type Queue struct {
+	store store.Store
+	run   Executor
+
+	// mu guards wake and pruned
+	mu     sync.Mutex
+	wake   map[string]chan struct{}
+	pruned time.Time
+	stop   chan struct{}
+	once   sync.Once
+}
+
+var (
+	// For singleton
+	instance *Queue
+	once     sync.Once
+)
+
+// GetInstance returns the queue with the store given by JOB_STORE,
+// or DynamoDB in AWS lambda and LevelDB otherwise.
+// Workers of every delegator key are started, except in AWS lambda where Drain is called
+// It returns nil if the store is not available
+func GetInstance() *Queue {
+	once.Do(func() {
+		location := os.Getenv(JobStore)
+		if location == "" {
+			if os.Getenv(crypto.IsAwsLambda) != "" {
+				location = store.DynamoDBScheme + DefaultJobStoreTable
+			} else {
+				location = DefaultJobStorePath
+			}
+		}
+		s, err := store.Open(location)
+		if err != nil {
+			log.Error("Failed to open job store, async requests are not available: ", err)
+			return
+		}
+		log.Info("Job store is set to ", location)
+		instance = NewQueue(s, executor)
+		if os.Getenv(crypto.IsAwsLambda) == "" {
+			instance.Start(Lanes())
+		}
+	})
+	return instance
+}
+
+// Lanes returns addresses of every delegator key
+func Lanes() []string {
+	var lanes []string
+	for _, a := range crypto.GetInstance().Accounts() {
+		lanes = append(lanes, a.GetAddress())
+	}
+	return lanes
+}
+
+// NewQueue returns a queue keeping jobs in the store, run by the executor
+func NewQueue(s store.Store, run Executor) *Queue {
+	return &Queue{
+		store: s,
+		run:   run,
+		wake:  make(map[string]chan struct{}),
+		stop:  make(chan struct{}),
+	}
+}
+
+func jobKey(id string) string {
+	return "job/" + id
+}
+
+func laneKey(lane string) string {
+	return "lane/" + strings.ToLower(lane)
+}
+
+func idempotencyKey(key string) string {
+	return "key/" + key
+}
+
+// Get returns the job of the ID, or store.ErrNotFound
+func (q *Queue) Get(id string) (*Job, error) {
+	b, err := q.store.Get(jobKey(id))
+	if err != nil {
+		return nil, err
+	}
+	job := new(Job)
+	return job, encodingJson.Unmarshal(b, job)
+}
+
+// Put stores the job
+func (q *Queue) Put(job *Job) error {
+	job.UpdatedAt = time.Now().Unix()
+	b, err := encodingJson.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.store.Put(jobKey(job.ID), b)
+}
+
+// Reserve binds the idempotency key of the job to it before the request is checked.
+// It returns the job already bound to the key, or ErrSubmitting if the job is being checked
+func (q *Queue) Reserve(job *Job) (*Job, error) {
+	var id string
+	err := q.store.Update(idempotencyKey(job.IdempotencyKey), func(old []byte) ([]byte, error) {
+		if old != nil {
+			id = string(old)
+			return nil, errClaimed
+		}
+		return []byte(job.ID), nil
+	})
+	if err == nil {
+		return nil, nil
+	} else if err != errClaimed {
+		return nil, err
+	}
+	existing, err := q.Get(id)
+	if err == store.ErrNotFound {
+		return nil, ErrSubmitting
+	}
+	return existing, err
+}
+
+// Release unbinds the idempotency key of the job not queued, so the key can be used again
+func (q *Queue) Release(job *Job) {
+	if job.IdempotencyKey == "" {
+		return
+	}
+	if b, err := q.store.Get(idempotencyKey(job.IdempotencyKey)); err == nil && string(b) == job.ID {
+		q.store.Delete(idempotencyKey(job.IdempotencyKey))
+	}
+}
+
+// queued returns IDs of jobs waiting in the lane in order
+func (q *Queue) queued(lane string) ([]string, error) {
+	b, err := q.store.Get(laneKey(lane))
+	if err == store.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ids []string
+	return ids, encodingJson.Unmarshal(b, &ids)
+}
+
+// updateLane replaces IDs of jobs waiting in the lane with the result of fn atomically
+func (q *Queue) updateLane(lane string, fn func(ids []string) []string) error {
+	return q.store.Update(laneKey(lane), func(old []byte) ([]byte, error) {
+		var ids []string
+		if old != nil {
+			if err := encodingJson.Unmarshal(old, &ids); err != nil {
+				return nil, err
+			}
+		}
+		return encodingJson.Marshal(fn(ids))
+	})
+}
+
+// dequeue removes the job from the lane
+func (q *Queue) dequeue(lane, id string) error {
+	return q.updateLane(lane, func(ids []string) []string {
+		for i, queued := range ids {
+			if queued == id {
+				return append(ids[:i], ids[i+1:]...)
+			}
+		}
+		return ids
+	})
+}
+
+// Enqueue queues the job to the lane with the fewest jobs of the lanes given
+func (q *Queue) Enqueue(job *Job, lanes []string) error {
+	if len(lanes) == 0 {
+		return errors.New("jobs: no lane")
+	}
+	lane, least := lanes[0], -1
+	for _, l := range lanes {
+		ids, err := q.queued(l)
+		if err != nil {
+			return err
+		}
+		if least < 0 || len(ids) < least {
+			lane, least = l, len(ids)
+		}
+	}
+
+	job.Lane = lane
+	job.Status = StatusQueued
+	job.CreatedAt = time.Now().Unix()
+	if err := q.Put(job); err != nil {
+		return err
+	}
+	// IDs are ordered by time, a job enqueued concurrently may be older than the last one
+	err := q.updateLane(lane, func(ids []string) []string {
+		i := sort.SearchStrings(ids, job.ID)
+		if i < len(ids) && ids[i] == job.ID {
+			return ids
+		}
+		return append(ids[:i], append([]string{job.ID}, ids[i:]...)...)
+	})
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	wake := q.wake[strings.ToLower(lane)]
+	q.mu.Unlock()
+	if wake != nil {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
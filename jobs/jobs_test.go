@@ -0,0 +1,139 @@
+package jobs
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/store"
+)
+
+var lanes = []string{"0x000000000000000000000000000000000000000A", "0x000000000000000000000000000000000000000B"}
+
+// recorder runs jobs, failing jobs of the method "fail"
+type recorder struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (r *recorder) run(job *Job) (string, *json.RPCError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ran = append(r.ran, job.Method)
+	if job.Method == "fail" {
+		return "", json.NewRPCError(-32618, "execution reverted")
+	}
+	return "0x" + job.Method, nil
+}
+
+func newJob(method, key string) *Job {
+	return NewJob(method, []interface{}{map[string]interface{}{"ein": "1"}}, key)
+}
+
+// scanStore records prefixes iterated, a full scan in DynamoDB
+type scanStore struct {
+	store.Store
+	mu    sync.Mutex
+	scans []string
+}
+
+func (s *scanStore) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	s.mu.Lock()
+	s.scans = append(s.scans, prefix)
+	s.mu.Unlock()
+	return s.Store.Iterate(prefix, fn)
+}
+
+func TestQueue(t *testing.T) {
+	r := new(recorder)
+	s := &scanStore{Store: store.NewMemoryStore()}
+	q := NewQueue(s, r.run)
+
+	first := newJob("a", "key")
+	if existing, err := q.Reserve(first); existing != nil || err != nil {
+		t.Fatalf("Expected key reserved, got %v, %v", existing, err)
+	}
+	if _, err := q.Reserve(newJob("a", "key")); err != ErrSubmitting {
+		t.Errorf("Expected ErrSubmitting before queued, got %v", err)
+	}
+	if err := q.Enqueue(first, lanes); err != nil {
+		t.Fatal(err)
+	}
+	if existing, err := q.Reserve(newJob("a", "key")); err != nil || existing == nil || existing.ID != first.ID || existing.ParamsHash != first.ParamsHash {
+		t.Errorf("Expected the job of the key, got %+v, %v", existing, err)
+	}
+
+	// Jobs go to the lane with the fewest jobs
+	second := newJob("b", "")
+	third := newJob("fail", "")
+	for _, job := range []*Job{second, third} {
+		if err := q.Enqueue(job, lanes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.Lane != lanes[0] || second.Lane != lanes[1] || third.Lane != lanes[0] {
+		t.Errorf("Unexpected lanes %s, %s, %s", first.Lane, second.Lane, third.Lane)
+	}
+
+	q.Drain(context.Background(), lanes[:1])
+	q.Drain(context.Background(), lanes[:1])
+	if strings.Join(r.ran, ",") != "a,fail" {
+		t.Errorf("Jobs of the lane must run in order, got %v", r.ran)
+	}
+	// Only pruning iterates jobs, once an hour
+	if len(s.scans) != 1 || s.scans[0] != "job/" {
+		t.Errorf("Lanes must be read without iterating, got %v", s.scans)
+	}
+	if job, _ := q.Get(first.ID); job.Status != StatusSent || job.TxHash != "0xa" {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	if job, _ := q.Get(third.ID); job.Status != StatusFailed || job.Error == nil || job.Error.Code != -32618 {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	if job, _ := q.Get(second.ID); job.Status != StatusQueued {
+		t.Errorf("Job of other lane must be queued, got %+v", job)
+	}
+	if s := first.Summary(); s.Params != nil || s.ParamsHash != "" {
+		t.Errorf("Summary must not have the request, got %+v", s)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	r := new(recorder)
+	q := NewQueue(store.NewMemoryStore(), r.run)
+	job := newJob("a", "key")
+	q.Reserve(job)
+	if err := q.Enqueue(job, lanes[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// A job claimed by other worker blocks the lane
+	if claimed, err := q.claim(job.ID); err != nil || claimed == nil {
+		t.Fatalf("Expected the job claimed, got %v", err)
+	}
+	q.drainLane(context.Background(), lanes[0])
+	if len(r.ran) != 0 {
+		t.Errorf("Job claimed by other worker must not run, got %v", r.ran)
+	}
+
+	// The worker is gone after the claim timeout
+	defer func(timeout time.Duration) { claimTimeout = timeout }(claimTimeout)
+	claimTimeout = -time.Second
+	q.drainLane(context.Background(), lanes[0])
+	failed, _ := q.Get(job.ID)
+	if len(r.ran) != 0 || failed.Status != StatusFailed {
+		t.Errorf("Job claimed too long must fail without run, got %+v", failed)
+	}
+
+	// Finished jobs are removed with idempotency keys after the retention
+	q.prune(time.Now().Add(retention + time.Minute))
+	if _, err := q.Get(job.ID); err != store.ErrNotFound {
+		t.Errorf("Expected job removed, got %v", err)
+	}
+	if existing, err := q.Reserve(newJob("a", "key")); existing != nil || err != nil {
+		t.Errorf("Key of the job removed must be reserved again, got %v, %v", existing, err)
+	}
+}
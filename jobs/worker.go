@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"context"
+	encodingJson "encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+)
+
+// Start runs a worker for each lane until stopped
+func (q *Queue) Start(lanes []string) {
+	for _, lane := range lanes {
+		wake := make(chan struct{}, 1)
+		q.mu.Lock()
+		q.wake[strings.ToLower(lane)] = wake
+		q.mu.Unlock()
+
+		go func(lane string) {
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+			for {
+				q.drainLane(context.Background(), lane)
+				select {
+				case <-wake:
+				case <-ticker.C:
+				case <-q.stop:
+					return
+				}
+			}
+		}(lane)
+	}
+	go func() {
+		for {
+			select {
+			case <-time.After(time.Hour):
+				q.prune(time.Now())
+			case <-q.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops workers
+func (q *Queue) Stop() {
+	q.once.Do(func() { close(q.stop) })
+}
+
+// Drain runs jobs of the lanes until the queues are empty or ctx is done.
+// It is called periodically where workers can't run in background, such as AWS lambda
+func (q *Queue) Drain(ctx context.Context, lanes []string) {
+	var wg sync.WaitGroup
+	for _, lane := range lanes {
+		wg.Add(1)
+		go func(lane string) {
+			defer wg.Done()
+			q.drainLane(ctx, lane)
+		}(lane)
+	}
+	wg.Wait()
+	q.prune(time.Now())
+}
+
+// drainLane runs jobs of the lane in order, and stops at a job claimed by other worker
+func (q *Queue) drainLane(ctx context.Context, lane string) {
+	for ctx.Err() == nil {
+		ids, err := q.queued(lane)
+		if err != nil {
+			log.Warnf("Failed to read jobs of %s: %v", lane, err)
+			return
+		}
+		if len(ids) == 0 {
+			return
+		}
+		if !q.runJob(lane, ids[0]) {
+			return
+		}
+	}
+}
+
+// runJob claims and runs the job, and returns false if other worker has it
+func (q *Queue) runJob(lane, id string) bool {
+	job, err := q.claim(id)
+	if err == errClaimed {
+		if job != nil && time.Since(time.Unix(job.ClaimedAt, 0)) > claimTimeout {
+			log.Warnf("Job %s is claimed at %d without result", id, job.ClaimedAt)
+			q.finish(job, "", json.NewRPCError(json.ErrCodeInternal, "Job is interrupted, check the TX of the request before retrying"))
+			return true
+		}
+		return false
+	} else if err != nil {
+		log.Warnf("Failed to claim job %s: %v", id, err)
+		return false
+	}
+	if job == nil {
+		// The job is finished or removed already
+		if err := q.dequeue(lane, id); err != nil {
+			log.Warnf("Failed to remove job %s from %s: %v", id, lane, err)
+			return false
+		}
+		return true
+	}
+
+	log.Infof("Run job %s of %s by %s", job.ID, job.Method, lane)
+	hash, rpcErr := q.run(job)
+	if rpcErr != nil {
+		log.Infof("Job %s failed: %s", job.ID, rpcErr.Message)
+	}
+	q.finish(job, hash, rpcErr)
+	return true
+}
+
+// claim marks the queued job as taken by this worker.
+// It returns nil if the job is not queued, or errClaimed with the job claimed by other worker
+func (q *Queue) claim(id string) (*Job, error) {
+	var job *Job
+	err := q.store.Update(jobKey(id), func(old []byte) ([]byte, error) {
+		job = nil
+		if old == nil {
+			return nil, errNotQueued
+		}
+		j := new(Job)
+		if err := encodingJson.Unmarshal(old, j); err != nil {
+			return nil, err
+		}
+		if j.Status != StatusQueued {
+			return nil, errNotQueued
+		}
+		job = j
+		if j.ClaimedAt != 0 {
+			return nil, errClaimed
+		}
+		j.ClaimedAt = time.Now().Unix()
+		return encodingJson.Marshal(j)
+	})
+	if err == errNotQueued {
+		return nil, nil
+	}
+	return job, err
+}
+
+// finish keeps the result of the job and removes it from its lane
+func (q *Queue) finish(job *Job, hash string, rpcErr *json.RPCError) {
+	if rpcErr != nil {
+		job.Status = StatusFailed
+		job.Error = rpcErr
+	} else {
+		job.Status = StatusSent
+		job.TxHash = hash
+	}
+	if err := q.Put(job); err != nil {
+		log.Errorf("Failed to keep result of job %s: %v", job.ID, err)
+	}
+	if err := q.dequeue(job.Lane, job.ID); err != nil {
+		log.Errorf("Failed to remove job %s from %s: %v", job.ID, job.Lane, err)
+	}
+}
+
+// prune removes jobs finished before the retention, with their idempotency keys, once an hour
+func (q *Queue) prune(now time.Time) {
+	q.mu.Lock()
+	if now.Sub(q.pruned) < time.Hour {
+		q.mu.Unlock()
+		return
+	}
+	q.pruned = now
+	q.mu.Unlock()
+
+	var jobs []*Job
+	q.store.Iterate("job/", func(key string, value []byte) bool {
+		job := new(Job)
+		if encodingJson.Unmarshal(value, job) == nil && job.Status != StatusQueued && now.Sub(time.Unix(job.UpdatedAt, 0)) > retention {
+			jobs = append(jobs, job)
+		}
+		return true
+	})
+	for _, job := range jobs {
+		q.Release(job)
+		q.store.Delete(jobKey(job.ID))
+	}
+}
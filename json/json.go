@@ -2,36 +2,270 @@
 package json
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 )
 
+// Error codes defined by JSON-RPC 2.0
+const (
+	// ErrCodeParse is a JSON-RPC error code for invalid JSON
+	ErrCodeParse = -32700
+	// ErrCodeInvalidRequest is a JSON-RPC error code for invalid request object
+	ErrCodeInvalidRequest = -32600
+	// ErrCodeMethodNotFound is a JSON-RPC error code for unknown method
+	ErrCodeMethodNotFound = -32601
+	// ErrCodeInvalidParams is a JSON-RPC error code for invalid method parameters
+	ErrCodeInvalidParams = -32602
+	// ErrCodeInternal is a JSON-RPC error code for internal error
+	ErrCodeInternal = -32603
+	// ErrCodeServer is a JSON-RPC error code for server-side failure
+	// such as no response from ethereum node
+	ErrCodeServer = -32000
+)
+
+// Version is a JSON-RPC version string
+const Version = "2.0"
+
 // RPCRequest is a interface for JSON-RPC request
+// ID is one of nil, string and json.Number to keep it exactly as sent
 type RPCRequest struct {
 	Jsonrpc string        `json:"jsonrpc"`
 	Method  string        `json:"method"`
 	Params  []interface{} `json:"params"`
-	ID      int32         `json:"id"`
+	ID      interface{}   `json:"id"`
+
+	// APIKey is the key of the client given by the transport, such as a header, not a member of the request
+	APIKey string `json:"-"`
+
+	// notification is set when the request is decoded without "id" member
+	notification bool
+	// namedParams is set when params is a JSON object, kept in Params[0]
+	namedParams bool
 }
 
 // RPCError is a interface for JSON-RPC error
 type RPCError struct {
-	Code    int32  `json:"code,omitempty"`
-	Message string `json:"message,omitempty"`
+	Code    int32       `json:"code,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
 // RPCResponse is a interface for JSON-RPC response
 type RPCResponse struct {
 	Jsonrpc string      `json:"jsonrpc"`
-	ID      int32       `json:"id"`
+	ID      interface{} `json:"id"`
 	Result  interface{} `json:"result,omitempty"`
 	Error   *RPCError   `json:"error,omitempty"`
 }
 
+// RPCNotification is a interface for JSON-RPC notification sent by server
+type RPCNotification struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// SubscriptionResult is params of "eth_subscription" notification
+type SubscriptionResult struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+// NewRPCError returns RPCError with given code and message
+func NewRPCError(code int32, message string) *RPCError {
+	return &RPCError{
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// HTTPStatus returns HTTP status code of a response carrying the error
+//
+//	nil                         200 OK
+//	-32700 Parse error          400 Bad Request
+//	-32600 Invalid Request      400 Bad Request
+//	-32601 Method not found     404 Not Found
+//	-32000 Server error         502 Bad Gateway (node is unavailable)
+//	-32603 Internal error       500 Internal Server Error
+//	others                      200 OK, the error is a result of the method
+func HTTPStatus(err *RPCError) int {
+	if err == nil {
+		return 200
+	}
+	switch err.Code {
+	case ErrCodeParse, ErrCodeInvalidRequest:
+		return 400
+	case ErrCodeMethodNotFound:
+		return 404
+	case ErrCodeServer:
+		return 502
+	case ErrCodeInternal:
+		return 500
+	}
+	return 200
+}
+
+func decode(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func isValidID(id interface{}) bool {
+	switch id.(type) {
+	case nil, string, json.Number:
+		return true
+	}
+	return false
+}
+
+// decodeRPCRequest decodes a request object, it fails only for wrong member types
+func decodeRPCRequest(data []byte) (req RPCRequest, rpcErr *RPCError) {
+	invalid := NewRPCError(ErrCodeInvalidRequest, "Invalid Request")
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil || members == nil {
+		return req, invalid
+	}
+
+	// Keep ID first, so that an error response can refer to it
+	if raw, ok := members["id"]; ok {
+		if err := decode(raw, &req.ID); err != nil || !isValidID(req.ID) {
+			req.ID = nil
+			return req, invalid
+		}
+	} else {
+		req.notification = true
+	}
+	if raw, ok := members["jsonrpc"]; ok {
+		if err := json.Unmarshal(raw, &req.Jsonrpc); err != nil {
+			return req, invalid
+		}
+	}
+	if raw, ok := members["method"]; ok {
+		if err := json.Unmarshal(raw, &req.Method); err != nil {
+			return req, invalid
+		}
+	}
+	if raw, ok := members["params"]; ok {
+		trimmed := bytes.TrimSpace(raw)
+		switch {
+		case bytes.Equal(trimmed, []byte("null")):
+		case len(trimmed) > 0 && trimmed[0] == '[':
+			if err := decode(trimmed, &req.Params); err != nil {
+				return req, invalid
+			}
+		case len(trimmed) > 0 && trimmed[0] == '{':
+			var named map[string]interface{}
+			if err := decode(trimmed, &named); err != nil {
+				return req, invalid
+			}
+			req.Params = []interface{}{named}
+			req.namedParams = true
+		default:
+			return req, invalid
+		}
+	}
+	return req, nil
+}
+
+// Validate checks required members of the request
+func (r *RPCRequest) Validate() *RPCError {
+	if r.Jsonrpc != Version || r.Method == "" {
+		return NewRPCError(ErrCodeInvalidRequest, "Invalid Request")
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes RPCRequest and remembers whether "id" member exists
+func (r *RPCRequest) UnmarshalJSON(data []byte) error {
+	req, err := decodeRPCRequest(data)
+	if err != nil {
+		return err
+	}
+	*r = req
+	return nil
+}
+
+// MarshalJSON encodes RPCRequest, "id" is omitted for notification
+// and named params are encoded back as a JSON object
+func (r RPCRequest) MarshalJSON() ([]byte, error) {
+	var id json.RawMessage
+	if !r.notification {
+		var err error
+		if id, err = json.Marshal(r.ID); err != nil {
+			return nil, err
+		}
+	}
+	var params interface{}
+	if r.namedParams && len(r.Params) == 1 {
+		params = r.Params[0]
+	} else if len(r.Params) > 0 {
+		params = r.Params
+	}
+	return json.Marshal(struct {
+		Jsonrpc string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  interface{}     `json:"params,omitempty"`
+		ID      json.RawMessage `json:"id,omitempty"`
+	}{r.Jsonrpc, r.Method, params, id})
+}
+
+// IsNotification returns true if the request has no "id" member,
+// the server must not reply to a notification
+func (r *RPCRequest) IsNotification() bool {
+	return r.notification
+}
+
+// HasNamedParams returns true if params is sent as a JSON object
+func (r *RPCRequest) HasNamedParams() bool {
+	return r.namedParams
+}
+
 // GetRPCRequestFromJSON returns RPCRequest struct from JSON
-func GetRPCRequestFromJSON(msg string) RPCRequest {
-	var data RPCRequest
-	json.Unmarshal([]byte(msg), &data)
-	return data
+// RPCError is -32700 for invalid JSON and -32600 for invalid request object
+func GetRPCRequestFromJSON(msg string) (RPCRequest, *RPCError) {
+	if !json.Valid([]byte(msg)) {
+		return RPCRequest{}, NewRPCError(ErrCodeParse, "Parse error")
+	}
+	req, err := decodeRPCRequest([]byte(msg))
+	if err == nil {
+		err = req.Validate()
+	}
+	return req, err
+}
+
+// IsBatch returns true if the message is a JSON array (batch request)
+func IsBatch(msg string) bool {
+	trimmed := bytes.TrimSpace([]byte(msg))
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// GetRPCBatchFromJSON returns RPCRequest list from JSON array
+// An invalid element is returned with empty Method and the ID if known
+func GetRPCBatchFromJSON(msg string) ([]RPCRequest, *RPCError) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal([]byte(msg), &raws); err != nil {
+		return nil, NewRPCError(ErrCodeParse, "Parse error")
+	}
+	reqs := make([]RPCRequest, len(raws))
+	for i, raw := range raws {
+		req, err := decodeRPCRequest(raw)
+		if err == nil {
+			err = req.Validate()
+		}
+		if err != nil {
+			reqs[i] = RPCRequest{ID: req.ID}
+			continue
+		}
+		reqs[i] = req
+	}
+	return reqs, nil
 }
 
 func (r *RPCRequest) String() string {
@@ -45,10 +279,29 @@ func (r *RPCRequest) String() string {
 // GetRPCResponseFromJSON returns RPCRequest struct from JSON
 func GetRPCResponseFromJSON(msg string) RPCResponse {
 	var data RPCResponse
-	json.Unmarshal([]byte(msg), &data)
+	decode([]byte(msg), &data)
 	return data
 }
 
+// GetRPCBatchResponseFromJSON returns RPCResponse list from JSON array
+func GetRPCBatchResponseFromJSON(msg string) ([]RPCResponse, error) {
+	var data []RPCResponse
+	err := decode([]byte(msg), &data)
+	return data, err
+}
+
+// BatchString returns JSON array of responses
+func BatchString(resps []RPCResponse) string {
+	if len(resps) == 0 {
+		return ""
+	}
+	ret, err := json.Marshal(resps)
+	if err == nil {
+		return string(ret)
+	}
+	return ""
+}
+
 func (r *RPCResponse) String() string {
 	ret, err := json.Marshal(r)
 	if err == nil {
@@ -56,3 +309,11 @@ func (r *RPCResponse) String() string {
 	}
 	return ""
 }
+
+func (r *RPCNotification) String() string {
+	ret, err := json.Marshal(r)
+	if err == nil {
+		return string(ret)
+	}
+	return ""
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	encodingJson "encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/metadium/go-delegator/admin"
+	"github.com/metadium/go-delegator/did"
+	"github.com/metadium/go-delegator/indexer"
+	"github.com/metadium/go-delegator/jobs"
 	"github.com/metadium/go-delegator/metaresolver"
 
 	"github.com/metadium/go-delegator/crypto"
@@ -16,6 +22,7 @@ import (
 	"github.com/metadium/go-delegator/log"
 	"github.com/metadium/go-delegator/metaservice"
 	"github.com/metadium/go-delegator/rpc"
+	"github.com/metadium/go-delegator/webhook"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -28,55 +35,221 @@ const (
 	ParamFuncName = "func"
 	// Targetnet indicates target network
 	Targetnet = rpc.Testnet
+	// HTTPAddr is a listen address for JSON-RPC over HTTP
+	HTTPAddr = ":8545"
+	// WsAddr is a listen address for JSON-RPC over WebSocket
+	WsAddr = ":8546"
+	// AdminAddr is a listen address for admin JSON-RPC, keep it private
+	AdminAddr = "127.0.0.1:8547"
+	// JobDrainMargin is time left before Lambda timeout when draining async jobs stops
+	JobDrainMargin = 30 * time.Second
+	// APIKeyHeader is a header of the API key of a client, to account gas of its requests
+	APIKeyHeader = "X-Api-Key"
 )
 
-func handler(req json.RPCRequest) (body string, statusCode int) {
+// handler serves a JSON-RPC request, ctx bounds the call to ethereum node
+func handler(ctx context.Context, req json.RPCRequest) (body string, statusCode int) {
 	//log.Info("request:", req.String())
 	var resp json.RPCResponse
 	var err error
-	if metaresolver.Contains(req.Method) {
-		// Forward RPC request to metaservice function (v3)
-		resp, err = metaresolver.Forward(req)
-	} else if metaservice.Contains(req.Method) {
-		// Forward RPC request to metaservice function (v2)
-		resp, err = metaservice.Forward(req)
+	if isLocalMethod(req.Method) {
+		if resp, err = forward(ctx, req); err != nil {
+			resp = errorResponse(req, json.ErrCodeInternal, err)
+		}
 	} else {
 		// Forward RPC request to Ether node
 		var respBody string
-		if respBody, err = rpc.GetInstance().DoRPC(req); err == nil {
+		if respBody, err = rpc.GetInstance().DoRPCContext(ctx, req); err == nil {
 			// Relay a response from the node
 			resp = json.GetRPCResponseFromJSON(respBody)
+		} else {
+			// In case of server-side RPC fail
+			resp = errorResponse(req, json.ErrCodeServer, err)
 		}
 	}
 
-	statusCode = 200
-	if err != nil {
-		// In case of server-side RPC fail
-		log.Error(err.Error())
-		resp.Error = &json.RPCError{
-			Code:    -1,
-			Message: err.Error(),
-		}
-		statusCode = 400
+	statusCode = json.HTTPStatus(resp.Error)
+	if req.IsNotification() {
+		return
 	}
 	body = resp.String()
 	return
 }
 
+// isLocalMethod returns true if the method is served by metaresolver or metaservice
+func isLocalMethod(method string) bool {
+	return metaresolver.Contains(method) || metaservice.Contains(method)
+}
+
+// forward delivers the request to metaresolver or metaservice, ctx bounds their calls to ethereum node
+func forward(ctx context.Context, req json.RPCRequest) (json.RPCResponse, error) {
+	if metaresolver.Contains(req.Method) {
+		// Forward RPC request to metaservice function (v3)
+		return metaresolver.Forward(ctx, req)
+	}
+	// Forward RPC request to metaservice function (v2)
+	return metaservice.Forward(ctx, req)
+}
+
+func errorResponse(req json.RPCRequest, code int32, err error) json.RPCResponse {
+	log.Error(err.Error())
+	return json.RPCResponse{
+		Jsonrpc: json.Version,
+		ID:      req.ID,
+		Error:   json.NewRPCError(code, err.Error()),
+	}
+}
+
+// invalidResponse returns a response for a request which can't be handled
+func invalidResponse(id interface{}, rpcErr *json.RPCError) (body string, statusCode int) {
+	resp := json.RPCResponse{
+		Jsonrpc: json.Version,
+		ID:      id,
+		Error:   rpcErr,
+	}
+	return resp.String(), json.HTTPStatus(rpcErr)
+}
+
+// batchHandler handles JSON-RPC batch request, apiKey is of the client
+// Local methods are served one by one, node methods are relayed as one batch.
+// Responses keep the order of requests and notifications get no response.
+func batchHandler(ctx context.Context, msg string, apiKey string) (body string, statusCode int) {
+	reqs, rpcErr := json.GetRPCBatchFromJSON(msg)
+	if rpcErr != nil {
+		return invalidResponse(nil, rpcErr)
+	}
+	for i := range reqs {
+		reqs[i].APIKey = apiKey
+	}
+	if len(reqs) == 0 {
+		return invalidResponse(nil, json.NewRPCError(json.ErrCodeInvalidRequest, "Invalid Request"))
+	}
+
+	resps := make([]json.RPCResponse, len(reqs))
+	var relayReqs []json.RPCRequest
+	var relayIdx []int
+	for i, req := range reqs {
+		switch {
+		case req.Method == "":
+			resps[i] = json.RPCResponse{
+				Jsonrpc: json.Version,
+				ID:      req.ID,
+				Error:   json.NewRPCError(json.ErrCodeInvalidRequest, "Invalid Request"),
+			}
+		case isLocalMethod(req.Method):
+			var err error
+			if resps[i], err = forward(ctx, req); err != nil {
+				resps[i] = errorResponse(req, json.ErrCodeInternal, err)
+			}
+		default:
+			relayReqs = append(relayReqs, req)
+			relayIdx = append(relayIdx, i)
+		}
+	}
+
+	if len(relayReqs) > 0 {
+		relayResps, err := rpc.GetInstance().DoRPCBatchContext(ctx, relayReqs)
+		for i, idx := range relayIdx {
+			if err != nil {
+				resps[idx] = errorResponse(relayReqs[i], json.ErrCodeServer, err)
+			} else {
+				resps[idx] = relayResps[i]
+			}
+		}
+	}
+
+	// Drop responses of notifications
+	var ret []json.RPCResponse
+	for i, req := range reqs {
+		if !req.IsNotification() {
+			ret = append(ret, resps[i])
+		}
+	}
+	return json.BatchString(ret), 200
+}
+
+// scheduledEvent is a CloudWatch scheduled event invoking Lambda
+type scheduledEvent struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+}
+
+// lambdaEntry drains async jobs on a scheduled event, and handles APIGatewayProxyRequest otherwise
+func lambdaEntry(ctx context.Context, event encodingJson.RawMessage) (interface{}, error) {
+	var scheduled scheduledEvent
+	if encodingJson.Unmarshal(event, &scheduled) == nil && scheduled.DetailType == "Scheduled Event" {
+		if q := jobs.GetInstance(); q != nil {
+			// Leave time to keep the result of the last job
+			if deadline, ok := ctx.Deadline(); ok {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, deadline.Add(-JobDrainMargin))
+				defer cancel()
+			}
+			q.Drain(ctx, jobs.Lanes())
+		}
+		return nil, nil
+	}
+	var request events.APIGatewayProxyRequest
+	if err := encodingJson.Unmarshal(event, &request); err != nil {
+		return nil, err
+	}
+	return lambdaHandler(ctx, request)
+}
+
 // lambdaHandler handles APIGatewayProxyRequest as JSON-RPC request
 func lambdaHandler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if id, ok := did.PathDID(request.Path); ok && request.HTTPMethod == "GET" {
+		body, contentType, statusCode := did.Response(metaresolver.ResolveDID(ctx, id), request.Headers["Accept"])
+		return events.APIGatewayProxyResponse{Body: string(body), StatusCode: statusCode, Headers: map[string]string{"Content-Type": contentType}}, nil
+	}
+
+	if json.IsBatch(request.Body) {
+		respBody, statusCode := batchHandler(ctx, request.Body, lambdaHeader(request, APIKeyHeader))
+		return events.APIGatewayProxyResponse{Body: respBody, StatusCode: statusCode}, nil
+	}
+
+	method := request.QueryStringParameters[ParamFuncName]
+	if method == "" {
+		method = request.PathParameters[ParamFuncName]
+	}
+
 	// Validate RPC request
-	req := json.GetRPCRequestFromJSON(request.Body)
-	if method := request.QueryStringParameters[ParamFuncName]; method != "" {
-		req.Method = method
-	} else if method := request.PathParameters[ParamFuncName]; method != "" {
+	var req json.RPCRequest
+	var rpcErr *json.RPCError
+	if method != "" && strings.TrimSpace(request.Body) == "" {
+		// Method only request without body
+		req = json.RPCRequest{Jsonrpc: json.Version}
+	} else {
+		req, rpcErr = json.GetRPCRequestFromJSON(request.Body)
+	}
+	if method != "" {
 		req.Method = method
+		if rpcErr != nil && rpcErr.Code == json.ErrCodeInvalidRequest {
+			rpcErr = req.Validate()
+		}
 	}
 
-	respBody, statusCode := handler(req)
+	var respBody string
+	var statusCode int
+	if rpcErr != nil {
+		respBody, statusCode = invalidResponse(req.ID, rpcErr)
+	} else {
+		req.APIKey = lambdaHeader(request, APIKeyHeader)
+		respBody, statusCode = handler(ctx, req)
+	}
 	return events.APIGatewayProxyResponse{Body: respBody, StatusCode: statusCode}, nil
 }
 
+// lambdaHeader returns the header of APIGatewayProxyRequest, of which names can be in any case
+func lambdaHeader(request events.APIGatewayProxyRequest, name string) string {
+	for k, v := range request.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
 // httpHandler handles http.Request as JSON-RPC request
 func httpHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := ioutil.ReadAll(r.Body)
@@ -87,9 +260,46 @@ func httpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Info("request:", r.RemoteAddr, string(b))
-	req := json.GetRPCRequestFromJSON(string(b))
-	respBody, statusCode := handler(req)
+	var respBody string
+	var statusCode int
+	if json.IsBatch(string(b)) {
+		respBody, statusCode = batchHandler(r.Context(), string(b), r.Header.Get(APIKeyHeader))
+	} else if req, rpcErr := json.GetRPCRequestFromJSON(string(b)); rpcErr != nil {
+		respBody, statusCode = invalidResponse(req.ID, rpcErr)
+	} else {
+		req.APIKey = r.Header.Get(APIKeyHeader)
+		respBody, statusCode = handler(r.Context(), req)
+	}
 	log.Info("response:", r.RemoteAddr, statusCode, respBody)
+	w.Header().Set("Content-Type", rpc.ContentType)
+	w.WriteHeader(statusCode)
+	w.Write([]byte(respBody))
+}
+
+// adminHandler handles http.Request as admin JSON-RPC request
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var respBody string
+	var statusCode int
+	if req, rpcErr := json.GetRPCRequestFromJSON(string(b)); rpcErr != nil {
+		respBody, statusCode = invalidResponse(req.ID, rpcErr)
+	} else if !admin.Contains(req.Method) {
+		respBody, statusCode = invalidResponse(req.ID, json.NewRPCError(json.ErrCodeMethodNotFound, "Method not found"))
+	} else {
+		resp, err := admin.Forward(req)
+		if err != nil {
+			resp = errorResponse(req, json.ErrCodeInternal, err)
+		}
+		respBody, statusCode = resp.String(), json.HTTPStatus(resp.Error)
+	}
+	log.Info("admin:", r.RemoteAddr, string(b), statusCode)
+	w.Header().Set("Content-Type", rpc.ContentType)
 	w.WriteHeader(statusCode)
 	w.Write([]byte(respBody))
 }
@@ -104,11 +314,22 @@ func help() {
 	fmt.Println("    $> export KEY_PATH=[path]")
 	fmt.Println("    $> export KEY_PASSPHRASE=[passphrase]")
 	fmt.Println("    $> proxy")
+	fmt.Println("  [path] is a key file, a directory of key files or comma-separated list of them")
+	fmt.Println("  Keys in [path] share [passphrase], and sign TXs in parallel")
+	fmt.Println("  Option 4. keys kept by external signer, refer to cmd/signer")
+	fmt.Println("    $> export SIGNER_URL=[http://127.0.0.1:8550 or path of Unix socket]")
+	fmt.Println("    $> proxy")
 }
 
 func init() {
 	rpc.NetType = Targetnet
 
+	// Keys are kept by external signer
+	if os.Getenv(crypto.SignerURL) != "" {
+		crypto.GetInstance()
+		return
+	}
+
 	// Initialize Crypto with arguments
 	var path, passphrase string
 	if path = os.Getenv(crypto.Path); path != "" {
@@ -138,11 +359,25 @@ func main() {
 	log.Info("Server starting...")
 	if os.Getenv(crypto.IsAwsLambda) != "" {
 		log.Info("Ready to start Lambda")
-		lambda.Start(lambdaHandler)
+		lambda.Start(lambdaEntry)
 	} else {
 		log.Info("Ready to start HTTP/HTTPS")
+		// Backfill and follow events while serving
+		indexer.GetInstance()
+		// Resume pending webhook deliveries
+		webhook.GetInstance()
+		// Run async jobs queued
+		jobs.GetInstance()
 		h := http.NewServeMux()
 		h.HandleFunc("/", httpHandler)
-		endless.ListenAndServe(":8545", h)
+		h.Handle(did.HTTPPath, did.NewHandler(metaresolver.ResolveDID))
+		ws := http.NewServeMux()
+		ws.Handle("/", wsServer())
+		go endless.ListenAndServe(WsAddr, ws)
+		a := http.NewServeMux()
+		a.HandleFunc("/", adminHandler)
+		a.HandleFunc(admin.AccountingExportPath, admin.AccountingExportHandler)
+		go endless.ListenAndServe(AdminAddr, a)
+		endless.ListenAndServe(HTTPAddr, h)
 	}
 }
@@ -1,7 +1,9 @@
 package metaresolver
 
 import (
+	"context"
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/metadium/go-delegator/crypto"
@@ -12,7 +14,7 @@ import (
 	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
 )
 
-func getIdentityRegistryAddress(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getIdentityRegistryAddress(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, "Call getIdentityRegistryAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -28,7 +30,7 @@ func getIdentityRegistryAddress(reqID uint64, req json.RPCRequest) (resp json.RP
 	}
 	return
 }
-func getServiceKeyAddress(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getServiceKeyAddress(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, "Call getServiceKeyAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -44,7 +46,7 @@ func getServiceKeyAddress(reqID uint64, req json.RPCRequest) (resp json.RPCRespo
 	}
 	return
 }
-func getServiceKeyAllAddresses(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getServiceKeyAllAddresses(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, "Call getServiceKeyAllAddresses Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -64,7 +66,7 @@ func getServiceKeyAllAddresses(reqID uint64, req json.RPCRequest) (resp json.RPC
 	return
 }
 
-func getPublicKeyAddress(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getPublicKeyAddress(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, "Call getPublicKeyAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -80,7 +82,7 @@ func getPublicKeyAddress(reqID uint64, req json.RPCRequest) (resp json.RPCRespon
 	}
 	return
 }
-func getPublicKeyAllAddresses(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getPublicKeyAllAddresses(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, "Call getPublicKeyAllAddresses Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -100,14 +102,14 @@ func getPublicKeyAllAddresses(reqID uint64, req json.RPCRequest) (resp json.RPCR
 	return
 }
 
-func getResolverAddresses(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getResolverAddresses(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, "Call getResolverAddresses Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
 	resp.Result = makeResolverAddresses()
 	return
 }
-func getProviderAddresses(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getProviderAddresses(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, "Call getProviderAddresses Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -116,7 +118,7 @@ func getProviderAddresses(reqID uint64, req json.RPCRequest) (resp json.RPCRespo
 	return
 }
 
-func getAllServiceAddresses(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getAllServiceAddresses(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, "Call getAllServiceAddresses Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -160,6 +162,20 @@ func getDelegatorAddress() (*common.Address, error) {
 	delegatorAddr := common.HexToAddress(crypto.GetInstance().GetAddress())
 	return &delegatorAddr, nil
 }
+
+// getProviderSigner returns a delegator account which is a provider for the EIN, or nil if none
+func getProviderSigner(ctx context.Context, reqID uint64, ein *big.Int) (*crypto.Account, error) {
+	for _, account := range crypto.GetInstance().Accounts() {
+		isProvider, err := identityregistry.CallIsProviderFor(ctx, reqID, ein, common.HexToAddress(account.GetAddress()))
+		if err != nil {
+			return nil, err
+		}
+		if isProvider {
+			return account, nil
+		}
+	}
+	return nil, nil
+}
 func makeResolverAddresses() []common.Address {
 	addrs := []common.Address{*servicekeyresolver.GetAddress(), *publickeyresolver.GetAddress()}
 	return addrs
@@ -0,0 +1,63 @@
+package metaresolver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/metadium/go-delegator/did"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
+	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+	"github.com/metadium/go-delegator/rpc"
+)
+
+var (
+	didResolver     *did.Resolver
+	didResolverOnce sync.Once
+)
+
+// getDIDResolver returns the resolver of DIDs on the network delegator serves
+func getDIDResolver() *did.Resolver {
+	didResolverOnce.Do(func() {
+		_rpc := rpc.GetInstance()
+		network := did.Testnet
+		if _rpc.NetType == rpc.Mainnet {
+			network = did.Mainnet
+		}
+		var err error
+		didResolver, err = did.NewResolver(network, _rpc.NetVersion, _rpc.GetEthClient(), *identityregistry.GetAddress(),
+			servicekeyresolver.GetAddressList(), publickeyresolver.GetAddressList())
+		if err != nil {
+			log.Error(err)
+		}
+	})
+	return didResolver
+}
+
+// ResolveDID resolves the did:meta identifier, errors are reported in the resolution metadata
+func ResolveDID(ctx context.Context, s string) *did.Result {
+	resolver := getDIDResolver()
+	if resolver == nil {
+		return &did.Result{ResolutionMetadata: did.ResolutionMetadata{Error: did.ErrInternal, ErrorMessage: "Cannot make DID resolver"}}
+	}
+	return resolver.Resolve(ctx, s)
+}
+
+func resolveDID(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call resolveDID Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(resolveDIDParams)
+	log.Debugfd(reqID, "parameter[DID] : %s", reqParam.DID)
+
+	// As DID resolution, a DID not resolved is not an error of the request
+	resp.Result = ResolveDID(ctx, reqParam.DID)
+	return
+}
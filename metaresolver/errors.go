@@ -1,6 +1,12 @@
 package metaresolver
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/metadium/go-delegator/rpc"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
 
 // Error is general error interface in Proxy
 type Error interface {
@@ -8,6 +14,12 @@ type Error interface {
 	ErrorCode() int32 // returns the code
 }
 
+// DataError is an Error with additional data in the response
+type DataError interface {
+	Error
+	ErrorData() interface{} // returns the data of the error
+}
+
 // request is for an unknown service
 type methodNotFoundError struct {
 	//	service string
@@ -113,3 +125,79 @@ type invalidPermissionError struct{ message string }
 func (e *invalidPermissionError) ErrorCode() int32 { return -32019 }
 
 func (e *invalidPermissionError) Error() string { return e.message }
+
+type notExistsTransactionError struct{ message string }
+
+func (e *notExistsTransactionError) ErrorCode() int32 { return -32617 }
+
+func (e *notExistsTransactionError) Error() string { return e.message }
+
+type invalidTimestampError struct{ message string }
+
+func (e *invalidTimestampError) ErrorCode() int32 { return -32619 }
+
+func (e *invalidTimestampError) Error() string { return e.message }
+
+type replayedSignatureError struct{ message string }
+
+func (e *replayedSignatureError) ErrorCode() int32 { return -32620 }
+
+func (e *replayedSignatureError) Error() string { return e.message }
+
+// recovery or recovery address change is locked by the recovery timeout
+type recoveryTimeoutError struct{ message string }
+
+func (e *recoveryTimeoutError) ErrorCode() int32 { return -32621 }
+
+func (e *recoveryTimeoutError) Error() string { return e.message }
+
+// event indexer is not running, such as in AWS lambda
+type indexerUnavailableError struct{ message string }
+
+func (e *indexerUnavailableError) ErrorCode() int32 { return -32622 }
+
+func (e *indexerUnavailableError) Error() string { return e.message }
+
+// async job of the ID doesn't exist
+type notExistsJobError struct{ message string }
+
+func (e *notExistsJobError) ErrorCode() int32 { return -32623 }
+
+func (e *notExistsJobError) Error() string { return e.message }
+
+// idempotency key of an async request is used by other request
+type idempotencyKeyError struct{ message string }
+
+func (e *idempotencyKeyError) ErrorCode() int32 { return -32624 }
+
+func (e *idempotencyKeyError) Error() string { return e.message }
+
+// delegated TX would revert, nothing is sent
+type executionRevertedError struct {
+	reason string
+	data   hexutil.Bytes
+}
+
+func (e *executionRevertedError) ErrorCode() int32 { return -32618 }
+
+func (e *executionRevertedError) Error() string {
+	if e.reason == "" {
+		return "execution reverted"
+	}
+	return "execution reverted: " + e.reason
+}
+
+func (e *executionRevertedError) ErrorData() interface{} {
+	return struct {
+		Reason string        `json:"reason"`
+		Data   hexutil.Bytes `json:"data,omitempty"`
+	}{e.reason, e.data}
+}
+
+// callError returns the error of a failed delegated TX, reverted in simulation or internal
+func callError(err error) Error {
+	if revertErr, ok := err.(*rpc.RevertError); ok {
+		return &executionRevertedError{revertErr.Reason, revertErr.Data}
+	}
+	return &internalError{err.Error()}
+}
@@ -0,0 +1,103 @@
+package metaresolver
+
+import (
+	"context"
+	"github.com/metadium/go-delegator/indexer"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+)
+
+// getIndexer returns the event indexer, or the error if not running
+func getIndexer() (*indexer.Indexer, Error) {
+	ix := indexer.GetInstance()
+	if ix == nil {
+		return nil, &indexerUnavailableError{"Event indexer is not available"}
+	}
+	return ix, nil
+}
+
+func getIdentityHistory(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getIdentityHistory Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(einParams)
+	log.Debugfd(reqID, "parameter[EIN] : %v", reqParam.EIN)
+	ix, errObj := getIndexer()
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	events, err := ix.History(reqParam.EIN)
+	setQueryResult(&resp, events, err)
+	return
+}
+
+func getKeyChanges(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getKeyChanges Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(einParams)
+	log.Debugfd(reqID, "parameter[EIN] : %v", reqParam.EIN)
+	ix, errObj := getIndexer()
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	events, err := ix.KeyChanges(reqParam.EIN)
+	setQueryResult(&resp, events, err)
+	return
+}
+
+func getAddressHistory(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getAddressHistory Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(addressParams)
+	log.Debugfd(reqID, "parameter[Address] : %x", reqParam.Address)
+	ix, errObj := getIndexer()
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	events, err := ix.AddressHistory(reqParam.Address)
+	if err != nil {
+		setQueryResult(&resp, nil, err)
+		return
+	}
+	ein, err := ix.EINOf(reqParam.Address, events)
+	setQueryResult(&resp, &addressHistoryResult{EIN: ein, Events: events}, err)
+	return
+}
+
+func getIndexerStatus(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getIndexerStatus Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	ix, errObj := getIndexer()
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	status, err := ix.Status()
+	setQueryResult(&resp, status, err)
+	return
+}
@@ -0,0 +1,272 @@
+package metaresolver
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/metadium/go-delegator/accounting"
+	proxyCommon "github.com/metadium/go-delegator/common"
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/jobs"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+// Members of params asking a delegated write to be queued
+const (
+	asyncParam          = "async"
+	idempotencyKeyParam = "idempotency_key"
+)
+
+// asyncMethods are delegated writes which can be queued as jobs
+var asyncMethods = map[string]bool{
+	"create_identity":                     true,
+	"add_associated_address_delegated":    true,
+	"remove_associated_address_delegated": true,
+	"trigger_recovery":                    true,
+	"trigger_recovery_address_change":     true,
+	"add_providers_delegated":             true,
+	"remove_providers_delegated":          true,
+	"add_resolvers_delegated":             true,
+	"remove_resolvers_delegated":          true,
+	"add_key_delegated":                   true,
+	"remove_key_delegated":                true,
+	"remove_keys_delegated":               true,
+	"add_public_key_delegated":            true,
+	"remove_public_key_delegated":         true,
+}
+
+// jobContext is the job of a request being handled
+type jobContext struct {
+	job *jobs.Job
+	// submitting is true while the request is checked to be queued, false while run by a worker
+	submitting bool
+}
+
+// jobContexts are jobs by request ID
+var jobContexts sync.Map
+
+func init() {
+	jobs.RegisterExecutor(runJob)
+}
+
+func jobOf(reqID uint64) *jobContext {
+	if c, ok := jobContexts.Load(reqID); ok {
+		return c.(*jobContext)
+	}
+	return nil
+}
+
+// jobIDOf returns the ID of the job of the request, empty if not async
+func jobIDOf(reqID uint64) string {
+	if c := jobOf(reqID); c != nil {
+		return c.job.ID
+	}
+	return ""
+}
+
+// jobSigner returns the key of the lane running the job of the request, nil if not a job
+func jobSigner(reqID uint64) *crypto.Account {
+	if c := jobOf(reqID); c != nil && !c.submitting {
+		return crypto.GetInstance().Account(c.job.Lane)
+	}
+	return nil
+}
+
+// asyncRequest returns true and the idempotency key if the request asks to be queued
+func asyncRequest(req json.RPCRequest) (bool, string) {
+	if len(req.Params) != 1 {
+		return false, ""
+	}
+	obj, ok := req.Params[0].(map[string]interface{})
+	if !ok {
+		return false, ""
+	}
+	async, _ := obj[asyncParam].(bool)
+	key, _ := obj[idempotencyKeyParam].(string)
+	return async, key
+}
+
+// submitJob checks the request as sent synchronously, and queues it instead of sending the TX
+func submitJob(ctx context.Context, reqID uint64, req json.RPCRequest, key string) (resp json.RPCResponse) {
+	log.Debugd(reqID, " Call submitJob Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	if !asyncMethods[req.Method] {
+		errObj := &invalidParamsError{fmt.Sprintf("%s can't be requested async", req.Method)}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	q := jobs.GetInstance()
+	if q == nil {
+		errObj := &internalError{"Job queue is not available"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	// Options of the request are not kept with the job
+	params := make(map[string]interface{})
+	for k, v := range req.Params[0].(map[string]interface{}) {
+		if k != asyncParam && k != idempotencyKeyParam {
+			params[k] = v
+		}
+	}
+	job := jobs.NewJob(req.Method, []interface{}{params}, key)
+	job.APIKey = req.APIKey
+	if key != "" {
+		existing, err := q.Reserve(job)
+		if err == jobs.ErrSubmitting {
+			errObj := &idempotencyKeyError{"Job of the idempotency key is being submitted, retry later"}
+			resp.Error = makeErrorResponse(errObj)
+			return
+		} else if err != nil {
+			errObj := &internalError{err.Error()}
+			resp.Error = makeErrorResponse(errObj)
+			return
+		}
+		if existing != nil {
+			if existing.ParamsHash != job.ParamsHash {
+				errObj := &idempotencyKeyError{"Idempotency key is used for other request"}
+				resp.Error = makeErrorResponse(errObj)
+				return
+			}
+			log.Debugfd(reqID, "Job %s of the idempotency key is returned", existing.ID)
+			resp.Result = existing.Summary()
+			return
+		}
+	}
+
+	jobContexts.Store(reqID, &jobContext{job: job, submitting: true})
+	defer jobContexts.Delete(reqID)
+	handler := predefinedPaths[req.Method].(func(context.Context, uint64, json.RPCRequest) (json.RPCResponse, error))
+	resp, _ = handler(ctx, reqID, json.RPCRequest{Jsonrpc: req.Jsonrpc, Method: req.Method, Params: job.Params, ID: req.ID})
+	if resp.Error != nil {
+		q.Release(job)
+	}
+	return
+}
+
+// queueJob queues the request checked if it is submitted async, and sets the job to resp.
+// signer is the key which must send the TX, or nil for any key.
+// It returns false if the TX is to be sent now
+func queueJob(reqID uint64, resp *json.RPCResponse, signer *crypto.Account) bool {
+	c := jobOf(reqID)
+	if c == nil || !c.submitting {
+		return false
+	}
+	lanes := jobs.Lanes()
+	if signer != nil {
+		lanes = []string{signer.GetAddress()}
+	}
+	if err := jobs.GetInstance().Enqueue(c.job, lanes); err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return true
+	}
+	log.Debugfd(reqID, "PASS - Queue job %s to %s", c.job.ID, c.job.Lane)
+	// The TX counts against quotas until the job runs
+	accounting.Hold(reqID, c.job.ID)
+	resp.Result = c.job.Summary()
+	return true
+}
+
+// runJob runs the request of the job by the key of its lane
+func runJob(job *jobs.Job) (txHash string, rpcErr *json.RPCError) {
+	reqID := proxyCommon.RandomUint64()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Internal Error(Panic) : %s", debug.Stack())
+			rpcErr = &json.RPCError{Code: json.ErrCodeInternal, Message: "Internal Error"}
+		}
+	}()
+	handler, ok := predefinedPaths[job.Method].(func(context.Context, uint64, json.RPCRequest) (json.RPCResponse, error))
+	if !ok || !asyncMethods[job.Method] {
+		return "", makeErrorResponse(&methodNotFoundError{job.Method})
+	}
+
+	jobContexts.Store(reqID, &jobContext{job: job})
+	defer jobContexts.Delete(reqID)
+	// Quotas are checked when the job is submitted
+	req := json.RPCRequest{Jsonrpc: json.Version, Method: job.Method, Params: job.Params, ID: job.ID, APIKey: job.APIKey}
+	accounting.Resume(reqID, req, job.ID)
+	defer accounting.End(reqID)
+	// The job is not bound to the request which submitted it
+	resp, _ := handler(context.Background(), reqID, req)
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+	txHash, _ = resp.Result.(string)
+	return txHash, nil
+}
+
+// refreshJob updates the job sent with the status of its TX
+func refreshJob(q *jobs.Queue, job *jobs.Job) error {
+	t := tracker.GetInstance()
+	if t == nil || job.Status != jobs.StatusSent {
+		return nil
+	}
+	rec, err := t.Get(job.TxHash)
+	if err == store.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !rec.Final() {
+		return nil
+	}
+	if rec.MinedHash != "" {
+		job.TxHash = rec.MinedHash
+	}
+	switch rec.Status {
+	case tracker.StatusMined:
+		job.Status = jobs.StatusMined
+	case tracker.StatusReverted:
+		job.Status = jobs.StatusFailed
+		job.Error = makeErrorResponse(&executionRevertedError{reason: rec.RevertReason})
+	default:
+		job.Status = jobs.StatusFailed
+		job.Error = makeErrorResponse(&internalError{"Transaction is dropped"})
+	}
+	return q.Put(job)
+}
+
+func getJob(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getJob Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(jobParams)
+	log.Debugfd(reqID, "parameter[ID] : %v", reqParam.ID)
+
+	q := jobs.GetInstance()
+	if q == nil {
+		errObj := &internalError{"Job queue is not available"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	job, err := q.Get(reqParam.ID)
+	if err == store.ErrNotFound {
+		errObj := &notExistsJobError{"Job is not found"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	} else if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if err := refreshJob(q, job); err != nil {
+		log.Warnfd(reqID, "Failed to refresh job %s: %v", job.ID, err)
+	}
+
+	resp.Result = job.Summary()
+	return
+}
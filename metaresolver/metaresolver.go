@@ -2,10 +2,12 @@
 package metaresolver
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"runtime/debug"
 
+	"github.com/metadium/go-delegator/accounting"
 	proxyCommon "github.com/metadium/go-delegator/common"
 	"github.com/metadium/go-delegator/json"
 	"github.com/metadium/go-delegator/log"
@@ -16,17 +18,23 @@ var (
 )
 
 func makeErrorResponse(err Error) *json.RPCError {
-	return &json.RPCError{
+	rpcErr := &json.RPCError{
 		Code:    err.ErrorCode(),
 		Message: err.Error(),
 	}
+	if dataErr, ok := err.(DataError); ok {
+		rpcErr.Data = dataErr.ErrorData()
+	}
+	return rpcErr
 }
 
 // Forward delivers RPCRequest to predefined function and returns that
-func Forward(req json.RPCRequest) (resp json.RPCResponse, err error) {
+func Forward(ctx context.Context, req json.RPCRequest) (resp json.RPCResponse, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Errorf("Internal Error(Panic) : %s", debug.Stack())
+			resp.ID = req.ID
+			resp.Jsonrpc = req.Jsonrpc
 			resp.Error = &json.RPCError{
 				Code:    -32603,
 				Message: "Internal Error",
@@ -36,7 +44,17 @@ func Forward(req json.RPCRequest) (resp json.RPCResponse, err error) {
 	for k, v := range predefinedPaths {
 		if k == req.Method {
 			requestID := proxyCommon.RandomUint64()
-			return v.(func(uint64, json.RPCRequest) (json.RPCResponse, error))(requestID, req)
+			defer accounting.End(requestID)
+			if errObj := meter(requestID, req); errObj != nil {
+				resp.ID = req.ID
+				resp.Jsonrpc = req.Jsonrpc
+				resp.Error = makeErrorResponse(errObj)
+				return resp, nil
+			}
+			if async, key := asyncRequest(req); async {
+				return submitJob(ctx, requestID, req, key), nil
+			}
+			return v.(func(context.Context, uint64, json.RPCRequest) (json.RPCResponse, error))(ctx, requestID, req)
 		}
 	}
 	err = fmt.Errorf("predefined NOT FOUND")
@@ -59,6 +77,32 @@ var predefinedPaths = map[string]interface{}{
 	"add_associated_address_delegated":    addAssociatedAddressDelegated,
 	"remove_associated_address_delegated": removeAssociatedAddressDelegated,
 
+	"trigger_recovery":                triggerRecovery,
+	"trigger_recovery_address_change": triggerRecoveryAddressChange,
+	"trigger_destruction":             triggerDestruction,
+
+	"add_providers_delegated":    addProvidersDelegated,
+	"remove_providers_delegated": removeProvidersDelegated,
+	"add_resolvers_delegated":    addResolversDelegated,
+	"remove_resolvers_delegated": removeResolversDelegated,
+	"get_identity_providers":     getIdentityProviders,
+	"get_identity_resolvers":     getIdentityResolvers,
+
+	"get_identity":              getIdentity,
+	"get_ein":                   getEIN,
+	"has_identity":              hasIdentity,
+	"is_associated_address_for": isAssociatedAddressFor,
+	"get_service_keys":          getServiceKeys,
+	"is_key_for":                isKeyFor,
+	"get_public_key":            getPublicKey,
+
+	"resolve_did": resolveDID,
+
+	"get_identity_history": getIdentityHistory,
+	"get_address_history":  getAddressHistory,
+	"get_key_changes":      getKeyChanges,
+	"get_indexer_status":   getIndexerStatus,
+
 	"add_key_delegated":     addKeyDelegated,
 	"remove_key_delegated":  removeKeyDelegated,
 	"remove_keys_delegated": removeKeysDelegated,
@@ -74,4 +118,7 @@ var predefinedPaths = map[string]interface{}{
 
 	"add_public_key_delegated":    addPublicKeyDelegated,
 	"remove_public_key_delegated": removePublicKeyDelegated,
+
+	"get_transaction_status": getTransactionStatus,
+	"get_job":                getJob,
 }
@@ -2,17 +2,28 @@ package metaresolver
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"math/big"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethCrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/metadium/go-delegator/crypto"
 	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
 	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
 	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+	"github.com/metadium/go-delegator/rpc"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -46,7 +57,7 @@ func TestGetProviderAddresses(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -66,7 +77,7 @@ func TestGetIdentityRegistryAddress(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -85,7 +96,7 @@ func TestGetServiceKeyAddress(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -104,7 +115,7 @@ func TestGetServiceKeyAllAddresses(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -124,7 +135,7 @@ func TestGetPublicKeyAddress(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -143,7 +154,7 @@ func TestGetPublicKeyAllAddresses(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -163,7 +174,7 @@ func TestGetResolverAddresses(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -183,7 +194,7 @@ func TestGetAllServiceAddresses(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -221,7 +232,7 @@ func TestCreateIdentity(t *testing.T) {
 	req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -251,7 +262,7 @@ func TestAddAssociatedAddressDelegated(t *testing.T) {
 	req.Params = append(req.Params, reqParam)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -280,7 +291,7 @@ func TestRemoveAssociatedAddressDelegated(t *testing.T) {
 	req.Params = append(req.Params, reqParam)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -313,7 +324,7 @@ func TestAddKeyDelegated(t *testing.T) {
 	req.Params = append(req.Params, reqParam)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -345,7 +356,7 @@ func TestRemoveKeyDelegated(t *testing.T) {
 	req.Params = append(req.Params, reqParam)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -375,7 +386,7 @@ func TestRemoveKeysDelegated(t *testing.T) {
 	req.Params = append(req.Params, reqParam)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -407,7 +418,7 @@ func TestAddPublicKeyDelegated(t *testing.T) {
 	req.Params = append(req.Params, reqParam)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -438,10 +449,409 @@ func TestRemovePublicKeyDelegated(t *testing.T) {
 	req.Params = append(req.Params, reqParam)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
 	}
 	fmt.Println("Response : ", resp.String())
 }
+
+func TestDecodeIdentityCreated(t *testing.T) {
+	rec := &tracker.Record{Method: "create_identity"}
+	logs := []*types.Log{
+		{Address: common.HexToAddress("0x01"), Topics: []common.Hash{identityCreatedTopic, {}, common.BigToHash(big.NewInt(1))}},
+		{Address: *identityregistry.GetAddress(), Topics: []common.Hash{identityCreatedTopic, {}, common.BigToHash(big.NewInt(42))}},
+	}
+	decodeIdentityCreated(rec, logs)
+	if rec.EIN != "42" {
+		t.Errorf("Expected EIN 42, got %q", rec.EIN)
+	}
+}
+
+func TestCallError(t *testing.T) {
+	data := hexutil.MustDecode("0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000011" +
+		"496e76616c6964207369676e6174757265000000000000000000000000000000")
+	resp := makeErrorResponse(callError(rpc.NewRevertError(data)))
+	if resp.Code != -32618 || resp.Message != "execution reverted: Invalid signature" || resp.Data == nil {
+		t.Errorf("Unexpected error %+v", resp)
+	}
+
+	resp = makeErrorResponse(callError(fmt.Errorf("timeout")))
+	if resp.Code != -32603 || resp.Data != nil {
+		t.Errorf("Unexpected error %+v", resp)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	irAddr := common.HexToAddress("0xBE2bB3d7085fF04BdE4B3F177a730a826f05cB70")
+	resolver := common.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")
+	// Signed by keys b71c71a6...f291 and 8a1f9a8f...7b7a
+	addr := common.HexToAddress("0x71562b71999873DB5b286dF957af199Ec94617F7")
+	addr2 := common.HexToAddress("0x703c4b2bD70c169f5717101CaeE543299Fc946C7")
+	ts := big.NewInt(1546300800)
+
+	create := identityCreateParams{RecoveryAddress: addr2, AssociatedAddress: addr, Providers: []common.Address{resolver}, Resolvers: []common.Address{resolver}, Timestamp: ts}
+	createHash, _ := create.Keccak256(&irAddr)
+	create.Timestamp = big.NewInt(1546300801)
+	createTamperedHash, _ := create.Keccak256(&irAddr)
+
+	addKey := addKeyDelegatedParams{ResolverAddress: resolver, AssociatedAddress: addr, Key: addr2, Symbol: "MYKEY", Timestamp: ts}
+	addKeyHash, _ := addKey.Keccak256()
+	addKey.Symbol = "OTHER"
+	addKeyTamperedHash, _ := addKey.Keccak256()
+
+	addAssoc := addAssociatedAddressDelegatedParams{ApprovingAddress: addr, AddressToAdd: addr2, Timestamp: [2]*big.Int{ts, ts}}
+	approveHash, _, addHash, _ := addAssoc.Keccak256(&irAddr, big.NewInt(7))
+	otherEINHash, _, _, _ := addAssoc.Keccak256(&irAddr, big.NewInt(8))
+
+	createSig := []string{"aa19072c666fde57a0e82beca5d5541faea150009bedc43dee62606be34ee039", "3a13e96deb4dc51030ec678e0012bb701445b36e5cf89b0194a894827b3926e4"}
+	addKeySig := []string{"04760699dd538f09838ecfc4a3c08a5e7e5a68c3fbed786c82b11c0f4d0122ae", "6fe920434a3410a8419ee74ec963754b75de59659a0b9ae8a7f50abc93e2396d"}
+	approveSig := []string{"07bc22c574e31455fbe446608452f5ab140959caaeb99a8973e33df5f0bc4e8c", "3ff17be4967e8f6d07da0610333153bcc2e390af04840a0289d21de992b9dc81"}
+	addSig := []string{"84d254ca7f3d450ae270fbd2fb22fc0da2f569da8bd1d839b46a1fc66d0f6dd4", "02f10f97474411446c158121fc885bdc2a6c292924e403dec1d5a5f83d24d030"}
+
+	tests := []struct {
+		name    string
+		hash    []byte
+		v       uint8
+		rs      []string
+		address common.Address
+		ok      bool
+	}{
+		{"create_identity", createHash, 27, createSig, addr, true},
+		{"create_identity by other", createHash, 27, createSig, addr2, false},
+		{"create_identity tampered", createTamperedHash, 27, createSig, addr, false},
+		{"add_key_delegated with prefix", addKeyHash, 27, addKeySig, addr, true},
+		{"add_key_delegated tampered", addKeyTamperedHash, 27, addKeySig, addr, false},
+		{"add_key_delegated wrong v", addKeyHash, 28, addKeySig, addr, false},
+		{"add_key_delegated v of 0", addKeyHash, 0, addKeySig, addr, false},
+		{"approving address", approveHash, 28, approveSig, addr, true},
+		{"address to add", addHash, 27, addSig, addr2, true},
+		{"approving address of other EIN", otherEINHash, 28, approveSig, addr, false},
+		{"signatures swapped", approveHash, 27, addSig, addr, false},
+	}
+	for _, test := range tests {
+		errObj := verifySignature(0, test.hash, test.v, common.FromHex(test.rs[0]), common.FromHex(test.rs[1]), test.address)
+		if test.ok && errObj != nil {
+			t.Errorf("%s: expected valid, got %v", test.name, errObj)
+		}
+		if !test.ok && (errObj == nil || errObj.ErrorCode() != (&invalidSignatureError{}).ErrorCode()) {
+			t.Errorf("%s: expected invalidSignatureError, got %v", test.name, errObj)
+		}
+	}
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	tests := []struct {
+		timestamp *big.Int
+		ok        bool
+	}{
+		{big.NewInt(1546300800), true},
+		{big.NewInt(1546300800 - 3599), true},
+		{big.NewInt(1546300800 - 3600), false},
+		// Clock skew
+		{big.NewInt(1546300800 + 30), true},
+		{big.NewInt(1546300800 + 31), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		errObj := checkTimestamp(test.timestamp, time.Hour, now)
+		if (errObj == nil) != test.ok || (errObj != nil && errObj.ErrorCode() != -32619) {
+			t.Errorf("%v: expected %v, got %v", test.timestamp, test.ok, errObj)
+		}
+	}
+}
+
+func TestSignatureTimeoutCache(t *testing.T) {
+	c := &signatureTimeouts{entries: make(map[common.Address]signatureTimeoutEntry)}
+	reads := 0
+	read := func() (*big.Int, error) {
+		reads++
+		return big.NewInt(3600), nil
+	}
+	contract := common.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")
+	for i := 0; i < 3; i++ {
+		if timeout, err := c.get(contract, read); err != nil || timeout != time.Hour {
+			t.Fatalf("Expected 1h, got %v, %v", timeout, err)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("Expected one read, got %d", reads)
+	}
+
+	// Read again after the cache expired
+	c.entries[contract] = signatureTimeoutEntry{time.Hour, time.Now().Add(-time.Second)}
+	c.get(contract, read)
+	c.get(contract, read)
+	if reads != 2 {
+		t.Errorf("Expected 2 reads, got %d", reads)
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	c := &replayCache{store: store.NewMemoryStore()}
+	signer := common.HexToAddress("0x71562b71999873DB5b286dF957af199Ec94617F7")
+	msg := signedMessage{signer, []byte{1}, big.NewInt(0)}
+	other := signedMessage{signer, []byte{2}, big.NewInt(0)}
+	expires := time.Now().Add(time.Hour)
+
+	if errObj := c.claim([]signedMessage{msg}, expires, ""); errObj != nil {
+		t.Fatal(errObj)
+	}
+	trx := types.NewTransaction(0, common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil)
+	c.sent([]signedMessage{msg}, trx.Hash().Hex())
+
+	// Replayed with other signature, none is claimed
+	errObj := c.claim([]signedMessage{other, msg}, expires, "")
+	if errObj == nil || errObj.ErrorCode() != -32620 {
+		t.Errorf("Expected replayedSignatureError, got %v", errObj)
+	}
+	if _, err := c.store.Get(replayKey(other)); err != store.ErrNotFound {
+		t.Errorf("Signature must be released if others are replayed")
+	}
+
+	// Signature of a TX sent is kept though released, the TX can be mined
+	c.release([]signedMessage{msg})
+	if errObj := c.claim([]signedMessage{msg}, expires, ""); errObj == nil || errObj.ErrorCode() != -32620 {
+		t.Errorf("Expected replayedSignatureError, got %v", errObj)
+	}
+
+	// Released signature can be relayed again if no TX is sent
+	if errObj := c.claim([]signedMessage{other}, expires, ""); errObj != nil {
+		t.Fatal(errObj)
+	}
+	c.release([]signedMessage{other})
+	if errObj := c.claim([]signedMessage{other}, expires, ""); errObj != nil {
+		t.Errorf("Released signature must be relayed, got %v", errObj)
+	}
+
+	// Signatures claimed by a job are claimed again only by the job, until its TX is sent
+	msg = signedMessage{signer, []byte{3}, big.NewInt(0)}
+	if errObj := c.claim([]signedMessage{msg}, expires, "job"); errObj != nil {
+		t.Fatal(errObj)
+	}
+	if errObj := c.claim([]signedMessage{msg}, expires, "job"); errObj != nil {
+		t.Errorf("Signature must be claimed again by the job, got %v", errObj)
+	}
+	if errObj := c.claim([]signedMessage{msg}, expires, ""); errObj == nil || errObj.ErrorCode() != -32620 {
+		t.Errorf("Expected replayedSignatureError, got %v", errObj)
+	}
+	c.sent([]signedMessage{msg}, trx.Hash().Hex())
+	if errObj := c.claim([]signedMessage{msg}, expires, "job"); errObj == nil || errObj.ErrorCode() != -32620 {
+		t.Errorf("Signature of a TX sent must not be claimed again by the job, got %v", errObj)
+	}
+
+	// Expired signature is removed
+	c.prune(expires)
+	if _, err := c.store.Get(replayKey(msg)); err != store.ErrNotFound {
+		t.Errorf("Expired signature must be removed")
+	}
+}
+
+func TestRecoveryLock(t *testing.T) {
+	timeout := big.NewInt(1209600)
+	since := big.NewInt(1546300800)
+	tests := []struct {
+		now    int64
+		since  *big.Int
+		locked bool
+	}{
+		{1546300800, nil, false},
+		{1546300800, since, true},
+		{1546300800 + 1209600, since, true},
+		{1546300800 + 1209601, since, false},
+	}
+	for i, test := range tests {
+		if locked := isLocked(big.NewInt(test.now), test.since, timeout); locked != test.locked {
+			t.Errorf("%d: expected locked %v, got %v", i, test.locked, locked)
+		}
+	}
+	if at := unlockedAt(since, timeout); isLocked(at, since, timeout) || !isLocked(new(big.Int).Sub(at, common.Big1), since, timeout) {
+		t.Errorf("Unexpected unlocked time %v", at)
+	}
+}
+
+func TestIsRemovedBy(t *testing.T) {
+	a := common.HexToAddress("0x01")
+	b := common.HexToAddress("0x02")
+	c := common.HexToAddress("0x03")
+	old := []common.Address{a, b, c}
+	tests := []struct {
+		first, last []common.Address
+		sender      common.Address
+		ok          bool
+	}{
+		{nil, []common.Address{b, c}, a, true},
+		{[]common.Address{a}, []common.Address{c}, b, true},
+		{[]common.Address{a, b}, nil, c, true},
+		{[]common.Address{a}, []common.Address{c}, c, false},
+		{[]common.Address{b}, []common.Address{c}, a, false},
+		{[]common.Address{a}, nil, b, false},
+	}
+	for i, test := range tests {
+		if ok := isRemovedBy(old, test.first, test.sender, test.last); ok != test.ok {
+			t.Errorf("%d: expected %v, got %v", i, test.ok, ok)
+		}
+	}
+}
+
+func TestRecoverySignature(t *testing.T) {
+	irAddr := common.HexToAddress("0xBE2bB3d7085fF04BdE4B3F177a730a826f05cB70")
+	key, _ := ethCrypto.GenerateKey()
+	addr := ethCrypto.PubkeyToAddress(key.PublicKey)
+	ts := big.NewInt(1546300800)
+	sign := func(hash []byte) (uint8, hexutil.Bytes, hexutil.Bytes) {
+		sig, _ := ethCrypto.Sign(hash, key)
+		return sig[64] + 27, sig[:32], sig[32:64]
+	}
+
+	recovery := triggerRecoveryParams{EIN: big.NewInt(7), NewAssociatedAddress: addr, Timestamp: ts}
+	hash, data := recovery.Keccak256(&irAddr)
+	if !bytes.Contains(data, []byte("I authorize being added to this Identity via recovery.")) {
+		t.Errorf("Unexpected recovery message %x", data)
+	}
+	v, r, s := sign(hash)
+	if errObj := verifySignature(0, hash, v, r, s, addr); errObj != nil {
+		t.Errorf("Expected valid recovery signature, got %v", errObj)
+	}
+	recovery.EIN = big.NewInt(8)
+	otherHash, _ := recovery.Keccak256(&irAddr)
+	if errObj := verifySignature(0, otherHash, v, r, s, addr); errObj == nil {
+		t.Errorf("Expected invalid recovery signature for other EIN")
+	}
+
+	change := triggerRecoveryAddressChangeParams{AssociatedAddress: addr, NewRecoveryAddress: irAddr, Timestamp: ts}
+	hash, _ = change.Keccak256(&irAddr, big.NewInt(7))
+	v, r, s = sign(hash)
+	if errObj := verifySignature(0, hash, v, r, s, addr); errObj != nil {
+		t.Errorf("Expected valid recovery address change signature, got %v", errObj)
+	}
+	change.NewRecoveryAddress = addr
+	otherHash, _ = change.Keccak256(&irAddr, big.NewInt(7))
+	if errObj := verifySignature(0, otherHash, v, r, s, addr); errObj == nil {
+		t.Errorf("Expected invalid signature for other recovery address")
+	}
+}
+
+func TestUnpackTriggerDestruction(t *testing.T) {
+	parsed, _ := abi.JSON(strings.NewReader(identityregistry.IdentityregistryABI))
+	first := []common.Address{common.HexToAddress("0x01")}
+	last := []common.Address{common.HexToAddress("0x02"), common.HexToAddress("0x03")}
+	data, err := parsed.Pack("triggerDestruction", big.NewInt(7), first, last, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destruction, err := identityregistry.UnpackTriggerDestruction(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if destruction.EIN.Int64() != 7 || !isRemovedBy(append(append(first, common.Address{}), last...), destruction.FirstChunk, common.Address{}, destruction.LastChunk) || !destruction.ResetResolvers {
+		t.Errorf("Unexpected destruction %+v", destruction)
+	}
+
+	other, _ := parsed.Pack("triggerRecoveryAddressChange", common.HexToAddress("0x01"))
+	if _, err := identityregistry.UnpackTriggerDestruction(other); err == nil {
+		t.Errorf("Expected error for other method")
+	}
+}
+
+func TestResolverAllowlist(t *testing.T) {
+	a := common.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")
+	b := common.HexToAddress("0x5d4b8c6c6abecf9b5277f6c3ab5dca4b9f0e5d37")
+	allowlist := parseAddressList(" 0x084f8293f1b047d3a217025b24cd7b5ace8fc657, invalid,0x5D4B8C6C6ABECF9B5277F6C3AB5DCA4B9F0E5D37,")
+	if len(allowlist) != 2 || allowlist[0] != a || allowlist[1] != b {
+		t.Fatalf("Unexpected allowlist %x", allowlist)
+	}
+
+	if r := notAllowedResolver([]common.Address{b, a}, allowlist); r != nil {
+		t.Errorf("Expected allowed, got %x", *r)
+	}
+	other := common.HexToAddress("0x01")
+	if r := notAllowedResolver([]common.Address{a, other}, allowlist); r == nil || *r != other {
+		t.Errorf("Expected %x not allowed, got %v", other, r)
+	}
+}
+
+func TestProvidersSignature(t *testing.T) {
+	irAddr := common.HexToAddress("0xBE2bB3d7085fF04BdE4B3F177a730a826f05cB70")
+	key, _ := ethCrypto.GenerateKey()
+	addr := ethCrypto.PubkeyToAddress(key.PublicKey)
+	provider := common.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")
+	ein := big.NewInt(7)
+
+	params := providersDelegatedParams{AssociatedAddress: addr, Providers: []common.Address{provider}, Timestamp: big.NewInt(1546300800)}
+	hash, _ := params.Keccak256(&irAddr, ein, addProvidersMessage)
+	sig, _ := ethCrypto.Sign(hash, key)
+	v, r, s := sig[64]+27, sig[:32], sig[32:64]
+	if errObj := verifySignature(0, hash, v, r, s, addr); errObj != nil {
+		t.Errorf("Expected valid signature, got %v", errObj)
+	}
+
+	// A signature is bound to the operation and the EIN
+	removeHash, _ := params.Keccak256(&irAddr, ein, removeProvidersMessage)
+	otherHash, _ := params.Keccak256(&irAddr, big.NewInt(8), addProvidersMessage)
+	resolvers := resolversDelegatedParams{AssociatedAddress: addr, Resolvers: params.Providers, Timestamp: params.Timestamp}
+	resolverHash, _ := resolvers.Keccak256(&irAddr, ein, addResolversMessage)
+	for _, h := range [][]byte{removeHash, otherHash, resolverHash} {
+		if errObj := verifySignature(0, h, v, r, s, addr); errObj == nil {
+			t.Errorf("Expected invalid signature for %x", h)
+		}
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	c := newQueryCache()
+	reads := 0
+	read := func(ein string) func() (interface{}, string, error) {
+		return func() (interface{}, string, error) {
+			reads++
+			return reads, ein, nil
+		}
+	}
+
+	c.load("get_identity/7", read("7"))
+	c.load("get_identity/8", read("8"))
+	c.load("has_identity/0x01", read(""))
+	if result, _ := c.load("get_identity/7", read("7")); result != 1 || reads != 3 {
+		t.Errorf("Expected cached result 1 with 3 reads, got %v with %d reads", result, reads)
+	}
+
+	// Errors are not cached
+	if _, err := c.load("get_ein/0x02", func() (interface{}, string, error) { return nil, "", fmt.Errorf("failed") }); err == nil {
+		t.Errorf("Expected error")
+	}
+	if _, ok := c.entries["get_ein/0x02"]; ok {
+		t.Errorf("Expected error not cached")
+	}
+
+	// Results of the EIN and without EIN are invalidated
+	c.invalidate("7")
+	if _, ok := c.entries["get_identity/8"]; !ok || len(c.entries) != 1 {
+		t.Errorf("Expected only result of other EIN, got %v", c.entries)
+	}
+	if result, _ := c.load("get_identity/7", read("7")); result != 4 {
+		t.Errorf("Expected result read again, got %v", result)
+	}
+
+	// Expired results are read again
+	defer func(ttl time.Duration) { queryCacheTTL = ttl }(queryCacheTTL)
+	queryCacheTTL = -time.Second
+	c.load("get_identity/9", read("9"))
+	if result, _ := c.load("get_identity/9", read("9")); result != 6 {
+		t.Errorf("Expected expired result read again, got %v", result)
+	}
+
+	// The oldest live results are removed when the cache is full
+	defer func(size int) { queryCacheSize = size }(queryCacheSize)
+	queryCacheTTL, queryCacheSize = time.Minute, 3
+	c = newQueryCache()
+	for i := 0; i < 5; i++ {
+		c.load(fmt.Sprintf("get_identity/%d", i), read(fmt.Sprint(i)))
+	}
+	if _, ok := c.entries["get_identity/1"]; ok || len(c.entries) != 3 || c.order.Len() != 3 {
+		t.Errorf("Expected the newest 3 results, got %v", c.entries)
+	}
+}
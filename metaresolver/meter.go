@@ -0,0 +1,34 @@
+package metaresolver
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metadium/go-delegator/accounting"
+	"github.com/metadium/go-delegator/json"
+)
+
+func init() {
+	methods := []string{"trigger_destruction"}
+	for method := range asyncMethods {
+		methods = append(methods, method)
+	}
+	accounting.RegisterMethods(methods...)
+	accounting.RegisterEINResolver(func(reqID uint64, address string) string {
+		if ein := einOf(context.Background(), reqID, common.HexToAddress(address)); ein != nil && ein.Sign() > 0 {
+			return ein.String()
+		}
+		return ""
+	})
+}
+
+// meter labels the request for accounting, and returns the error if a quota of the request is exceeded
+func meter(reqID uint64, req json.RPCRequest) Error {
+	err := accounting.Begin(reqID, req)
+	if quotaErr, ok := err.(*accounting.QuotaError); ok {
+		return quotaErr
+	} else if err != nil {
+		return &internalError{err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package metaresolver
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+)
+
+// sendForFunc sends a TX for the EIN by the provider
+type sendForFunc func(provider *crypto.Account, ein *big.Int) (*types.Transaction, error)
+
+// relayForEIN verifies the signature of the associated address,
+// and sends a TX for its EIN by a delegator key which is a provider of the EIN
+func relayForEIN(ctx context.Context, reqID uint64, req json.RPCRequest, associatedAddress common.Address, hash func(ein *big.Int) []byte, v uint8, r, s []byte, timestamp *big.Int, send sendForFunc) (resp json.RPCResponse) {
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	// 2. verify signature of the associated address
+	ein, err := identityregistry.CallGetEIN(ctx, reqID, associatedAddress)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	msgHash := hash(ein)
+	errObj := verifySignature(reqID, msgHash, v, r, s, associatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{associatedAddress, msgHash, timestamp}}
+	errObj = claimSignatures(reqID, *identityregistry.GetAddress(), func() (*big.Int, error) {
+		return identityregistry.CallSignatureTimeout(ctx, reqID)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
+	// 3. the TX must be sent by the provider
+	provider, err := getProviderSigner(ctx, reqID, ein)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if provider == nil {
+		errObj := &invalidAddressError{"Is not provider Address for user"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	if queueJob(reqID, &resp, provider) {
+		return
+	}
+	trx, err := send(provider, ein)
+	if err != nil {
+		log.Errorfd(reqID, "%s Error : %v", req.Method, err)
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugfd(reqID, "PASS - Call %s : %v", req.Method, trx.Hash().String())
+	trackTx(reqID, req.Method, trx, ein)
+	signaturesSent(trx, msgs...)
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+	return
+}
+
+func addProvidersDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call addProvidersDelegated Function")
+
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.ID = req.ID
+		resp.Jsonrpc = req.Jsonrpc
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(providersDelegatedParams)
+	log.Debugfd(reqID, "parameter[AssociatedAddress] : %x", reqParam.AssociatedAddress)
+	log.Debugfd(reqID, "parameter[Providers] : %x", reqParam.Providers)
+	log.Debugfd(reqID, "parameter[Timestamp] : %v", reqParam.Timestamp)
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	hash := func(ein *big.Int) []byte {
+		h, _ := reqParam.Keccak256(identityregistry.GetAddress(), ein, addProvidersMessage)
+		return h
+	}
+	resp = relayForEIN(ctx, reqID, req, reqParam.AssociatedAddress, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.Timestamp, func(provider *crypto.Account, ein *big.Int) (*types.Transaction, error) {
+		return identityregistry.CallAddProvidersFor(ctx, reqID, provider, ein, reqParam.Providers)
+	})
+	return
+}
+
+func removeProvidersDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call removeProvidersDelegated Function")
+
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.ID = req.ID
+		resp.Jsonrpc = req.Jsonrpc
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(providersDelegatedParams)
+	log.Debugfd(reqID, "parameter[AssociatedAddress] : %x", reqParam.AssociatedAddress)
+	log.Debugfd(reqID, "parameter[Providers] : %x", reqParam.Providers)
+	log.Debugfd(reqID, "parameter[Timestamp] : %v", reqParam.Timestamp)
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	hash := func(ein *big.Int) []byte {
+		h, _ := reqParam.Keccak256(identityregistry.GetAddress(), ein, removeProvidersMessage)
+		return h
+	}
+	resp = relayForEIN(ctx, reqID, req, reqParam.AssociatedAddress, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.Timestamp, func(provider *crypto.Account, ein *big.Int) (*types.Transaction, error) {
+		return identityregistry.CallRemoveProvidersFor(ctx, reqID, provider, ein, reqParam.Providers)
+	})
+	return
+}
+
+func addResolversDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call addResolversDelegated Function")
+
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.ID = req.ID
+		resp.Jsonrpc = req.Jsonrpc
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(resolversDelegatedParams)
+	log.Debugfd(reqID, "parameter[AssociatedAddress] : %x", reqParam.AssociatedAddress)
+	log.Debugfd(reqID, "parameter[Resolvers] : %x", reqParam.Resolvers)
+	log.Debugfd(reqID, "parameter[Timestamp] : %v", reqParam.Timestamp)
+
+	// resolvers users can add are limited
+	if r := notAllowedResolver(reqParam.Resolvers, getResolverAllowlist()); r != nil {
+		resp.ID = req.ID
+		resp.Jsonrpc = req.Jsonrpc
+		errObj := &invalidAddressError{fmt.Sprintf("Resolver %x is not allowed", *r)}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	hash := func(ein *big.Int) []byte {
+		h, _ := reqParam.Keccak256(identityregistry.GetAddress(), ein, addResolversMessage)
+		return h
+	}
+	resp = relayForEIN(ctx, reqID, req, reqParam.AssociatedAddress, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.Timestamp, func(provider *crypto.Account, ein *big.Int) (*types.Transaction, error) {
+		return identityregistry.CallAddResolversFor(ctx, reqID, provider, ein, reqParam.Resolvers)
+	})
+	return
+}
+
+func removeResolversDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call removeResolversDelegated Function")
+
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.ID = req.ID
+		resp.Jsonrpc = req.Jsonrpc
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(resolversDelegatedParams)
+	log.Debugfd(reqID, "parameter[AssociatedAddress] : %x", reqParam.AssociatedAddress)
+	log.Debugfd(reqID, "parameter[Resolvers] : %x", reqParam.Resolvers)
+	log.Debugfd(reqID, "parameter[Timestamp] : %v", reqParam.Timestamp)
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	// any resolver can be removed, users opt out of resolvers added elsewhere
+	hash := func(ein *big.Int) []byte {
+		h, _ := reqParam.Keccak256(identityregistry.GetAddress(), ein, removeResolversMessage)
+		return h
+	}
+	resp = relayForEIN(ctx, reqID, req, reqParam.AssociatedAddress, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.Timestamp, func(provider *crypto.Account, ein *big.Int) (*types.Transaction, error) {
+		return identityregistry.CallRemoveResolversFor(ctx, reqID, provider, ein, reqParam.Resolvers)
+	})
+	return
+}
+
+func getIdentityProviders(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getIdentityProviders Function")
+	identity, resp := getIdentityOf(ctx, reqID, req)
+	if identity != nil {
+		resp.Result = identity.Providers
+	}
+	return
+}
+
+func getIdentityResolvers(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getIdentityResolvers Function")
+	identity, resp := getIdentityOf(ctx, reqID, req)
+	if identity != nil {
+		resp.Result = identity.Resolvers
+	}
+	return
+}
+
+// getIdentityOf returns the identity of EIN in the request, or nil with the error response
+func getIdentityOf(ctx context.Context, reqID uint64, req json.RPCRequest) (identity *identityregistry.Identity, resp json.RPCResponse) {
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(einParams)
+	log.Debugfd(reqID, "parameter[EIN] : %v", reqParam.EIN)
+
+	identity, err := cachedIdentity(ctx, reqID, reqParam.EIN)
+	if err != nil {
+		setQueryResult(&resp, nil, err)
+		return nil, resp
+	}
+	return identity, resp
+}
@@ -1,17 +1,18 @@
 package metaresolver
 
 import (
+	"context"
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/metadium/go-delegator/crypto"
 	"github.com/metadium/go-delegator/json"
 	"github.com/metadium/go-delegator/log"
 	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
 	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
 )
 
-func addPublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func addPublicKeyDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 
 	log.Debugd(reqID, " Call addPublicKeyDelegated Function")
 
@@ -42,8 +43,28 @@ func addPublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResp
 		return
 	}
 
+	//2-1. verify signature of the associated address
+	hash, _ := reqParam.Keccak256()
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AssociatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.AssociatedAddress, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, reqParam.ResolverAddress, func() (*big.Int, error) {
+		return publickeyresolver.CallSignatureTimeout(ctx, reqParam.ResolverAddress)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
 	//3. GET EIN
-	ein, err := identityregistry.CallGetEIN(reqID, reqParam.AssociatedAddress)
+	ein, err := identityregistry.CallGetEIN(ctx, reqID, reqParam.AssociatedAddress)
 	if err != nil {
 		errObj = &internalError{err.Error()}
 		resp.Error = makeErrorResponse(errObj)
@@ -52,16 +73,14 @@ func addPublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResp
 
 	log.Debugfd(reqID, "EIN is %v", ein)
 
-	//4. Check IsProviderFor
-	providerAddr := common.HexToAddress(crypto.GetInstance().GetAddress())
-
-	isProvider, err := identityregistry.CallIsProviderFor(reqID, ein, providerAddr)
+	//4. Check IsProviderFor, addResolversFor must be sent by the provider
+	provider, err := getProviderSigner(ctx, reqID, ein)
 	if err != nil {
 		errObj = &internalError{err.Error()}
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
-	if !isProvider {
+	if provider == nil {
 		err = fmt.Errorf("Is not provider Address for user")
 		errObj = &invalidAddressError{err.Error()}
 		resp.Error = makeErrorResponse(errObj)
@@ -69,7 +88,7 @@ func addPublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResp
 	}
 
 	//5. get isResolverFor
-	isResolver, err := identityregistry.CallIsResolverFor(reqID, ein, reqParam.ResolverAddress)
+	isResolver, err := identityregistry.CallIsResolverFor(ctx, reqID, ein, reqParam.ResolverAddress)
 	if err != nil {
 		errObj = &internalError{err.Error()}
 		resp.Error = makeErrorResponse(errObj)
@@ -83,13 +102,14 @@ func addPublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResp
 		// return
 
 		// 5-1 Add PublicKeyResolver address to resolvers
-		tx, err := identityregistry.CallAddResolversFor(reqID, ein, []common.Address{reqParam.ResolverAddress})
+		tx, err := identityregistry.CallAddResolversFor(ctx, reqID, provider, ein, []common.Address{reqParam.ResolverAddress})
 		if err != nil {
-			errObj = &internalError{err.Error()}
+			errObj = callError(err)
 			resp.Error = makeErrorResponse(errObj)
 			return
 		}
 		log.Infofd(reqID, "Transaction for Adding Resolver for (%v) : %x", ein, tx)
+		trackTx(reqID, req.Method, tx, ein)
 	}
 
 	//6. get instance PublicKeyResolver
@@ -112,21 +132,27 @@ func addPublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResp
 	var rBytes, sBytes [32]byte
 	copy(rBytes[:], reqParam.R)
 	copy(sBytes[:], reqParam.S)
-	trx, err := publickeyresolver.CallAddPublicKeyDelegated(reqID, instance, reqParam.AssociatedAddress, reqParam.PublicKey, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
+	if queueJob(reqID, &resp, nil) {
+		return
+	}
+	trx, err := publickeyresolver.CallAddPublicKeyDelegated(ctx, reqID, jobSigner(reqID), instance, reqParam.AssociatedAddress, reqParam.PublicKey, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
 	if err != nil {
 		log.Errorfd(reqID, "CallAddPublicKeyDelegated Error : %v", err)
-		errObj := &internalError{err.Error()}
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS 05. Call addPublicKeyDelegated : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, ein)
+	signaturesSent(trx, msgs...)
 
 	//  return txid
 	resp.Result = trx.Hash().String()
 	return
 }
 
-func removePublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func removePublicKeyDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, " Call removePublicKeyDelegated Function")
 
 	resp.ID = req.ID
@@ -155,6 +181,26 @@ func removePublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCR
 		return
 	}
 
+	//2-1. verify signature of the associated address
+	hash, _ := reqParam.Keccak256()
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AssociatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.AssociatedAddress, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, reqParam.ResolverAddress, func() (*big.Int, error) {
+		return publickeyresolver.CallSignatureTimeout(ctx, reqParam.ResolverAddress)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
 	//3. get instance PublicKeyResolver
 	instance, err := publickeyresolver.GetInstance(reqParam.ResolverAddress)
 	if err != nil {
@@ -175,14 +221,20 @@ func removePublicKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCR
 	var rBytes, sBytes [32]byte
 	copy(rBytes[:], reqParam.R)
 	copy(sBytes[:], reqParam.S)
-	trx, err := publickeyresolver.CallRemovePublicKeyDelegated(reqID, instance, reqParam.AssociatedAddress, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
+	if queueJob(reqID, &resp, nil) {
+		return
+	}
+	trx, err := publickeyresolver.CallRemovePublicKeyDelegated(ctx, reqID, jobSigner(reqID), instance, reqParam.AssociatedAddress, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
 	if err != nil {
 		log.Errorfd(reqID, "CallRemovePublicKeyDelegated Error : %v", err)
-		errObj := &internalError{err.Error()}
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS 05. Call RemovePublicKeyDelegated : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, einOf(ctx, reqID, reqParam.AssociatedAddress))
+	signaturesSent(trx, msgs...)
 
 	//return txid
 	resp.Result = trx.Hash().String()
@@ -0,0 +1,94 @@
+package metaresolver
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/tracker"
+)
+
+var (
+	// Results of read methods are kept for this long
+	queryCacheTTL = 15 * time.Second
+	// The oldest results are removed when the cache grows over this
+	queryCacheSize = 10000
+)
+
+// queryCache keeps results of read methods for a short time.
+// A result depends on an EIN, or on no known EIN such as has_identity of a new address
+type queryCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order is entries by the time cached, the oldest first, so that they expire in order
+	order *list.List
+}
+
+type queryEntry struct {
+	key     string
+	result  interface{}
+	ein     string
+	expires time.Time
+}
+
+var queries = newQueryCache()
+
+func newQueryCache() *queryCache {
+	return &queryCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func init() {
+	// State changed by delegator TXs is read again once mined
+	tracker.OnFinal(func(rec *tracker.Record) {
+		queries.invalidate(rec.EIN)
+	})
+}
+
+// load returns the result of the key, read by read if not cached.
+// read returns the EIN the result depends on, empty if none, and errors are not cached
+func (c *queryCache) load(key string, read func() (result interface{}, ein string, err error)) (interface{}, error) {
+	now := time.Now()
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		if entry := e.Value.(*queryEntry); now.Before(entry.expires) {
+			c.mu.Unlock()
+			return entry.result, nil
+		}
+	}
+	c.mu.Unlock()
+
+	result, ein, err := read()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	// Expired results are the oldest, live ones are removed too if the cache is still full
+	for front := c.order.Front(); front != nil; front = c.order.Front() {
+		if len(c.entries) < queryCacheSize && now.Before(front.Value.(*queryEntry).expires) {
+			break
+		}
+		c.remove(front)
+	}
+	c.entries[key] = c.order.PushBack(&queryEntry{key: key, result: result, ein: ein, expires: now.Add(queryCacheTTL)})
+	return result, nil
+}
+
+func (c *queryCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*queryEntry).key)
+}
+
+// invalidate removes results of the EIN, and results without EIN as the EIN of a changed address is not known
+func (c *queryCache) invalidate(ein string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if entry := e.Value.(*queryEntry); entry.ein == ein || entry.ein == "" {
+			c.remove(e)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package metaresolver
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
+	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+)
+
+// setQueryResult sets the result or the error of a read method to the response
+func setQueryResult(resp *json.RPCResponse, result interface{}, err error) {
+	if err != nil {
+		errObj, ok := err.(Error)
+		if !ok {
+			errObj = &internalError{err.Error()}
+		}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	resp.Result = result
+}
+
+func queryKey(method string, args ...interface{}) string {
+	key := method
+	for _, arg := range args {
+		key += "/" + strings.ToLower(fmt.Sprint(arg))
+	}
+	return key
+}
+
+// cachedIdentity returns the identity of the EIN, invalidMetaIDError if not exists
+func cachedIdentity(ctx context.Context, reqID uint64, ein *big.Int) (*identityregistry.Identity, error) {
+	result, err := queries.load(queryKey("identity", ein), func() (interface{}, string, error) {
+		exists, err := identityregistry.CallIdentityExists(ctx, reqID, ein)
+		if err != nil {
+			return nil, "", err
+		}
+		if !exists {
+			return nil, "", &invalidMetaIDError{fmt.Sprintf("Identity %v does not exist", ein)}
+		}
+		identity, err := identityregistry.CallGetIdentity(ctx, reqID, ein)
+		return identity, ein.String(), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*identityregistry.Identity), nil
+}
+
+// serviceKeyResolver returns the resolver given, or the current one if nil
+func serviceKeyResolver(address *common.Address) (common.Address, Error) {
+	if address == nil {
+		return *servicekeyresolver.GetAddress(), nil
+	}
+	if !servicekeyresolver.ContainsInAddresses(*address) {
+		return common.Address{}, &invalidAddressError{"Is not valid resolver address"}
+	}
+	return *address, nil
+}
+
+// publicKeyResolver returns the resolver given, or the current one if nil
+func publicKeyResolver(address *common.Address) (common.Address, Error) {
+	if address == nil {
+		return *publickeyresolver.GetAddress(), nil
+	}
+	if !publickeyresolver.ContainsInAddresses(*address) {
+		return common.Address{}, &invalidAddressError{"Is not valid resolver address"}
+	}
+	return *address, nil
+}
+
+func getIdentity(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getIdentity Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(einParams)
+	log.Debugfd(reqID, "parameter[EIN] : %v", reqParam.EIN)
+
+	identity, err := cachedIdentity(ctx, reqID, reqParam.EIN)
+	if err != nil {
+		setQueryResult(&resp, nil, err)
+		return
+	}
+	resp.Result = &identityResult{
+		EIN:                 reqParam.EIN,
+		RecoveryAddress:     identity.RecoveryAddress,
+		AssociatedAddresses: identity.AssociatedAddresses,
+		Providers:           identity.Providers,
+		Resolvers:           identity.Resolvers,
+	}
+	return
+}
+
+func getEIN(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getEIN Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(addressParams)
+	log.Debugfd(reqID, "parameter[Address] : %x", reqParam.Address)
+
+	result, err := queries.load(queryKey(req.Method, reqParam.Address.Hex()), func() (interface{}, string, error) {
+		// getEIN of the contract reverts for an address without identity
+		hasIdentity, err := identityregistry.CallHasIdentity(ctx, reqID, reqParam.Address)
+		if err != nil {
+			return nil, "", err
+		}
+		if !hasIdentity {
+			return nil, "", &notExistsAddressError{"Address does not have an identity"}
+		}
+		ein, err := identityregistry.CallGetEIN(ctx, reqID, reqParam.Address)
+		if err != nil {
+			return nil, "", err
+		}
+		return ein, ein.String(), nil
+	})
+	setQueryResult(&resp, result, err)
+	return
+}
+
+func hasIdentity(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call hasIdentity Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(addressParams)
+	log.Debugfd(reqID, "parameter[Address] : %x", reqParam.Address)
+
+	result, err := queries.load(queryKey(req.Method, reqParam.Address.Hex()), func() (interface{}, string, error) {
+		hasIdentity, err := identityregistry.CallHasIdentity(ctx, reqID, reqParam.Address)
+		return hasIdentity, "", err
+	})
+	setQueryResult(&resp, result, err)
+	return
+}
+
+func isAssociatedAddressFor(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call isAssociatedAddressFor Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(associatedAddressForParams)
+	log.Debugfd(reqID, "parameter[EIN] : %v", reqParam.EIN)
+	log.Debugfd(reqID, "parameter[Address] : %x", reqParam.Address)
+
+	result, err := queries.load(queryKey(req.Method, reqParam.EIN, reqParam.Address.Hex()), func() (interface{}, string, error) {
+		associated, err := identityregistry.CallIsAssociatedAddressFor(ctx, reqID, reqParam.EIN, reqParam.Address)
+		return associated, reqParam.EIN.String(), err
+	})
+	setQueryResult(&resp, result, err)
+	return
+}
+
+func getServiceKeys(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getServiceKeys Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(serviceKeysParams)
+	log.Debugfd(reqID, "parameter[EIN] : %v", reqParam.EIN)
+	resolver, errObj := serviceKeyResolver(reqParam.ResolverAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	result, err := queries.load(queryKey(req.Method, resolver.Hex(), reqParam.EIN), func() (interface{}, string, error) {
+		keys, err := servicekeyresolver.CallGetKeys(ctx, resolver, reqParam.EIN)
+		if err != nil {
+			return nil, "", err
+		}
+		serviceKeys := make([]serviceKeyResult, 0, len(keys))
+		for _, key := range keys {
+			symbol, err := servicekeyresolver.CallGetSymbol(ctx, resolver, key)
+			if err != nil {
+				return nil, "", err
+			}
+			serviceKeys = append(serviceKeys, serviceKeyResult{Key: key, Symbol: symbol})
+		}
+		return serviceKeys, reqParam.EIN.String(), nil
+	})
+	setQueryResult(&resp, result, err)
+	return
+}
+
+func isKeyFor(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call isKeyFor Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(keyForParams)
+	log.Debugfd(reqID, "parameter[EIN] : %v", reqParam.EIN)
+	log.Debugfd(reqID, "parameter[Key] : %x", reqParam.Key)
+	resolver, errObj := serviceKeyResolver(reqParam.ResolverAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	result, err := queries.load(queryKey(req.Method, resolver.Hex(), reqParam.EIN, reqParam.Key.Hex()), func() (interface{}, string, error) {
+		isKey, err := servicekeyresolver.CallIsKeyFor(ctx, resolver, reqParam.Key, reqParam.EIN)
+		return isKey, reqParam.EIN.String(), err
+	})
+	setQueryResult(&resp, result, err)
+	return
+}
+
+func getPublicKey(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getPublicKey Function")
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(publicKeyParams)
+	log.Debugfd(reqID, "parameter[Address] : %x", reqParam.Address)
+	resolver, errObj := publicKeyResolver(reqParam.ResolverAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	result, err := queries.load(queryKey(req.Method, resolver.Hex(), reqParam.Address.Hex()), func() (interface{}, string, error) {
+		// EIN of the address is not known without another call
+		publicKey, err := publickeyresolver.CallGetPublicKey(ctx, resolver, reqParam.Address)
+		return hexutil.Bytes(publicKey), "", err
+	})
+	setQueryResult(&resp, result, err)
+	return
+}
@@ -0,0 +1,403 @@
+package metaresolver
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/indexer"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/rpc"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// isLocked returns true if now is within the timeout since the time triggered,
+// as the contract checks with block.timestamp. since is nil if never triggered
+func isLocked(now, since, timeout *big.Int) bool {
+	if since == nil {
+		return false
+	}
+	return now.Cmp(new(big.Int).Add(since, timeout)) <= 0
+}
+
+// unlockedAt returns the first block time the lock is released
+func unlockedAt(since, timeout *big.Int) *big.Int {
+	return new(big.Int).Add(new(big.Int).Add(since, timeout), common.Big1)
+}
+
+// isRemovedBy returns true if old addresses are first, sender and last in order,
+// triggerDestruction takes the old addresses so
+func isRemovedBy(old []common.Address, first []common.Address, sender common.Address, last []common.Address) bool {
+	if len(old) != len(first)+1+len(last) {
+		return false
+	}
+	chunks := append(append(append([]common.Address{}, first...), sender), last...)
+	for i := range old {
+		if old[i] != chunks[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// recoveryClock returns the latest block time and the recovery timeout of IdentityRegistry
+func recoveryClock(ctx context.Context, reqID uint64) (now *big.Int, timeout *big.Int, err error) {
+	now, err = identityregistry.BlockTime(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	timeout, err = identityregistry.CallRecoveryTimeout(ctx, reqID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return now, timeout, nil
+}
+
+// scanStart returns the block to read events of the EIN from to find the last one.
+// It's from the indexer if it is running, or INDEXER_START_BLOCK otherwise
+func scanStart(ein *big.Int, event string) uint64 {
+	if ix := indexer.GetInstance(); ix != nil {
+		if from, ok, err := ix.ScanStart(ein, event); err == nil && ok {
+			return from
+		}
+	}
+	return indexer.StartBlock()
+}
+
+// getRecoverySigner returns the account sending recovery of the EIN, or nil if the delegator doesn't have it
+// It's the recovery address, or the old one if the recovery address is changed recently
+func getRecoverySigner(ctx context.Context, reqID uint64, ein *big.Int, now *big.Int, timeout *big.Int) (*crypto.Account, common.Address, error) {
+	change, err := identityregistry.LastRecoveryAddressChange(ctx, reqID, ein, scanStart(ein, "RecoveryAddressChangeTriggered"))
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	var recoveryAddress common.Address
+	if change != nil && isLocked(now, change.Timestamp, timeout) {
+		recoveryAddress = change.OldRecoveryAddress
+	} else {
+		recoveryAddress, err = identityregistry.CallGetRecoveryAddress(ctx, reqID, ein)
+		if err != nil {
+			return nil, common.Address{}, err
+		}
+	}
+	return crypto.GetInstance().Account(recoveryAddress.Hex()), recoveryAddress, nil
+}
+
+func triggerRecovery(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call triggerRecovery Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(triggerRecoveryParams)
+
+	log.Debugfd(reqID, "parameter[EIN] : %v", reqParam.EIN)
+	log.Debugfd(reqID, "parameter[NewAssociatedAddress] : %x", reqParam.NewAssociatedAddress)
+	log.Debugfd(reqID, "parameter[V] : %v", reqParam.V)
+	log.Debugfd(reqID, "parameter[R] : %v", reqParam.R)
+	log.Debugfd(reqID, "parameter[S] : %v", reqParam.S)
+	log.Debugfd(reqID, "parameter[Timestamp] : %v", reqParam.Timestamp)
+
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	// 2. verify signature of the new associated address
+	hash, _ := reqParam.Keccak256(identityregistry.GetAddress())
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.NewAssociatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.NewAssociatedAddress, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, *identityregistry.GetAddress(), func() (*big.Int, error) {
+		return identityregistry.CallSignatureTimeout(ctx, reqID)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
+	// 3. check the identity and the new associated address
+	exists, err := identityregistry.CallIdentityExists(ctx, reqID, reqParam.EIN)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if !exists {
+		errObj := &invalidMetaIDError{fmt.Sprintf("Identity %v does not exist", reqParam.EIN)}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	hasIdentity, err := identityregistry.CallHasIdentity(ctx, reqID, reqParam.NewAssociatedAddress)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if hasIdentity {
+		errObj := &alreadyExistsAddressError{"New associated address already has an identity"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	// 4. check the recovery timeout
+	now, timeout, err := recoveryClock(ctx, reqID)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	recovery, err := identityregistry.LastRecovery(ctx, reqID, reqParam.EIN, scanStart(reqParam.EIN, "RecoveryTriggered"))
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if recovery != nil && isLocked(now, recovery.Timestamp, timeout) {
+		errObj := &recoveryTimeoutError{fmt.Sprintf("Recovery is triggered recently, retry at %v", unlockedAt(recovery.Timestamp, timeout))}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 03. Check Recovery Timeout")
+
+	// 5. triggerRecovery must be sent by the recovery address
+	signer, recoveryAddress, err := getRecoverySigner(ctx, reqID, reqParam.EIN, now, timeout)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if signer == nil {
+		errObj := &invalidAddressError{fmt.Sprintf("Recovery address %x is not managed by delegator", recoveryAddress)}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	var rBytes, sBytes [32]byte
+	copy(rBytes[:], reqParam.R)
+	copy(sBytes[:], reqParam.S)
+	if queueJob(reqID, &resp, signer) {
+		return
+	}
+	trx, err := identityregistry.CallTriggerRecovery(ctx, reqID, signer, reqParam.EIN, reqParam.NewAssociatedAddress, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
+	if err != nil {
+		log.Errorfd(reqID, "CallTriggerRecovery Error : %v", err)
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugfd(reqID, "PASS - Call TriggerRecovery : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, reqParam.EIN)
+	signaturesSent(trx, msgs...)
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+
+	return
+}
+
+func triggerRecoveryAddressChange(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call triggerRecoveryAddressChange Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(triggerRecoveryAddressChangeParams)
+
+	log.Debugfd(reqID, "parameter[AssociatedAddress] : %x", reqParam.AssociatedAddress)
+	log.Debugfd(reqID, "parameter[NewRecoveryAddress] : %x", reqParam.NewRecoveryAddress)
+	log.Debugfd(reqID, "parameter[V] : %v", reqParam.V)
+	log.Debugfd(reqID, "parameter[R] : %v", reqParam.R)
+	log.Debugfd(reqID, "parameter[S] : %v", reqParam.S)
+	log.Debugfd(reqID, "parameter[Timestamp] : %v", reqParam.Timestamp)
+
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	// 2. verify signature of the associated address
+	ein, err := identityregistry.CallGetEIN(ctx, reqID, reqParam.AssociatedAddress)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	hash, _ := reqParam.Keccak256(identityregistry.GetAddress(), ein)
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AssociatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.AssociatedAddress, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, *identityregistry.GetAddress(), func() (*big.Int, error) {
+		return identityregistry.CallSignatureTimeout(ctx, reqID)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
+	// 3. check the recovery timeout
+	now, timeout, err := recoveryClock(ctx, reqID)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	change, err := identityregistry.LastRecoveryAddressChange(ctx, reqID, ein, scanStart(ein, "RecoveryAddressChangeTriggered"))
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if change != nil && isLocked(now, change.Timestamp, timeout) {
+		errObj := &recoveryTimeoutError{fmt.Sprintf("Recovery address is changed recently, retry at %v", unlockedAt(change.Timestamp, timeout))}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 03. Check Recovery Timeout")
+
+	// 4. triggerRecoveryAddressChangeFor must be sent by the provider
+	provider, err := getProviderSigner(ctx, reqID, ein)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if provider == nil {
+		errObj := &invalidAddressError{"Is not provider Address for user"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	if queueJob(reqID, &resp, provider) {
+		return
+	}
+	trx, err := identityregistry.CallTriggerRecoveryAddressChangeFor(ctx, reqID, provider, ein, reqParam.NewRecoveryAddress)
+	if err != nil {
+		log.Errorfd(reqID, "CallTriggerRecoveryAddressChangeFor Error : %v", err)
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugfd(reqID, "PASS - Call TriggerRecoveryAddressChangeFor : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, ein)
+	signaturesSent(trx, msgs...)
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+
+	return
+}
+
+func triggerDestruction(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call triggerDestruction Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(triggerDestructionParams)
+
+	log.Debugfd(reqID, "parameter[RawTransaction] : %v", reqParam.RawTransaction)
+
+	// 2. decode the TX and its sender
+	trx := new(types.Transaction)
+	if err := rlp.DecodeBytes(reqParam.RawTransaction, trx); err != nil {
+		errObj := &invalidParamsError{"Invalid raw transaction: " + err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if trx.To() == nil || *trx.To() != *identityregistry.GetAddress() {
+		errObj := &invalidParamsError{"Transaction is not to IdentityRegistry"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	destruction, err := identityregistry.UnpackTriggerDestruction(trx.Data())
+	if err != nil {
+		errObj := &invalidParamsError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	var signer types.Signer = types.HomesteadSigner{}
+	if trx.Protected() {
+		signer = types.NewEIP155Signer(rpc.GetInstance().NetVersion)
+	}
+	sender, err := types.Sender(signer, trx)
+	if err != nil {
+		errObj := &invalidSignatureError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugfd(reqID, "EIN : %v, Sender : %x", destruction.EIN, sender)
+
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	// 3. destruction is allowed within the recovery timeout, from an address removed by recovery
+	now, timeout, err := recoveryClock(ctx, reqID)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	recovery, err := identityregistry.LastRecovery(ctx, reqID, destruction.EIN, scanStart(destruction.EIN, "RecoveryTriggered"))
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if recovery == nil || !isLocked(now, recovery.Timestamp, timeout) {
+		errObj := &recoveryTimeoutError{"Recovery has not recently been triggered"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if !isRemovedBy(recovery.OldAssociatedAddresses, destruction.FirstChunk, sender, destruction.LastChunk) {
+		errObj := &invalidAddressError{fmt.Sprintf("Sender is not an address removed by the recovery, old associated addresses are %x", recovery.OldAssociatedAddresses)}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Check Recovery Timeout")
+
+	err = identityregistry.SendTriggerDestruction(reqID, trx, sender)
+	if err != nil {
+		log.Errorfd(reqID, "SendTriggerDestruction Error : %v", err)
+		errObj := callError(err)
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugfd(reqID, "PASS - Send TriggerDestruction : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, destruction.EIN)
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+
+	return
+}
@@ -1,12 +1,15 @@
 package metaresolver
 
 import (
+	"context"
+	"math/big"
+
 	"github.com/metadium/go-delegator/json"
 	"github.com/metadium/go-delegator/log"
 	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
 )
 
-func createIdentity(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func createIdentity(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 
 	log.Debugd(reqID, " Call createIdentity Function")
 
@@ -33,27 +36,44 @@ func createIdentity(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, e
 	log.Debugfd(reqID, "parameter[Timestamp] : %v", reqParam.Timestamp)
 
 	log.Debugd(reqID, "PASS - 01. Check Parameter \n")
-	// // 2. verify signature
-	// identityRegistryAddr, _ := identityregistry.GetAddress()
-	// keccakBytes, _ := reqParam.Keccak256(identityRegistryAddr)
-	// errObj = verifySignature(reqID, keccakBytes, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AssociatedAddress)
-	// if errObj != nil {
-	// 	resp.Error = makeErrorResponse(errObj)
-	// 	return
-	// }
+	// 2. verify signature of the associated address
+	hash, _ := reqParam.Keccak256(identityregistry.GetAddress())
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AssociatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.AssociatedAddress, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, *identityregistry.GetAddress(), func() (*big.Int, error) {
+		return identityregistry.CallSignatureTimeout(ctx, reqID)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
 
 	// 3. CallCreateIdentity
 	var rBytes, sBytes [32]byte
 	copy(rBytes[:], reqParam.R)
 	copy(sBytes[:], reqParam.S)
-	trx, err := identityregistry.CallCreateIdentity(reqID, reqParam.RecoveryAddress, reqParam.AssociatedAddress, reqParam.Providers, reqParam.Resolvers, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
+	if queueJob(reqID, &resp, nil) {
+		return
+	}
+	trx, err := identityregistry.CallCreateIdentity(ctx, reqID, jobSigner(reqID), reqParam.RecoveryAddress, reqParam.AssociatedAddress, reqParam.Providers, reqParam.Resolvers, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
 	if err != nil {
 		log.Errorfd(reqID, "CallCreateIdentity Error : %v", err)
-		errObj := &internalError{err.Error()}
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS - Call CreateIdentity : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, nil)
+	signaturesSent(trx, msgs...)
 
 	//  return txid
 	resp.Result = trx.Hash().String()
@@ -61,7 +81,7 @@ func createIdentity(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, e
 	return
 }
 
-func addAssociatedAddressDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func addAssociatedAddressDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, " Call addAssociatedAddressDelegated Function")
 
 	resp.ID = req.ID
@@ -82,6 +102,36 @@ func addAssociatedAddressDelegated(reqID uint64, req json.RPCRequest) (resp json
 	log.Debugfd(reqID, "parameter[Timestamp] : %v", reqParam.Timestamp)
 
 	log.Debugd(reqID, "PASS - 01. Check Parameter \n")
+
+	// 2. verify signatures of the approving address and the address to add
+	ein, err := identityregistry.CallGetEIN(ctx, reqID, reqParam.ApprovingAddress)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	hash0, _, hash1, _ := reqParam.Keccak256(identityregistry.GetAddress(), ein)
+	errObj = verifySignature(reqID, hash0, reqParam.V[0][0], reqParam.R[0], reqParam.S[0], reqParam.ApprovingAddress)
+	if errObj == nil {
+		errObj = verifySignature(reqID, hash1, reqParam.V[1][0], reqParam.R[1], reqParam.S[1], reqParam.AddressToAdd)
+	}
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.ApprovingAddress, hash0, reqParam.Timestamp[0]}, {reqParam.AddressToAdd, hash1, reqParam.Timestamp[1]}}
+	errObj = claimSignatures(reqID, *identityregistry.GetAddress(), func() (*big.Int, error) {
+		return identityregistry.CallSignatureTimeout(ctx, reqID)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
 	var vBytes [2]byte
 	vBytes[0] = reqParam.V[0][0]
 	vBytes[1] = reqParam.V[1][0]
@@ -91,14 +141,20 @@ func addAssociatedAddressDelegated(reqID uint64, req json.RPCRequest) (resp json
 	copy(sBytes[0][:], reqParam.S[0])
 	copy(sBytes[1][:], reqParam.S[1])
 
-	trx, err := identityregistry.CallAddAssociatedAddressDelegated(reqID, reqParam.ApprovingAddress, reqParam.AddressToAdd, vBytes, rBytes, sBytes, reqParam.Timestamp)
+	if queueJob(reqID, &resp, nil) {
+		return
+	}
+	trx, err := identityregistry.CallAddAssociatedAddressDelegated(ctx, reqID, jobSigner(reqID), reqParam.ApprovingAddress, reqParam.AddressToAdd, vBytes, rBytes, sBytes, reqParam.Timestamp)
 	if err != nil {
 		log.Errorfd(reqID, "CallAddAssociatedAddressDelegated Error : %v", err)
-		errObj := &internalError{err.Error()}
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS - Call AddAssociatedAddressDelegated : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, ein)
+	signaturesSent(trx, msgs...)
 
 	//  return txid
 	resp.Result = trx.Hash().String()
@@ -106,7 +162,7 @@ func addAssociatedAddressDelegated(reqID uint64, req json.RPCRequest) (resp json
 	return
 }
 
-func removeAssociatedAddressDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func removeAssociatedAddressDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, " Call removeAssociatedAddressDelegated Function")
 
 	resp.ID = req.ID
@@ -127,17 +183,49 @@ func removeAssociatedAddressDelegated(reqID uint64, req json.RPCRequest) (resp j
 
 	log.Debugd(reqID, "PASS - 01. Check Parameter \n")
 
+	// 2. verify signature of the address to remove
+	ein, err := identityregistry.CallGetEIN(ctx, reqID, reqParam.AddressToRemove)
+	if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	hash, _ := reqParam.Keccak256(identityregistry.GetAddress(), ein)
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AddressToRemove)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.AddressToRemove, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, *identityregistry.GetAddress(), func() (*big.Int, error) {
+		return identityregistry.CallSignatureTimeout(ctx, reqID)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
 	var rBytes, sBytes [32]byte
 	copy(rBytes[:], reqParam.R)
 	copy(sBytes[:], reqParam.S)
-	trx, err := identityregistry.CallRemoveAssociatedAddressDelegated(reqID, reqParam.AddressToRemove, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
+	if queueJob(reqID, &resp, nil) {
+		return
+	}
+	trx, err := identityregistry.CallRemoveAssociatedAddressDelegated(ctx, reqID, jobSigner(reqID), reqParam.AddressToRemove, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
 	if err != nil {
 		log.Errorfd(reqID, "CallRemoveAssociatedAddressDelegated Error : %v", err)
-		errObj := &internalError{err.Error()}
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS - Call RemoveAssociatedAddressDelegated : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, ein)
+	signaturesSent(trx, msgs...)
 
 	//  return txid
 	resp.Result = trx.Hash().String()
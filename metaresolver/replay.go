@@ -0,0 +1,285 @@
+package metaresolver
+
+import (
+	encodingJson "encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// For environment arguments
+const (
+	// ReplayStore is a location of store for signatures relayed, refer to store.Open
+	// Use DynamoDB to share it between delegator processes
+	ReplayStore = "REPLAY_STORE"
+)
+
+// For replay store
+const (
+	// DefaultReplayStorePath is a LevelDB path for signatures relayed
+	DefaultReplayStorePath = "replay"
+	// DefaultReplayStoreTable is a DynamoDB table for signatures relayed used in AWS lambda
+	DefaultReplayStoreTable = "Replay"
+)
+
+var (
+	// Timestamp of a signature can be ahead of the delegator clock by this
+	maxClockSkew = 30 * time.Second
+	// Signature timeout of a contract is read again after this
+	signatureTimeoutTTL = time.Hour
+	// Interval of removing expired signatures
+	replayPruneInterval = time.Hour
+)
+
+// signedMessage is a message hash signed by the signer at the timestamp
+type signedMessage struct {
+	signer    common.Address
+	hash      []byte
+	timestamp *big.Int
+}
+
+// signatureTimeouts caches SignatureTimeout of contracts
+type signatureTimeouts struct {
+	mu      sync.Mutex
+	entries map[common.Address]signatureTimeoutEntry
+}
+
+type signatureTimeoutEntry struct {
+	timeout time.Duration
+	expires time.Time
+}
+
+var timeouts = &signatureTimeouts{entries: make(map[common.Address]signatureTimeoutEntry)}
+
+// get returns the signature timeout of the contract, read by read if not cached
+func (c *signatureTimeouts) get(contract common.Address, read func() (*big.Int, error)) (time.Duration, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[contract]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.timeout, nil
+	}
+
+	seconds, err := read()
+	if err != nil {
+		return 0, err
+	}
+	if !seconds.IsInt64() || seconds.Sign() < 0 {
+		return 0, fmt.Errorf("Invalid signature timeout %v", seconds)
+	}
+	entry = signatureTimeoutEntry{
+		timeout: time.Duration(seconds.Int64()) * time.Second,
+		expires: time.Now().Add(signatureTimeoutTTL),
+	}
+	c.mu.Lock()
+	c.entries[contract] = entry
+	c.mu.Unlock()
+	return entry.timeout, nil
+}
+
+// checkTimestamp rejects a timestamp outside the window the contract accepts
+func checkTimestamp(timestamp *big.Int, timeout time.Duration, now time.Time) Error {
+	if timestamp == nil || !timestamp.IsInt64() {
+		return &invalidTimestampError{"Timestamp is missing"}
+	}
+	signedAt := time.Unix(timestamp.Int64(), 0)
+	if signedAt.After(now.Add(maxClockSkew)) {
+		return &invalidTimestampError{"Timestamp is in the future"}
+	}
+	if !now.Before(signedAt.Add(timeout)) {
+		return &invalidTimestampError{"Timestamp is expired"}
+	}
+	return nil
+}
+
+// replayCache keeps signatures relayed until they expire
+type replayCache struct {
+	store store.Store
+}
+
+// replayEntry is a signature relayed
+type replayEntry struct {
+	TxHash  string `json:"tx_hash,omitempty"`
+	Expires int64  `json:"expires"`
+	// Job is the async job which claimed the signature, claimed again by its worker
+	Job string `json:"job,omitempty"`
+}
+
+var (
+	// For singleton
+	replays     *replayCache
+	replaysOnce sync.Once
+)
+
+// getReplayCache returns the replay cache with the store given by REPLAY_STORE,
+// or DynamoDB in AWS lambda and LevelDB otherwise
+// It returns nil if the store is not available
+func getReplayCache() *replayCache {
+	replaysOnce.Do(func() {
+		location := os.Getenv(ReplayStore)
+		if location == "" {
+			if os.Getenv(crypto.IsAwsLambda) != "" {
+				location = store.DynamoDBScheme + DefaultReplayStoreTable
+			} else {
+				location = DefaultReplayStorePath
+			}
+		}
+		s, err := store.Open(location)
+		if err != nil {
+			log.Error("Failed to open replay store, replayed signatures are caught on chain: ", err)
+			return
+		}
+		log.Info("Replay store is set to ", location)
+		replays = &replayCache{store: s}
+		if os.Getenv(crypto.IsAwsLambda) == "" {
+			go func() {
+				for range time.Tick(replayPruneInterval) {
+					replays.prune(time.Now())
+				}
+			}()
+		}
+	})
+	return replays
+}
+
+func replayKey(msg signedMessage) string {
+	return "sig/" + strings.ToLower(msg.signer.Hex()) + "/" + hexutil.Encode(msg.hash)
+}
+
+// claim records the signatures until expires, failing if any of them is relayed and not expired.
+// Signatures claimed by the job are claimed again by the job until its TX is sent, job is empty if not async
+func (c *replayCache) claim(msgs []signedMessage, expires time.Time, job string) Error {
+	value, _ := encodingJson.Marshal(replayEntry{Expires: expires.Unix(), Job: job})
+	for i, msg := range msgs {
+		err := c.store.Update(replayKey(msg), func(old []byte) ([]byte, error) {
+			var entry replayEntry
+			if old != nil && encodingJson.Unmarshal(old, &entry) == nil && time.Now().Unix() < entry.Expires && (job == "" || entry.Job != job || entry.TxHash != "") {
+				return nil, &replayedSignatureError{"Signature is already relayed " + entry.TxHash}
+			}
+			return value, nil
+		})
+		if err != nil {
+			c.release(msgs[:i])
+			if errObj, ok := err.(Error); ok {
+				return errObj
+			}
+			return &internalError{err.Error()}
+		}
+	}
+	return nil
+}
+
+// release forgets the signatures whose TX is not sent, signatures of a TX sent are kept until expired
+func (c *replayCache) release(msgs []signedMessage) {
+	for _, msg := range msgs {
+		var entry replayEntry
+		if b, err := c.store.Get(replayKey(msg)); err == nil && encodingJson.Unmarshal(b, &entry) == nil && entry.TxHash != "" {
+			continue
+		}
+		c.store.Delete(replayKey(msg))
+	}
+}
+
+// sent records the hash of the TX relaying the signatures
+func (c *replayCache) sent(msgs []signedMessage, hash string) {
+	for _, msg := range msgs {
+		c.store.Update(replayKey(msg), func(old []byte) ([]byte, error) {
+			var entry replayEntry
+			if old == nil {
+				return nil, store.ErrNotFound
+			}
+			if err := encodingJson.Unmarshal(old, &entry); err != nil {
+				return nil, err
+			}
+			entry.TxHash = hash
+			return encodingJson.Marshal(entry)
+		})
+	}
+}
+
+// prune removes expired signatures
+func (c *replayCache) prune(now time.Time) {
+	var keys []string
+	c.store.Iterate("sig/", func(key string, value []byte) bool {
+		var entry replayEntry
+		if encodingJson.Unmarshal(value, &entry) != nil || now.Unix() >= entry.Expires {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		c.store.Delete(key)
+	}
+}
+
+// claimSignatures rejects stale or replayed signatures for the contract,
+// and keeps them until expired. They must be released by releaseSignatures if no TX is sent
+func claimSignatures(reqID uint64, contract common.Address, readTimeout func() (*big.Int, error), msgs ...signedMessage) Error {
+	timeout, err := timeouts.get(contract, readTimeout)
+	if err != nil {
+		log.Errorfd(reqID, "Failed to read signature timeout of %x: %v", contract, err)
+		return &internalError{err.Error()}
+	}
+	now := time.Now()
+	var expires time.Time
+	for _, msg := range msgs {
+		if errObj := checkTimestamp(msg.timestamp, timeout, now); errObj != nil {
+			return errObj
+		}
+		if e := time.Unix(msg.timestamp.Int64(), 0).Add(timeout); e.After(expires) {
+			expires = e
+		}
+	}
+	log.Debugd(reqID, "PASS - Check Timestamp")
+
+	if c := getReplayCache(); c != nil {
+		return c.claim(msgs, expires, jobIDOf(reqID))
+	}
+	return nil
+}
+
+// releaseSignatures allows the signatures to be relayed again
+func releaseSignatures(msgs ...signedMessage) {
+	if c := getReplayCache(); c != nil {
+		c.release(msgs)
+	}
+}
+
+// releaseOnError releases the signatures if the request failed, deferred after claimSignatures.
+// Signatures of a TX in flight are kept, refer to signaturesInflight
+func releaseOnError(resp *json.RPCResponse, msgs ...signedMessage) {
+	if resp.Error != nil {
+		releaseSignatures(msgs...)
+	}
+}
+
+// signaturesSent records the TX relaying the signatures
+func signaturesSent(trx *types.Transaction, msgs ...signedMessage) {
+	if c := getReplayCache(); c != nil {
+		c.sent(msgs, trx.Hash().Hex())
+	}
+}
+
+// signaturesInflight records the TX relaying the signatures if it may be in the pool though sending it failed,
+// so that they are not relayed again while it can be mined
+func signaturesInflight(err error, msgs ...signedMessage) {
+	inflight, ok := err.(*crypto.InflightError)
+	if !ok {
+		return
+	}
+	if c := getReplayCache(); c != nil {
+		c.sent(msgs, inflight.Hash)
+	}
+}
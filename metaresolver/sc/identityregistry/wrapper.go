@@ -1,15 +1,22 @@
 package identityregistry
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"math/big"
+	"strings"
 	"sync"
 
+	ethjson "github.com/metadium/go-delegator/json"
 	"github.com/metadium/go-delegator/log"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/metadium/go-delegator/crypto"
 	"github.com/metadium/go-delegator/rpc"
 )
@@ -43,7 +50,7 @@ func getService() (*Identityregistry, error) {
 }
 
 //CallCreateIdentity CreateIdentity function call
-func CallCreateIdentity(reqID uint64, recoveryAddress common.Address, associatedAddress common.Address, providers []common.Address, resolvers []common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
+func CallCreateIdentity(ctx context.Context, reqID uint64, signer *crypto.Account, recoveryAddress common.Address, associatedAddress common.Address, providers []common.Address, resolvers []common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
 
 	var trx *types.Transaction
 	var err error
@@ -55,9 +62,12 @@ func CallCreateIdentity(reqID uint64, recoveryAddress common.Address, associated
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -70,13 +80,7 @@ func CallCreateIdentity(reqID uint64, recoveryAddress common.Address, associated
 
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - CreateIdentity")
-		}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 
@@ -84,7 +88,7 @@ func CallCreateIdentity(reqID uint64, recoveryAddress common.Address, associated
 }
 
 //CallAddAssociatedAddressDelegated  AddAssociatedAddressDelegated function call
-func CallAddAssociatedAddressDelegated(reqID uint64, approvingAddress common.Address, addressToAdd common.Address, v [2]uint8, r [2][32]byte, s [2][32]byte, timestamp [2]*big.Int) (*types.Transaction, error) {
+func CallAddAssociatedAddressDelegated(ctx context.Context, reqID uint64, signer *crypto.Account, approvingAddress common.Address, addressToAdd common.Address, v [2]uint8, r [2][32]byte, s [2][32]byte, timestamp [2]*big.Int) (*types.Transaction, error) {
 
 	var trx *types.Transaction
 	var err error
@@ -96,10 +100,13 @@ func CallAddAssociatedAddressDelegated(reqID uint64, approvingAddress common.Add
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -112,12 +119,7 @@ func CallAddAssociatedAddressDelegated(reqID uint64, approvingAddress common.Add
 
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - AddAssociatedAddressDelegated")
-		}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 
@@ -125,7 +127,7 @@ func CallAddAssociatedAddressDelegated(reqID uint64, approvingAddress common.Add
 }
 
 //CallRemoveAssociatedAddressDelegated  RemoveAssociatedAddressDelegated function call
-func CallRemoveAssociatedAddressDelegated(reqID uint64, addressToRemove common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
+func CallRemoveAssociatedAddressDelegated(ctx context.Context, reqID uint64, signer *crypto.Account, addressToRemove common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
 
 	var trx *types.Transaction
 	var err error
@@ -137,10 +139,13 @@ func CallRemoveAssociatedAddressDelegated(reqID uint64, addressToRemove common.A
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -153,13 +158,7 @@ func CallRemoveAssociatedAddressDelegated(reqID uint64, addressToRemove common.A
 
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - RemoveAssociatedAddressDelegated")
-		}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 
@@ -167,7 +166,7 @@ func CallRemoveAssociatedAddressDelegated(reqID uint64, addressToRemove common.A
 }
 
 //CallAddResolversFor  AddResolversFor function call
-func CallAddResolversFor(reqID uint64, ein *big.Int, resolvers []common.Address) (*types.Transaction, error) {
+func CallAddResolversFor(ctx context.Context, reqID uint64, signer *crypto.Account, ein *big.Int, resolvers []common.Address) (*types.Transaction, error) {
 
 	var trx *types.Transaction
 	var err error
@@ -179,10 +178,13 @@ func CallAddResolversFor(reqID uint64, ein *big.Int, resolvers []common.Address)
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -195,12 +197,121 @@ func CallAddResolversFor(reqID uint64, ein *big.Int, resolvers []common.Address)
 
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - AddAssociatedAddressDelegated")
+	if err := signer.Apply(tx); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+//CallAddProvidersFor AddProvidersFor function call, signer must be a provider of the EIN
+func CallAddProvidersFor(ctx context.Context, reqID uint64, signer *crypto.Account, ein *big.Int, providers []common.Address) (*types.Transaction, error) {
+
+	var trx *types.Transaction
+	var err error
+	service, err := getService()
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
+	tx := func(nonce uint64) error {
+
+		_rpc := rpc.GetInstance()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
+		auth.Nonce = big.NewInt(int64(nonce))
+		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
+		auth.GasLimit = glimit
+		trx, err = service.AddProvidersFor(auth, ein, providers)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
+
+		return nil
+	}
+	if err := signer.Apply(tx); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+//CallRemoveProvidersFor RemoveProvidersFor function call, signer must be a provider of the EIN
+func CallRemoveProvidersFor(ctx context.Context, reqID uint64, signer *crypto.Account, ein *big.Int, providers []common.Address) (*types.Transaction, error) {
+
+	var trx *types.Transaction
+	var err error
+	service, err := getService()
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
+	tx := func(nonce uint64) error {
+
+		_rpc := rpc.GetInstance()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
+		auth.Nonce = big.NewInt(int64(nonce))
+		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
+		auth.GasLimit = glimit
+		trx, err = service.RemoveProvidersFor(auth, ein, providers)
+		if err != nil {
+			log.Error(err)
+			return err
 		}
+		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
+
+		return nil
+	}
+	if err := signer.Apply(tx); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+//CallRemoveResolversFor RemoveResolversFor function call, signer must be a provider of the EIN
+func CallRemoveResolversFor(ctx context.Context, reqID uint64, signer *crypto.Account, ein *big.Int, resolvers []common.Address) (*types.Transaction, error) {
+
+	var trx *types.Transaction
+	var err error
+	service, err := getService()
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
+	tx := func(nonce uint64) error {
+
+		_rpc := rpc.GetInstance()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
+		auth.Nonce = big.NewInt(int64(nonce))
+		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
+		auth.GasLimit = glimit
+		trx, err = service.RemoveResolversFor(auth, ein, resolvers)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
+
+		return nil
+	}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 
@@ -208,7 +319,7 @@ func CallAddResolversFor(reqID uint64, ein *big.Int, resolvers []common.Address)
 }
 
 //CallGetEIN  get ein for associated address
-func CallGetEIN(reqID uint64, associatedAddress common.Address) (*big.Int, error) {
+func CallGetEIN(ctx context.Context, reqID uint64, associatedAddress common.Address) (*big.Int, error) {
 	var err error
 
 	service, err := getService()
@@ -218,7 +329,7 @@ func CallGetEIN(reqID uint64, associatedAddress common.Address) (*big.Int, error
 		return nil, err
 	}
 
-	result, err := service.GetEIN(&bind.CallOpts{}, associatedAddress)
+	result, err := service.GetEIN(&bind.CallOpts{Context: ctx}, associatedAddress)
 	if err != nil {
 		// if err.Error() == "abi: unmarshalling empty output" {
 		// 	return common.Big0, nil
@@ -233,7 +344,7 @@ func CallGetEIN(reqID uint64, associatedAddress common.Address) (*big.Int, error
 }
 
 //CallIsProviderFor Checks whether the passed provider is set for the passed EIN.
-func CallIsProviderFor(reqID uint64, ein *big.Int, provider common.Address) (bool, error) {
+func CallIsProviderFor(ctx context.Context, reqID uint64, ein *big.Int, provider common.Address) (bool, error) {
 	var err error
 
 	service, err := getService()
@@ -243,7 +354,7 @@ func CallIsProviderFor(reqID uint64, ein *big.Int, provider common.Address) (boo
 		return false, err
 	}
 
-	result, err := service.IsProviderFor(&bind.CallOpts{}, ein, provider)
+	result, err := service.IsProviderFor(&bind.CallOpts{Context: ctx}, ein, provider)
 	if err != nil {
 		// if err.Error() == "abi: unmarshalling empty output" {
 		// 	return common.Big0, nil
@@ -258,7 +369,7 @@ func CallIsProviderFor(reqID uint64, ein *big.Int, provider common.Address) (boo
 }
 
 //CallIsResolverFor Checks whether the passed resolver is set for the passed EIN.
-func CallIsResolverFor(reqID uint64, ein *big.Int, provider common.Address) (bool, error) {
+func CallIsResolverFor(ctx context.Context, reqID uint64, ein *big.Int, provider common.Address) (bool, error) {
 	var err error
 
 	service, err := getService()
@@ -268,7 +379,7 @@ func CallIsResolverFor(reqID uint64, ein *big.Int, provider common.Address) (boo
 		return false, err
 	}
 
-	result, err := service.IsResolverFor(&bind.CallOpts{}, ein, provider)
+	result, err := service.IsResolverFor(&bind.CallOpts{Context: ctx}, ein, provider)
 	if err != nil {
 		// if err.Error() == "abi: unmarshalling empty output" {
 		// 	return common.Big0, nil
@@ -282,6 +393,363 @@ func CallIsResolverFor(reqID uint64, ein *big.Int, provider common.Address) (boo
 
 }
 
+//CallSignatureTimeout Returns how long a signature is valid since its timestamp, in seconds
+func CallSignatureTimeout(ctx context.Context, reqID uint64) (*big.Int, error) {
+	service, err := getService()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	result, err := service.SignatureTimeout(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	log.Debugfd(reqID, "SignatureTimeout of IdentityRegistry : %v", result)
+	return result, nil
+}
+
+//CallTriggerRecovery TriggerRecovery function call, signer must be the recovery address of the EIN
+func CallTriggerRecovery(ctx context.Context, reqID uint64, signer *crypto.Account, ein *big.Int, newAssociatedAddress common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
+
+	var trx *types.Transaction
+	var err error
+	service, err := getService()
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
+	tx := func(nonce uint64) error {
+
+		_rpc := rpc.GetInstance()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
+		auth.Nonce = big.NewInt(int64(nonce))
+		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
+		auth.GasLimit = glimit
+		trx, err = service.TriggerRecovery(auth, ein, newAssociatedAddress, v, r, s, timestamp)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
+
+		return nil
+	}
+	if err := signer.Apply(tx); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+//CallTriggerRecoveryAddressChangeFor TriggerRecoveryAddressChangeFor function call, signer must be a provider of the EIN
+func CallTriggerRecoveryAddressChangeFor(ctx context.Context, reqID uint64, signer *crypto.Account, ein *big.Int, newRecoveryAddress common.Address) (*types.Transaction, error) {
+
+	var trx *types.Transaction
+	var err error
+	service, err := getService()
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
+	tx := func(nonce uint64) error {
+
+		_rpc := rpc.GetInstance()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
+		auth.Nonce = big.NewInt(int64(nonce))
+		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
+		auth.GasLimit = glimit
+		trx, err = service.TriggerRecoveryAddressChangeFor(auth, ein, newRecoveryAddress)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
+
+		return nil
+	}
+	if err := signer.Apply(tx); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+// Destruction is the arguments of TriggerDestruction
+type Destruction struct {
+	EIN            *big.Int
+	FirstChunk     []common.Address
+	LastChunk      []common.Address
+	ResetResolvers bool
+}
+
+//UnpackTriggerDestruction Returns the arguments of TriggerDestruction call data
+func UnpackTriggerDestruction(data []byte) (*Destruction, error) {
+	parsed, err := abi.JSON(strings.NewReader(IdentityregistryABI))
+	if err != nil {
+		return nil, err
+	}
+	method, ok := parsed.Methods["triggerDestruction"]
+	if !ok || len(data) < 4 || !bytes.Equal(data[:4], method.Id()) {
+		return nil, fmt.Errorf("Not a triggerDestruction call")
+	}
+	values, err := method.Inputs.UnpackValues(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &Destruction{
+		EIN:            values[0].(*big.Int),
+		FirstChunk:     values[1].([]common.Address),
+		LastChunk:      values[2].([]common.Address),
+		ResetResolvers: values[3].(bool),
+	}, nil
+}
+
+//SendTriggerDestruction Sends TriggerDestruction TX signed by the sender after simulating it
+//The contract takes it only from an address removed by recovery, so the delegator relays it as signed
+func SendTriggerDestruction(reqID uint64, trx *types.Transaction, sender common.Address) error {
+	_rpc := rpc.GetInstance()
+	args := rpc.CallArgs{
+		From:  sender,
+		To:    trx.To(),
+		Gas:   hexutil.Uint64(trx.Gas()),
+		Value: (*hexutil.Big)(trx.Value()),
+		Data:  trx.Data(),
+	}
+	if _, err := _rpc.Simulate(args); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	raw, err := rlp.EncodeToBytes(trx)
+	if err != nil {
+		return err
+	}
+	respStr, err := _rpc.SendRawTransaction(raw)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	resp := ethjson.GetRPCResponseFromJSON(respStr)
+	if resp.Error != nil {
+		return fmt.Errorf("%s", resp.Error.Message)
+	}
+	log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
+	return nil
+}
+
+//CallIdentityExists Checks whether the passed EIN exists
+func CallIdentityExists(ctx context.Context, reqID uint64, ein *big.Int) (bool, error) {
+	service, err := getService()
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	result, err := service.IdentityExists(&bind.CallOpts{Context: ctx}, ein)
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	log.Debugfd(reqID, "IdentityExists for (%v): %v ", ein, result)
+	return result, nil
+}
+
+//CallHasIdentity Checks whether the passed address is associated with an identity
+func CallHasIdentity(ctx context.Context, reqID uint64, address common.Address) (bool, error) {
+	service, err := getService()
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	result, err := service.HasIdentity(&bind.CallOpts{Context: ctx}, address)
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	log.Debugfd(reqID, "HasIdentity for (%x): %v ", address, result)
+	return result, nil
+}
+
+// Identity is the identity of an EIN
+type Identity struct {
+	RecoveryAddress     common.Address
+	AssociatedAddresses []common.Address
+	Providers           []common.Address
+	Resolvers           []common.Address
+}
+
+//CallIsAssociatedAddressFor Checks whether the passed address is associated with the passed EIN
+func CallIsAssociatedAddressFor(ctx context.Context, reqID uint64, ein *big.Int, address common.Address) (bool, error) {
+	service, err := getService()
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	result, err := service.IsAssociatedAddressFor(&bind.CallOpts{Context: ctx}, ein, address)
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	log.Debugfd(reqID, "IsAssociatedAddressFor for (%v, %x): %v ", ein, address, result)
+	return result, nil
+}
+
+//CallGetIdentity Returns the identity of the passed EIN
+func CallGetIdentity(ctx context.Context, reqID uint64, ein *big.Int) (*Identity, error) {
+	service, err := getService()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	result, err := service.GetIdentity(&bind.CallOpts{Context: ctx}, ein)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	identity := Identity(result)
+	log.Debugfd(reqID, "Identity for (%v): %+v ", ein, identity)
+	return &identity, nil
+}
+
+//CallGetRecoveryAddress Returns the recovery address of the passed EIN
+func CallGetRecoveryAddress(ctx context.Context, reqID uint64, ein *big.Int) (common.Address, error) {
+	identity, err := CallGetIdentity(ctx, reqID, ein)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return identity.RecoveryAddress, nil
+}
+
+//CallRecoveryTimeout Returns how long recovery and recovery address change are locked since triggered, in seconds
+func CallRecoveryTimeout(ctx context.Context, reqID uint64) (*big.Int, error) {
+	service, err := getService()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	result, err := service.RecoveryTimeout(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	log.Debugfd(reqID, "RecoveryTimeout of IdentityRegistry : %v", result)
+	return result, nil
+}
+
+// Recovery is the last recovery triggered for an EIN
+type Recovery struct {
+	Timestamp              *big.Int
+	OldAssociatedAddresses []common.Address
+}
+
+// RecoveryAddressChange is the last change of the recovery address triggered for an EIN
+type RecoveryAddressChange struct {
+	Timestamp          *big.Int
+	OldRecoveryAddress common.Address
+}
+
+//LastRecovery Returns the last recovery of the EIN from RecoveryTriggered events since the block, or nil if never triggered
+//The contract keeps it without a getter
+func LastRecovery(ctx context.Context, reqID uint64, ein *big.Int, from uint64) (*Recovery, error) {
+	service, err := getService()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	it, err := service.FilterRecoveryTriggered(&bind.FilterOpts{Start: from, Context: ctx}, nil, []*big.Int{ein})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer it.Close()
+
+	var last *IdentityregistryRecoveryTriggered
+	for it.Next() {
+		last = it.Event
+	}
+	if it.Error() != nil {
+		return nil, it.Error()
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	timestamp, err := BlockTime(ctx, new(big.Int).SetUint64(last.Raw.BlockNumber))
+	if err != nil {
+		return nil, err
+	}
+	log.Debugfd(reqID, "Last recovery of (%v) at %v", ein, timestamp)
+	return &Recovery{Timestamp: timestamp, OldAssociatedAddresses: last.OldAssociatedAddresses}, nil
+}
+
+//LastRecoveryAddressChange Returns the last recovery address change of the EIN from RecoveryAddressChangeTriggered events since the block, or nil if never triggered
+//The contract keeps it without a getter
+func LastRecoveryAddressChange(ctx context.Context, reqID uint64, ein *big.Int, from uint64) (*RecoveryAddressChange, error) {
+	service, err := getService()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	it, err := service.FilterRecoveryAddressChangeTriggered(&bind.FilterOpts{Start: from, Context: ctx}, nil, []*big.Int{ein})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer it.Close()
+
+	var last *IdentityregistryRecoveryAddressChangeTriggered
+	for it.Next() {
+		last = it.Event
+	}
+	if it.Error() != nil {
+		return nil, it.Error()
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	timestamp, err := BlockTime(ctx, new(big.Int).SetUint64(last.Raw.BlockNumber))
+	if err != nil {
+		return nil, err
+	}
+	log.Debugfd(reqID, "Last recovery address change of (%v) at %v", ein, timestamp)
+	return &RecoveryAddressChange{Timestamp: timestamp, OldRecoveryAddress: last.OldRecoveryAddress}, nil
+}
+
+//BlockTime Returns the timestamp of the block, the latest block if number is nil
+func BlockTime(ctx context.Context, number *big.Int) (*big.Int, error) {
+	header, err := rpc.GetInstance().GetEthClient().HeaderByNumber(ctx, number)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return header.Time, nil
+}
+
 //GetAddress Get IdentityRegistry Contract Address deployed by metadium
 func GetAddress() *common.Address {
 	return &irAddress
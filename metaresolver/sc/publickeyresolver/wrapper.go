@@ -2,6 +2,7 @@ package publickeyresolver
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/big"
 	"sync"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/metadium/go-delegator/log"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/metadium/go-delegator/crypto"
@@ -41,7 +43,7 @@ func GetInstance(address common.Address) (*Publickeyresolver, error) {
 }
 
 //CallAddPublicKeyDelegated addKeyDelegated function call
-func CallAddPublicKeyDelegated(reqID uint64, instance *Publickeyresolver, associatedAddress common.Address, publickey hexutil.Bytes, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
+func CallAddPublicKeyDelegated(ctx context.Context, reqID uint64, signer *crypto.Account, instance *Publickeyresolver, associatedAddress common.Address, publickey hexutil.Bytes, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
 	var trx *types.Transaction
 	var err error
 
@@ -50,9 +52,12 @@ func CallAddPublicKeyDelegated(reqID uint64, instance *Publickeyresolver, associ
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -64,13 +69,7 @@ func CallAddPublicKeyDelegated(reqID uint64, instance *Publickeyresolver, associ
 		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - CallAddPublicKeyDelegated")
-		}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 	return trx, nil
@@ -78,7 +77,7 @@ func CallAddPublicKeyDelegated(reqID uint64, instance *Publickeyresolver, associ
 }
 
 //CallRemovePublicKeyDelegated RemoveKeyDelegated function call
-func CallRemovePublicKeyDelegated(reqID uint64, instance *Publickeyresolver, associatedAddress common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
+func CallRemovePublicKeyDelegated(ctx context.Context, reqID uint64, signer *crypto.Account, instance *Publickeyresolver, associatedAddress common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
 	var trx *types.Transaction
 	var err error
 
@@ -87,9 +86,12 @@ func CallRemovePublicKeyDelegated(reqID uint64, instance *Publickeyresolver, ass
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -101,13 +103,7 @@ func CallRemovePublicKeyDelegated(reqID uint64, instance *Publickeyresolver, ass
 		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - CallRemovePublicKeyDelegated")
-		}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 
@@ -115,6 +111,36 @@ func CallRemovePublicKeyDelegated(reqID uint64, instance *Publickeyresolver, ass
 
 }
 
+//CallSignatureTimeout Returns how long a signature is valid since its timestamp, in seconds
+func CallSignatureTimeout(ctx context.Context, address common.Address) (*big.Int, error) {
+	instance, err := GetInstance(address)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := instance.SignatureTimeout(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+//CallGetPublicKey Returns the public key of the address, empty if not added
+func CallGetPublicKey(ctx context.Context, address common.Address, addr common.Address) ([]byte, error) {
+	instance, err := GetInstance(address)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := instance.GetPublicKey(&bind.CallOpts{Context: ctx}, addr)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return result, nil
+}
+
 //GetAddress return current ServiceKeyResolver contract address
 func GetAddress() *common.Address {
 	return &pkrAddress
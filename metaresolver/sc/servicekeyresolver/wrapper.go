@@ -2,12 +2,14 @@ package servicekeyresolver
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/big"
 	"sync"
 
 	"github.com/metadium/go-delegator/log"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/metadium/go-delegator/crypto"
@@ -39,7 +41,7 @@ func GetInstance(address common.Address) (*Servicekeyresolver, error) {
 }
 
 //CallAddKeyDelegated addKeyDelegated function call
-func CallAddKeyDelegated(reqID uint64, instance *Servicekeyresolver, associatedAddress common.Address, key common.Address, symbol string, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
+func CallAddKeyDelegated(ctx context.Context, reqID uint64, signer *crypto.Account, instance *Servicekeyresolver, associatedAddress common.Address, key common.Address, symbol string, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
 	var trx *types.Transaction
 	var err error
 
@@ -48,9 +50,12 @@ func CallAddKeyDelegated(reqID uint64, instance *Servicekeyresolver, associatedA
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -62,13 +67,7 @@ func CallAddKeyDelegated(reqID uint64, instance *Servicekeyresolver, associatedA
 		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - CallAddKeyDelegated")
-		}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 	return trx, nil
@@ -76,7 +75,7 @@ func CallAddKeyDelegated(reqID uint64, instance *Servicekeyresolver, associatedA
 }
 
 //CallRemoveKeyDelegated RemoveKeyDelegated function call
-func CallRemoveKeyDelegated(reqID uint64, instance *Servicekeyresolver, associatedAddress common.Address, key common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
+func CallRemoveKeyDelegated(ctx context.Context, reqID uint64, signer *crypto.Account, instance *Servicekeyresolver, associatedAddress common.Address, key common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
 	var trx *types.Transaction
 	var err error
 
@@ -85,9 +84,12 @@ func CallRemoveKeyDelegated(reqID uint64, instance *Servicekeyresolver, associat
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -99,13 +101,7 @@ func CallRemoveKeyDelegated(reqID uint64, instance *Servicekeyresolver, associat
 		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - CallRemoveKeyDelegated")
-		}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 
@@ -114,7 +110,7 @@ func CallRemoveKeyDelegated(reqID uint64, instance *Servicekeyresolver, associat
 }
 
 //CallRemoveKeysDelegated RemoveKeysDelegated function call
-func CallRemoveKeysDelegated(reqID uint64, instance *Servicekeyresolver, associatedAddress common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
+func CallRemoveKeysDelegated(ctx context.Context, reqID uint64, signer *crypto.Account, instance *Servicekeyresolver, associatedAddress common.Address, v uint8, r [32]byte, s [32]byte, timestamp *big.Int) (*types.Transaction, error) {
 	var trx *types.Transaction
 	var err error
 
@@ -123,9 +119,12 @@ func CallRemoveKeysDelegated(reqID uint64, instance *Servicekeyresolver, associa
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -138,13 +137,7 @@ func CallRemoveKeysDelegated(reqID uint64, instance *Servicekeyresolver, associa
 		log.Debugfd(reqID, "trxid : %v", trx.Hash().String())
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
-
-	if !res {
-		if err == nil {
-			err = fmt.Errorf("call function Error - CallRemoveKeyDelegated")
-		}
+	if err := signer.Apply(tx); err != nil {
 		return nil, err
 	}
 
@@ -152,6 +145,66 @@ func CallRemoveKeysDelegated(reqID uint64, instance *Servicekeyresolver, associa
 
 }
 
+//CallSignatureTimeout Returns how long a signature is valid since its timestamp, in seconds
+func CallSignatureTimeout(ctx context.Context, address common.Address) (*big.Int, error) {
+	instance, err := GetInstance(address)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := instance.SignatureTimeout(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+//CallGetKeys Returns service keys of the EIN
+func CallGetKeys(ctx context.Context, address common.Address, ein *big.Int) ([]common.Address, error) {
+	instance, err := GetInstance(address)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := instance.GetKeys(&bind.CallOpts{Context: ctx}, ein)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+//CallGetSymbol Returns the symbol of the service key
+func CallGetSymbol(ctx context.Context, address common.Address, key common.Address) (string, error) {
+	instance, err := GetInstance(address)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := instance.GetSymbol(&bind.CallOpts{Context: ctx}, key)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	return result, nil
+}
+
+//CallIsKeyFor Checks whether the key is a service key of the EIN
+func CallIsKeyFor(ctx context.Context, address common.Address, key common.Address, ein *big.Int) (bool, error) {
+	instance, err := GetInstance(address)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := instance.IsKeyFor(&bind.CallOpts{Context: ctx}, key, ein)
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+	return result, nil
+}
+
 //GetAddress return current ServiceKeyResolver contract address
 func GetAddress() *common.Address {
 	return &skrAddress
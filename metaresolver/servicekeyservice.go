@@ -1,14 +1,16 @@
 package metaresolver
 
 import (
+	"context"
 	"fmt"
+	"math/big"
 
 	"github.com/metadium/go-delegator/json"
 	"github.com/metadium/go-delegator/log"
 	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
 )
 
-func addKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func addKeyDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 
 	log.Debugd(reqID, " Call addKeyDelegated Function")
 
@@ -40,6 +42,26 @@ func addKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse,
 		return
 	}
 
+	//2-1. verify signature of the associated address
+	hash, _ := reqParam.Keccak256()
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AssociatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.AssociatedAddress, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, reqParam.ResolverAddress, func() (*big.Int, error) {
+		return servicekeyresolver.CallSignatureTimeout(ctx, reqParam.ResolverAddress)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
 	//3. get instance ServiceKeyResolver
 	instance, err := servicekeyresolver.GetInstance(reqParam.ResolverAddress)
 	if err != nil {
@@ -60,21 +82,27 @@ func addKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse,
 	var rBytes, sBytes [32]byte
 	copy(rBytes[:], reqParam.R)
 	copy(sBytes[:], reqParam.S)
-	trx, err := servicekeyresolver.CallAddKeyDelegated(reqID, instance, reqParam.AssociatedAddress, reqParam.Key, reqParam.Symbol, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
+	if queueJob(reqID, &resp, nil) {
+		return
+	}
+	trx, err := servicekeyresolver.CallAddKeyDelegated(ctx, reqID, jobSigner(reqID), instance, reqParam.AssociatedAddress, reqParam.Key, reqParam.Symbol, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
 	if err != nil {
 		log.Errorfd(reqID, "CallAddKeyDelegated Error : %v", err)
-		errObj := &internalError{err.Error()}
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS 05. Call addKeyDelegated : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, einOf(ctx, reqID, reqParam.AssociatedAddress))
+	signaturesSent(trx, msgs...)
 
 	//  return txid
 	resp.Result = trx.Hash().String()
 	return
 }
 
-func removeKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func removeKeyDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, " Call removeKeyDelegated Function")
 
 	resp.ID = req.ID
@@ -104,6 +132,26 @@ func removeKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCRespons
 		return
 	}
 
+	//2-1. verify signature of the associated address
+	hash, _ := reqParam.Keccak256()
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AssociatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.AssociatedAddress, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, reqParam.ResolverAddress, func() (*big.Int, error) {
+		return servicekeyresolver.CallSignatureTimeout(ctx, reqParam.ResolverAddress)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
 	//3. get instance ServiceKeyResolver
 	instance, err := servicekeyresolver.GetInstance(reqParam.ResolverAddress)
 	if err != nil {
@@ -124,14 +172,20 @@ func removeKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCRespons
 	var rBytes, sBytes [32]byte
 	copy(rBytes[:], reqParam.R)
 	copy(sBytes[:], reqParam.S)
-	trx, err := servicekeyresolver.CallRemoveKeyDelegated(reqID, instance, reqParam.AssociatedAddress, reqParam.Key, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
+	if queueJob(reqID, &resp, nil) {
+		return
+	}
+	trx, err := servicekeyresolver.CallRemoveKeyDelegated(ctx, reqID, jobSigner(reqID), instance, reqParam.AssociatedAddress, reqParam.Key, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
 	if err != nil {
 		log.Errorfd(reqID, "CallRemoveKeyDelegated Error : %v", err)
-		errObj := &internalError{err.Error()}
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS 05. Call RemoveKeyDelegated : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, einOf(ctx, reqID, reqParam.AssociatedAddress))
+	signaturesSent(trx, msgs...)
 
 	//return txid
 	resp.Result = trx.Hash().String()
@@ -139,7 +193,7 @@ func removeKeyDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCRespons
 	return
 }
 
-func removeKeysDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func removeKeysDelegated(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, " Call removeKeysDelegated Function")
 
 	resp.ID = req.ID
@@ -168,6 +222,26 @@ func removeKeysDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCRespon
 		return
 	}
 
+	//2-1. verify signature of the associated address
+	hash, _ := reqParam.Keccak256()
+	errObj = verifySignature(reqID, hash, reqParam.V[0], reqParam.R, reqParam.S, reqParam.AssociatedAddress)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Verify Signature")
+
+	//2-2. check timestamp and replay of the signature
+	msgs := []signedMessage{{reqParam.AssociatedAddress, hash, reqParam.Timestamp}}
+	errObj = claimSignatures(reqID, reqParam.ResolverAddress, func() (*big.Int, error) {
+		return servicekeyresolver.CallSignatureTimeout(ctx, reqParam.ResolverAddress)
+	}, msgs...)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	defer releaseOnError(&resp, msgs...)
+
 	//3. get instance ServiceKeyResolver
 	instance, err := servicekeyresolver.GetInstance(reqParam.ResolverAddress)
 	if err != nil {
@@ -188,14 +262,20 @@ func removeKeysDelegated(reqID uint64, req json.RPCRequest) (resp json.RPCRespon
 	var rBytes, sBytes [32]byte
 	copy(rBytes[:], reqParam.R)
 	copy(sBytes[:], reqParam.S)
-	trx, err := servicekeyresolver.CallRemoveKeysDelegated(reqID, instance, reqParam.AssociatedAddress, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
+	if queueJob(reqID, &resp, nil) {
+		return
+	}
+	trx, err := servicekeyresolver.CallRemoveKeysDelegated(ctx, reqID, jobSigner(reqID), instance, reqParam.AssociatedAddress, reqParam.V[0], rBytes, sBytes, reqParam.Timestamp)
 	if err != nil {
 		log.Errorfd(reqID, "CallRemoveKeysDelegated Error : %v", err)
-		errObj := &internalError{err.Error()}
+		signaturesInflight(err, msgs...)
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS 05. Call RemoveKeysDelegated : %v", trx.Hash().String())
+	trackTx(reqID, req.Method, trx, einOf(ctx, reqID, reqParam.AssociatedAddress))
+	signaturesSent(trx, msgs...)
 
 	//return txid
 	resp.Result = trx.Hash().String()
@@ -1,7 +1,74 @@
 package metaresolver
 
 import (
+	"os"
+	"strings"
+	"sync"
+
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaresolver/sc/publickeyresolver"
+	"github.com/metadium/go-delegator/metaresolver/sc/servicekeyresolver"
+)
+
+// For environment arguments
+const (
+	// ResolverAllowlist is comma separated resolver addresses which users can add by add_resolvers_delegated
+	// Every service key and public key resolver is allowed if not given
+	ResolverAllowlist = "RESOLVER_ALLOWLIST"
 )
 
 var providerAddresses = []common.Address{common.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")}
+
+var (
+	resolverAllowlist     []common.Address
+	resolverAllowlistOnce sync.Once
+)
+
+// parseAddressList returns addresses in the comma separated list, invalid ones are skipped
+func parseAddressList(list string) []common.Address {
+	var addresses []common.Address
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !common.IsHexAddress(s) {
+			log.Errorf("Invalid address %q is skipped", s)
+			continue
+		}
+		addresses = append(addresses, common.HexToAddress(s))
+	}
+	return addresses
+}
+
+// getResolverAllowlist returns resolvers which users can add by delegated methods
+func getResolverAllowlist() []common.Address {
+	resolverAllowlistOnce.Do(func() {
+		if list := os.Getenv(ResolverAllowlist); list != "" {
+			resolverAllowlist = parseAddressList(list)
+		} else {
+			resolverAllowlist = append(resolverAllowlist, servicekeyresolver.GetAddressList()...)
+			resolverAllowlist = append(resolverAllowlist, publickeyresolver.GetAddressList()...)
+		}
+		log.Info("Resolver allowlist is set to ", resolverAllowlist)
+	})
+	return resolverAllowlist
+}
+
+// notAllowedResolver returns the first resolver not in the allowlist, or nil if all are allowed
+func notAllowedResolver(resolvers []common.Address, allowlist []common.Address) *common.Address {
+	for i, r := range resolvers {
+		allowed := false
+		for _, a := range allowlist {
+			if r == a {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &resolvers[i]
+		}
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	ethCrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/metadium/go-delegator/indexer"
 	"github.com/metadium/go-delegator/log"
 	"gopkg.in/validator.v2"
 )
@@ -112,6 +113,159 @@ func (p *removeAssociatedAddressDelegatedParams) Keccak256(identityRegistryAddr
 	return hash, data
 }
 
+type triggerRecoveryParams struct {
+	EIN                  *big.Int       `json:"ein" validate:"nonzero"`
+	NewAssociatedAddress common.Address `json:"new_associated_address"`
+	V                    hexutil.Bytes  `json:"v" validate:"len=1"`
+	R                    hexutil.Bytes  `json:"r" validate:"len=32"`
+	S                    hexutil.Bytes  `json:"s" validate:"len=32"`
+	Timestamp            *big.Int       `json:"timestamp"`
+}
+
+func (p *triggerRecoveryParams) Keccak256(identityRegistryAddr *common.Address) (hash []byte, data []byte) {
+	einBytes := bigIntToByte32(p.EIN)
+	timestampBytes := bigIntToByte32(p.Timestamp)
+	data = append(data, headerBytes...)
+	data = append(data, identityRegistryAddr.Bytes()...)
+	data = append(data, []byte("I authorize being added to this Identity via recovery.")...)
+	data = append(data, einBytes[:]...)
+	data = append(data, p.NewAssociatedAddress.Bytes()...)
+	data = append(data, timestampBytes[:]...)
+	hash = ethCrypto.Keccak256(data)
+	return hash, data
+}
+
+// The contract takes recovery address change from a provider without a signature,
+// the delegator asks one of an associated address
+type triggerRecoveryAddressChangeParams struct {
+	AssociatedAddress  common.Address `json:"associated_address"`
+	NewRecoveryAddress common.Address `json:"new_recovery_address"`
+	V                  hexutil.Bytes  `json:"v" validate:"len=1"`
+	R                  hexutil.Bytes  `json:"r" validate:"len=32"`
+	S                  hexutil.Bytes  `json:"s" validate:"len=32"`
+	Timestamp          *big.Int       `json:"timestamp"`
+}
+
+func (p *triggerRecoveryAddressChangeParams) Keccak256(identityRegistryAddr *common.Address, ein *big.Int) (hash []byte, data []byte) {
+	einBytes := bigIntToByte32(ein)
+	timestampBytes := bigIntToByte32(p.Timestamp)
+	data = append(data, headerBytes...)
+	data = append(data, identityRegistryAddr.Bytes()...)
+	data = append(data, []byte("I authorize changing the recovery address of my Identity.")...)
+	data = append(data, einBytes[:]...)
+	data = append(data, p.NewRecoveryAddress.Bytes()...)
+	data = append(data, timestampBytes[:]...)
+	hash = ethCrypto.Keccak256(data)
+	return hash, data
+}
+
+// The contract takes destruction only from an address removed by recovery,
+// the delegator relays the TX signed by it
+type triggerDestructionParams struct {
+	RawTransaction hexutil.Bytes `json:"raw_transaction" validate:"nonzero"`
+}
+
+// Messages signed by an associated address to manage providers and resolvers,
+// the contract takes them from a provider without a signature
+const (
+	addProvidersMessage    = "I authorize the addition of providers on my behalf."
+	removeProvidersMessage = "I authorize the removal of providers on my behalf."
+	addResolversMessage    = "I authorize the addition of resolvers on my behalf."
+	removeResolversMessage = "I authorize the removal of resolvers on my behalf."
+)
+
+type providersDelegatedParams struct {
+	AssociatedAddress common.Address   `json:"associated_address"`
+	Providers         []common.Address `json:"providers" validate:"min=1"`
+	V                 hexutil.Bytes    `json:"v" validate:"len=1"`
+	R                 hexutil.Bytes    `json:"r" validate:"len=32"`
+	S                 hexutil.Bytes    `json:"s" validate:"len=32"`
+	Timestamp         *big.Int         `json:"timestamp"`
+}
+
+func (p *providersDelegatedParams) Keccak256(identityRegistryAddr *common.Address, ein *big.Int, message string) (hash []byte, data []byte) {
+	return addressesMessageKeccak256(identityRegistryAddr, message, ein, p.Providers, p.Timestamp)
+}
+
+type resolversDelegatedParams struct {
+	AssociatedAddress common.Address   `json:"associated_address"`
+	Resolvers         []common.Address `json:"resolvers" validate:"min=1"`
+	V                 hexutil.Bytes    `json:"v" validate:"len=1"`
+	R                 hexutil.Bytes    `json:"r" validate:"len=32"`
+	S                 hexutil.Bytes    `json:"s" validate:"len=32"`
+	Timestamp         *big.Int         `json:"timestamp"`
+}
+
+func (p *resolversDelegatedParams) Keccak256(identityRegistryAddr *common.Address, ein *big.Int, message string) (hash []byte, data []byte) {
+	return addressesMessageKeccak256(identityRegistryAddr, message, ein, p.Resolvers, p.Timestamp)
+}
+
+func addressesMessageKeccak256(identityRegistryAddr *common.Address, message string, ein *big.Int, addresses []common.Address, timestamp *big.Int) (hash []byte, data []byte) {
+	einBytes := bigIntToByte32(ein)
+	timestampBytes := bigIntToByte32(timestamp)
+	data = append(data, headerBytes...)
+	data = append(data, identityRegistryAddr.Bytes()...)
+	data = append(data, []byte(message)...)
+	data = append(data, einBytes[:]...)
+	data = append(data, addressArrayToBytes(addresses)...)
+	data = append(data, timestampBytes[:]...)
+	hash = ethCrypto.Keccak256(data)
+	return hash, data
+}
+
+type einParams struct {
+	EIN *big.Int `json:"ein" validate:"nonzero"`
+}
+
+type addressParams struct {
+	Address common.Address `json:"address"`
+}
+
+type associatedAddressForParams struct {
+	EIN     *big.Int       `json:"ein" validate:"nonzero"`
+	Address common.Address `json:"address"`
+}
+
+// ResolverAddress is the current resolver if not given
+type serviceKeysParams struct {
+	EIN             *big.Int        `json:"ein" validate:"nonzero"`
+	ResolverAddress *common.Address `json:"resolver_address"`
+}
+
+type keyForParams struct {
+	EIN             *big.Int        `json:"ein" validate:"nonzero"`
+	Key             common.Address  `json:"key"`
+	ResolverAddress *common.Address `json:"resolver_address"`
+}
+
+type publicKeyParams struct {
+	Address         common.Address  `json:"address"`
+	ResolverAddress *common.Address `json:"resolver_address"`
+}
+
+type addressHistoryResult struct {
+	// EIN is the identity the address is associated with now, nil if none
+	EIN    *big.Int         `json:"ein"`
+	Events []*indexer.Event `json:"events"`
+}
+
+type resolveDIDParams struct {
+	DID string `json:"did" validate:"nonzero"`
+}
+
+type identityResult struct {
+	EIN                 *big.Int         `json:"ein"`
+	RecoveryAddress     common.Address   `json:"recovery_address"`
+	AssociatedAddresses []common.Address `json:"associated_addresses"`
+	Providers           []common.Address `json:"providers"`
+	Resolvers           []common.Address `json:"resolvers"`
+}
+
+type serviceKeyResult struct {
+	Key    common.Address `json:"key"`
+	Symbol string         `json:"symbol"`
+}
+
 type addKeyDelegatedParams struct {
 	ResolverAddress   common.Address `json:"resolver_address"`
 	AssociatedAddress common.Address `json:"associated_address"`
@@ -217,6 +371,14 @@ func (p *removePublicKeyDelegatedParams) Keccak256() (hash []byte, data []byte)
 	return hash, data
 }
 
+type transactionStatusParams struct {
+	TxHash common.Hash `json:"tx_hash"`
+}
+
+type jobParams struct {
+	ID string `json:"id" validate:"nonzero"`
+}
+
 func init() {
 	validator.SetValidationFunc("itemlen", checkBytesLength)
 }
@@ -274,6 +436,90 @@ func getParameter(method string, params []interface{}) (interface{}, Error) {
 			return nil, err
 		}
 		return reqParam, nil
+	case "trigger_recovery":
+		var reqParam triggerRecoveryParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "trigger_recovery_address_change":
+		var reqParam triggerRecoveryAddressChangeParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "trigger_destruction":
+		var reqParam triggerDestructionParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "add_providers_delegated", "remove_providers_delegated":
+		var reqParam providersDelegatedParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "add_resolvers_delegated", "remove_resolvers_delegated":
+		var reqParam resolversDelegatedParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "get_identity", "get_identity_providers", "get_identity_resolvers", "get_identity_history", "get_key_changes":
+		var reqParam einParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "get_ein", "has_identity", "get_address_history":
+		var reqParam addressParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "is_associated_address_for":
+		var reqParam associatedAddressForParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "get_service_keys":
+		var reqParam serviceKeysParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "is_key_for":
+		var reqParam keyForParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "get_public_key":
+		var reqParam publicKeyParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+	case "resolve_did":
+		var reqParam resolveDIDParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
 	case "add_key_delegated":
 		var reqParam addKeyDelegatedParams
 		err := fillParam(&reqParam, obj)
@@ -314,6 +560,22 @@ func getParameter(method string, params []interface{}) (interface{}, Error) {
 		}
 		return reqParam, nil
 
+	case "get_transaction_status":
+		var reqParam transactionStatusParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "get_job":
+		var reqParam jobParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
 	default:
 		return nil, &methodNotFoundError{method}
 
@@ -0,0 +1,86 @@
+package metaresolver
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaresolver/sc/identityregistry"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethCrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+// identityCreatedTopic is a topic of IdentityCreated event, EIN is the 2nd indexed argument
+var identityCreatedTopic = ethCrypto.Keccak256Hash([]byte("IdentityCreated(address,uint256,address,address,address[],address[],bool)"))
+
+func init() {
+	tracker.RegisterLogDecoder("create_identity", decodeIdentityCreated)
+}
+
+// decodeIdentityCreated sets EIN of the identity created by the TX
+func decodeIdentityCreated(rec *tracker.Record, logs []*types.Log) {
+	for _, l := range logs {
+		if l.Address == *identityregistry.GetAddress() && len(l.Topics) > 2 && l.Topics[0] == identityCreatedTopic {
+			rec.EIN = l.Topics[2].Big().String()
+			return
+		}
+	}
+}
+
+// einOf returns EIN of the associated address, or nil if unknown
+func einOf(ctx context.Context, reqID uint64, address common.Address) *big.Int {
+	ein, err := identityregistry.CallGetEIN(ctx, reqID, address)
+	if err != nil {
+		return nil
+	}
+	return ein
+}
+
+// trackTx records the TX sent for the request to follow its status
+func trackTx(reqID uint64, method string, trx *types.Transaction, ein *big.Int) {
+	var einStr string
+	if ein != nil {
+		einStr = ein.String()
+	}
+	tracker.Track(reqID, method, trx, einStr, "")
+	queries.invalidate(einStr)
+}
+
+func getTransactionStatus(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getTransactionStatus Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(transactionStatusParams)
+	log.Debugfd(reqID, "parameter[TxHash] : %v", reqParam.TxHash.Hex())
+
+	t := tracker.GetInstance()
+	if t == nil {
+		errObj := &internalError{"Transaction tracker is not available"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	rec, err := t.Get(reqParam.TxHash.Hex())
+	if err == store.ErrNotFound {
+		errObj := &notExistsTransactionError{"Transaction is not sent by delegator"}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	} else if err != nil {
+		errObj := &internalError{err.Error()}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	resp.Result = rec
+	return
+}
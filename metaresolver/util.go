@@ -37,6 +37,10 @@ func isValidSignatureWithPrefix(reqID uint64, hash []byte, v uint8, r hexutil.By
 }
 func isValidSignature(reqID uint64, hash []byte, v uint8, r hexutil.Bytes, s hexutil.Bytes, address common.Address) (bool, Error) {
 	var sig hexutil.Bytes
+	// Contracts take v of 27 or 28 only
+	if v != 27 && v != 28 {
+		return false, &invalidSignatureError{"Invalid signature v"}
+	}
 
 	sig = append(sig, r...)
 	sig = append(sig, s...)
@@ -47,7 +51,7 @@ func isValidSignature(reqID uint64, hash []byte, v uint8, r hexutil.Bytes, s hex
 	pKeyBytes, err := ethCrypto.Ecrecover(hash, sig)
 	if err != nil {
 		log.Errorfd(reqID, "EcRecover Error: %v", err)
-		return false, &internalError{"Failed to EcRecover"}
+		return false, &invalidSignatureError{"Failed to EcRecover"}
 	}
 	pKey, err := ethCrypto.UnmarshalPubkey(pKeyBytes)
 	if err != nil {
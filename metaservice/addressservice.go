@@ -1,12 +1,14 @@
 package metaservice
 
 import (
+	"context"
+
 	"github.com/metadium/go-delegator/json"
 	"github.com/metadium/go-delegator/log"
 	"github.com/metadium/go-delegator/metaservice/sc/registry"
 )
 
-func getRegistryAddress(reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getRegistryAddress(ctx context.Context, reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqId, "Call getRegistryAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -24,7 +26,7 @@ func getRegistryAddress(reqId uint64, req json.RPCRequest) (resp json.RPCRespons
 	}
 	return
 }
-func getIdentityManagerAddress(reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getIdentityManagerAddress(ctx context.Context, reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqId, "Call getIdentityManagerAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -43,7 +45,7 @@ func getIdentityManagerAddress(reqId uint64, req json.RPCRequest) (resp json.RPC
 	return
 }
 
-func getTopicRegistryAddress(reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getTopicRegistryAddress(ctx context.Context, reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqId, "Call getTopicRegistryAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -62,7 +64,7 @@ func getTopicRegistryAddress(reqId uint64, req json.RPCRequest) (resp json.RPCRe
 	return
 }
 
-func getAttestationAgencyRegistryAddress(reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getAttestationAgencyRegistryAddress(ctx context.Context, reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqId, "Call getAttestationAgencyRegistryAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -81,7 +83,7 @@ func getAttestationAgencyRegistryAddress(reqId uint64, req json.RPCRequest) (res
 	return
 }
 
-func getAchievementManagerAddress(reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getAchievementManagerAddress(ctx context.Context, reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqId, "Call getAchievementManagerAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -100,7 +102,7 @@ func getAchievementManagerAddress(reqId uint64, req json.RPCRequest) (resp json.
 	return
 }
 
-func getAchievementAddress(reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getAchievementAddress(ctx context.Context, reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqId, "Call getAchievementAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
@@ -119,7 +121,7 @@ func getAchievementAddress(reqId uint64, req json.RPCRequest) (resp json.RPCResp
 	return
 }
 
-func getAllSystemAddress(reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getAllSystemAddress(ctx context.Context, reqId uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqId, "Call getAllSystemAddress Function")
 	resp.ID = req.ID
 	resp.Jsonrpc = req.Jsonrpc
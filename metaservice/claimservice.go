@@ -0,0 +1,287 @@
+package metaservice
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaservice/sc/identity"
+)
+
+// claimResult is a claim of MetaID with the result of verifying its issuer signature
+type claimResult struct {
+	ClaimID   string        `json:"claim_id"`
+	Topic     string        `json:"topic"`
+	Scheme    string        `json:"scheme"`
+	Issuer    string        `json:"issuer"`
+	Signature hexutil.Bytes `json:"signature"`
+	Data      hexutil.Bytes `json:"data"`
+	URI       string        `json:"uri"`
+	// Signer is the address recovered from the signature, empty if it can't be recovered
+	Signer string `json:"signer,omitempty"`
+	// Valid is true if the signer is the issuer, or a claim signer key of the issuer MetaID
+	Valid bool `json:"valid"`
+}
+
+// verifyClaim returns the address signed the claim of MetaID, and whether it is the issuer
+// or a claim signer key of the issuer MetaID.
+// A malformed signature is not valid with zero address, an error is of calling node
+func verifyClaim(ctx context.Context, instance *identity.Identity, topic *big.Int, issuer common.Address, data []byte, signature []byte) (common.Address, bool, error) {
+	toSign, err := identity.CallClaimToSign(ctx, instance, *instance.Address, topic, data)
+	if err != nil {
+		return common.Address{}, false, err
+	}
+	signer, err := identity.CallGetSignatureAddress(ctx, instance, toSign, signature)
+	if err != nil {
+		return common.Address{}, false, err
+	}
+	if signer == (common.Address{}) {
+		return signer, false, nil
+	}
+	if signer == issuer {
+		return signer, true, nil
+	}
+	issuerInstance, err := identity.GetInstance(issuer)
+	if err != nil {
+		return signer, false, err
+	}
+	ok, err := identity.CallKeyHasPurpose(ctx, issuerInstance, identity.CallAddrToKey(signer), identity.ClaimSignerKey)
+	return signer, ok, err
+}
+
+// readClaim returns the claim of MetaID verified, nil if not exists
+func readClaim(ctx context.Context, reqID uint64, instance *identity.Identity, claimID [32]byte) (*claimResult, error) {
+	claim, err := identity.CallGetClaim(ctx, instance, claimID)
+	if err != nil || claim == nil {
+		return nil, err
+	}
+	result := &claimResult{
+		ClaimID:   hexutil.Encode(claimID[:]),
+		Topic:     claim.Topic.String(),
+		Scheme:    claim.Scheme.String(),
+		Issuer:    claim.Issuer.Hex(),
+		Signature: claim.Signature,
+		Data:      claim.Data,
+		URI:       claim.URI,
+	}
+	signer, valid, err := verifyClaim(ctx, instance, claim.Topic, claim.Issuer, claim.Data, claim.Signature)
+	if err != nil {
+		log.Errorfd(reqID, "Failed to verify claim %x : %v", claimID, err)
+		return nil, err
+	}
+	if signer != (common.Address{}) {
+		result.Signer = signer.Hex()
+	}
+	result.Valid = valid
+	return result, nil
+}
+
+func delegatedAddClaim(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call delegatedAddClaim Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDAddClaimParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[from] : %x", reqParam.From)
+	log.Debugfd(reqID, "parameter[topic] : %v", reqParam.Topic)
+	log.Debugfd(reqID, "parameter[scheme] : %v", reqParam.Scheme)
+	log.Debugfd(reqID, "parameter[issuer] : %x", reqParam.Issuer)
+	log.Debugfd(reqID, "parameter[claim_signature] : %v", reqParam.ClaimSignature)
+	log.Debugfd(reqID, "parameter[data] : %v", reqParam.Data)
+	log.Debugfd(reqID, "parameter[uri] : %v", reqParam.URI)
+	log.Debugfd(reqID, "parameter[nonce] : %v", reqParam.Nonce)
+	log.Debugfd(reqID, "parameter[Signature] : %v", reqParam.Signature)
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Get Identity")
+
+	signer, valid, err := verifyClaim(ctx, instance, reqParam.Topic, reqParam.Issuer, reqParam.Data, reqParam.ClaimSignature)
+	if err != nil {
+		log.Errorfd(reqID, "verifyClaim Error : %v", err)
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	} else if signer == (common.Address{}) {
+		resp.Error = makeErrorResponse(&invalidSignatureError{"Failed to recover claim signature"})
+		return
+	} else if !valid {
+		log.Debugfd(reqID, "Error : claim is signed by %v, not by issuer %v", signer.String(), reqParam.Issuer.String())
+		resp.Error = makeErrorResponse(&invalidSignatureError{"Claim is not signed by the issuer"})
+		return
+	}
+	log.Debugd(reqID, "PASS - 03. Verify Claim Sign : ", signer.String())
+
+	data, err := identity.PackAddClaim(reqParam.Topic, reqParam.Scheme, reqParam.Issuer, reqParam.ClaimSignature, reqParam.Data, reqParam.URI)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	}
+	trx, errObj := executeSelf(ctx, reqID, req, instance, reqParam.From, data, reqParam.Nonce, reqParam.Signature)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+	return
+}
+
+func delegatedRemoveClaim(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call delegatedRemoveClaim Function")
+	return executeClaim(ctx, reqID, req, identity.PackRemoveClaim)
+}
+
+func delegatedRefreshClaim(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call delegatedRefreshClaim Function")
+	return executeClaim(ctx, reqID, req, identity.PackRefreshClaim)
+}
+
+// executeClaim sends the call packed for an existing claim, by MetaID itself or by its issuer MetaID
+func executeClaim(ctx context.Context, reqID uint64, req json.RPCRequest, pack func([32]byte) (hexutil.Bytes, error)) (resp json.RPCResponse, errRet error) {
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDClaimParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[claim_id] : %v", reqParam.ClaimID)
+	log.Debugfd(reqID, "parameter[issuer_meta_id] : %v", reqParam.IssuerMetaID)
+	log.Debugfd(reqID, "parameter[from] : %x", reqParam.From)
+	log.Debugfd(reqID, "parameter[nonce] : %v", reqParam.Nonce)
+	log.Debugfd(reqID, "parameter[Signature] : %v", reqParam.Signature)
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	var claimID [32]byte
+	copy(claimID[:], reqParam.ClaimID)
+	claim, err := identity.CallGetClaim(ctx, instance, claimID)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	} else if claim == nil {
+		resp.Error = makeErrorResponse(&notExistsClaimError{"Claim is not found"})
+		return
+	}
+	executor := instance
+	if reqParam.IssuerMetaID != nil {
+		if *reqParam.IssuerMetaID != claim.Issuer {
+			resp.Error = makeErrorResponse(&invalidPermissionError{"Claim is not issued by the issuer MetaID"})
+			return
+		}
+		if executor, errObj = getIdentity(*reqParam.IssuerMetaID); errObj != nil {
+			resp.Error = makeErrorResponse(errObj)
+			return
+		}
+	}
+	log.Debugd(reqID, "PASS - 02. Get Claim")
+
+	data, err := pack(claimID)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	}
+	trx, errObj := execute(ctx, reqID, req, executor, reqParam.From, reqParam.MetaID, data, reqParam.Nonce, reqParam.Signature)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+	return
+}
+
+func getClaims(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getClaims Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDGetClaimsParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[topic] : %v", reqParam.Topic)
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	claimIDs, err := identity.CallGetClaimIdsByType(ctx, instance, reqParam.Topic)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	}
+	claims := []*claimResult{}
+	for _, claimID := range claimIDs {
+		claim, err := readClaim(ctx, reqID, instance, claimID)
+		if err != nil {
+			resp.Error = makeErrorResponse(&internalError{err.Error()})
+			return
+		}
+		if claim != nil {
+			claims = append(claims, claim)
+		}
+	}
+
+	resp.Result = claims
+	return
+}
+
+func getClaim(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getClaim Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDGetClaimParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[claim_id] : %v", reqParam.ClaimID)
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	var claimID [32]byte
+	copy(claimID[:], reqParam.ClaimID)
+	claim, err := readClaim(ctx, reqID, instance, claimID)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	} else if claim == nil {
+		resp.Error = makeErrorResponse(&notExistsClaimError{"Claim is not found"})
+		return
+	}
+
+	resp.Result = claim
+	return
+}
@@ -1,6 +1,12 @@
 package metaservice
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/metadium/go-delegator/rpc"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
 
 // Error is general error interface in Proxy
 type Error interface {
@@ -8,6 +14,12 @@ type Error interface {
 	ErrorCode() int32 // returns the code
 }
 
+// DataError is an Error with additional data in the response
+type DataError interface {
+	Error
+	ErrorData() interface{} // returns the data of the error
+}
+
 // request is for an unknown service
 type methodNotFoundError struct {
 	//	service string
@@ -113,3 +125,45 @@ type invalidPermissionError struct{ message string }
 func (e *invalidPermissionError) ErrorCode() int32 { return -32019 }
 
 func (e *invalidPermissionError) Error() string { return e.message }
+
+type notExistsClaimError struct{ message string }
+
+func (e *notExistsClaimError) ErrorCode() int32 { return -32625 }
+
+func (e *notExistsClaimError) Error() string { return e.message }
+
+type notExistsExecutionError struct{ message string }
+
+func (e *notExistsExecutionError) ErrorCode() int32 { return -32626 }
+
+func (e *notExistsExecutionError) Error() string { return e.message }
+
+// delegated TX would revert, nothing is sent
+type executionRevertedError struct {
+	reason string
+	data   hexutil.Bytes
+}
+
+func (e *executionRevertedError) ErrorCode() int32 { return -32618 }
+
+func (e *executionRevertedError) Error() string {
+	if e.reason == "" {
+		return "execution reverted"
+	}
+	return "execution reverted: " + e.reason
+}
+
+func (e *executionRevertedError) ErrorData() interface{} {
+	return struct {
+		Reason string        `json:"reason"`
+		Data   hexutil.Bytes `json:"data,omitempty"`
+	}{e.reason, e.data}
+}
+
+// callError returns the error of a failed delegated TX, reverted in simulation or internal
+func callError(err error) Error {
+	if revertErr, ok := err.(*rpc.RevertError); ok {
+		return &executionRevertedError{revertErr.Reason, revertErr.Data}
+	}
+	return &internalError{err.Error()}
+}
@@ -0,0 +1,481 @@
+package metaservice
+
+import (
+	"context"
+	encodingJson "encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethCrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/metadium/go-delegator/accounting"
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaservice/sc/identity"
+	"github.com/metadium/go-delegator/rpc"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+// For environment arguments
+const (
+	// ExecutionStore is a location of store for executions requested by delegated TXs, refer to store.Open
+	ExecutionStore = "EXECUTION_STORE"
+)
+
+// For execution store
+const (
+	// DefaultExecutionStorePath is a LevelDB path for executions
+	DefaultExecutionStorePath = "executions"
+	// DefaultExecutionStoreTable is a DynamoDB table for executions used in AWS lambda
+	DefaultExecutionStoreTable = "Executions"
+)
+
+// Status of an execution
+const (
+	executionPending  = "pending"
+	executionExecuted = "executed"
+	executionFailed   = "failed"
+)
+
+// Approvals relayed in a batch are up to this
+const maxApprovalBatch = 16
+
+// executionEntry is an execution of MetaID requested or approved by delegated TXs
+type executionEntry struct {
+	MetaID string `json:"meta_id"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Txs are hashes of delegated TXs of the execution, the request first
+	Txs       []string `json:"txs"`
+	CreatedAt int64    `json:"created_at"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+// executionBook keeps executions in a store
+//
+//	execution/<meta id>/<execution id>    executionEntry
+type executionBook struct {
+	store store.Store
+}
+
+var (
+	// For singleton
+	executions     *executionBook
+	executionsOnce sync.Once
+)
+
+func init() {
+	tracker.OnFinal(recordExecution)
+}
+
+// getExecutionBook returns the execution book with the store given by EXECUTION_STORE,
+// or DynamoDB in AWS lambda and LevelDB otherwise
+// It returns nil if the store is not available
+func getExecutionBook() *executionBook {
+	executionsOnce.Do(func() {
+		location := os.Getenv(ExecutionStore)
+		if location == "" {
+			if os.Getenv(crypto.IsAwsLambda) != "" {
+				location = store.DynamoDBScheme + DefaultExecutionStoreTable
+			} else {
+				location = DefaultExecutionStorePath
+			}
+		}
+		s, err := store.Open(location)
+		if err != nil {
+			log.Error("Failed to open execution store, pending executions are not listed: ", err)
+			return
+		}
+		log.Info("Execution store is set to ", location)
+		executions = &executionBook{store: s}
+	})
+	return executions
+}
+
+// recordExecution keeps the execution of a delegated TX mined
+func recordExecution(rec *tracker.Record) {
+	if rec.Status != tracker.StatusMined || rec.ExecutionID == "" || rec.MetaID == "" {
+		return
+	}
+	b := getExecutionBook()
+	if b == nil {
+		return
+	}
+	if err := b.record(rec.MetaID, rec.ExecutionID, rec.ExecutionStatus, rec.MinedHash); err != nil {
+		log.Warnf("Failed to keep execution %s of %s: %v", rec.ExecutionID, rec.MetaID, err)
+	}
+}
+
+func executionKey(metaID, id string) string {
+	return "execution/" + strings.ToLower(metaID) + "/" + strings.ToLower(id)
+}
+
+// record adds the TX to the execution with the status, a finished execution is not pending again
+func (b *executionBook) record(metaID, id, status, tx string) error {
+	now := time.Now().Unix()
+	return b.store.Update(executionKey(metaID, id), func(old []byte) ([]byte, error) {
+		entry := &executionEntry{MetaID: common.HexToAddress(metaID).Hex(), ID: strings.ToLower(id), Status: executionPending, CreatedAt: now}
+		if old != nil {
+			if err := encodingJson.Unmarshal(old, entry); err != nil {
+				return nil, err
+			}
+		}
+		if entry.Status == executionPending && status != "" {
+			entry.Status = status
+		}
+		found := false
+		for _, t := range entry.Txs {
+			found = found || t == tx
+		}
+		if !found && tx != "" {
+			entry.Txs = append(entry.Txs, tx)
+		}
+		entry.UpdatedAt = now
+		return encodingJson.Marshal(entry)
+	})
+}
+
+// get returns the execution, or store.ErrNotFound
+func (b *executionBook) get(metaID, id string) (*executionEntry, error) {
+	value, err := b.store.Get(executionKey(metaID, id))
+	if err != nil {
+		return nil, err
+	}
+	entry := new(executionEntry)
+	return entry, encodingJson.Unmarshal(value, entry)
+}
+
+// list returns executions of MetaID
+func (b *executionBook) list(metaID string) ([]*executionEntry, error) {
+	var entries []*executionEntry
+	var errRet error
+	err := b.store.Iterate(executionKey(metaID, ""), func(key string, value []byte) bool {
+		entry := new(executionEntry)
+		if errRet = encodingJson.Unmarshal(value, entry); errRet != nil {
+			return false
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, errRet
+}
+
+// executionResult is an execution with its call and approvals on chain
+type executionResult struct {
+	MetaID string `json:"meta_id"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// To, Value and Data are the call, empty if the execution is gone from MetaID
+	To           string        `json:"to,omitempty"`
+	Value        string        `json:"value,omitempty"`
+	Data         hexutil.Bytes `json:"data,omitempty"`
+	NeedsApprove string        `json:"needs_approve,omitempty"`
+	Approvals    []string      `json:"approvals"`
+	Txs          []string      `json:"txs,omitempty"`
+}
+
+// readExecution returns the execution with its state on chain, nil if it is neither on chain nor kept.
+// A pending execution gone from chain is approved by TXs not relayed by delegator
+func readExecution(ctx context.Context, reqID uint64, instance *identity.Identity, id []byte) (*executionResult, error) {
+	metaID := instance.Address.Hex()
+	result := &executionResult{MetaID: metaID, ID: hexutil.Encode(id), Approvals: []string{}}
+	b := getExecutionBook()
+	var entry *executionEntry
+	if b != nil {
+		var err error
+		if entry, err = b.get(metaID, result.ID); err != nil && err != store.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	idBigInt := new(big.Int).SetBytes(id)
+	execution, err := identity.CallGetExecution(ctx, instance, idBigInt)
+	if err != nil {
+		return nil, err
+	}
+	if execution == nil && entry == nil {
+		return nil, nil
+	}
+	if entry != nil {
+		result.Status = entry.Status
+		result.Txs = entry.Txs
+	}
+	if execution == nil {
+		if result.Status == executionPending {
+			log.Debugfd(reqID, "Execution %s of %s is gone from chain", result.ID, metaID)
+			result.Status = executionExecuted
+			if err := b.record(metaID, result.ID, executionExecuted, ""); err != nil {
+				log.Warnfd(reqID, "Failed to keep execution %s: %v", result.ID, err)
+			}
+		}
+		return result, nil
+	}
+
+	result.Status = executionPending
+	result.To = execution.To.Hex()
+	result.Value = execution.Value.String()
+	result.Data = execution.Data
+	result.NeedsApprove = execution.NeedsApprove.String()
+	approvals, err := identity.CallGetApprovals(ctx, instance, idBigInt)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range approvals {
+		result.Approvals = append(result.Approvals, a.Hex())
+	}
+	return result, nil
+}
+
+// approveSigData returns the data signed to approve the execution with the nonce of MetaID
+func approveSigData(id []byte, approve bool, nonce *big.Int) hexutil.Bytes {
+	_approve := []byte{0}
+	if approve {
+		_approve = []byte{1}
+	}
+	nonceBytes := intToByte32(nonce)
+	return concatBytes(id, _approve, nonceBytes[:])
+}
+
+func listPendingExecutions(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call listPendingExecutions Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDExecutionsParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+
+	b := getExecutionBook()
+	if b == nil {
+		resp.Error = makeErrorResponse(&internalError{"Execution store is not available"})
+		return
+	}
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	entries, err := b.list(reqParam.MetaID.Hex())
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	}
+	pending := []*executionResult{}
+	for _, entry := range entries {
+		if entry.Status != executionPending {
+			continue
+		}
+		result, err := readExecution(ctx, reqID, instance, hexutil.MustDecode(entry.ID))
+		if err != nil {
+			resp.Error = makeErrorResponse(&internalError{err.Error()})
+			return
+		}
+		if result != nil && result.Status == executionPending {
+			pending = append(pending, result)
+		}
+	}
+
+	resp.Result = pending
+	return
+}
+
+func getExecution(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call getExecution Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDExecutionParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[id] : %v", reqParam.Id)
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	result, err := readExecution(ctx, reqID, instance, reqParam.Id)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	} else if result == nil {
+		resp.Error = makeErrorResponse(&notExistsExecutionError{"Execution is not found"})
+		return
+	}
+
+	resp.Result = result
+	return
+}
+
+// approveBatchResult is TXs relayed in order, and the error of the approval stopped the batch
+type approveBatchResult struct {
+	TxHashes []string       `json:"tx_hashes"`
+	Error    *json.RPCError `json:"error,omitempty"`
+}
+
+// checkApprovals verifies approvals of the batch are signed by distinct keys which can approve,
+// with consecutive nonces of MetaID. Approvals must not exceed those the execution still needs,
+// since approvals after it is executed revert
+func checkApprovals(ctx context.Context, reqID uint64, instance *identity.Identity, execution *identity.Execution, reqParam metaIDApproveBatchParams) Error {
+	if len(reqParam.Approvals) == 0 || len(reqParam.Approvals) > maxApprovalBatch {
+		return &invalidParamsError{fmt.Sprintf("Approvals must be 1 to %d", maxApprovalBatch)}
+	}
+	approved, err := identity.CallGetApprovals(ctx, instance, new(big.Int).SetBytes(reqParam.Id))
+	if err != nil {
+		return &internalError{err.Error()}
+	}
+	if reqParam.Approve && execution.NeedsApprove != nil {
+		remaining := new(big.Int).Sub(execution.NeedsApprove, big.NewInt(int64(len(approved))))
+		if remaining.Cmp(big.NewInt(int64(len(reqParam.Approvals)))) < 0 {
+			return &invalidParamsError{fmt.Sprintf("Execution needs %v more approvals, %d are given", remaining, len(reqParam.Approvals))}
+		}
+	}
+	seen := make(map[common.Address]bool)
+	for _, a := range approved {
+		seen[a] = true
+	}
+	for i, a := range reqParam.Approvals {
+		if i > 0 && new(big.Int).Sub(a.Nonce, reqParam.Approvals[i-1].Nonce).Cmp(common.Big1) != 0 {
+			return &invalidParamsError{fmt.Sprintf("Nonce of approval %d must follow the previous one", i)}
+		}
+		if seen[a.From] {
+			return &invalidParamsError{fmt.Sprintf("Execution is approved by %s already", a.From.Hex())}
+		}
+		seen[a.From] = true
+		sigData := approveSigData(reqParam.Id, reqParam.Approve, a.Nonce)
+		if _, errObj := verifySignature(reqID, hexutil.Encode(ethCrypto.Keccak256(sigData)), a.Signature.String(), &a.From); errObj != nil {
+			return errObj
+		}
+		if err := identity.CheckDelegateApprovePermission(ctx, instance, a.From); err != nil {
+			return &invalidPermissionError{fmt.Sprintf("%s: %v", a.From.Hex(), err)}
+		}
+	}
+	return nil
+}
+
+func delegatedApproveBatch(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call delegatedApproveBatch Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDApproveBatchParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[id] : %v", reqParam.Id)
+	log.Debugfd(reqID, "parameter[approve] : %v", reqParam.Approve)
+	log.Debugfd(reqID, "parameter[approvals] : %d", len(reqParam.Approvals))
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	idBigInt := new(big.Int).SetBytes(reqParam.Id)
+	execution, err := identity.CallGetExecution(ctx, instance, idBigInt)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	} else if execution == nil {
+		resp.Error = makeErrorResponse(&notExistsExecutionError{"Execution is not pending"})
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Get Execution")
+
+	if errObj := checkApprovals(ctx, reqID, instance, execution, reqParam); errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 03. Check Approvals")
+
+	// The request reserves one TX against quotas, the rest of approvals are reserved here
+	if err := accounting.Reserve(reqID, len(reqParam.Approvals)-1); err != nil {
+		if quotaErr, ok := err.(*accounting.QuotaError); ok {
+			resp.Error = makeErrorResponse(quotaErr)
+		} else {
+			resp.Error = makeErrorResponse(&internalError{err.Error()})
+		}
+		return
+	}
+
+	// One key sends every approval, so they are mined in order of nonces
+	signer := crypto.GetInstance().Pick()
+	calls, errObj := checkApprovePolicy(ctx, reqID, signer, instance, idBigInt, reqParam.Approve, reqParam.Approvals)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 04. Check Policy")
+
+	// Approvals after the first revert until the first is mined, so only the first is simulated.
+	// The rest are sent with its gas, and the last adds gas of the execution it runs
+	executionGas, errObj := triggerGas(ctx, reqID, instance, execution, idBigInt, reqParam.Approve, len(reqParam.Approvals))
+	if errObj != nil {
+		releasePolicy(calls)
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	var gas uint64
+	result := &approveBatchResult{TxHashes: []string{}}
+	for i, a := range reqParam.Approvals {
+		var trx *types.Transaction
+		var err error
+		if i == 0 {
+			trx, err = identity.CallDelegatedApprove(ctx, signer, instance, a.From, idBigInt, reqParam.Approve, a.Nonce, a.Signature)
+		} else {
+			limit := gas
+			if i == len(reqParam.Approvals)-1 && executionGas > 0 {
+				limit += rpc.WithMargin(executionGas)
+			}
+			trx, err = identity.SendDelegatedApprove(ctx, signer, instance, idBigInt, reqParam.Approve, a.Nonce, a.Signature, limit)
+		}
+		if calls != nil {
+			relayedPolicy(calls[i], trx)
+		}
+		if err != nil {
+			// Approvals after the failed one are not sent
+			if calls != nil {
+				releasePolicy(calls[i+1:])
+			}
+			log.Errorfd(reqID, "CallDelegatedApprove Error of approval %d : %v", i, err)
+			if i == 0 {
+				resp.Error = makeErrorResponse(callError(err))
+				return
+			}
+			result.Error = makeErrorResponse(callError(err))
+			break
+		}
+		if i == 0 {
+			gas = trx.Gas()
+		}
+		log.Debugfd(reqID, "PASS - Call DelegatedApprove %d : %v", i, trx.Hash().String())
+		tracker.Track(reqID, req.Method, trx, "", reqParam.MetaID.Hex())
+		result.TxHashes = append(result.TxHashes, trx.Hash().String())
+	}
+
+	resp.Result = result
+	return
+}
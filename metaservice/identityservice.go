@@ -2,11 +2,13 @@ package metaservice
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	ethCrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/metadium/go-delegator/crypto"
 	"github.com/metadium/go-delegator/ipfs"
@@ -14,9 +16,27 @@ import (
 	"github.com/metadium/go-delegator/log"
 	"github.com/metadium/go-delegator/metaservice/sc/identity"
 	"github.com/metadium/go-delegator/metaservice/sc/identitymanager"
+	"github.com/metadium/go-delegator/tracker"
 )
 
-func createMetaID(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+// createMetaIDTopic is a topic of CreateMetaId event, MetaID is not indexed
+var createMetaIDTopic = ethCrypto.Keccak256Hash([]byte("CreateMetaId(address,address)"))
+
+func init() {
+	tracker.RegisterLogDecoder("create_meta_id", decodeCreateMetaID)
+}
+
+// decodeCreateMetaID sets MetaID created by the TX
+func decodeCreateMetaID(rec *tracker.Record, logs []*types.Log) {
+	for _, l := range logs {
+		if len(l.Topics) > 0 && l.Topics[0] == createMetaIDTopic && len(l.Data) >= 32 {
+			rec.MetaID = common.BytesToAddress(l.Data[:32]).Hex()
+			return
+		}
+	}
+}
+
+func createMetaID(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 
 	log.Debugd(reqID, " Call createMetaID Function")
 
@@ -48,21 +68,22 @@ func createMetaID(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, err
 	}
 
 	// 3. CallCreateMetaID
-	trx, err := identitymanager.CallCreateMetaID(reqParam.Address)
+	trx, err := identitymanager.CallCreateMetaID(ctx, nil, reqParam.Address)
 	if err != nil {
 		log.Errorfd(reqID, "CallCreateMetaID Error : %v", err)
-		errObj := &internalError{err.Error()}
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS - Call CreateMetaID : %v", trx.Hash().String())
+	tracker.Track(reqID, req.Method, trx, "", "")
 
 	//  return txid
 	resp.Result = trx.Hash().String()
 	return
 }
 
-func delegatedExecute(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func delegatedExecute(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 
 	log.Debugd(reqID, " Call delegatedExecute Function")
 
@@ -122,7 +143,7 @@ func delegatedExecute(reqID uint64, req json.RPCRequest) (resp json.RPCResponse,
 	log.Debugd(reqID, "PASS - 03. Verify Sign : ", signAddr.String())
 
 	//3. Check permission
-	err = identity.CheckDelegateExecutePermission(instance, reqParam.From, reqParam.To, reqParam.Data)
+	err = identity.CheckDelegateExecutePermission(ctx, instance, reqParam.From, reqParam.To, reqParam.Data)
 	if err != nil {
 		errObj := &invalidPermissionError{err.Error()}
 		resp.Error = makeErrorResponse(errObj)
@@ -130,22 +151,32 @@ func delegatedExecute(reqID uint64, req json.RPCRequest) (resp json.RPCResponse,
 	}
 	log.Debugd(reqID, "PASS - 04. Check Permission ")
 
-	// 4. CallDelegatedExecute
-	trx, err := identity.CallDelegatedExecute(instance, reqParam.From, reqParam.To, reqParam.Value, reqParam.Data, reqParam.Nonce, reqParam.Signature)
+	//4. Check policy
+	signer := crypto.GetInstance().Pick()
+	call, errObj := checkPolicy(ctx, reqID, signer, instance, reqParam.From, reqParam.To, reqParam.Value, reqParam.Data, reqParam.Nonce, reqParam.Signature)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	// 5. CallDelegatedExecute
+	trx, err := identity.CallDelegatedExecute(ctx, signer, instance, reqParam.From, reqParam.To, reqParam.Value, reqParam.Data, reqParam.Nonce, reqParam.Signature)
+	relayedPolicy(call, trx)
 	if err != nil {
 		log.Errorfd(reqID, "CallDelegatedExecute Error : %v", err)
-		errObj := &internalError{err.Error()}
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
-	log.Debugfd(reqID, "PASS 05. Call DelegatedExecute : %v", trx.Hash().String())
+	log.Debugfd(reqID, "PASS 06. Call DelegatedExecute : %v", trx.Hash().String())
+	tracker.Track(reqID, req.Method, trx, "", reqParam.MetaID.Hex())
 
 	//  return txid
 	resp.Result = trx.Hash().String()
 	return
 }
 
-func delegatedApprove(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func delegatedApprove(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	log.Debugd(reqID, " Call delegatedApprove Function")
 
 	resp.ID = req.ID
@@ -210,7 +241,7 @@ func delegatedApprove(reqID uint64, req json.RPCRequest) (resp json.RPCResponse,
 	log.Debugd(reqID, "PASS - 03. Verify Sign : ", signAddr.String())
 
 	//3. Check permission
-	err = identity.CheckDelegateApprovePermission(instance, reqParam.From)
+	err = identity.CheckDelegateApprovePermission(ctx, instance, reqParam.From)
 	if err != nil {
 		errObj := &invalidPermissionError{err.Error()}
 		resp.Error = makeErrorResponse(errObj)
@@ -218,15 +249,28 @@ func delegatedApprove(reqID uint64, req json.RPCRequest) (resp json.RPCResponse,
 	}
 	log.Debugd(reqID, "PASS - 04. Check Permission ")
 
-	// 4. CallDelegatedApprove
-	trx, err := identity.CallDelegatedApprove(instance, reqParam.From, idBigInt, reqParam.Approve, reqParam.Nonce, reqParam.Signature)
+	//4. Check policy, the approval may trigger the call
+	signer := crypto.GetInstance().Pick()
+	approval := metaIDApprovalParams{From: reqParam.From, Nonce: reqParam.Nonce, Signature: reqParam.Signature}
+	calls, errObj := checkApprovePolicy(ctx, reqID, signer, instance, idBigInt, reqParam.Approve, []metaIDApprovalParams{approval})
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	// 5. CallDelegatedApprove
+	trx, err := identity.CallDelegatedApprove(ctx, signer, instance, reqParam.From, idBigInt, reqParam.Approve, reqParam.Nonce, reqParam.Signature)
+	for _, call := range calls {
+		relayedPolicy(call, trx)
+	}
 	if err != nil {
 		log.Errorfd(reqID, "CallDelegatedApprove Error : %v", err)
-		errObj := &internalError{err.Error()}
+		errObj := callError(err)
 		resp.Error = makeErrorResponse(errObj)
 		return
 	}
 	log.Debugfd(reqID, "PASS 05. Call DelegatedApprove : %v", trx.Hash().String())
+	tracker.Track(reqID, req.Method, trx, "", reqParam.MetaID.Hex())
 
 	//return txid
 	resp.Result = trx.Hash().String()
@@ -234,7 +278,7 @@ func delegatedApprove(reqID uint64, req json.RPCRequest) (resp json.RPCResponse,
 	return
 }
 
-func backupUserData(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func backupUserData(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 
 	log.Debugd(reqID, "Call backupUserData Function")
 	//var reqParam interface{}
@@ -327,7 +371,7 @@ func backupUserData(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, e
 	return
 }
 
-func getUserData(reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+func getUserData(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
 	//requestTmpID := proxyCommon.RandomUint64()
 	log.Debugd(reqID, "Call getUserData Function")
 	resp.ID = req.ID
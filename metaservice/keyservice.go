@@ -0,0 +1,333 @@
+package metaservice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethCrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaservice/sc/identity"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+// keyPurposes are purposes a MetaID key can have
+var keyPurposes = []*big.Int{
+	identity.ManagementKey,
+	identity.ActionKey,
+	identity.ClaimSignerKey,
+	identity.EncryptionKey,
+	identity.AssistKey,
+	identity.DelegateKey,
+	identity.RestoreKey,
+	identity.CustomKey,
+}
+
+func init() {
+	for _, method := range []string{"delegated_execute", "delegated_approve", "delegated_approve_batch",
+		"delegated_add_key", "delegated_remove_key", "delegated_change_threshold",
+		"delegated_add_claim", "delegated_remove_claim", "delegated_refresh_claim"} {
+		tracker.RegisterLogDecoder(method, decodeExecutionID)
+	}
+}
+
+// decodeExecutionID sets the ID of the execution requested or approved by the TX,
+// and whether it is executed by the TX
+func decodeExecutionID(rec *tracker.Record, logs []*types.Log) {
+	metaID := common.HexToAddress(rec.MetaID)
+	for _, l := range logs {
+		if len(l.Topics) < 2 || !bytes.Equal(l.Address.Bytes(), metaID.Bytes()) {
+			continue
+		}
+		switch l.Topics[0] {
+		case identity.ExecutionRequestedTopic, identity.ApprovedTopic:
+			rec.ExecutionID = l.Topics[1].Hex()
+			if rec.ExecutionStatus == "" {
+				rec.ExecutionStatus = executionPending
+			}
+		case identity.ExecutedTopic:
+			rec.ExecutionID = l.Topics[1].Hex()
+			rec.ExecutionStatus = executionExecuted
+		case identity.ExecutionFailedTopic:
+			rec.ExecutionID = l.Topics[1].Hex()
+			rec.ExecutionStatus = executionFailed
+		}
+	}
+}
+
+// checkKeyPurpose returns an error if the purpose is unknown
+func checkKeyPurpose(purpose *big.Int) Error {
+	for _, p := range keyPurposes {
+		if p.Cmp(purpose) == 0 {
+			return nil
+		}
+	}
+	return &invalidParamsError{fmt.Sprintf("Unknown key purpose %v", purpose)}
+}
+
+// checkKeyType returns an error if the type is unknown, or the key doesn't fit the type.
+// An ECDSA key is the address of the key, left padded with zeros
+func checkKeyType(key hexutil.Bytes, keyType *big.Int) Error {
+	switch {
+	case identity.ECDSAType.Cmp(keyType) == 0:
+		if !bytes.Equal(key[:12], make([]byte, 12)) {
+			return &invalidParamsError{"ECDSA key must be an address left padded with zeros"}
+		}
+		return nil
+	case identity.RSAType.Cmp(keyType) == 0:
+		return nil
+	}
+	return &invalidParamsError{fmt.Sprintf("Unknown key type %v", keyType)}
+}
+
+// keyHasPurpose returns true if the key of MetaID has the purpose
+func keyHasPurpose(ctx context.Context, instance *identity.Identity, key [32]byte, purpose *big.Int) (bool, error) {
+	k, err := identity.CallGetKey(ctx, instance, key)
+	if err != nil || k == nil {
+		return false, err
+	}
+	for _, p := range k.Purposes {
+		if p.Cmp(purpose) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getIdentity returns MetaID instance of the address
+func getIdentity(metaID common.Address) (*identity.Identity, Error) {
+	instance, err := identity.GetInstance(metaID)
+	if err != nil {
+		return nil, &notExistsAddressError{err.Error()}
+	}
+	if instance == nil {
+		return nil, &notExistsAddressError{"Cannot get MetaID Instance"}
+	}
+	return instance, nil
+}
+
+// executeSelf verifies the signature of the self call data by the key of from,
+// checks the key is a management key, and sends delegatedExecute of MetaID to itself
+func executeSelf(ctx context.Context, reqID uint64, req json.RPCRequest, instance *identity.Identity, from common.Address, data hexutil.Bytes, nonce *big.Int, signature hexutil.Bytes) (*types.Transaction, Error) {
+	return execute(ctx, reqID, req, instance, from, *instance.Address, data, nonce, signature)
+}
+
+// execute verifies the signature of the call data to the address by the key of from,
+// checks the permission of the key, and sends delegatedExecute of MetaID
+func execute(ctx context.Context, reqID uint64, req json.RPCRequest, instance *identity.Identity, from common.Address, to common.Address, data hexutil.Bytes, nonce *big.Int, signature hexutil.Bytes) (*types.Transaction, Error) {
+	metaID := *instance.Address
+	log.Debugfd(reqID, "call data to %x : %v", to, data)
+
+	valueBytes := intToByte32(zero)
+	nonceBytes := intToByte32(nonce)
+	executeSigData := concatBytes(to.Bytes(), valueBytes[:], data, nonceBytes[:])
+	signAddr, errObj := verifySignature(reqID, hexutil.Encode(ethCrypto.Keccak256(executeSigData)), signature.String(), &from)
+	if errObj != nil {
+		return nil, errObj
+	}
+	log.Debugd(reqID, "PASS - Verify Sign : ", signAddr.String())
+
+	if err := identity.CheckDelegateExecutePermission(ctx, instance, from, to, data); err != nil {
+		return nil, &invalidPermissionError{err.Error()}
+	}
+	log.Debugd(reqID, "PASS - Check Permission")
+
+	signer := crypto.GetInstance().Pick()
+	call, errObj := checkPolicy(ctx, reqID, signer, instance, from, to, zero, data, nonce, signature)
+	if errObj != nil {
+		return nil, errObj
+	}
+
+	trx, err := identity.CallDelegatedExecute(ctx, signer, instance, from, to, zero, data, nonce, signature)
+	relayedPolicy(call, trx)
+	if err != nil {
+		log.Errorfd(reqID, "CallDelegatedExecute Error : %v", err)
+		return nil, callError(err)
+	}
+	log.Debugfd(reqID, "PASS - Call DelegatedExecute : %v", trx.Hash().String())
+	tracker.Track(reqID, req.Method, trx, "", metaID.Hex())
+	return trx, nil
+}
+
+func delegatedAddKey(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call delegatedAddKey Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDAddKeyParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[from] : %x", reqParam.From)
+	log.Debugfd(reqID, "parameter[key] : %v", reqParam.Key)
+	log.Debugfd(reqID, "parameter[purpose] : %v", reqParam.Purpose)
+	log.Debugfd(reqID, "parameter[key_type] : %v", reqParam.KeyType)
+	log.Debugfd(reqID, "parameter[nonce] : %v", reqParam.Nonce)
+	log.Debugfd(reqID, "parameter[Signature] : %v", reqParam.Signature)
+
+	if errObj := checkKeyPurpose(reqParam.Purpose); errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	if errObj := checkKeyType(reqParam.Key, reqParam.KeyType); errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	var key [32]byte
+	copy(key[:], reqParam.Key)
+	exists, err := keyHasPurpose(ctx, instance, key, reqParam.Purpose)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	} else if exists {
+		resp.Error = makeErrorResponse(&invalidParamsError{"Key has the purpose already"})
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Get Identity")
+
+	data, err := identity.PackAddKey(key, reqParam.Purpose, reqParam.KeyType)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	}
+	trx, errObj := executeSelf(ctx, reqID, req, instance, reqParam.From, data, reqParam.Nonce, reqParam.Signature)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+	return
+}
+
+func delegatedRemoveKey(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call delegatedRemoveKey Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDRemoveKeyParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[from] : %x", reqParam.From)
+	log.Debugfd(reqID, "parameter[key] : %v", reqParam.Key)
+	log.Debugfd(reqID, "parameter[purpose] : %v", reqParam.Purpose)
+	log.Debugfd(reqID, "parameter[nonce] : %v", reqParam.Nonce)
+	log.Debugfd(reqID, "parameter[Signature] : %v", reqParam.Signature)
+
+	if errObj := checkKeyPurpose(reqParam.Purpose); errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	var key [32]byte
+	copy(key[:], reqParam.Key)
+	exists, err := keyHasPurpose(ctx, instance, key, reqParam.Purpose)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	} else if !exists {
+		resp.Error = makeErrorResponse(&invalidParamsError{"Key doesn't have the purpose"})
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Get Identity")
+
+	data, err := identity.PackRemoveKey(key, reqParam.Purpose)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	}
+	trx, errObj := executeSelf(ctx, reqID, req, instance, reqParam.From, data, reqParam.Nonce, reqParam.Signature)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+	return
+}
+
+func delegatedChangeThreshold(ctx context.Context, reqID uint64, req json.RPCRequest) (resp json.RPCResponse, errRet error) {
+	log.Debugd(reqID, " Call delegatedChangeThreshold Function")
+
+	resp.ID = req.ID
+	resp.Jsonrpc = req.Jsonrpc
+	tmpParams, errObj := getParameter(req.Method, req.Params)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	reqParam := tmpParams.(metaIDThresholdParams)
+	log.Debugfd(reqID, "parameter[MetaID] : %x", reqParam.MetaID)
+	log.Debugfd(reqID, "parameter[from] : %x", reqParam.From)
+	log.Debugfd(reqID, "parameter[purpose] : %v", reqParam.Purpose)
+	log.Debugfd(reqID, "parameter[threshold] : %v", reqParam.Threshold)
+	log.Debugfd(reqID, "parameter[nonce] : %v", reqParam.Nonce)
+	log.Debugfd(reqID, "parameter[Signature] : %v", reqParam.Signature)
+
+	if reqParam.Threshold.Sign() <= 0 {
+		resp.Error = makeErrorResponse(&invalidParamsError{"Threshold must be positive"})
+		return
+	}
+	data, err := identity.PackChangeThreshold(reqParam.Purpose, reqParam.Threshold)
+	if err != nil {
+		resp.Error = makeErrorResponse(&invalidParamsError{err.Error()})
+		return
+	}
+	log.Debugd(reqID, "PASS - 01. Check Parameter")
+
+	instance, errObj := getIdentity(reqParam.MetaID)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	// A threshold above the number of keys would lock the purpose
+	keys, err := identity.CallGetKeysByPurpose(ctx, instance, reqParam.Purpose)
+	if err != nil {
+		resp.Error = makeErrorResponse(&internalError{err.Error()})
+		return
+	}
+	if reqParam.Threshold.Cmp(big.NewInt(int64(len(keys)))) > 0 {
+		errObj := &invalidParamsError{fmt.Sprintf("Threshold is more than %d keys of the purpose", len(keys))}
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+	log.Debugd(reqID, "PASS - 02. Get Identity")
+
+	trx, errObj := executeSelf(ctx, reqID, req, instance, reqParam.From, data, reqParam.Nonce, reqParam.Signature)
+	if errObj != nil {
+		resp.Error = makeErrorResponse(errObj)
+		return
+	}
+
+	//  return txid
+	resp.Result = trx.Hash().String()
+	return
+}
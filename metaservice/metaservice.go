@@ -2,10 +2,12 @@
 package metaservice
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"runtime/debug"
 
+	"github.com/metadium/go-delegator/accounting"
 	proxyCommon "github.com/metadium/go-delegator/common"
 	"github.com/metadium/go-delegator/json"
 	"github.com/metadium/go-delegator/log"
@@ -16,17 +18,23 @@ var (
 )
 
 func makeErrorResponse(err Error) *json.RPCError {
-	return &json.RPCError{
+	rpcErr := &json.RPCError{
 		Code:    err.ErrorCode(),
 		Message: err.Error(),
 	}
+	if dataErr, ok := err.(DataError); ok {
+		rpcErr.Data = dataErr.ErrorData()
+	}
+	return rpcErr
 }
 
 // Forward delivers RPCRequest to predefined function and returns that
-func Forward(req json.RPCRequest) (resp json.RPCResponse, err error) {
+func Forward(ctx context.Context, req json.RPCRequest) (resp json.RPCResponse, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Errorf("Internal Error(Panic) : %s", debug.Stack())
+			resp.ID = req.ID
+			resp.Jsonrpc = req.Jsonrpc
 			resp.Error = &json.RPCError{
 				Code:    -32603,
 				Message: "Internal Error",
@@ -36,7 +44,21 @@ func Forward(req json.RPCRequest) (resp json.RPCResponse, err error) {
 	for k, v := range predefinedPaths {
 		if k == req.Method {
 			requestID := proxyCommon.RandomUint64()
-			return v.(func(uint64, json.RPCRequest) (json.RPCResponse, error))(requestID, req)
+			defer accounting.End(requestID)
+			if errObj := meter(requestID, req); errObj != nil {
+				resp.ID = req.ID
+				resp.Jsonrpc = req.Jsonrpc
+				resp.Error = makeErrorResponse(errObj)
+				return resp, nil
+			}
+			// MetaID methods are not queued as jobs, unlike metaresolver methods
+			if asyncRequest(req) {
+				resp.ID = req.ID
+				resp.Jsonrpc = req.Jsonrpc
+				resp.Error = makeErrorResponse(&invalidParamsError{fmt.Sprintf("%s can't be requested async", req.Method)})
+				return resp, nil
+			}
+			return v.(func(context.Context, uint64, json.RPCRequest) (json.RPCResponse, error))(ctx, requestID, req)
 		}
 	}
 	err = fmt.Errorf("predefined NOT FOUND")
@@ -44,6 +66,19 @@ func Forward(req json.RPCRequest) (resp json.RPCResponse, err error) {
 	return resp, err
 }
 
+// asyncRequest returns true if the request asks to be queued as a job
+func asyncRequest(req json.RPCRequest) bool {
+	if len(req.Params) != 1 {
+		return false
+	}
+	obj, ok := req.Params[0].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	async, _ := obj["async"].(bool)
+	return async
+}
+
 // Contains check if given path is in predefined or not
 func Contains(path string) bool {
 	for k := range predefinedPaths {
@@ -58,6 +93,17 @@ var predefinedPaths = map[string]interface{}{
 	"create_meta_id":                          createMetaID,
 	"delegated_execute":                       delegatedExecute,
 	"delegated_approve":                       delegatedApprove,
+	"delegated_add_key":                       delegatedAddKey,
+	"delegated_remove_key":                    delegatedRemoveKey,
+	"delegated_change_threshold":              delegatedChangeThreshold,
+	"delegated_add_claim":                     delegatedAddClaim,
+	"delegated_remove_claim":                  delegatedRemoveClaim,
+	"delegated_refresh_claim":                 delegatedRefreshClaim,
+	"get_claims":                              getClaims,
+	"get_claim":                               getClaim,
+	"list_pending_executions":                 listPendingExecutions,
+	"get_execution":                           getExecution,
+	"delegated_approve_batch":                 delegatedApproveBatch,
 	"backup_user_data":                        backupUserData,
 	"get_user_data":                           getUserData,
 	"get_registry_address":                    getRegistryAddress,
@@ -2,8 +2,10 @@ package metaservice
 
 import (
 	"bufio"
+	"context"
 	"crypto/ecdsa"
 	"fmt"
+	"math/big"
 	"os"
 	"strings"
 	"testing"
@@ -14,10 +16,13 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/metadium/go-delegator/crypto"
 	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
 )
 
 func defaultSetting() {
@@ -51,7 +56,7 @@ func TestGetRegistryAddress(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -70,7 +75,7 @@ func TestGetIdentityManagerAddress(t *testing.T) {
 	//req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -89,7 +94,7 @@ func TestGetTopicRegistryAddress(t *testing.T) {
 	// req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -108,7 +113,7 @@ func TestGetAttestationAgencyRegistryAddress(t *testing.T) {
 	// req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -128,7 +133,7 @@ func TestGetAchievementManagerAddress(t *testing.T) {
 	req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -148,7 +153,7 @@ func TestGetAchievementAddress(t *testing.T) {
 	// req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -167,7 +172,7 @@ func TestGetAllSystemAddress(t *testing.T) {
 	// req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -191,7 +196,7 @@ func TestCreateMetaId(t *testing.T) {
 	req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
 
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -213,7 +218,7 @@ func TestAddActionKey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	nonce, err := identity.CallGetTransactionCount(ins)
+	nonce, err := identity.CallGetTransactionCount(context.Background(), ins)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -262,7 +267,7 @@ func TestAddActionKey(t *testing.T) {
 
 	req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -287,7 +292,7 @@ func TestAddSelfClaim(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	nonce, err := identity.CallGetTransactionCount(ins)
+	nonce, err := identity.CallGetTransactionCount(context.Background(), ins)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -358,7 +363,7 @@ func TestAddSelfClaim(t *testing.T) {
 
 	req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -383,7 +388,7 @@ func TestDelegatedApprove(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	nonce, err := identity.CallGetTransactionCount(ins)
+	nonce, err := identity.CallGetTransactionCount(context.Background(), ins)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -477,7 +482,7 @@ func TestDelegatedApprove(t *testing.T) {
 
 	req.Params = append(req.Params, param)
 	fmt.Println("request : ", req.String())
-	resp, err := Forward(req)
+	resp, err := Forward(context.Background(), req)
 	if resp.String() == "" || err != nil {
 		fmt.Println("Error : ", err)
 		t.Errorf("Failed to start main")
@@ -486,6 +491,155 @@ func TestDelegatedApprove(t *testing.T) {
 
 }
 
+func TestKeyParams(t *testing.T) {
+	ecdsaKey := identity.CallAddrToKey(common.HexToAddress("0xd3Cb37aE6a81EbF1b5C3D9422636b0dB48767B72"))
+	rsaKey := ethCrypto.Keccak256([]byte("rsa public key"))
+	if err := checkKeyPurpose(identity.ActionKey); err != nil {
+		t.Error(err)
+	}
+	if err := checkKeyPurpose(big.NewInt(9)); err == nil {
+		t.Error("Expected unknown purpose")
+	}
+	if err := checkKeyType(ecdsaKey[:], identity.ECDSAType); err != nil {
+		t.Error(err)
+	}
+	if err := checkKeyType(rsaKey, identity.ECDSAType); err == nil {
+		t.Error("Expected ECDSA key not fit")
+	}
+	if err := checkKeyType(rsaKey, identity.RSAType); err != nil {
+		t.Error(err)
+	}
+	if err := checkKeyType(rsaKey, big.NewInt(3)); err == nil {
+		t.Error("Expected unknown key type")
+	}
+
+	identityAbi, err := abi.JSON(strings.NewReader(identity.IdentityABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := identityAbi.Pack("changeActionThreshold", common.Big2)
+	if data, err := identity.PackChangeThreshold(identity.ActionKey, common.Big2); err != nil || hexutil.Encode(data) != hexutil.Encode(expected) {
+		t.Errorf("Unexpected data %v, %v", data, err)
+	}
+	if _, err := identity.PackChangeThreshold(identity.ClaimSignerKey, common.Big2); err == nil {
+		t.Error("Expected no threshold of claim signer keys")
+	}
+}
+
+func TestClaimParams(t *testing.T) {
+	metaID := common.HexToAddress("0xe052cb04e4fe4d3ca69d247b4eff2aff35613b0e")
+	issuer := common.HexToAddress("0x961c20596e7EC441723FBb168461f4B51371D8aA")
+	sig := hexutil.Encode(make([]byte, 65))
+	param := map[string]interface{}{
+		"meta_id":   metaID,
+		"claim_id":  common.BigToHash(common.Big1),
+		"from":      issuer,
+		"nonce":     common.Big0,
+		"signature": sig,
+	}
+	p, errObj := getParameter("delegated_remove_claim", []interface{}{param})
+	if errObj != nil || p.(metaIDClaimParams).IssuerMetaID != nil {
+		t.Fatalf("Unexpected params %+v, %v", p, errObj)
+	}
+	param["issuer_meta_id"] = issuer
+	if p, errObj := getParameter("delegated_refresh_claim", []interface{}{param}); errObj != nil || *p.(metaIDClaimParams).IssuerMetaID != issuer {
+		t.Errorf("Unexpected params %+v, %v", p, errObj)
+	}
+
+	add := map[string]interface{}{
+		"meta_id":   metaID,
+		"from":      issuer,
+		"topic":     common.Big1,
+		"scheme":    common.Big1,
+		"issuer":    issuer,
+		"data":      "0x1b44",
+		"uri":       "MetaPrint",
+		"nonce":     common.Big0,
+		"signature": sig,
+	}
+	if _, errObj := getParameter("delegated_add_claim", []interface{}{add}); errObj == nil || errObj.ErrorCode() != -32602 {
+		t.Errorf("Expected claim signature required, got %v", errObj)
+	}
+	add["claim_signature"] = sig
+	if _, errObj := getParameter("delegated_add_claim", []interface{}{add}); errObj != nil {
+		t.Error(errObj)
+	}
+}
+
+func TestDecodeExecutionID(t *testing.T) {
+	metaID := common.HexToAddress("0xe052cb04e4fe4d3ca69d247b4eff2aff35613b0e")
+	id := common.BigToHash(big.NewInt(42))
+	logs := []*types.Log{
+		// Executed of other contract
+		{Address: common.HexToAddress("0x01"), Topics: []common.Hash{identity.ExecutedTopic, common.BigToHash(common.Big1)}},
+		{Address: metaID, Topics: []common.Hash{identity.ExecutionRequestedTopic, id, metaID.Hash(), common.Hash{}}},
+		{Address: metaID, Topics: []common.Hash{identity.ExecutedTopic, id, metaID.Hash(), common.Hash{}}},
+	}
+	rec := &tracker.Record{MetaID: metaID.Hex()}
+	decodeExecutionID(rec, logs)
+	if rec.ExecutionID != id.Hex() || rec.ExecutionStatus != executionExecuted {
+		t.Errorf("Expected execution %s executed, got %s %s", id.Hex(), rec.ExecutionID, rec.ExecutionStatus)
+	}
+
+	// Approved without execution is pending
+	rec = &tracker.Record{MetaID: metaID.Hex()}
+	decodeExecutionID(rec, []*types.Log{{Address: metaID, Topics: []common.Hash{identity.ApprovedTopic, id}}})
+	if rec.ExecutionID != id.Hex() || rec.ExecutionStatus != executionPending {
+		t.Errorf("Expected execution %s pending, got %s %s", id.Hex(), rec.ExecutionID, rec.ExecutionStatus)
+	}
+}
+
+func TestExecutionBook(t *testing.T) {
+	b := &executionBook{store: store.NewMemoryStore()}
+	metaID := "0xe052cb04e4fe4d3ca69d247b4eff2aff35613b0e"
+	id := common.BigToHash(big.NewInt(42)).Hex()
+	if err := b.record(metaID, id, executionPending, "0x01"); err != nil {
+		t.Fatal(err)
+	}
+	b.record(metaID, id, executionPending, "0x02")
+	b.record(metaID, id, executionPending, "0x02")
+	b.record("0x0000000000000000000000000000000000000001", id, executionPending, "0x03")
+
+	entries, err := b.list(common.HexToAddress(metaID).Hex())
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected an execution of MetaID, got %v, %v", entries, err)
+	}
+	if e := entries[0]; e.Status != executionPending || strings.Join(e.Txs, ",") != "0x01,0x02" {
+		t.Errorf("Unexpected execution %+v", e)
+	}
+
+	// A finished execution is not pending again
+	b.record(metaID, id, executionExecuted, "0x04")
+	b.record(metaID, id, executionPending, "")
+	if e, _ := b.get(metaID, id); e.Status != executionExecuted || len(e.Txs) != 3 {
+		t.Errorf("Unexpected execution %+v", e)
+	}
+}
+
+func TestApproveBatchParams(t *testing.T) {
+	param := map[string]interface{}{
+		"meta_id": common.HexToAddress("0xe052cb04e4fe4d3ca69d247b4eff2aff35613b0e"),
+		"id":      common.BigToHash(big.NewInt(42)),
+		"approve": true,
+		"approvals": []map[string]interface{}{
+			{"from": common.HexToAddress("0x01"), "nonce": common.Big1, "signature": hexutil.Encode(make([]byte, 65))},
+			{"from": common.HexToAddress("0x02"), "signature": hexutil.Encode(make([]byte, 65))},
+		},
+	}
+	if _, errObj := getParameter("delegated_approve_batch", []interface{}{param}); errObj == nil {
+		t.Error("Expected nonce of approval required")
+	}
+	param["approvals"].([]map[string]interface{})[1]["nonce"] = common.Big2
+	p, errObj := getParameter("delegated_approve_batch", []interface{}{param})
+	if errObj != nil || len(p.(metaIDApproveBatchParams).Approvals) != 2 {
+		t.Errorf("Unexpected params %+v, %v", p, errObj)
+	}
+	sigData := approveSigData(common.BigToHash(big.NewInt(42)).Bytes(), true, common.Big1)
+	if len(sigData) != 65 || sigData[32] != 1 {
+		t.Errorf("Unexpected data signed %v", sigData)
+	}
+}
+
 func signBytes(bmsg []byte, privKey *ecdsa.PrivateKey) ([]byte, error) {
 	bMsg := ethCrypto.Keccak256(bmsg)
 	return ethCrypto.Sign(signHash(bMsg), privKey)
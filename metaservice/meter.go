@@ -0,0 +1,32 @@
+package metaservice
+
+import (
+	"github.com/metadium/go-delegator/accounting"
+	"github.com/metadium/go-delegator/json"
+)
+
+func init() {
+	accounting.RegisterMethods(
+		"create_meta_id",
+		"delegated_execute",
+		"delegated_approve",
+		"delegated_add_key",
+		"delegated_remove_key",
+		"delegated_change_threshold",
+		"delegated_add_claim",
+		"delegated_remove_claim",
+		"delegated_refresh_claim",
+		"delegated_approve_batch",
+	)
+}
+
+// meter labels the request for accounting, and returns the error if a quota of the request is exceeded
+func meter(reqID uint64, req json.RPCRequest) Error {
+	err := accounting.Begin(reqID, req)
+	if quotaErr, ok := err.(*accounting.QuotaError); ok {
+		return quotaErr
+	} else if err != nil {
+		return &internalError{err.Error()}
+	}
+	return nil
+}
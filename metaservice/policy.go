@@ -0,0 +1,146 @@
+package metaservice
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaservice/sc/identity"
+	"github.com/metadium/go-delegator/policy"
+)
+
+// checkPolicy estimates gas of the delegated execution sent by the signer, checks it against the policy
+// and reserves its daily gas. It returns nil if no policy is set
+func checkPolicy(ctx context.Context, reqID uint64, signer *crypto.Account, instance *identity.Identity, from, to common.Address, value *big.Int, data hexutil.Bytes, nonce *big.Int, signature hexutil.Bytes) (*policy.Call, Error) {
+	p := policy.GetInstance()
+	if p == nil {
+		return nil, nil
+	}
+	gas, err := identity.EstimateDelegatedExecute(ctx, signer, instance, to, value, data, nonce, signature)
+	if err != nil {
+		log.Errorfd(reqID, "EstimateDelegatedExecute Error : %v", err)
+		return nil, callError(err)
+	}
+	call := &policy.Call{MetaID: *instance.Address, Signer: from, To: to, Value: value, Data: data, Gas: gas, Execution: pendingCall(ctx, instance)}
+	if err := p.Check(call); err != nil {
+		return nil, policyError(err)
+	}
+	if err := p.Reserve(call); err != nil {
+		return nil, policyError(err)
+	}
+	log.Debugfd(reqID, "PASS - Check Policy : gas %d", gas)
+	return call, nil
+}
+
+// checkApprovePolicy estimates gas of the approvals of the execution sent by the signer in order,
+// checks the execution against the policy and reserves daily gas of each approval.
+// Approvals after the first can't be estimated before it is mined, so they are counted as the first,
+// and the last one adds gas of the execution if it triggers the call. It returns nil if no policy is set
+func checkApprovePolicy(ctx context.Context, reqID uint64, signer *crypto.Account, instance *identity.Identity, id *big.Int, approve bool, approvals []metaIDApprovalParams) ([]*policy.Call, Error) {
+	p := policy.GetInstance()
+	if p == nil || len(approvals) == 0 {
+		return nil, nil
+	}
+	execution, err := identity.CallGetExecution(ctx, instance, id)
+	if err != nil {
+		return nil, &internalError{err.Error()}
+	} else if execution == nil {
+		return nil, &notExistsExecutionError{"Execution is not pending"}
+	}
+	gas, err := identity.EstimateDelegatedApprove(ctx, signer, instance, id, approve, approvals[0].Nonce, approvals[0].Signature)
+	if err != nil {
+		log.Errorfd(reqID, "EstimateDelegatedApprove Error : %v", err)
+		return nil, callError(err)
+	}
+	executionGas, errObj := triggerGas(ctx, reqID, instance, execution, id, approve, len(approvals))
+	if errObj != nil {
+		return nil, errObj
+	}
+
+	var calls []*policy.Call
+	for i, a := range approvals {
+		// A disapproval calls nothing, only its gas is checked
+		call := &policy.Call{MetaID: *instance.Address, Signer: a.From, To: *instance.Address, Gas: gas, Execution: pendingCall(ctx, instance)}
+		if approve {
+			call.To, call.Value, call.Data = execution.To, execution.Value, execution.Data
+		}
+		if i == len(approvals)-1 {
+			call.Gas += executionGas
+		}
+		if err := p.Check(call); err != nil {
+			releasePolicy(calls)
+			return nil, policyError(err)
+		}
+		if err := p.Reserve(call); err != nil {
+			releasePolicy(calls)
+			return nil, policyError(err)
+		}
+		calls = append(calls, call)
+	}
+	log.Debugfd(reqID, "PASS - Check Policy : gas %d of %d approvals", gas, len(approvals))
+	return calls, nil
+}
+
+// triggerGas estimates gas of the execution run by the last approval of the batch, if the batch completes
+// approvals it needs. It is 0 for a single approval, which is estimated with the call
+func triggerGas(ctx context.Context, reqID uint64, instance *identity.Identity, execution *identity.Execution, id *big.Int, approve bool, approvals int) (uint64, Error) {
+	if !approve || approvals < 2 || execution.NeedsApprove == nil {
+		return 0, nil
+	}
+	approved, err := identity.CallGetApprovals(ctx, instance, id)
+	if err != nil {
+		return 0, &internalError{err.Error()}
+	}
+	if new(big.Int).Sub(execution.NeedsApprove, big.NewInt(int64(len(approved)))).Cmp(big.NewInt(int64(approvals))) != 0 {
+		return 0, nil
+	}
+	gas, err := identity.EstimateExecution(ctx, instance, execution)
+	if err != nil {
+		log.Errorfd(reqID, "EstimateExecution Error : %v", err)
+		return 0, callError(err)
+	}
+	return gas, nil
+}
+
+// pendingCall returns a function reading an execution pending of the MetaID as a call,
+// to check approvals called by the MetaID itself
+func pendingCall(ctx context.Context, instance *identity.Identity) func(id *big.Int) (*policy.Call, error) {
+	return func(id *big.Int) (*policy.Call, error) {
+		execution, err := identity.CallGetExecution(ctx, instance, id)
+		if err != nil || execution == nil {
+			return nil, err
+		}
+		return &policy.Call{MetaID: *instance.Address, To: execution.To, Value: execution.Value, Data: execution.Data}, nil
+	}
+}
+
+// releasePolicy releases gas of the calls checked, of which TXs are not sent
+func releasePolicy(calls []*policy.Call) {
+	for _, call := range calls {
+		relayedPolicy(call, nil)
+	}
+}
+
+// policyError returns a violation as it is, other errors are internal
+func policyError(err error) Error {
+	if v, ok := err.(*policy.Violation); ok {
+		return v
+	}
+	return &internalError{err.Error()}
+}
+
+// relayedPolicy settles gas of the call checked with the TX sent, or releases it if the TX is not sent
+func relayedPolicy(call *policy.Call, trx *types.Transaction) {
+	if call == nil {
+		return
+	}
+	if trx == nil {
+		policy.GetInstance().Release(call)
+		return
+	}
+	policy.GetInstance().Track(trx.Hash().Hex(), call)
+}
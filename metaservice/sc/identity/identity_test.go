@@ -2,11 +2,14 @@ package identity
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/metadium/go-delegator/crypto"
 )
 
@@ -40,7 +43,7 @@ func TestCallGetTransactionCount(t *testing.T) {
 		t.Error("Error get Identity", err)
 	}
 
-	nonce, err := CallGetTransactionCount(identity)
+	nonce, err := CallGetTransactionCount(context.Background(), identity)
 
 	if err != nil {
 		t.Error("Error CallCreateMetaID", err)
@@ -67,7 +70,7 @@ func TestCallGetKey(t *testing.T) {
 		t.Error("Key empty")
 	}
 	fmt.Printf("mgt Key  : %x \n", keyBytes)
-	metaKey, err := CallGetKey(instance, keyBytes)
+	metaKey, err := CallGetKey(context.Background(), instance, keyBytes)
 	if err != nil {
 		t.Error("Error getKey", err)
 	}
@@ -84,7 +87,7 @@ func TestCallGetKeysByPurpose(t *testing.T) {
 	if err != nil {
 		t.Error("Error getInstance", err)
 	}
-	keys, err := CallGetKeysByPurpose(instance, common.Big2)
+	keys, err := CallGetKeysByPurpose(context.Background(), instance, common.Big2)
 	if err != nil {
 		t.Error("Error getKey", err)
 	}
@@ -113,7 +116,7 @@ func TestCallDelegatedExecute(t *testing.T) {
 	sig := hexutil.MustDecode("0x00")
 
 
-	trx, err := CallDelegatedExecute(identity, mgtAddress, scAddress, value, data, executeID, sig)
+	trx, err := CallDelegatedExecute(context.Background(), nil, identity, mgtAddress, scAddress, value, data, executeID, sig)
 	if err != nil {
 		t.Error("Error CallCreateMetaID", err)
 	}
@@ -125,3 +128,16 @@ func TestCallDelegatedExecute(t *testing.T) {
 	fmt.Printf("trxid : %v", trx.Hash().String())
 }
 */
+
+func TestCallKeyHasPurposeNoCode(t *testing.T) {
+	// An issuer which is not a MetaID has no key
+	eoa := common.HexToAddress("0x961c20596e7ec441723fbb168461f4b51371d8aa")
+	instance, err := NewIdentity(eoa, backends.NewSimulatedBackend(core.GenesisAlloc{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := CallKeyHasPurpose(context.Background(), instance, CallAddrToKey(eoa), ClaimSignerKey)
+	if ok || err != nil {
+		t.Errorf("CallKeyHasPurpose of account = %v, %v, want false", ok, err)
+	}
+}
@@ -2,12 +2,15 @@ package identity
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/big"
+	"strings"
 	"sync"
 
 	"github.com/metadium/go-delegator/log"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -26,6 +29,81 @@ var (
 	emptyKeyVal      = new([32]byte)
 )
 
+// Purposes of MetaID keys
+var (
+	ManagementKey  = big.NewInt(1)
+	ActionKey      = big.NewInt(2)
+	ClaimSignerKey = big.NewInt(3)
+	EncryptionKey  = big.NewInt(4)
+	AssistKey      = big.NewInt(5)
+	DelegateKey    = big.NewInt(6)
+	RestoreKey     = big.NewInt(7)
+	CustomKey      = big.NewInt(8)
+)
+
+// Types of MetaID keys
+var (
+	ECDSAType = big.NewInt(1)
+	RSAType   = big.NewInt(2)
+)
+
+// Topics of events carrying the ID of an execution
+var (
+	ExecutionRequestedTopic = parsedABI.Events["ExecutionRequested"].Id()
+	ExecutedTopic           = parsedABI.Events["Executed"].Id()
+	ExecutionFailedTopic    = parsedABI.Events["ExecutionFailed"].Id()
+	ApprovedTopic           = parsedABI.Events["Approved"].Id()
+)
+
+// Approvals of an execution are read up to this
+const maxApprovals = 64
+
+// parsedABI packs self calls of MetaID
+var parsedABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(IdentityABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+//PackAddKey returns the data of addKey to be executed by MetaID itself
+func PackAddKey(key [32]byte, purpose *big.Int, keyType *big.Int) (hexutil.Bytes, error) {
+	return parsedABI.Pack("addKey", key, purpose, keyType)
+}
+
+//PackRemoveKey returns the data of removeKey to be executed by MetaID itself
+func PackRemoveKey(key [32]byte, purpose *big.Int) (hexutil.Bytes, error) {
+	return parsedABI.Pack("removeKey", key, purpose)
+}
+
+//PackChangeThreshold returns the data of changeManagementThreshold or changeActionThreshold
+//by the purpose, to be executed by MetaID itself
+func PackChangeThreshold(purpose *big.Int, threshold *big.Int) (hexutil.Bytes, error) {
+	switch {
+	case ManagementKey.Cmp(purpose) == 0:
+		return parsedABI.Pack("changeManagementThreshold", threshold)
+	case ActionKey.Cmp(purpose) == 0:
+		return parsedABI.Pack("changeActionThreshold", threshold)
+	}
+	return nil, fmt.Errorf("No threshold for purpose %v", purpose)
+}
+
+//PackAddClaim returns the data of addClaim to be executed by MetaID itself
+func PackAddClaim(topic *big.Int, scheme *big.Int, issuer common.Address, signature []byte, data []byte, uri string) (hexutil.Bytes, error) {
+	return parsedABI.Pack("addClaim", topic, scheme, issuer, signature, data, uri)
+}
+
+//PackRemoveClaim returns the data of removeClaim to be executed by MetaID or the issuer
+func PackRemoveClaim(claimID [32]byte) (hexutil.Bytes, error) {
+	return parsedABI.Pack("removeClaim", claimID)
+}
+
+//PackRefreshClaim returns the data of refreshClaim to be executed by MetaID or the issuer
+func PackRefreshClaim(claimID [32]byte) (hexutil.Bytes, error) {
+	return parsedABI.Pack("refreshClaim", claimID)
+}
+
 //GetInstance get MetaID Instance
 func GetInstance(address common.Address) (*Identity, error) {
 	_rpc := rpc.GetInstance()
@@ -45,7 +123,7 @@ func GetInstance(address common.Address) (*Identity, error) {
 }
 
 //CallDelegatedExecute DelegatedExecute function call
-func CallDelegatedExecute(instance *Identity, mgtAddress common.Address, to common.Address, value *big.Int, data hexutil.Bytes, metaNonce *big.Int, signature hexutil.Bytes) (*types.Transaction, error) {
+func CallDelegatedExecute(ctx context.Context, signer *crypto.Account, instance *Identity, mgtAddress common.Address, to common.Address, value *big.Int, data hexutil.Bytes, metaNonce *big.Int, signature hexutil.Bytes) (*types.Transaction, error) {
 	var trx *types.Transaction
 	var err error
 
@@ -61,9 +139,12 @@ func CallDelegatedExecute(instance *Identity, mgtAddress common.Address, to comm
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
 		auth.GasLimit = glimit
@@ -74,8 +155,7 @@ func CallDelegatedExecute(instance *Identity, mgtAddress common.Address, to comm
 		}
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
+	res := signer.ApplyNonce(tx)
 
 	if !res {
 		if err == nil {
@@ -87,11 +167,48 @@ func CallDelegatedExecute(instance *Identity, mgtAddress common.Address, to comm
 	return trx, nil
 }
 
-//CallDelegatedApprove DelegatedApprove function call
-func CallDelegatedApprove(instance *Identity, mgtAddress common.Address, id *big.Int, approve bool, metaNonce *big.Int, signature hexutil.Bytes) (*types.Transaction, error) {
-	var trx *types.Transaction
-	var err error
+//EstimateDelegatedExecute estimates gas of the DelegatedExecute TX sent by the signer
+//It returns rpc.RevertError if the TX would revert
+func EstimateDelegatedExecute(ctx context.Context, signer *crypto.Account, instance *Identity, to common.Address, value *big.Int, data hexutil.Bytes, metaNonce *big.Int, signature hexutil.Bytes) (uint64, error) {
+	if instance == nil {
+		return 0, fmt.Errorf("Error - Identity nil")
+	}
+	if value == nil {
+		value = zero
+	}
+	input, err := parsedABI.Pack("delegatedExecute", to, value, []byte(data), metaNonce, []byte(signature))
+	if err != nil {
+		return 0, err
+	}
+	return rpc.GetInstance().EstimateGasContext(ctx, rpc.CallArgs{From: common.HexToAddress(signer.GetAddress()), To: instance.Address, Data: input})
+}
 
+//EstimateDelegatedApprove estimate gas of DelegatedApprove sent by the signer, with the execution if it is the last approval
+func EstimateDelegatedApprove(ctx context.Context, signer *crypto.Account, instance *Identity, id *big.Int, approve bool, metaNonce *big.Int, signature hexutil.Bytes) (uint64, error) {
+	if instance == nil {
+		return 0, fmt.Errorf("Error - Identity nil")
+	}
+	input, err := parsedABI.Pack("delegatedApprove", id, approve, metaNonce, []byte(signature))
+	if err != nil {
+		return 0, err
+	}
+	return rpc.GetInstance().EstimateGasContext(ctx, rpc.CallArgs{From: common.HexToAddress(signer.GetAddress()), To: instance.Address, Data: input})
+}
+
+//EstimateExecution estimate gas of the execution called by MetaID
+func EstimateExecution(ctx context.Context, instance *Identity, execution *Execution) (uint64, error) {
+	if instance == nil {
+		return 0, fmt.Errorf("Error - Identity nil")
+	}
+	args := rpc.CallArgs{From: *instance.Address, To: &execution.To, Data: execution.Data}
+	if execution.Value != nil {
+		args.Value = (*hexutil.Big)(execution.Value)
+	}
+	return rpc.GetInstance().EstimateGasContext(ctx, args)
+}
+
+//CallDelegatedApprove DelegatedApprove function call
+func CallDelegatedApprove(ctx context.Context, signer *crypto.Account, instance *Identity, mgtAddress common.Address, id *big.Int, approve bool, metaNonce *big.Int, signature hexutil.Bytes) (*types.Transaction, error) {
 	// instance, err := getInstance(mgtAddress)
 	// //session, err := getSession()
 
@@ -100,16 +217,39 @@ func CallDelegatedApprove(instance *Identity, mgtAddress common.Address, id *big
 	// 	return nil, err
 	// }
 	if instance == nil {
-		err = fmt.Errorf("Error - Identity nil")
-		return nil, err
+		return nil, fmt.Errorf("Error - Identity nil")
 	}
 
+	return sendDelegatedApprove(ctx, signer, instance, id, approve, metaNonce, signature, 0)
+}
+
+//SendDelegatedApprove sends DelegatedApprove with the gas limit, without simulation.
+//It is for an approval which reverts until approvals sent before it are mined
+func SendDelegatedApprove(ctx context.Context, signer *crypto.Account, instance *Identity, id *big.Int, approve bool, metaNonce *big.Int, signature hexutil.Bytes, gas uint64) (*types.Transaction, error) {
+	if instance == nil {
+		return nil, fmt.Errorf("Error - Identity nil")
+	}
+	return sendDelegatedApprove(ctx, signer, instance, id, approve, metaNonce, signature, gas)
+}
+
+// sendDelegatedApprove simulates the approval for its gas if gas is 0
+func sendDelegatedApprove(ctx context.Context, signer *crypto.Account, instance *Identity, id *big.Int, approve bool, metaNonce *big.Int, signature hexutil.Bytes, gas uint64) (*types.Transaction, error) {
+	var trx *types.Transaction
+	var err error
+
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := signer.TransactionOptsContext(ctx)
+		auth.GasLimit = gas
+		if gas == 0 {
+			auth = _rpc.Simulated(auth)
+			auth.GasLimit = glimit
+		}
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
-		auth.GasLimit = glimit
 		log.Debug("gas price : ", auth.GasPrice)
 		trx, err = instance.DelegatedApprove(auth, id, approve, metaNonce, signature)
 
@@ -118,8 +258,7 @@ func CallDelegatedApprove(instance *Identity, mgtAddress common.Address, id *big
 		}
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
+	res := signer.ApplyNonce(tx)
 
 	if !res {
 		if err == nil {
@@ -132,14 +271,14 @@ func CallDelegatedApprove(instance *Identity, mgtAddress common.Address, id *big
 }
 
 //CallGetTransactionCount  get Transaction count for MetaID
-func CallGetTransactionCount(instance *Identity) (*big.Int, error) {
+func CallGetTransactionCount(ctx context.Context, instance *Identity) (*big.Int, error) {
 	var err error
 	if instance == nil {
 		err = fmt.Errorf("Error - Identity nil")
 		return nil, err
 	}
 
-	result, err := instance.GetTransactionCount(&bind.CallOpts{})
+	result, err := instance.GetTransactionCount(&bind.CallOpts{Context: ctx})
 	if err != nil {
 		if err.Error() == "abi: unmarshalling empty output" {
 			return common.Big0, nil
@@ -154,7 +293,7 @@ func CallGetTransactionCount(instance *Identity) (*big.Int, error) {
 }
 
 //CheckDelegateExecutePermission Check permission for DelegateExecute
-func CheckDelegateExecutePermission(instance *Identity, from common.Address, to common.Address, data hexutil.Bytes) error {
+func CheckDelegateExecutePermission(ctx context.Context, instance *Identity, from common.Address, to common.Address, data hexutil.Bytes) error {
 	var err error
 
 	//1. convert addr to key
@@ -167,7 +306,7 @@ func CheckDelegateExecutePermission(instance *Identity, from common.Address, to
 	// 	return fmt.Errorf("fail to check Permission")
 	// }
 	//2. get Key
-	key, err := CallGetKey(instance, keyBytes)
+	key, err := CallGetKey(ctx, instance, keyBytes)
 	if err != nil {
 		return err
 	}
@@ -176,7 +315,7 @@ func CheckDelegateExecutePermission(instance *Identity, from common.Address, to
 	}
 	//3. check purpose
 	if bytes.Equal(instance.Address.Bytes(), to.Bytes()) { //For Management or Recovery
-		funcHash, err := CallGetFunctionSignature(instance, data)
+		funcHash, err := CallGetFunctionSignature(ctx, instance, data)
 		if err != nil {
 			return err
 		}
@@ -206,14 +345,14 @@ func CheckDelegateExecutePermission(instance *Identity, from common.Address, to
 }
 
 //CheckDelegateApprovePermission Check permission for DelegateApprove
-func CheckDelegateApprovePermission(instance *Identity, from common.Address) error {
+func CheckDelegateApprovePermission(ctx context.Context, instance *Identity, from common.Address) error {
 	var err error
 
 	//1. convert addr to key
 	keyBytes := CallAddrToKey(from)
 
 	//2. get Key
-	key, err := CallGetKey(instance, keyBytes)
+	key, err := CallGetKey(ctx, instance, keyBytes)
 	if err != nil {
 		return err
 	}
@@ -237,14 +376,14 @@ func CheckDelegateApprovePermission(instance *Identity, from common.Address) err
 }
 
 //CallGetFunctionSignature Get FunctionSignature
-func CallGetFunctionSignature(instance *Identity, data hexutil.Bytes) (*[4]byte, error) {
+func CallGetFunctionSignature(ctx context.Context, instance *Identity, data hexutil.Bytes) (*[4]byte, error) {
 	var err error
 	if instance == nil {
 		err = fmt.Errorf("Error - Identity nil")
 		return nil, err
 	}
 
-	result, err := instance.GetFunctionSignature(&bind.CallOpts{}, data)
+	result, err := instance.GetFunctionSignature(&bind.CallOpts{Context: ctx}, data)
 	if err != nil {
 		//데이터가 없을때 나는 에러 처리
 		if err.Error() == "abi: unmarshalling empty output" {
@@ -258,13 +397,13 @@ func CallGetFunctionSignature(instance *Identity, data hexutil.Bytes) (*[4]byte,
 }
 
 //CallGetKey get Key Info by key
-func CallGetKey(instance *Identity, key [32]byte) (*metaIDKey, error) {
+func CallGetKey(ctx context.Context, instance *Identity, key [32]byte) (*metaIDKey, error) {
 	var err error
 	if instance == nil {
 		err = fmt.Errorf("Error - Identity nil")
 		return nil, err
 	}
-	result, err := instance.GetKey(&bind.CallOpts{}, key)
+	result, err := instance.GetKey(&bind.CallOpts{Context: ctx}, key)
 	if err != nil {
 		//데이터가 없을때 나는 에러 처리
 		if err.Error() == "abi: unmarshalling empty output" {
@@ -278,13 +417,13 @@ func CallGetKey(instance *Identity, key [32]byte) (*metaIDKey, error) {
 }
 
 //CallGetKeysByPurpose  get Key Info list by purpose
-func CallGetKeysByPurpose(instance *Identity, purpose *big.Int) ([][32]byte, error) {
+func CallGetKeysByPurpose(ctx context.Context, instance *Identity, purpose *big.Int) ([][32]byte, error) {
 	var err error
 	if instance == nil {
 		err = fmt.Errorf("Error - Identity nil")
 		return nil, err
 	}
-	result, err := instance.GetKeysByPurpose(&bind.CallOpts{}, purpose)
+	result, err := instance.GetKeysByPurpose(&bind.CallOpts{Context: ctx}, purpose)
 	if err != nil {
 		//데이터가 없을때 나는 에러 처리
 		if err.Error() == "abi: unmarshalling empty output" {
@@ -303,3 +442,140 @@ func CallAddrToKey(address common.Address) [32]byte {
 	copy(result[12:], address.Bytes())
 	return result
 }
+
+//Claim is an ERC-735 claim of MetaID
+type Claim struct {
+	Topic     *big.Int
+	Scheme    *big.Int
+	Issuer    common.Address
+	Signature []byte
+	Data      []byte
+	URI       string
+}
+
+//CallGetClaim get Claim by claim ID, nil if not exists
+func CallGetClaim(ctx context.Context, instance *Identity, claimID [32]byte) (*Claim, error) {
+	if instance == nil {
+		return nil, fmt.Errorf("Error - Identity nil")
+	}
+	result, err := instance.GetClaim(&bind.CallOpts{Context: ctx}, claimID)
+	if err != nil {
+		if err.Error() == "abi: unmarshalling empty output" {
+			return nil, nil
+		}
+		log.Error(err)
+		return nil, err
+	}
+	if result.Issuer == (common.Address{}) {
+		return nil, nil
+	}
+	return &Claim{result.Topic, result.Scheme, result.Issuer, result.Signature, result.Data, result.Uri}, nil
+}
+
+//CallGetClaimIdsByType get claim IDs of the topic
+func CallGetClaimIdsByType(ctx context.Context, instance *Identity, topic *big.Int) ([][32]byte, error) {
+	if instance == nil {
+		return nil, fmt.Errorf("Error - Identity nil")
+	}
+	result, err := instance.GetClaimIdsByType(&bind.CallOpts{Context: ctx}, topic)
+	if err != nil {
+		if err.Error() == "abi: unmarshalling empty output" {
+			return nil, nil
+		}
+		log.Error(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+//CallClaimToSign get the hash an issuer signs for the claim of the subject
+func CallClaimToSign(ctx context.Context, instance *Identity, subject common.Address, topic *big.Int, data []byte) ([32]byte, error) {
+	if instance == nil {
+		return [32]byte{}, fmt.Errorf("Error - Identity nil")
+	}
+	return instance.ClaimToSign(&bind.CallOpts{Context: ctx}, subject, topic, data)
+}
+
+//CallGetSignatureAddress get the address signed the hash, zero address if a malformed signature is not recovered
+func CallGetSignatureAddress(ctx context.Context, instance *Identity, toSign [32]byte, signature []byte) (common.Address, error) {
+	if instance == nil {
+		return common.Address{}, fmt.Errorf("Error - Identity nil")
+	}
+	result, err := instance.GetSignatureAddress(&bind.CallOpts{Context: ctx}, toSign, signature)
+	if err != nil {
+		if err.Error() == "abi: unmarshalling empty output" {
+			return common.Address{}, nil
+		}
+		log.Error(err)
+		return common.Address{}, err
+	}
+	return result, nil
+}
+
+//CallKeyHasPurpose check the key of MetaID has the purpose, false if the address is not a MetaID
+func CallKeyHasPurpose(ctx context.Context, instance *Identity, key [32]byte, purpose *big.Int) (bool, error) {
+	if instance == nil {
+		return false, fmt.Errorf("Error - Identity nil")
+	}
+	result, err := instance.KeyHasPurpose(&bind.CallOpts{Context: ctx}, key, purpose)
+	if err != nil {
+		// An account without code returns ErrNoCode, and a contract without the function returns nothing
+		if err == bind.ErrNoCode || err.Error() == "abi: unmarshalling empty output" {
+			return false, nil
+		}
+		log.Error(err)
+		return false, err
+	}
+	return result, nil
+}
+
+//Execution is a call requested by MetaID waiting for approvals
+type Execution struct {
+	To           common.Address
+	Value        *big.Int
+	Data         []byte
+	NeedsApprove *big.Int
+}
+
+//CallGetExecution get Execution by execution ID, nil if not exists
+func CallGetExecution(ctx context.Context, instance *Identity, id *big.Int) (*Execution, error) {
+	if instance == nil {
+		return nil, fmt.Errorf("Error - Identity nil")
+	}
+	result, err := instance.Execution(&bind.CallOpts{Context: ctx}, id)
+	if err != nil {
+		if err.Error() == "abi: unmarshalling empty output" {
+			return nil, nil
+		}
+		log.Error(err)
+		return nil, err
+	}
+	if result.To == (common.Address{}) {
+		return nil, nil
+	}
+	return &Execution{result.To, result.Value, result.Data, result.NeedsApprove}, nil
+}
+
+//CallGetApprovals get addresses approved the execution, in order
+func CallGetApprovals(ctx context.Context, instance *Identity, id *big.Int) ([]common.Address, error) {
+	if instance == nil {
+		return nil, fmt.Errorf("Error - Identity nil")
+	}
+	approvals := []common.Address{}
+	for i := 0; i < maxApprovals; i++ {
+		addr, err := instance.Approved(&bind.CallOpts{Context: ctx}, id, big.NewInt(int64(i)))
+		if err != nil {
+			// Reading past the approvals reverts
+			if err.Error() == "abi: unmarshalling empty output" || len(approvals) > 0 {
+				break
+			}
+			log.Error(err)
+			return nil, err
+		}
+		if addr == (common.Address{}) {
+			break
+		}
+		approvals = append(approvals, addr)
+	}
+	return approvals, nil
+}
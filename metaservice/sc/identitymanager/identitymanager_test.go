@@ -2,6 +2,7 @@ package identitymanager
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"testing"
@@ -34,7 +35,7 @@ func TestCallCreateMetaID(t *testing.T) {
 	defaultSetting()
 
 	address := common.HexToAddress("0x961c20596e7ec441723fbb168461f4b51371d8aa") //e052cb04e4fe4d3ca69d247b4eff2aff35613b0e
-	trx, err := CallCreateMetaID(address)
+	trx, err := CallCreateMetaID(context.Background(), nil, address)
 	if err != nil {
 		t.Error("Error CallCreateMetaID", err)
 	}
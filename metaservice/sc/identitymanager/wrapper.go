@@ -1,6 +1,7 @@
 package identitymanager
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"sync"
@@ -48,7 +49,7 @@ func getService() (*Identitymanager, error) {
 }
 
 //CallCreateMetaID createMetaID function call
-func CallCreateMetaID(mgtAddress common.Address) (*types.Transaction, error) {
+func CallCreateMetaID(ctx context.Context, signer *crypto.Account, mgtAddress common.Address) (*types.Transaction, error) {
 	var trx *types.Transaction
 	var err error
 
@@ -60,11 +61,15 @@ func CallCreateMetaID(mgtAddress common.Address) (*types.Transaction, error) {
 		return nil, err
 	}
 
+	if signer == nil {
+		signer = crypto.GetInstance().Pick()
+	}
 	tx := func(nonce uint64) error {
 		_rpc := rpc.GetInstance()
-		auth := crypto.GetTransactionOpts()
+		auth := _rpc.Simulated(signer.TransactionOptsContext(ctx))
 		auth.Nonce = big.NewInt(int64(nonce))
 		auth.GasPrice = big.NewInt(int64(_rpc.GetGasPrice()))
+		auth.GasLimit = glimit
 
 		trx, err = service.CreateMetaId(auth, mgtAddress)
 		if err != nil {
@@ -73,8 +78,7 @@ func CallCreateMetaID(mgtAddress common.Address) (*types.Transaction, error) {
 		}
 		return nil
 	}
-	c := crypto.GetInstance()
-	res := c.ApplyNonce(tx)
+	res := signer.ApplyNonce(tx)
 
 	if !res {
 		if err == nil {
@@ -50,6 +50,92 @@ type metaIDApproveParams struct {
 	Signature hexutil.Bytes `json:"signature" validate:"len=65"`
 }
 
+type metaIDAddKeyParams struct {
+	MetaID    common.Address `json:"meta_id" validate:"len=20"`
+	From      common.Address `json:"from" validate:"len=20"`
+	Key       hexutil.Bytes  `json:"key" validate:"len=32"`
+	Purpose   *big.Int       `json:"purpose" validate:"nonzero"`
+	KeyType   *big.Int       `json:"key_type" validate:"nonzero"`
+	Nonce     *big.Int       `json:"nonce" validate:"nonzero"`
+	Signature hexutil.Bytes  `json:"signature" validate:"len=65"` // Sign(MetaID, 0, addKey data, nonce)
+}
+
+type metaIDRemoveKeyParams struct {
+	MetaID    common.Address `json:"meta_id" validate:"len=20"`
+	From      common.Address `json:"from" validate:"len=20"`
+	Key       hexutil.Bytes  `json:"key" validate:"len=32"`
+	Purpose   *big.Int       `json:"purpose" validate:"nonzero"`
+	Nonce     *big.Int       `json:"nonce" validate:"nonzero"`
+	Signature hexutil.Bytes  `json:"signature" validate:"len=65"` // Sign(MetaID, 0, removeKey data, nonce)
+}
+
+type metaIDThresholdParams struct {
+	MetaID common.Address `json:"meta_id" validate:"len=20"`
+	From   common.Address `json:"from" validate:"len=20"`
+	// Purpose is management or action key, of which threshold is changed
+	Purpose   *big.Int      `json:"purpose" validate:"nonzero"`
+	Threshold *big.Int      `json:"threshold" validate:"nonzero"`
+	Nonce     *big.Int      `json:"nonce" validate:"nonzero"`
+	Signature hexutil.Bytes `json:"signature" validate:"len=65"` // Sign(MetaID, 0, change threshold data, nonce)
+}
+
+type metaIDAddClaimParams struct {
+	MetaID common.Address `json:"meta_id" validate:"len=20"`
+	From   common.Address `json:"from" validate:"len=20"`
+	Topic  *big.Int       `json:"topic" validate:"nonzero"`
+	Scheme *big.Int       `json:"scheme" validate:"nonzero"`
+	Issuer common.Address `json:"issuer" validate:"len=20"`
+	// ClaimSignature is signed by the issuer or its claim signer key, Sign(claimToSign(MetaID, topic, data))
+	ClaimSignature hexutil.Bytes `json:"claim_signature" validate:"len=65"`
+	Data           hexutil.Bytes `json:"data"`
+	URI            string        `json:"uri"`
+	Nonce          *big.Int      `json:"nonce" validate:"nonzero"`
+	Signature      hexutil.Bytes `json:"signature" validate:"len=65"` // Sign(MetaID, 0, addClaim data, nonce)
+}
+
+type metaIDClaimParams struct {
+	MetaID  common.Address `json:"meta_id" validate:"len=20"`
+	ClaimID hexutil.Bytes  `json:"claim_id" validate:"len=32"`
+	// IssuerMetaID executes the call instead of MetaID if given, it must be the issuer of the claim
+	IssuerMetaID *common.Address `json:"issuer_meta_id"`
+	From         common.Address  `json:"from" validate:"len=20"`
+	Nonce        *big.Int        `json:"nonce" validate:"nonzero"`
+	Signature    hexutil.Bytes   `json:"signature" validate:"len=65"` // Sign(MetaID, 0, removeClaim or refreshClaim data, nonce)
+}
+
+type metaIDGetClaimsParams struct {
+	MetaID common.Address `json:"meta_id" validate:"len=20"`
+	Topic  *big.Int       `json:"topic" validate:"nonzero"`
+}
+
+type metaIDGetClaimParams struct {
+	MetaID  common.Address `json:"meta_id" validate:"len=20"`
+	ClaimID hexutil.Bytes  `json:"claim_id" validate:"len=32"`
+}
+
+type metaIDExecutionsParams struct {
+	MetaID common.Address `json:"meta_id" validate:"len=20"`
+}
+
+type metaIDExecutionParams struct {
+	MetaID common.Address `json:"meta_id" validate:"len=20"`
+	Id     hexutil.Bytes  `json:"id" validate:"len=32"`
+}
+
+type metaIDApprovalParams struct {
+	From      common.Address `json:"from" validate:"len=20"`
+	Nonce     *big.Int       `json:"nonce" validate:"nonzero"`
+	Signature hexutil.Bytes  `json:"signature" validate:"len=65"` // Sign(id, approve, nonce)
+}
+
+type metaIDApproveBatchParams struct {
+	MetaID  common.Address `json:"meta_id" validate:"len=20"`
+	Id      hexutil.Bytes  `json:"id" validate:"len=32"`
+	Approve bool           `json:"approve"`
+	// Approvals are relayed in order, with consecutive nonces of MetaID
+	Approvals []metaIDApprovalParams `json:"approvals"`
+}
+
 type metaIDBackupParams struct {
 	Address   common.Address `json:"address" validate:"len=20"`
 	MetaID    hexutil.Bytes  `json:"meta_id" validate:"len=20"`
@@ -122,6 +208,86 @@ func getParameter(method string, params []interface{}) (interface{}, Error) {
 		}
 		return reqParam, nil
 
+	case "delegated_add_key":
+		var reqParam metaIDAddKeyParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "delegated_remove_key":
+		var reqParam metaIDRemoveKeyParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "delegated_change_threshold":
+		var reqParam metaIDThresholdParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "delegated_add_claim":
+		var reqParam metaIDAddClaimParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "delegated_remove_claim", "delegated_refresh_claim":
+		var reqParam metaIDClaimParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "get_claims":
+		var reqParam metaIDGetClaimsParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "get_claim":
+		var reqParam metaIDGetClaimParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "list_pending_executions":
+		var reqParam metaIDExecutionsParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "get_execution":
+		var reqParam metaIDExecutionParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
+	case "delegated_approve_batch":
+		var reqParam metaIDApproveBatchParams
+		err := fillParam(&reqParam, obj)
+		if err != nil {
+			return nil, err
+		}
+		return reqParam, nil
+
 	case "backup_user_data":
 		var reqParam metaIDBackupParams
 		err := fillParam(&reqParam, obj)
@@ -0,0 +1,188 @@
+package policy
+
+import (
+	encodingJson "encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+// Budget store keeps
+//
+//	gas/<day>/meta_id/<address>    gas of calls of MetaID in the UTC day
+//	gas/<day>/signer/<address>     gas of calls signed by the key of MetaID
+//	tx/<hash>                      call relayed, settled with gas used when the TX is final
+//
+// Gas is reserved by the estimation when a call is relayed, and replaced by gas used when mined
+
+// Scopes of daily gas
+const (
+	scopeMetaID = "meta_id"
+	scopeSigner = "signer"
+)
+
+// errNoStore is returned for daily gas budgets without the store
+var errNoStore = errors.New("policy: store of daily gas budgets is not available")
+
+// relayed is a call relayed, kept to be settled
+type relayed struct {
+	MetaID string `json:"meta_id"`
+	Signer string `json:"signer"`
+	Gas    uint64 `json:"gas"`
+	Day    string `json:"day"`
+}
+
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func gasKey(day, scope string, address common.Address) string {
+	return "gas/" + day + "/" + scope + "/" + strings.ToLower(address.Hex())
+}
+
+func txKey(hash string) string {
+	return "tx/" + strings.ToLower(hash)
+}
+
+// Reserve adds estimated gas of the call to daily gas of its MetaID and signer,
+// and returns a Violation if a budget is exceeded
+func (e *Engine) Reserve(call *Call) error {
+	r := e.current()
+	if r == nil {
+		return ErrNotLoaded
+	}
+	if r.config.DailyGasPerMetaID == 0 && r.config.DailyGasPerSigner == 0 {
+		return nil
+	}
+	if e.store == nil {
+		return errNoStore
+	}
+	today := day(time.Now())
+	e.prune(today)
+
+	metaKey := gasKey(today, scopeMetaID, call.MetaID)
+	if err := e.add(metaKey, int64(call.Gas), r.config.DailyGasPerMetaID, "daily_gas_per_meta_id"); err != nil {
+		return err
+	}
+	if err := e.add(gasKey(today, scopeSigner, call.Signer), int64(call.Gas), r.config.DailyGasPerSigner, "daily_gas_per_signer"); err != nil {
+		e.add(metaKey, -int64(call.Gas), 0, "")
+		return err
+	}
+	call.day = today
+	return nil
+}
+
+// Release removes gas of the call reserved, when its TX is not sent
+func (e *Engine) Release(call *Call) {
+	if call.day == "" {
+		return
+	}
+	e.add(gasKey(call.day, scopeMetaID, call.MetaID), -int64(call.Gas), 0, "")
+	e.add(gasKey(call.day, scopeSigner, call.Signer), -int64(call.Gas), 0, "")
+	call.day = ""
+}
+
+// Track keeps the call reserved with the hash of its TX, so that gas used replaces the estimation
+func (e *Engine) Track(hash string, call *Call) {
+	if call.day == "" {
+		return
+	}
+	b, _ := encodingJson.Marshal(&relayed{MetaID: call.MetaID.Hex(), Signer: call.Signer.Hex(), Gas: call.Gas, Day: call.day})
+	if err := e.store.Put(txKey(hash), b); err != nil {
+		log.Warnf("Failed to keep policy gas of %s: %v", hash, err)
+	}
+}
+
+// Spent returns gas of the address in the scope, meta_id or signer, today
+func (e *Engine) Spent(scope string, address common.Address) (uint64, error) {
+	if e.store == nil {
+		return 0, errNoStore
+	}
+	b, err := e.store.Get(gasKey(day(time.Now()), scope, address))
+	if err == store.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// settle replaces the estimation of the TX final by gas used, a TX dropped uses none.
+// Gas of days passed is not changed
+func (e *Engine) settle(rec *tracker.Record) {
+	if e.store == nil {
+		return
+	}
+	b, err := e.store.Get(txKey(rec.Hash))
+	if err != nil {
+		return
+	}
+	defer e.store.Delete(txKey(rec.Hash))
+	r := new(relayed)
+	if err := encodingJson.Unmarshal(b, r); err != nil || r.Day != day(time.Now()) {
+		return
+	}
+	delta := int64(rec.GasUsed) - int64(r.Gas)
+	e.add(gasKey(r.Day, scopeMetaID, common.HexToAddress(r.MetaID)), delta, 0, "")
+	e.add(gasKey(r.Day, scopeSigner, common.HexToAddress(r.Signer)), delta, 0, "")
+}
+
+// add adds delta to daily gas of the key, and returns a Violation if more gas is over the limit
+func (e *Engine) add(key string, delta int64, limit uint64, rule string) error {
+	var spent uint64
+	err := e.store.Update(key, func(old []byte) ([]byte, error) {
+		var n int64
+		if old != nil {
+			n, _ = strconv.ParseInt(string(old), 10, 64)
+		}
+		n += delta
+		if n < 0 {
+			n = 0
+		}
+		if delta > 0 && limit > 0 && uint64(n) > limit {
+			spent = uint64(n - delta)
+			return nil, violation(CodeBudget, rule, "Daily gas budget %d is exceeded, %d is used today", limit, spent)
+		}
+		return []byte(strconv.FormatInt(n, 10)), nil
+	})
+	if _, ok := err.(*Violation); !ok && err != nil {
+		log.Warnf("Failed to update policy gas %s: %v", key, err)
+	}
+	return err
+}
+
+// prune removes gas of days before yesterday and calls not settled since, once a day
+func (e *Engine) prune(today string) {
+	e.mu.Lock()
+	if e.pruned == today {
+		e.mu.Unlock()
+		return
+	}
+	e.pruned = today
+	e.mu.Unlock()
+
+	yesterday := day(time.Now().Add(-24 * time.Hour))
+	var keys []string
+	e.store.Iterate("gas/", func(key string, value []byte) bool {
+		if d := strings.SplitN(strings.TrimPrefix(key, "gas/"), "/", 2)[0]; d < yesterday {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	e.store.Iterate("tx/", func(key string, value []byte) bool {
+		r := new(relayed)
+		if encodingJson.Unmarshal(value, r) != nil || r.Day < yesterday {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		e.store.Delete(key)
+	}
+}
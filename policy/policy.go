@@ -0,0 +1,441 @@
+// Package policy checks delegated executions against rules of the operator before they are relayed.
+// Rules are loaded from a JSON file, and reloaded when the file changes
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/metaservice/sc/identity"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+
+	encodingJson "encoding/json"
+)
+
+// For environment arguments
+const (
+	// PolicyFile is a path of the policy file, no policy is applied if empty
+	PolicyFile = "POLICY_FILE"
+	// PolicyStore is a location of store for daily gas budgets, refer to store.Open
+	PolicyStore = "POLICY_STORE"
+)
+
+// For policy store
+const (
+	// DefaultPolicyStorePath is a LevelDB path for daily gas budgets
+	DefaultPolicyStorePath = "policy"
+	// DefaultPolicyStoreTable is a DynamoDB table for daily gas budgets used in AWS lambda
+	DefaultPolicyStoreTable = "Policy"
+)
+
+// Codes of violations
+const (
+	// CodeTarget is for a target contract not allowed
+	CodeTarget int32 = -32627
+	// CodeSelector is for a function selector not allowed
+	CodeSelector int32 = -32628
+	// CodeValue is for a value over the cap
+	CodeValue int32 = -32629
+	// CodeGas is for estimated gas over the cap
+	CodeGas int32 = -32630
+	// CodeBudget is for a daily gas budget exceeded
+	CodeBudget int32 = -32631
+)
+
+// The policy file is checked for changes at this interval
+var reloadInterval = 10 * time.Second
+
+// Executions of MetaID to itself are checked by calls inside them up to this depth
+const maxCallDepth = 4
+
+// managementFunctions of MetaID are not checked by Targets and Selectors when MetaID calls itself
+var managementFunctions = map[string]bool{
+	"addKey":                    true,
+	"removeKey":                 true,
+	"addClaim":                  true,
+	"removeClaim":               true,
+	"refreshClaim":              true,
+	"changeManagementThreshold": true,
+	"changeActionThreshold":     true,
+}
+
+var identityABI abi.ABI
+
+// ErrNotLoaded is returned while no valid policy file is loaded, nothing is relayed
+var ErrNotLoaded = errors.New("policy: no valid policy is loaded")
+
+// List allows or denies items, an item must be in Allow if it is not empty, and must not be in Deny
+type List struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Config is rules of the policy file
+//
+// Calls of MetaID to itself managing keys, claims and thresholds are not checked by Targets and Selectors,
+// and executions and approvals of MetaID to itself are checked by the calls inside them
+type Config struct {
+	// Targets are addresses of contracts called
+	Targets List `json:"targets"`
+	// Selectors are 4-byte function selectors such as 0xa9059cbb
+	Selectors List `json:"selectors"`
+	// MaxValue is wei a call can send in decimal, no cap if empty
+	MaxValue string `json:"max_value,omitempty"`
+	// MaxGas is estimated gas of a TX relaying a call, no cap if 0
+	MaxGas uint64 `json:"max_gas,omitempty"`
+	// DailyGasPerMetaID and DailyGasPerSigner are gas relayed for a MetaID and a key of MetaID in a UTC day, no budget if 0
+	DailyGasPerMetaID uint64 `json:"daily_gas_per_meta_id,omitempty"`
+	DailyGasPerSigner uint64 `json:"daily_gas_per_signer,omitempty"`
+}
+
+// rules are a config parsed
+type rules struct {
+	config         Config
+	allowTargets   map[common.Address]bool
+	denyTargets    map[common.Address]bool
+	allowSelectors map[string]bool
+	denySelectors  map[string]bool
+	maxValue       *big.Int
+}
+
+// Parse returns the config of the policy file, failing for a malformed address, selector or value
+func Parse(b []byte) (*Config, error) {
+	r, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	return &r.config, nil
+}
+
+func parse(b []byte) (*rules, error) {
+	r := &rules{
+		allowTargets:   make(map[common.Address]bool),
+		denyTargets:    make(map[common.Address]bool),
+		allowSelectors: make(map[string]bool),
+		denySelectors:  make(map[string]bool),
+	}
+	if err := encodingJson.Unmarshal(b, &r.config); err != nil {
+		return nil, err
+	}
+	for _, l := range []struct {
+		items []string
+		set   map[common.Address]bool
+	}{{r.config.Targets.Allow, r.allowTargets}, {r.config.Targets.Deny, r.denyTargets}} {
+		for _, item := range l.items {
+			if !common.IsHexAddress(item) {
+				return nil, fmt.Errorf("policy: invalid target %q", item)
+			}
+			l.set[common.HexToAddress(item)] = true
+		}
+	}
+	for _, l := range []struct {
+		items []string
+		set   map[string]bool
+	}{{r.config.Selectors.Allow, r.allowSelectors}, {r.config.Selectors.Deny, r.denySelectors}} {
+		for _, item := range l.items {
+			if b, err := hexutil.Decode(item); err != nil || len(b) != 4 {
+				return nil, fmt.Errorf("policy: invalid selector %q", item)
+			}
+			l.set[strings.ToLower(item)] = true
+		}
+	}
+	if r.config.MaxValue != "" {
+		v, ok := new(big.Int).SetString(r.config.MaxValue, 10)
+		if !ok || v.Sign() < 0 {
+			return nil, fmt.Errorf("policy: invalid max_value %q", r.config.MaxValue)
+		}
+		r.maxValue = v
+	}
+	return r, nil
+}
+
+// Violation is a call against the policy
+type Violation struct {
+	code int32
+	// Rule is the name of the config violated
+	Rule    string
+	message string
+}
+
+func violation(code int32, rule, format string, args ...interface{}) *Violation {
+	return &Violation{code: code, Rule: rule, message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorCode returns the code of the violation
+func (v *Violation) ErrorCode() int32 { return v.code }
+
+func (v *Violation) Error() string { return v.message }
+
+// ErrorData returns the rule violated
+func (v *Violation) ErrorData() interface{} {
+	return map[string]string{"rule": v.Rule}
+}
+
+// Call is a delegated execution of MetaID to be relayed
+type Call struct {
+	MetaID common.Address
+	// Signer is the key of MetaID signed the call
+	Signer common.Address
+	To     common.Address
+	Value  *big.Int
+	Data   []byte
+	// Gas is estimated gas of the TX relaying the call
+	Gas uint64
+	// Execution returns the execution of MetaID pending by ID, nil if not pending,
+	// to check an approval called by MetaID itself. Such approvals are rejected if it is nil
+	Execution func(id *big.Int) (*Call, error)
+
+	// day is the UTC day gas is reserved at, empty if not reserved
+	day string
+}
+
+// Engine applies the policy file
+type Engine struct {
+	path  string
+	store store.Store
+
+	// mu guards fields below
+	mu        sync.Mutex
+	rules     *rules
+	modTime   time.Time
+	checkedAt time.Time
+	loadedAt  time.Time
+	loadErr   error
+	pruned    string
+}
+
+var (
+	// For singleton
+	instance *Engine
+	once     sync.Once
+)
+
+func init() {
+	var err error
+	if identityABI, err = abi.JSON(strings.NewReader(identity.IdentityABI)); err != nil {
+		panic(err)
+	}
+	tracker.OnFinal(func(rec *tracker.Record) {
+		if e := GetInstance(); e != nil {
+			e.settle(rec)
+		}
+	})
+}
+
+// GetInstance returns the engine of the file given by POLICY_FILE with the store given by POLICY_STORE,
+// or DynamoDB in AWS lambda and LevelDB otherwise
+// It returns nil if no policy file is given
+func GetInstance() *Engine {
+	once.Do(func() {
+		path := os.Getenv(PolicyFile)
+		if path == "" {
+			return
+		}
+		location := os.Getenv(PolicyStore)
+		if location == "" {
+			if os.Getenv(crypto.IsAwsLambda) != "" {
+				location = store.DynamoDBScheme + DefaultPolicyStoreTable
+			} else {
+				location = DefaultPolicyStorePath
+			}
+		}
+		s, err := store.Open(location)
+		if err != nil {
+			log.Error("Failed to open policy store, calls with daily gas budgets are not relayed: ", err)
+		} else {
+			log.Info("Policy store is set to ", location)
+		}
+		instance = NewEngine(path, s)
+	})
+	return instance
+}
+
+// NewEngine returns the engine of the policy file, keeping daily gas in the store
+// A policy file failing to load rejects every call until it is fixed
+func NewEngine(path string, s store.Store) *Engine {
+	e := &Engine{path: path, store: s}
+	if err := e.Reload(); err != nil {
+		log.Error("Failed to load policy, no call is relayed: ", err)
+	}
+	return e
+}
+
+// Reload loads the policy file, the policy loaded before is kept if it fails
+func (e *Engine) Reload() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.reload()
+}
+
+func (e *Engine) reload() error {
+	e.checkedAt = time.Now()
+	info, err := os.Stat(e.path)
+	if err == nil {
+		e.modTime = info.ModTime()
+		var b []byte
+		if b, err = ioutil.ReadFile(e.path); err == nil {
+			var r *rules
+			if r, err = parse(b); err == nil {
+				e.rules = r
+				e.loadedAt = time.Now()
+			}
+		}
+	}
+	e.loadErr = err
+	if err == nil {
+		log.Info("Policy is loaded from ", e.path)
+	}
+	return err
+}
+
+// current returns the rules loaded, reloading the file if it has changed
+func (e *Engine) current() *rules {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if time.Since(e.checkedAt) >= reloadInterval {
+		e.checkedAt = time.Now()
+		if info, err := os.Stat(e.path); err == nil && !info.ModTime().Equal(e.modTime) {
+			if err := e.reload(); err != nil {
+				log.Error("Failed to reload policy, the policy loaded before is kept: ", err)
+			}
+		}
+	}
+	return e.rules
+}
+
+// Status is the policy loaded
+type Status struct {
+	File     string  `json:"file"`
+	Config   *Config `json:"config"`
+	LoadedAt int64   `json:"loaded_at,omitempty"`
+	// Error is of the last load, the config loaded before is applied
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns the policy loaded
+func (e *Engine) Status() *Status {
+	r := e.current()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := &Status{File: e.path}
+	if r != nil {
+		s.Config = &r.config
+		s.LoadedAt = e.loadedAt.Unix()
+	}
+	if e.loadErr != nil {
+		s.Error = e.loadErr.Error()
+	}
+	return s
+}
+
+// Check returns a Violation if the call is against the policy, gas budgets are checked by Reserve
+func (e *Engine) Check(call *Call) error {
+	r := e.current()
+	if r == nil {
+		return ErrNotLoaded
+	}
+	if err := r.checkCall(call, call.To, call.Value, call.Data, 0); err != nil {
+		return err
+	}
+	if r.config.MaxGas != 0 && call.Gas > r.config.MaxGas {
+		return violation(CodeGas, "max_gas", "Estimated gas %d is over %d", call.Gas, r.config.MaxGas)
+	}
+	return nil
+}
+
+// checkCall checks the target, function and value called by MetaID of the call.
+// Executions and approvals called by MetaID itself are checked by the calls inside them
+func (r *rules) checkCall(call *Call, to common.Address, value *big.Int, data []byte, depth int) error {
+	if r.maxValue != nil && value != nil && value.Cmp(r.maxValue) > 0 {
+		return violation(CodeValue, "max_value", "Value %v is over %v", value, r.maxValue)
+	}
+	if to == call.MetaID {
+		inner, checked, err := selfCall(call, data)
+		if err != nil || checked {
+			return err
+		}
+		if inner != nil {
+			if depth >= maxCallDepth {
+				return violation(CodeTarget, "targets", "Calls of MetaID to itself are nested deeper than %d", maxCallDepth)
+			}
+			return r.checkCall(call, inner.To, inner.Value, inner.Data, depth+1)
+		}
+	}
+	if len(r.allowTargets) > 0 && !r.allowTargets[to] {
+		return violation(CodeTarget, "targets", "Target contract %s is not allowed", to.Hex())
+	}
+	if r.denyTargets[to] {
+		return violation(CodeTarget, "targets", "Target contract %s is denied", to.Hex())
+	}
+	if len(data) < 4 {
+		if len(r.allowSelectors) > 0 {
+			return violation(CodeSelector, "selectors", "Call without a function selector is not allowed")
+		}
+		return nil
+	}
+	selector := hexutil.Encode(data[:4])
+	if len(r.allowSelectors) > 0 && !r.allowSelectors[selector] {
+		return violation(CodeSelector, "selectors", "Function %s is not allowed", selector)
+	}
+	if r.denySelectors[selector] {
+		return violation(CodeSelector, "selectors", "Function %s is denied", selector)
+	}
+	return nil
+}
+
+// selfCall returns the call inside an execution or an approval called by MetaID to itself,
+// or checked true if the call needs no check as it calls nothing or manages keys, claims or thresholds.
+// Other calls of MetaID to itself are checked as calls of other contracts
+func selfCall(call *Call, data []byte) (inner *Call, checked bool, err error) {
+	if len(data) == 0 {
+		return nil, true, nil
+	}
+	if len(data) < 4 {
+		return nil, false, nil
+	}
+	method, err := identityABI.MethodById(data[:4])
+	if err != nil {
+		return nil, false, nil
+	}
+	if managementFunctions[method.Name] {
+		return nil, true, nil
+	}
+	switch method.Name {
+	case "execute", "delegatedExecute":
+		args, err := method.Inputs.UnpackValues(data[4:])
+		if err != nil {
+			return nil, false, violation(CodeSelector, "selectors", "Execution of MetaID can't be decoded")
+		}
+		return &Call{To: args[0].(common.Address), Value: args[1].(*big.Int), Data: args[2].([]byte)}, false, nil
+	case "approve", "delegatedApprove":
+		args, err := method.Inputs.UnpackValues(data[4:])
+		if err != nil {
+			return nil, false, violation(CodeSelector, "selectors", "Approval of MetaID can't be decoded")
+		}
+		// A disapproval calls nothing
+		if !args[1].(bool) {
+			return nil, true, nil
+		}
+		if call.Execution == nil {
+			return nil, false, violation(CodeTarget, "targets", "Approval of MetaID can't be checked")
+		}
+		if inner, err = call.Execution(args[0].(*big.Int)); err != nil {
+			return nil, false, err
+		}
+		// An execution not pending is not executed by the approval
+		return inner, inner == nil, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,195 @@
+package policy
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+var (
+	metaID = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	signer = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	target = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	other  = common.HexToAddress("0x4444444444444444444444444444444444444444")
+)
+
+// writeEngine returns the engine of the policy file written in a temporary directory
+func writeEngine(t *testing.T, config string) (*Engine, string) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return NewEngine(path, store.NewMemoryStore()), dir
+}
+
+func code(err error) int32 {
+	if v, ok := err.(*Violation); ok {
+		return v.ErrorCode()
+	}
+	return 0
+}
+
+// pack returns data calling the function of MetaID
+func pack(t *testing.T, method string, args ...interface{}) []byte {
+	data, err := identityABI.Pack(method, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCheck(t *testing.T) {
+	e, dir := writeEngine(t, `{
+		"targets": {"allow": ["0x3333333333333333333333333333333333333333"]},
+		"selectors": {"allow": ["0xa9059cbb", "0x095ea7b3"], "deny": ["0x095ea7b3"]},
+		"max_value": "1000",
+		"max_gas": 100000
+	}`)
+	defer os.RemoveAll(dir)
+
+	transfer := []byte{0xa9, 0x05, 0x9c, 0xbb, 0x00}
+	// Execution 1 calls a function denied, and execution 2 transfers
+	pending := func(id *big.Int) (*Call, error) {
+		if id.Int64() == 1 {
+			return &Call{To: target, Data: []byte{0x09, 0x5e, 0xa7, 0xb3}}, nil
+		}
+		return &Call{To: target, Data: transfer}, nil
+	}
+	for _, c := range []struct {
+		call Call
+		code int32
+	}{
+		{Call{MetaID: metaID, To: target, Data: transfer, Value: big.NewInt(1000), Gas: 100000}, 0},
+		{Call{MetaID: metaID, To: other, Data: transfer}, CodeTarget},
+		{Call{MetaID: metaID, To: target, Data: []byte{0x09, 0x5e, 0xa7, 0xb3}}, CodeSelector},
+		{Call{MetaID: metaID, To: target, Data: []byte{0x12, 0x34, 0x56, 0x78}}, CodeSelector},
+		{Call{MetaID: metaID, To: target}, CodeSelector},
+		{Call{MetaID: metaID, To: target, Data: transfer, Value: big.NewInt(1001)}, CodeValue},
+		{Call{MetaID: metaID, To: target, Data: transfer, Gas: 100001}, CodeGas},
+		// Calls of MetaID to itself managing keys are not checked by targets and selectors,
+		// executions and approvals are checked by calls inside them
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "addKey", [32]byte{}, big.NewInt(1), big.NewInt(1))}, 0},
+		{Call{MetaID: metaID, To: metaID, Data: []byte{0x12, 0x34, 0x56, 0x78}}, CodeTarget},
+		{Call{MetaID: metaID, To: metaID, Gas: 100001}, CodeGas},
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "execute", target, big.NewInt(0), transfer)}, 0},
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "execute", other, big.NewInt(0), transfer)}, CodeTarget},
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "execute", target, big.NewInt(1001), transfer)}, CodeValue},
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "execute", metaID, big.NewInt(0), pack(t, "execute", other, big.NewInt(0), transfer))}, CodeTarget},
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "approve", big.NewInt(1), true)}, CodeTarget},
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "approve", big.NewInt(1), false)}, 0},
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "approve", big.NewInt(1), true), Execution: pending}, CodeSelector},
+		{Call{MetaID: metaID, To: metaID, Data: pack(t, "approve", big.NewInt(2), true), Execution: pending}, 0},
+	} {
+		if err := e.Check(&c.call); code(err) != c.code || (c.code == 0 && err != nil) {
+			t.Errorf("Check(%x to %x) = %v, want code %d", c.call.Data, c.call.To, err, c.code)
+		}
+	}
+
+	for _, config := range []string{
+		`{"targets": {"deny": ["0x1234"]}}`,
+		`{"selectors": {"allow": ["0xa9059c"]}}`,
+		`{"max_value": "-1"}`,
+		`{"max_gas": "100"}`,
+	} {
+		if _, err := Parse([]byte(config)); err == nil {
+			t.Errorf("Parse(%s) succeeds, want error", config)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	defer func(interval time.Duration) { reloadInterval = interval }(reloadInterval)
+	reloadInterval = 0
+
+	e, dir := writeEngine(t, `{"targets": {"deny": ["0x3333333333333333333333333333333333333333"]}}`)
+	defer os.RemoveAll(dir)
+	call := &Call{MetaID: metaID, To: target}
+	if code(e.Check(call)) != CodeTarget {
+		t.Fatal("Target denied is allowed")
+	}
+
+	// A file changed is reloaded, an invalid one keeps the policy loaded before
+	modTime := time.Now().Add(time.Second)
+	ioutil.WriteFile(e.path, []byte(`{}`), 0600)
+	os.Chtimes(e.path, modTime, modTime)
+	if err := e.Check(call); err != nil {
+		t.Fatal("Policy is not reloaded: ", err)
+	}
+	modTime = modTime.Add(time.Second)
+	ioutil.WriteFile(e.path, []byte(`{"max_gas": -1}`), 0600)
+	os.Chtimes(e.path, modTime, modTime)
+	if err := e.Check(call); err != nil {
+		t.Fatal("Invalid policy is applied: ", err)
+	}
+	if s := e.Status(); s.Error == "" || s.Config == nil {
+		t.Errorf("Status = %+v, want the error with the config loaded before", s)
+	}
+
+	// No valid policy rejects every call
+	e = NewEngine(filepath.Join(dir, "missing.json"), store.NewMemoryStore())
+	if err := e.Check(call); err != ErrNotLoaded {
+		t.Errorf("Check without policy = %v, want %v", err, ErrNotLoaded)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	e, dir := writeEngine(t, `{"daily_gas_per_meta_id": 100, "daily_gas_per_signer": 60}`)
+	defer os.RemoveAll(dir)
+
+	spent := func(scope string, address common.Address) uint64 {
+		n, err := e.Spent(scope, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	first := &Call{MetaID: metaID, Signer: signer, Gas: 50}
+	if err := e.Reserve(first); err != nil {
+		t.Fatal(err)
+	}
+	// Over the signer budget, the MetaID budget is not taken
+	if err := e.Reserve(&Call{MetaID: metaID, Signer: signer, Gas: 20}); code(err) != CodeBudget {
+		t.Fatalf("Reserve over signer budget = %v", err)
+	}
+	if n := spent(scopeMetaID, metaID); n != 50 {
+		t.Errorf("MetaID spent %d, want 50", n)
+	}
+
+	// Another key of MetaID is limited by the MetaID budget
+	second := &Call{MetaID: metaID, Signer: other, Gas: 50}
+	if err := e.Reserve(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reserve(&Call{MetaID: metaID, Signer: other, Gas: 1}); code(err) != CodeBudget {
+		t.Fatalf("Reserve over MetaID budget = %v", err)
+	}
+
+	// Gas not sent is released, gas used replaces the estimation
+	e.Release(second)
+	e.Track("0xaa", first)
+	e.settle(&tracker.Record{Hash: "0xaa", Status: tracker.StatusMined, GasUsed: 30})
+	if n := spent(scopeMetaID, metaID); n != 30 {
+		t.Errorf("MetaID spent %d, want 30", n)
+	}
+	if n := spent(scopeSigner, signer); n != 30 {
+		t.Errorf("Signer spent %d, want 30", n)
+	}
+	if n := spent(scopeSigner, other); n != 0 {
+		t.Errorf("Other signer spent %d, want 0", n)
+	}
+	if _, err := e.store.Get(txKey("0xaa")); err != store.ErrNotFound {
+		t.Error("Call settled is kept")
+	}
+}
@@ -0,0 +1,220 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metadium/go-delegator/json"
+)
+
+// newTestRPC returns RPC using only given node URLs
+func newTestRPC(urls ...string) *RPC {
+	r := &RPC{NetType: Testnet}
+	r.InitClient()
+	r.pool = NewNodePool(urls, r.client)
+	return r
+}
+
+// echoNode answers the method name as result, failing first n requests with 503
+func echoNode(failFirst int32, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(hits, 1) <= failFirst {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		req, rpcErr := json.GetRPCRequestFromJSON(string(body))
+		if rpcErr != nil {
+			// Empty or broken body must not reach the node
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp := json.RPCResponse{Jsonrpc: "2.0", ID: req.ID, Result: req.Method}
+		w.Write([]byte(resp.String()))
+	}))
+}
+
+func TestDoRPCRetryBody(t *testing.T) {
+	backoff := retryBackoff
+	defer func() { retryBackoff = backoff }()
+	retryBackoff = time.Millisecond
+
+	var hits int32
+	srv := echoNode(2, &hits)
+	defer srv.Close()
+	r := newTestRPC(srv.URL)
+
+	ret, err := r.DoRPC(initRPCRequest("eth_blockNumber"))
+	if err != nil {
+		t.Fatalf("Failed to retry: %v", err)
+	}
+	resp := json.GetRPCResponseFromJSON(ret)
+	if resp.Result != "eth_blockNumber" || hits != 3 {
+		t.Errorf("Retry must send the same body, response %s, hits %d", ret, hits)
+	}
+}
+
+func TestDoRPCNoRetryNonIdempotent(t *testing.T) {
+	backoff := retryBackoff
+	defer func() { retryBackoff = backoff }()
+	retryBackoff = time.Millisecond
+
+	var hits int32
+	srv := echoNode(1, &hits)
+	defer srv.Close()
+	r := newTestRPC(srv.URL)
+
+	req := initRPCRequest("eth_sendRawTransaction")
+	req.Params = append(req.Params, "0x00")
+	if _, err := r.DoRPC(req); err == nil {
+		t.Errorf("Failed request must return error")
+	}
+	if hits != 1 {
+		t.Errorf("Non-idempotent method must not be retried, hits %d", hits)
+	}
+
+	// Batch is retried only if every method is idempotent
+	hits = 0
+	msg := "[{\"jsonrpc\":\"2.0\",\"method\":\"eth_blockNumber\",\"id\":1},{\"jsonrpc\":\"2.0\",\"method\":\"eth_sendRawTransaction\",\"params\":[\"0x00\"],\"id\":2}]"
+	r.DoRPC(msg)
+	if hits != 1 {
+		t.Errorf("Batch with non-idempotent method must not be retried, hits %d", hits)
+	}
+}
+
+func TestDoRPCInternalError(t *testing.T) {
+	backoff := retryBackoff
+	defer func() { retryBackoff = backoff }()
+	retryBackoff = time.Millisecond
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		resp := json.RPCResponse{Jsonrpc: "2.0", ID: 1, Error: json.NewRPCError(json.ErrCodeInternal, "missing trie node")}
+		w.Write([]byte(resp.String()))
+	}))
+	defer srv.Close()
+	r := newTestRPC(srv.URL)
+
+	ret, err := r.DoRPC(initRPCRequest("eth_call"))
+	if err != nil {
+		t.Fatalf("Internal error of the node must be returned as the response: %v", err)
+	}
+	if resp := json.GetRPCResponseFromJSON(ret); resp.Error == nil || resp.Error.Message != "missing trie node" || hits != retryCnt {
+		t.Errorf("Expected the error after %d attempts, response %s, hits %d", retryCnt, ret, hits)
+	}
+}
+
+func TestDoRPCRetryDialError(t *testing.T) {
+	backoff := retryBackoff
+	defer func() { retryBackoff = backoff }()
+	retryBackoff = time.Millisecond
+
+	var hits int32
+	srv := echoNode(0, &hits)
+	defer srv.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	// Request never reached the dead node, so it is safe to send elsewhere
+	r := newTestRPC(deadURL, srv.URL)
+	for i := 0; i < 10; i++ {
+		if _, err := r.DoRPC(initRPCRequest("eth_sendRawTransaction")); err != nil {
+			t.Fatalf("Request must be sent to live node: %v", err)
+		}
+	}
+}
+
+func TestDoRPCContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	r := newTestRPC(srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	_, err := r.DoRPCContext(ctx, initRPCRequest("eth_blockNumber"))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Request must stop at deadline")
+	}
+	for _, s := range r.NodeStatus() {
+		if s.TotalFail != 0 {
+			t.Errorf("Cancelled request must not penalize node")
+		}
+	}
+}
+
+func TestSimulateContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	r := newTestRPC(srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.SimulateContext(ctx, CallArgs{}); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Simulation must stop at deadline")
+	}
+}
+
+func TestDoRPCConcurrent(t *testing.T) {
+	var hits int32
+	srv := echoNode(0, &hits)
+	defer srv.Close()
+	r := newTestRPC(srv.URL)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			req := initRPCRequest(fmt.Sprintf("eth_method%d", i))
+			ret, err := r.DoRPCContext(context.Background(), req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if resp := json.GetRPCResponseFromJSON(ret); resp.Result != req.Method {
+				errs <- fmt.Errorf("Mismatched response %s", ret)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			r.GetEthClient()
+			r.NodeStatus()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.pool.Probe()
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,417 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/common"
+	ethjson "github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+)
+
+const (
+	// Node states
+	nodeUp      = "up"
+	nodeDown    = "down"
+	nodeLagging = "lagging"
+	nodeUnknown = "unknown"
+
+	// Latency assumed for a node which is never measured
+	defaultLatency = 100 * time.Millisecond
+	// Weight of the newest sample in latency moving average
+	latencyAlpha = 0.3
+)
+
+var (
+	// Interval of eth_blockNumber/net_version probes
+	probeInterval = 15 * time.Second
+	// Period a failed node is excluded before it can recover by probe
+	nodeCooldown = 60 * time.Second
+	// Consecutive failures to mark a node down
+	failThreshold = 3
+	// Blocks a node can be behind the best node
+	maxBlockLag uint64 = 10
+)
+
+// NodeStatus is a snapshot of node state
+type NodeStatus struct {
+	URL         string  `json:"url"`
+	State       string  `json:"state"`
+	LatencyMs   float64 `json:"latency_ms"`
+	BlockNumber uint64  `json:"block_number"`
+	BlockLag    uint64  `json:"block_lag"`
+	ChainID     string  `json:"chain_id"`
+	FailCnt     int     `json:"fail_count"`
+	TotalReq    uint64  `json:"total_requests"`
+	TotalFail   uint64  `json:"total_failures"`
+	LastError   string  `json:"last_error,omitempty"`
+	LastProbe   string  `json:"last_probe,omitempty"`
+	DownUntil   string  `json:"down_until,omitempty"`
+}
+
+type node struct {
+	url         string
+	latency     time.Duration
+	blockNumber uint64
+	chainID     string
+	failCnt     int
+	totalReq    uint64
+	totalFail   uint64
+	lastErr     string
+	lastProbe   time.Time
+	downUntil   time.Time
+	probed      bool
+	down        bool
+	wrongChain  bool
+}
+
+// NodePool selects a healthy node weighted by latency.
+// A node is marked down after consecutive failures and comes back
+// when a probe succeeds after cooldown. A node behind the best node
+// more than maxBlockLag blocks is not selected until it catches up.
+type NodePool struct {
+	mu        sync.RWMutex
+	nodes     []*node
+	bestBlock uint64
+	chainID   string
+	client    *http.Client
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// NewNodePool returns a node pool for given URLs
+func NewNodePool(urls []string, client *http.Client) *NodePool {
+	p := &NodePool{
+		client: client,
+		stop:   make(chan struct{}),
+	}
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+		p.nodes = append(p.nodes, &node{url: url})
+	}
+	return p
+}
+
+func (n *node) state(now time.Time, bestBlock uint64, maxLag uint64) string {
+	switch {
+	case n.down || n.wrongChain:
+		return nodeDown
+	case n.probed && bestBlock > n.blockNumber+maxLag:
+		return nodeLagging
+	case !n.probed && n.totalReq == 0:
+		return nodeUnknown
+	}
+	return nodeUp
+}
+
+func (n *node) weight() float64 {
+	latency := n.latency
+	if latency <= 0 {
+		latency = defaultLatency
+	}
+	return 1 / latency.Seconds()
+}
+
+// Pick returns URL of a node, healthy and faster nodes are picked more.
+// If every node is unavailable, the node which recovers first is returned.
+func (p *NodePool) Pick() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.nodes) == 0 {
+		return ""
+	}
+
+	now := time.Now()
+	var candidates []*node
+	var total float64
+	for _, n := range p.nodes {
+		switch n.state(now, p.bestBlock, maxBlockLag) {
+		case nodeUp, nodeUnknown:
+			candidates = append(candidates, n)
+			total += n.weight()
+		}
+	}
+	if len(candidates) == 0 {
+		fallback := p.nodes[0]
+		for _, n := range p.nodes[1:] {
+			if n.downUntil.Before(fallback.downUntil) {
+				fallback = n
+			}
+		}
+		return fallback.url
+	}
+
+	x := rand.Float64() * total
+	for _, n := range candidates {
+		if x -= n.weight(); x < 0 {
+			return n.url
+		}
+	}
+	return candidates[len(candidates)-1].url
+}
+
+func (p *NodePool) find(url string) *node {
+	for _, n := range p.nodes {
+		if n.url == url {
+			return n
+		}
+	}
+	return nil
+}
+
+func (n *node) observeLatency(latency time.Duration) {
+	if n.latency <= 0 {
+		n.latency = latency
+		return
+	}
+	n.latency = time.Duration(latencyAlpha*float64(latency) + (1-latencyAlpha)*float64(n.latency))
+}
+
+// ReportSuccess records a successful request to the node
+func (p *NodePool) ReportSuccess(url string, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.find(url)
+	if n == nil {
+		return
+	}
+	n.totalReq++
+	n.failCnt = 0
+	n.observeLatency(latency)
+}
+
+// ReportFailure records a failed request, the node is down after failThreshold failures
+func (p *NodePool) ReportFailure(url string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.find(url)
+	if n == nil {
+		return
+	}
+	n.totalReq++
+	n.totalFail++
+	p.failLocked(n, err)
+}
+
+func (p *NodePool) failLocked(n *node, err error) {
+	n.failCnt++
+	n.lastErr = err.Error()
+	if n.failCnt >= failThreshold {
+		if !n.down {
+			log.Errorf("Node %s is down: %s", n.url, n.lastErr)
+		}
+		n.down = true
+		n.downUntil = time.Now().Add(nodeCooldown)
+	}
+}
+
+// Start probes nodes periodically until Stop
+func (p *NodePool) Start() {
+	go func() {
+		p.Probe()
+		ticker := time.NewTicker(probeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.Probe()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops probing
+func (p *NodePool) Stop() {
+	p.once.Do(func() { close(p.stop) })
+}
+
+type probeResult struct {
+	blockNumber uint64
+	chainID     string
+	latency     time.Duration
+	err         error
+}
+
+// Probe checks every node with eth_blockNumber and net_version in a batch
+func (p *NodePool) Probe() {
+	p.mu.RLock()
+	urls := make([]string, len(p.nodes))
+	for i, n := range p.nodes {
+		urls[i] = n.url
+	}
+	p.mu.RUnlock()
+
+	results := make([]probeResult, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			results[i] = p.probe(url)
+		}(i, url)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+
+	// Majority of chain IDs is the chain of this pool
+	chainCnt := make(map[string]int)
+	for _, res := range results {
+		if res.err == nil {
+			chainCnt[res.chainID]++
+		}
+	}
+	for chainID, cnt := range chainCnt {
+		if p.chainID == "" || cnt > chainCnt[p.chainID] {
+			p.chainID = chainID
+		}
+	}
+
+	// Best block among nodes on the chain, a node gone down doesn't keep it
+	var bestBlock uint64
+	for _, res := range results {
+		if res.err == nil && res.chainID == p.chainID && res.blockNumber > bestBlock {
+			bestBlock = res.blockNumber
+		}
+	}
+	p.bestBlock = bestBlock
+
+	for i, res := range results {
+		n := p.find(urls[i])
+		if n == nil {
+			continue
+		}
+		n.lastProbe = now
+		if res.err != nil {
+			p.failLocked(n, res.err)
+			continue
+		}
+		n.probed = true
+		n.blockNumber = res.blockNumber
+		n.chainID = res.chainID
+		n.wrongChain = p.chainID != "" && n.chainID != p.chainID
+		n.observeLatency(res.latency)
+		if n.wrongChain {
+			n.lastErr = fmt.Sprintf("Chain ID %s, expected %s", n.chainID, p.chainID)
+			continue
+		}
+		n.failCnt = 0
+		// Recover after cooldown
+		if n.down && now.After(n.downUntil) {
+			log.Infof("Node %s is recovered", n.url)
+			n.down = false
+		}
+	}
+}
+
+func (p *NodePool) probe(url string) (res probeResult) {
+	reqs := []ethjson.RPCRequest{initRPCRequest("eth_blockNumber"), initRPCRequest("net_version")}
+	reqs[1].ID = initParamID + 1
+	body, _ := json.Marshal(reqs)
+
+	start := time.Now()
+	resp, err := p.client.Post(url, ContentType, bytes.NewReader(body))
+	if err != nil {
+		res.err = err
+		return
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	res.latency = time.Since(start)
+	if err != nil {
+		res.err = err
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		res.err = fmt.Errorf("HTTP status %d", resp.StatusCode)
+		return
+	}
+
+	resps, err := ethjson.GetRPCBatchResponseFromJSON(string(respBody))
+	if err != nil || len(resps) != 2 {
+		res.err = fmt.Errorf("Invalid probe response")
+		return
+	}
+	// Node may reorder batch responses
+	byID := make(map[int64]ethjson.RPCResponse)
+	for _, r := range resps {
+		if r.Error != nil {
+			res.err = fmt.Errorf("%s", r.Error.Message)
+			return
+		}
+		if id, ok := responseID(r.ID); ok {
+			byID[id] = r
+		}
+	}
+	blockNumber, ok1 := byID[initParamID].Result.(string)
+	chainID, ok2 := byID[initParamID+1].Result.(string)
+	if !ok1 || !ok2 {
+		res.err = fmt.Errorf("Invalid probe response")
+		return
+	}
+	offset, base := common.FindOffsetNBase(blockNumber)
+	var parseErr error
+	if res.blockNumber, parseErr = strconv.ParseUint(blockNumber[offset:], base, 64); parseErr != nil {
+		res.err = parseErr
+		return
+	}
+	res.chainID = chainID
+	return
+}
+
+// responseID returns the number of a response ID, decoded as json.Number or float64
+func responseID(id interface{}) (int64, bool) {
+	switch v := id.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	case float64:
+		return int64(v), v == float64(int64(v))
+	}
+	return 0, false
+}
+
+// Status returns state of every node
+func (p *NodePool) Status() []NodeStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	now := time.Now()
+	ret := make([]NodeStatus, len(p.nodes))
+	for i, n := range p.nodes {
+		s := NodeStatus{
+			URL:         n.url,
+			State:       n.state(now, p.bestBlock, maxBlockLag),
+			LatencyMs:   float64(n.latency) / float64(time.Millisecond),
+			BlockNumber: n.blockNumber,
+			ChainID:     n.chainID,
+			FailCnt:     n.failCnt,
+			TotalReq:    n.totalReq,
+			TotalFail:   n.totalFail,
+			LastError:   n.lastErr,
+		}
+		if n.probed && p.bestBlock > n.blockNumber {
+			s.BlockLag = p.bestBlock - n.blockNumber
+		}
+		if !n.lastProbe.IsZero() {
+			s.LastProbe = n.lastProbe.UTC().Format(time.RFC3339)
+		}
+		if n.down {
+			s.DownUntil = n.downUntil.UTC().Format(time.RFC3339)
+		}
+		ret[i] = s
+	}
+	return ret
+}
@@ -0,0 +1,188 @@
+package rpc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metadium/go-delegator/json"
+)
+
+type fakeNode struct {
+	mu          sync.Mutex
+	blockNumber uint64
+	chainID     string
+	fail        bool
+	// reversed answers a batch in reverse order
+	reversed bool
+	srv      *httptest.Server
+}
+
+func newFakeNode(blockNumber uint64, chainID string) *fakeNode {
+	n := &fakeNode{blockNumber: blockNumber, chainID: chainID}
+	n.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		reqs, _ := json.GetRPCBatchFromJSON(string(body))
+		var resps []json.RPCResponse
+		for _, req := range reqs {
+			resp := json.RPCResponse{Jsonrpc: "2.0", ID: req.ID}
+			switch req.Method {
+			case "eth_blockNumber":
+				resp.Result = fmt.Sprintf("0x%x", n.blockNumber)
+			case "net_version":
+				resp.Result = n.chainID
+			}
+			if n.reversed {
+				resps = append([]json.RPCResponse{resp}, resps...)
+			} else {
+				resps = append(resps, resp)
+			}
+		}
+		w.Write([]byte(json.BatchString(resps)))
+	}))
+	return n
+}
+
+func (n *fakeNode) setFail(fail bool) {
+	n.mu.Lock()
+	n.fail = fail
+	n.mu.Unlock()
+}
+
+func newTestPool(nodes ...*fakeNode) *NodePool {
+	var urls []string
+	for _, n := range nodes {
+		urls = append(urls, n.srv.URL)
+	}
+	return NewNodePool(urls, &http.Client{Timeout: time.Second})
+}
+
+func stateOf(p *NodePool, url string) string {
+	for _, s := range p.Status() {
+		if s.URL == url {
+			return s.State
+		}
+	}
+	return ""
+}
+
+func TestNodePoolLagAndChain(t *testing.T) {
+	good := newFakeNode(1000, "101")
+	lagging := newFakeNode(900, "101")
+	wrong := newFakeNode(1000, "1")
+	other := newFakeNode(998, "101")
+	defer good.srv.Close()
+	defer lagging.srv.Close()
+	defer wrong.srv.Close()
+	defer other.srv.Close()
+
+	p := newTestPool(good, lagging, wrong, other)
+	p.Probe()
+
+	if s := stateOf(p, lagging.srv.URL); s != nodeLagging {
+		t.Errorf("Lagging node state is %s", s)
+	}
+	if s := stateOf(p, wrong.srv.URL); s != nodeDown {
+		t.Errorf("Node on other chain state is %s", s)
+	}
+	for i := 0; i < 100; i++ {
+		if url := p.Pick(); url != good.srv.URL && url != other.srv.URL {
+			t.Fatalf("Unhealthy node is picked: %s", url)
+		}
+	}
+
+	// Lagging node is selected again when it catches up
+	lagging.mu.Lock()
+	lagging.blockNumber = 1000
+	lagging.mu.Unlock()
+	p.Probe()
+	if s := stateOf(p, lagging.srv.URL); s != nodeUp {
+		t.Errorf("Caught up node state is %s", s)
+	}
+}
+
+func TestNodePoolProbeOrder(t *testing.T) {
+	n := newFakeNode(1000, "101")
+	n.reversed = true
+	defer n.srv.Close()
+
+	res := newTestPool(n).probe(n.srv.URL)
+	if res.err != nil || res.blockNumber != 1000 || res.chainID != "101" {
+		t.Errorf("Probe of responses reordered = %+v", res)
+	}
+}
+
+func TestNodePoolCooldown(t *testing.T) {
+	cooldown := nodeCooldown
+	defer func() { nodeCooldown = cooldown }()
+	nodeCooldown = time.Millisecond * 100
+
+	a := newFakeNode(10, "101")
+	b := newFakeNode(10, "101")
+	defer a.srv.Close()
+	defer b.srv.Close()
+	p := newTestPool(a, b)
+	p.Probe()
+
+	a.setFail(true)
+	for i := 0; i < failThreshold; i++ {
+		p.ReportFailure(a.srv.URL, fmt.Errorf("connection refused"))
+	}
+	if s := stateOf(p, a.srv.URL); s != nodeDown {
+		t.Fatalf("Failed node state is %s", s)
+	}
+	for i := 0; i < 100; i++ {
+		if p.Pick() != b.srv.URL {
+			t.Fatalf("Down node is picked")
+		}
+	}
+
+	// Node recovers only by a successful probe after cooldown
+	a.setFail(false)
+	p.Probe()
+	if s := stateOf(p, a.srv.URL); s != nodeDown {
+		t.Errorf("Node must stay down during cooldown, state %s", s)
+	}
+	time.Sleep(nodeCooldown)
+	p.Probe()
+	if s := stateOf(p, a.srv.URL); s != nodeUp {
+		t.Errorf("Node must recover after cooldown, state %s", s)
+	}
+}
+
+func TestNodePoolLatencyWeight(t *testing.T) {
+	p := NewNodePool([]string{"fast", "slow"}, nil)
+	p.ReportSuccess("fast", time.Millisecond*10)
+	p.ReportSuccess("slow", time.Millisecond*90)
+
+	cnt := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		cnt[p.Pick()]++
+	}
+	// Expected ratio is 9:1
+	if cnt["fast"] < cnt["slow"]*5 {
+		t.Errorf("Fast node must be picked more: %v", cnt)
+	}
+
+	// Every node is down, the first to recover is picked
+	for i := 0; i < failThreshold; i++ {
+		p.ReportFailure("slow", fmt.Errorf("timeout"))
+	}
+	time.Sleep(time.Millisecond)
+	for i := 0; i < failThreshold; i++ {
+		p.ReportFailure("fast", fmt.Errorf("timeout"))
+	}
+	if p.Pick() != "slow" {
+		t.Errorf("Node recovering first must be picked")
+	}
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	ethjson "github.com/metadium/go-delegator/json"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// revertSelector is a selector of Error(string) which solidity reverts with
+var revertSelector = hexutil.MustDecode("0x08c379a0")
+
+// RevertError is an error of a call reverted by contract
+type RevertError struct {
+	// Reason is a message given to revert or require
+	Reason string
+	// Data is the revert data returned by the contract
+	Data hexutil.Bytes
+}
+
+// NewRevertError returns RevertError with the reason decoded from revert data
+func NewRevertError(data []byte) *RevertError {
+	reason, _ := DecodeRevertReason(data)
+	return &RevertError{Reason: reason, Data: data}
+}
+
+func (e *RevertError) Error() string {
+	if e.Reason == "" {
+		return "execution reverted"
+	}
+	return "execution reverted: " + e.Reason
+}
+
+// IsRevertData returns true if data is encoded as Error(string)
+func IsRevertData(data []byte) bool {
+	return len(data) >= 4 && bytes.Equal(data[:4], revertSelector)
+}
+
+// revertData returns revert data in the error of the node, or nil
+func revertData(err *ethjson.RPCError) []byte {
+	data, ok := err.Data.(string)
+	if !ok {
+		return nil
+	}
+	b, decErr := hexutil.Decode(data)
+	if decErr != nil || !IsRevertData(b) {
+		return nil
+	}
+	return b
+}
+
+// DecodeRevertReason returns the reason of revert data encoded as Error(string)
+func DecodeRevertReason(data []byte) (string, error) {
+	if !IsRevertData(data) {
+		return "", fmt.Errorf("Not a revert reason")
+	}
+	data = data[4:]
+	if len(data) < 64 {
+		return "", fmt.Errorf("Revert reason is too short")
+	}
+	offset := new(big.Int).SetBytes(data[:32])
+	if !offset.IsUint64() || offset.Uint64()+32 > uint64(len(data)) {
+		return "", fmt.Errorf("Invalid offset of revert reason")
+	}
+	start := offset.Uint64() + 32
+	size := new(big.Int).SetBytes(data[start-32 : start])
+	if !size.IsUint64() || start+size.Uint64() > uint64(len(data)) {
+		return "", fmt.Errorf("Invalid length of revert reason")
+	}
+	return string(data[start : start+size.Uint64()]), nil
+}
@@ -0,0 +1,64 @@
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// "Not authorized" encoded as Error(string)
+const testRevertData = "0x08c379a0" +
+	"0000000000000000000000000000000000000000000000000000000000000020" +
+	"000000000000000000000000000000000000000000000000000000000000000e" +
+	"4e6f7420617574686f72697a6564000000000000000000000000000000000000"
+
+func TestDecodeRevertReason(t *testing.T) {
+	tests := []struct {
+		data   string
+		reason string
+		ok     bool
+	}{
+		{testRevertData, "Not authorized", true},
+		{"0x", "", false},
+		{"0x12345678", "", false},
+		{"0x08c379a0", "", false},
+		// Length beyond data
+		{testRevertData[:len(testRevertData)-64], "", false},
+	}
+	for _, test := range tests {
+		reason, err := DecodeRevertReason(hexutil.MustDecode(test.data))
+		if reason != test.reason || (err == nil) != test.ok {
+			t.Errorf("%s: expected %q, %v, got %q, %v", test.data, test.reason, test.ok, reason, err)
+		}
+	}
+}
+
+func TestCallAtRevert(t *testing.T) {
+	bodies := []string{
+		// Old nodes
+		`{"jsonrpc":"2.0","id":1,"result":"` + testRevertData + `"}`,
+		// Recent nodes
+		`{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted: Not authorized","data":"` + testRevertData + `"}}`,
+	}
+	for _, body := range bodies {
+		node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		_, err := newTestRPC(node.URL).CallAt(CallArgs{}, "latest")
+		node.Close()
+		revertErr, ok := err.(*RevertError)
+		if !ok || revertErr.Reason != "Not authorized" {
+			t.Errorf("Expected RevertError, got %v", err)
+		}
+	}
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x01"}`))
+	}))
+	defer node.Close()
+	if ret, err := newTestRPC(node.URL).CallAt(CallArgs{}, "latest"); err != nil || hexutil.Encode(ret) != "0x01" {
+		t.Errorf("Expected 0x01, got %x, %v", ret, err)
+	}
+}
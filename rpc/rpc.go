@@ -3,6 +3,7 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -10,14 +11,19 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	neturl "net/url"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/metadium/go-delegator/common"
 	"github.com/metadium/go-delegator/crypto"
 	ethjson "github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
 
+	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
@@ -26,6 +32,7 @@ type RPC struct {
 	NetType    string
 	NetVersion *big.Int
 	client     *http.Client
+	pool       *NodePool
 	GasPrice   uint64
 }
 
@@ -37,8 +44,6 @@ const (
 	// For initial request
 	initParamJsonrpc = "2.0"
 	initParamID      = 1
-	// Threshold to classify nodes
-	threshold = 10
 	// RPC retry count
 	retryCnt = 3
 	// HTTP timeout
@@ -46,16 +51,49 @@ const (
 )
 
 var (
-	zero = big.NewInt(0)
 	// For singleton
 	instance *RPC
 	once     sync.Once
 	// IP => ethclient
-	ethClients = make(map[string]*ethclient.Client)
-	// IP => http fail count
-	httpFailCnt = make(map[string]int)
-	// NetType => available length of IP list
-	availLen = make(map[string]int)
+	ethClients   = make(map[string]*ethclient.Client)
+	ethClientsMu sync.Mutex
+	// Backoff before the first retry, doubled for each retry
+	retryBackoff = 100 * time.Millisecond
+	// Methods which don't change state, safe to send again
+	idempotentMethods = map[string]bool{
+		"web3_clientVersion":                      true,
+		"web3_sha3":                               true,
+		"net_version":                             true,
+		"net_listening":                           true,
+		"net_peerCount":                           true,
+		"eth_protocolVersion":                     true,
+		"eth_syncing":                             true,
+		"eth_coinbase":                            true,
+		"eth_mining":                              true,
+		"eth_hashrate":                            true,
+		"eth_gasPrice":                            true,
+		"eth_accounts":                            true,
+		"eth_blockNumber":                         true,
+		"eth_getBalance":                          true,
+		"eth_getStorageAt":                        true,
+		"eth_getTransactionCount":                 true,
+		"eth_getBlockTransactionCountByHash":      true,
+		"eth_getBlockTransactionCountByNumber":    true,
+		"eth_getUncleCountByBlockHash":            true,
+		"eth_getUncleCountByBlockNumber":          true,
+		"eth_getCode":                             true,
+		"eth_call":                                true,
+		"eth_estimateGas":                         true,
+		"eth_getBlockByHash":                      true,
+		"eth_getBlockByNumber":                    true,
+		"eth_getTransactionByHash":                true,
+		"eth_getTransactionByBlockHashAndIndex":   true,
+		"eth_getTransactionByBlockNumberAndIndex": true,
+		"eth_getTransactionReceipt":               true,
+		"eth_getUncleByBlockHashAndIndex":         true,
+		"eth_getUncleByBlockNumberAndIndex":       true,
+		"eth_getLogs":                             true,
+	}
 	// NetType is either mainnet or testnet
 	NetType = Testnet
 )
@@ -65,94 +103,68 @@ func GetInstance() *RPC {
 	once.Do(func() {
 		instance = &RPC{}
 		instance.InitClient()
-		availLen[Mainnet] = len(MainnetUrls)
-		availLen[Testnet] = len(TestnetUrls)
-
 		instance.NetType = NetType
+		instance.InitPool()
+		instance.pool.Start()
+
 		instance.NetVersion = instance.GetChainID()
 		instance.GasPrice = instance.GetGasPrice()
 
 		c := crypto.GetInstance()
 		if c != nil {
 			c.InitChainID(instance.NetVersion)
-			c.InitNonce(instance.GetTransactionCount(c.GetAddress()))
+			if err := c.InitNonceManager(instance); err != nil {
+				log.Error("Failed to initialize nonce manager, nonce is kept in memory: ", err)
+				for _, a := range c.Accounts() {
+					a.InitNonce(instance.GetTransactionCount(a.GetAddress()))
+				}
+			}
 		}
 	})
 	return instance
 }
 
-func randomURL(netType string) (url string) {
+func nodeURLs(netType string) []string {
 	switch netType {
 	case Mainnet:
-		url = MainnetUrls[rand.Intn(availLen[Mainnet])]
-		break
+		return MainnetUrls
 	case Testnet:
-		url = TestnetUrls[rand.Intn(availLen[Testnet])]
-		break
+		return TestnetUrls
 	}
-	return
+	return nil
 }
 
 func (r *RPC) getURL() string {
-	return randomURL(r.NetType)
+	return r.pool.Pick()
 }
 
 // GetEthClient returns ether client among urls included in target net
 func (r *RPC) GetEthClient() *ethclient.Client {
-	url := randomURL(r.NetType)
+	url := r.getURL()
+	ethClientsMu.Lock()
+	defer ethClientsMu.Unlock()
 	if ethClients[url] == nil {
 		ethClients[url], _ = ethclient.Dial(url)
 	}
 	return ethClients[url]
 }
 
-// refreshURLList sorts url list to avoid bad nodes
-// which is not responsible for our request in the past
-func (r *RPC) refreshURLList(url string) {
-	httpFailCnt[url]++
-	if httpFailCnt[url] <= threshold {
-		return
-	}
-
-	// Pick url list following NetType
-	var p *[]string
-	switch r.NetType {
-	case Mainnet:
-		p = &MainnetUrls
-		break
-	case Testnet:
-		p = &TestnetUrls
-		break
-	}
-
-	// Pick item will be deleted
-	delIdx := -1
-	for i, item := range *p {
-		if item == url {
-			delIdx = i
-			break
-		}
-	}
-
-	// Ignore if this url is already removed or not found
-	if delIdx >= availLen[r.NetType] || delIdx < 0 {
-		return
-	}
-
-	// Swap last item and the item will be deleted
-	l := availLen[r.NetType]
-	(*p)[l-1], (*p)[delIdx] = (*p)[delIdx], (*p)[l-1]
+// NodeStatus returns state of nodes in target net
+func (r *RPC) NodeStatus() []NodeStatus {
+	return r.pool.Status()
+}
 
-	// Decrease available length of url list
-	availLen[r.NetType]--
+// InitPool initializes node pool of target net, InitClient should be called first
+func (r *RPC) InitPool() {
+	r.pool = NewNodePool(nodeURLs(r.NetType), r.client)
 }
 
 // InitClient initializes HTTP client to reduce handshaking overhead
 func (r *RPC) InitClient() {
 	netTransport := &http.Transport{
-		Dial: (&net.Dialer{
+		DialContext: (&net.Dialer{
 			Timeout: time.Second * httpTimeout,
-		}).Dial,
+		}).DialContext,
 		TLSHandshakeTimeout: time.Second * httpTimeout,
 	}
 	r.client = &http.Client{
@@ -161,50 +173,224 @@ func (r *RPC) InitClient() {
 	}
 }
 
-// DoRPC invokes HTTP post request to ethereum node
-// Retry when fail, give penalty to low-latency node
+// DoRPC invokes HTTP post request to ethereum node without deadline
 func (r *RPC) DoRPC(req interface{}) (ret string, err error) {
-	// Get url following NetType
-	url := r.getURL()
+	return r.DoRPCContext(context.Background(), req)
+}
 
+// DoRPCContext invokes HTTP post request to ethereum node
+// req is either JSON string or RPCRequest, ctx bounds the whole call including retries.
+// Retry on another node with backoff when fail, only if every method is idempotent
+// or the request was not sent at all.
+// An internal error answered by the node is retried too, and the last one is returned as the response.
+func (r *RPC) DoRPCContext(ctx context.Context, req interface{}) (ret string, err error) {
 	// Validate request type
-	var msg string
-	switch req.(type) {
+	var msg []byte
+	var idempotent bool
+	switch v := req.(type) {
 	case string:
-		msg, _ = req.(string)
-		break
+		msg = []byte(v)
+		idempotent = isIdempotentMsg(v)
 	case ethjson.RPCRequest:
-		if marshal, e := json.Marshal(req); e == nil {
-			msg = string(marshal)
-			break
+		if msg, err = json.Marshal(v); err != nil {
+			return
 		}
+		idempotent = isIdempotent(v.Method)
 	default:
 		err = fmt.Errorf("Invalid req type")
 		return
 	}
 
-	// HTTP request
-	reqBody := bytes.NewBufferString(msg)
-	var resp *http.Response
-	var respBody []byte
+	backoff := retryBackoff
 	for i := 0; i < retryCnt; i++ {
-		resp, err = r.client.Post(url, ContentType, reqBody)
-		if err != nil {
-			r.refreshURLList(url)
-			continue
+		if i > 0 {
+			// Wait before retry, but not longer than caller allows
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(backoff + time.Duration(rand.Int63n(int64(backoff)))):
+			}
+			backoff *= 2
 		}
-		respBody, err = ioutil.ReadAll(resp.Body)
+
+		// Get url following NetType
+		url := r.getURL()
+		var respBody []byte
+		var sent bool
+		respBody, sent, err = r.post(ctx, url, msg)
 		if err == nil {
+			return string(respBody), nil
+		}
+		if ctx.Err() != nil {
+			// Caller gave up, not a fault of the node
+			return "", ctx.Err()
+		}
+		r.pool.ReportFailure(url, err)
+		if sent && !idempotent {
 			break
 		}
 	}
-	if len(respBody) == 0 {
-		return
+	// The last internal error of the node is returned as it answered
+	if nodeErr, ok := err.(*internalNodeError); ok {
+		return string(nodeErr.body), nil
 	}
+	return "", err
+}
+
+// post sends msg to url with a fresh body,
+// sent is false only if the request didn't reach the node
+func (r *RPC) post(ctx context.Context, url string, msg []byte) (respBody []byte, sent bool, err error) {
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, false, err
+	}
+	httpReq.Header.Set("Content-Type", ContentType)
+	httpReq = httpReq.WithContext(ctx)
+
+	start := time.Now()
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return nil, !isDialError(err), err
+	}
+	defer resp.Body.Close()
+	if respBody, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, true, err
+	}
+	if err = checkNodeResponse(resp.StatusCode, respBody); err != nil {
+		return nil, true, err
+	}
+	r.pool.ReportSuccess(url, time.Since(start))
+	return respBody, true, nil
+}
+
+func isDialError(err error) bool {
+	if urlErr, ok := err.(*neturl.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// isIdempotent returns true if the method can be sent again safely
+func isIdempotent(method string) bool {
+	return idempotentMethods[method]
+}
+
+func isIdempotentMsg(msg string) bool {
+	if ethjson.IsBatch(msg) {
+		reqs, rpcErr := ethjson.GetRPCBatchFromJSON(msg)
+		if rpcErr != nil {
+			return false
+		}
+		for _, req := range reqs {
+			if !isIdempotent(req.Method) {
+				return false
+			}
+		}
+		return true
+	}
+	req, _ := ethjson.GetRPCRequestFromJSON(msg)
+	return isIdempotent(req.Method)
+}
+
+// checkNodeResponse returns error if the node itself is at fault,
+// error of a request such as revert is not a fault of the node
+func checkNodeResponse(statusCode int, body []byte) error {
+	if statusCode >= 500 || statusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("HTTP status %d", statusCode)
+	}
+	var resps []ethjson.RPCResponse
+	if ethjson.IsBatch(string(body)) {
+		var err error
+		if resps, err = ethjson.GetRPCBatchResponseFromJSON(string(body)); err != nil {
+			return fmt.Errorf("Invalid response")
+		}
+	} else {
+		var resp ethjson.RPCResponse
+		if err := json.Unmarshal(body, &resp); err != nil || resp.Jsonrpc == "" {
+			return fmt.Errorf("Invalid response")
+		}
+		resps = append(resps, resp)
+	}
+	for _, resp := range resps {
+		if resp.Error != nil && resp.Error.Code == ethjson.ErrCodeInternal {
+			return &internalNodeError{message: resp.Error.Message, body: body}
+		}
+	}
+	return nil
+}
 
-	ret = string(respBody)
-	resp.Body.Close()
-	return
+// internalNodeError is an internal error answered by the node, body is its response
+type internalNodeError struct {
+	message string
+	body    []byte
+}
+
+func (e *internalNodeError) Error() string {
+	return e.message
+}
+
+// DoRPCBatch sends requests to ethereum node as one JSON-RPC batch without deadline
+func (r *RPC) DoRPCBatch(reqs []ethjson.RPCRequest) ([]ethjson.RPCResponse, error) {
+	return r.DoRPCBatchContext(context.Background(), reqs)
+}
+
+// DoRPCBatchContext sends requests to ethereum node as one JSON-RPC batch
+// Responses are returned in the order of requests with their original IDs
+func (r *RPC) DoRPCBatchContext(ctx context.Context, reqs []ethjson.RPCRequest) ([]ethjson.RPCResponse, error) {
+	// Node may reorder batch responses, so number requests sequentially
+	batch := make([]ethjson.RPCRequest, len(reqs))
+	for i, req := range reqs {
+		batch[i] = req
+		batch[i].ID = i + 1
+	}
+	marshal, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := r.DoRPCContext(ctx, string(marshal))
+	if err != nil {
+		return nil, err
+	}
+	if respBody == "" {
+		return nil, fmt.Errorf("Empty response from node")
+	}
+	batchResp, err := ethjson.GetRPCBatchResponseFromJSON(respBody)
+	if err != nil {
+		// Node may answer a whole batch with single error object
+		resp := ethjson.GetRPCResponseFromJSON(respBody)
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s", resp.Error.Message)
+		}
+		return nil, err
+	}
+
+	ret := make([]ethjson.RPCResponse, len(reqs))
+	found := make([]bool, len(reqs))
+	for _, resp := range batchResp {
+		id, e := strconv.Atoi(fmt.Sprint(resp.ID))
+		idx := id - 1
+		if e != nil || idx < 0 || idx >= len(reqs) || found[idx] {
+			continue
+		}
+		resp.ID = reqs[idx].ID
+		ret[idx] = resp
+		found[idx] = true
+	}
+	for i := range ret {
+		if !found[i] {
+			ret[i] = ethjson.RPCResponse{
+				Jsonrpc: reqs[i].Jsonrpc,
+				ID:      reqs[i].ID,
+				Error: &ethjson.RPCError{
+					Code:    ethjson.ErrCodeServer,
+					Message: "No response from node",
+				},
+			}
+		}
+	}
+	return ret, nil
 }
 
 func initRPCRequest(method string) ethjson.RPCRequest {
@@ -241,7 +427,7 @@ func (r *RPC) GetChainID() *big.Int {
 	if netVersion, err := r.DoRPC(req); err == nil {
 		resp := ethjson.GetRPCResponseFromJSON(netVersion)
 		offset, base := common.FindOffsetNBase(resp.Result.(string))
-		if chainID, ok := zero.SetString(resp.Result.(string)[offset:], base); ok {
+		if chainID, ok := new(big.Int).SetString(resp.Result.(string)[offset:], base); ok {
 			return chainID
 		}
 	}
@@ -254,7 +440,7 @@ func (r *RPC) GetGasPrice() uint64 {
 	if gasPrice, err := r.DoRPC(req); err == nil {
 		resp := ethjson.GetRPCResponseFromJSON(gasPrice)
 		offset, base := common.FindOffsetNBase(resp.Result.(string))
-		if uint64GasPrice, ok := zero.SetString(resp.Result.(string)[offset:], base); ok {
+		if uint64GasPrice, ok := new(big.Int).SetString(resp.Result.(string)[offset:], base); ok {
 			return uint64GasPrice.Uint64()
 		}
 	}
@@ -263,17 +449,30 @@ func (r *RPC) GetGasPrice() uint64 {
 
 // GetTransactionCount invokes RPC "eth_getTransactionCount"
 func (r *RPC) GetTransactionCount(addr string) uint64 {
+	nonce, _ := r.GetTransactionCountAt(addr, "latest")
+	return nonce
+}
+
+// GetTransactionCountAt invokes RPC "eth_getTransactionCount" at the block such as "latest" and "pending"
+func (r *RPC) GetTransactionCountAt(addr, block string) (uint64, error) {
 	req := initRPCRequest("eth_getTransactionCount")
 	req.Params = append(req.Params, addr)
-	req.Params = append(req.Params, "latest")
-	if retStr, txCntErr := r.DoRPC(req); txCntErr == nil {
-		resp := ethjson.GetRPCResponseFromJSON(retStr)
-		offset, base := common.FindOffsetNBase(resp.Result.(string))
-		if txNonce, ok := zero.SetString(resp.Result.(string)[offset:], base); ok {
-			return txNonce.Uint64()
-		}
+	req.Params = append(req.Params, block)
+	retStr, err := r.DoRPC(req)
+	if err != nil {
+		return 0, err
 	}
-	return 0
+	resp := ethjson.GetRPCResponseFromJSON(retStr)
+	if resp.Error != nil {
+		return 0, resp.Error
+	}
+	result, _ := resp.Result.(string)
+	offset, base := common.FindOffsetNBase(result)
+	txNonce, ok := new(big.Int).SetString(result[offset:], base)
+	if !ok {
+		return 0, fmt.Errorf("Invalid transaction count %q", result)
+	}
+	return txNonce.Uint64(), nil
 }
 
 // SendTransaction invokes RPC "eth_sendTransaction"
@@ -295,3 +494,116 @@ func (r *RPC) SendRawTransaction(raw []byte) (string, error) {
 	req.Params = append(req.Params, hexutil.Encode(raw))
 	return r.DoRPC(req)
 }
+
+// Receipt is a result of "eth_getTransactionReceipt"
+type Receipt struct {
+	TxHash      ethcommon.Hash  `json:"transactionHash"`
+	BlockHash   ethcommon.Hash  `json:"blockHash"`
+	BlockNumber hexutil.Uint64  `json:"blockNumber"`
+	GasUsed     hexutil.Uint64  `json:"gasUsed"`
+	Status      *hexutil.Uint64 `json:"status"`
+	Logs        []*types.Log    `json:"logs"`
+}
+
+// Failed returns true if the TX is reverted
+func (r *Receipt) Failed() bool {
+	return r.Status != nil && *r.Status == 0
+}
+
+// Transaction is a result of "eth_getTransactionByHash"
+type Transaction struct {
+	Hash        ethcommon.Hash     `json:"hash"`
+	From        ethcommon.Address  `json:"from"`
+	To          *ethcommon.Address `json:"to"`
+	Input       hexutil.Bytes      `json:"input"`
+	Value       *hexutil.Big       `json:"value"`
+	Gas         hexutil.Uint64     `json:"gas"`
+	GasPrice    *hexutil.Big       `json:"gasPrice"`
+	Nonce       hexutil.Uint64     `json:"nonce"`
+	BlockNumber *hexutil.Uint64    `json:"blockNumber"`
+}
+
+// CallArgs is a message of "eth_call"
+type CallArgs struct {
+	From     ethcommon.Address  `json:"from"`
+	To       *ethcommon.Address `json:"to"`
+	Gas      hexutil.Uint64     `json:"gas,omitempty"`
+	GasPrice *hexutil.Big       `json:"gasPrice,omitempty"`
+	Value    *hexutil.Big       `json:"value,omitempty"`
+	Data     hexutil.Bytes      `json:"data"`
+}
+
+// callResult invokes the request and decodes its result to "ret"
+// It returns false if the result is null
+func (r *RPC) callResult(ctx context.Context, req ethjson.RPCRequest, ret interface{}) (bool, error) {
+	body, err := r.DoRPCContext(ctx, req)
+	if err != nil {
+		return false, err
+	}
+	var resp struct {
+		Result json.RawMessage   `json:"result"`
+		Error  *ethjson.RPCError `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		return false, err
+	}
+	if resp.Error != nil {
+		return false, resp.Error
+	}
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return false, nil
+	}
+	return true, json.Unmarshal(resp.Result, ret)
+}
+
+// GetTransactionReceipt invokes RPC "eth_getTransactionReceipt"
+// It returns nil without error if the TX is not mined
+func (r *RPC) GetTransactionReceipt(hash string) (*Receipt, error) {
+	req := initRPCRequest("eth_getTransactionReceipt")
+	req.Params = append(req.Params, hash)
+	receipt := new(Receipt)
+	if ok, err := r.callResult(context.Background(), req, receipt); !ok {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// GetTransactionByHash invokes RPC "eth_getTransactionByHash"
+// It returns nil without error if the TX is unknown to the node
+func (r *RPC) GetTransactionByHash(hash string) (*Transaction, error) {
+	req := initRPCRequest("eth_getTransactionByHash")
+	req.Params = append(req.Params, hash)
+	tx := new(Transaction)
+	if ok, err := r.callResult(context.Background(), req, tx); !ok {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// CallAt invokes RPC "eth_call" at the block such as "latest" and "0x10"
+// It returns RevertError if the call is reverted with revert data
+func (r *RPC) CallAt(args CallArgs, block string) ([]byte, error) {
+	return r.CallAtContext(context.Background(), args, block)
+}
+
+// CallAtContext is CallAt bounded by ctx
+func (r *RPC) CallAtContext(ctx context.Context, args CallArgs, block string) ([]byte, error) {
+	req := initRPCRequest("eth_call")
+	req.Params = append(req.Params, args)
+	req.Params = append(req.Params, block)
+	var ret hexutil.Bytes
+	if _, err := r.callResult(ctx, req, &ret); err != nil {
+		// Recent nodes return revert data in error
+		if rpcErr, ok := err.(*ethjson.RPCError); ok {
+			if b := revertData(rpcErr); b != nil {
+				return nil, NewRevertError(b)
+			}
+		}
+		return nil, err
+	}
+	// Old nodes return revert data as result
+	if IsRevertData(ret) {
+		return nil, NewRevertError(ret)
+	}
+	return ret, nil
+}
@@ -2,6 +2,10 @@ package rpc
 
 import (
 	"context"
+	stdjson "encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/metadium/go-delegator/json"
@@ -74,19 +78,6 @@ func BenchmarkHttpClient(b *testing.B) {
 	b.Logf("errCnt %d", errCnt)
 }
 
-func TestRefreshUrlList(t *testing.T) {
-	NetType = Testnet
-	r := GetInstance()
-	initLen := len(TestnetUrls)
-	target := TestnetUrls[0]
-	for i := 0; i < 30; i++ {
-		r.refreshURLList(target)
-	}
-	if (initLen - 1) != availLen[Testnet] {
-		t.Errorf("refreshUrlList is abnormal")
-	}
-}
-
 func TestCall(t *testing.T) {
 	NetType = Testnet
 	r := GetInstance()
@@ -116,7 +107,7 @@ func TestRpc(t *testing.T) {
 	}
 
 	// Test with RpcRequest param
-	testRPCRequest := json.GetRPCRequestFromJSON(testMsg)
+	testRPCRequest, _ := json.GetRPCRequestFromJSON(testMsg)
 	if _, err := r.DoRPC(testRPCRequest); err != nil {
 		t.Errorf("Failed to RPC with RpcRequest: %s", err)
 	}
@@ -131,3 +122,40 @@ func BenchmarkRpc(b *testing.B) {
 		r.DoRPC(testMsg)
 	}
 }
+
+func TestDoRPCBatch(t *testing.T) {
+	// Fake node answers in reverse order
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []json.RPCRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		stdjson.Unmarshal(body, &reqs)
+		var resps []json.RPCResponse
+		for i := len(reqs) - 1; i >= 0; i-- {
+			resps = append(resps, json.RPCResponse{Jsonrpc: "2.0", ID: reqs[i].ID, Result: reqs[i].Method})
+		}
+		w.Write([]byte(json.BatchString(resps)))
+	}))
+	defer srv.Close()
+
+	urls := TestnetUrls
+	defer func() { TestnetUrls = urls }()
+	TestnetUrls = []string{srv.URL}
+
+	r := &RPC{NetType: Testnet}
+	r.InitClient()
+	r.InitPool()
+	reqs := []json.RPCRequest{
+		{Jsonrpc: "2.0", Method: "eth_blockNumber", ID: 7},
+		{Jsonrpc: "2.0", Method: "net_version", ID: 7},
+		{Jsonrpc: "2.0", Method: "eth_gasPrice", ID: "abc"},
+	}
+	resps, err := r.DoRPCBatch(reqs)
+	if err != nil {
+		t.Fatalf("Failed to RPC batch: %s", err)
+	}
+	for i, resp := range resps {
+		if resp.ID != reqs[i].ID || resp.Result != reqs[i].Method {
+			t.Errorf("Batch response %d mismatch: %v", i, resp.String())
+		}
+	}
+}
@@ -6,5 +6,11 @@ var MainnetUrls = []string{""}
 // TestnetUrls is a URL list for testnet
 var TestnetUrls = []string{"REPLACE WITH YOUR NODE URL #1", "REPLACE WITH YOUR NODE URL #2"} //ex.  "https://api.metadium.com/dev"
 
+// MainnetWsUrls is a WebSocket URL list for mainnet
+var MainnetWsUrls = []string{""}
+
+// TestnetWsUrls is a WebSocket URL list for testnet, used for eth_subscribe
+var TestnetWsUrls = []string{"REPLACE WITH YOUR NODE WEBSOCKET URL #1"} //ex.  "wss://api.metadium.com/dev/ws"
+
 // ContentType is a content-type for JSON-RPC
 const ContentType = "application/json"
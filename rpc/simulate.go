@@ -0,0 +1,104 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	ethjson "github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Estimated gas is raised by this percent, state can change until the TX is mined
+var gasMarginPercent uint64 = 20
+
+// EstimateGas invokes RPC "eth_estimateGas"
+// It returns RevertError if the call always fails
+func (r *RPC) EstimateGas(args CallArgs) (uint64, error) {
+	return r.EstimateGasContext(context.Background(), args)
+}
+
+// EstimateGasContext is EstimateGas bounded by ctx
+func (r *RPC) EstimateGasContext(ctx context.Context, args CallArgs) (uint64, error) {
+	req := initRPCRequest("eth_estimateGas")
+	req.Params = append(req.Params, args)
+	var gas hexutil.Uint64
+	if _, err := r.callResult(ctx, req, &gas); err != nil {
+		if rpcErr, ok := err.(*ethjson.RPCError); ok {
+			if b := revertData(rpcErr); b != nil {
+				return 0, NewRevertError(b)
+			}
+			// Old nodes fail without revert data
+			if strings.Contains(rpcErr.Message, "always failing transaction") {
+				return 0, &RevertError{}
+			}
+		}
+		return 0, err
+	}
+	return uint64(gas), nil
+}
+
+// Simulate runs the TX as a call at the pending block, and estimates its gas with a margin
+// Gas of args is the limit of the estimation
+// It returns RevertError if the TX would revert
+func (r *RPC) Simulate(args CallArgs) (uint64, error) {
+	return r.SimulateContext(context.Background(), args)
+}
+
+// SimulateContext is Simulate bounded by ctx
+func (r *RPC) SimulateContext(ctx context.Context, args CallArgs) (uint64, error) {
+	if _, err := r.CallAtContext(ctx, args, "pending"); err != nil {
+		return 0, err
+	}
+	gas, err := r.EstimateGasContext(ctx, args)
+	if err != nil {
+		return 0, err
+	}
+	gas = WithMargin(gas)
+	if args.Gas != 0 && gas > uint64(args.Gas) {
+		gas = uint64(args.Gas)
+	}
+	return gas, nil
+}
+
+// WithMargin returns the estimated gas raised by the margin
+func WithMargin(gas uint64) uint64 {
+	return gas + gas*gasMarginPercent/100
+}
+
+// Simulated returns the options which simulate the TX before signing it,
+// GasLimit of opts is the limit of the estimation and Context of opts bounds the simulation
+// A TX which would revert is not signed nor sent, the contract call returns RevertError
+func (r *RPC) Simulated(opts *bind.TransactOpts) *bind.TransactOpts {
+	sign := opts.Signer
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	simulated := *opts
+	simulated.Signer = func(signer types.Signer, from ethcommon.Address, tx *types.Transaction) (*types.Transaction, error) {
+		args := CallArgs{
+			From:  from,
+			To:    tx.To(),
+			Gas:   hexutil.Uint64(tx.Gas()),
+			Value: (*hexutil.Big)(tx.Value()),
+			Data:  tx.Data(),
+		}
+		gas, err := r.SimulateContext(ctx, args)
+		if err != nil {
+			log.Warn("Simulation failed, TX is not sent: ", err)
+			return nil, err
+		}
+		if tx.To() == nil {
+			tx = types.NewContractCreation(tx.Nonce(), tx.Value(), gas, tx.GasPrice(), tx.Data())
+		} else {
+			tx = types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), gas, tx.GasPrice(), tx.Data())
+		}
+		return sign(signer, from, tx)
+	}
+	return &simulated
+}
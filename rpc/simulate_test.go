@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/metadium/go-delegator/json"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// simulationNode answers eth_call and eth_estimateGas with given responses in JSON
+func simulationNode(call, estimate string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, _ := json.GetRPCRequestFromJSON(string(body))
+		resp := call
+		if req.Method == "eth_estimateGas" {
+			resp = estimate
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,` + resp + `}`))
+	}))
+}
+
+func TestSimulate(t *testing.T) {
+	tests := []struct {
+		call, estimate string
+		limit, gas     uint64
+		reason         string
+		reverted       bool
+	}{
+		{`"result":"0x"`, `"result":"0x5208"`, 0, 25200, "", false},
+		// Margin is limited
+		{`"result":"0x"`, `"result":"0x5208"`, 22000, 22000, "", false},
+		// Old nodes return revert data as result
+		{`"result":"` + testRevertData + `"`, `"result":"0x5208"`, 0, 0, "Not authorized", true},
+		{`"error":{"code":3,"message":"execution reverted","data":"` + testRevertData + `"}`, `"result":"0x5208"`, 0, 0, "Not authorized", true},
+		// Revert without reason on old nodes
+		{`"result":"0x"`, `"error":{"code":-32000,"message":"gas required exceeds allowance or always failing transaction"}`, 0, 0, "", true},
+	}
+	for i, test := range tests {
+		node := simulationNode(test.call, test.estimate)
+		gas, err := newTestRPC(node.URL).Simulate(CallArgs{Gas: hexutil.Uint64(test.limit)})
+		node.Close()
+		revertErr, reverted := err.(*RevertError)
+		if reverted != test.reverted || (reverted && revertErr.Reason != test.reason) || (!reverted && err != nil) {
+			t.Errorf("%d: unexpected error %v", i, err)
+		}
+		if gas != test.gas {
+			t.Errorf("%d: expected gas %d, got %d", i, test.gas, gas)
+		}
+	}
+}
+
+func TestSimulated(t *testing.T) {
+	var signed *types.Transaction
+	opts := &bind.TransactOpts{
+		Signer: func(signer types.Signer, from ethcommon.Address, tx *types.Transaction) (*types.Transaction, error) {
+			signed = tx
+			return tx, nil
+		},
+	}
+	to := ethcommon.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")
+	tx := types.NewTransaction(1, to, big.NewInt(0), 2000000, big.NewInt(1000), []byte{1})
+
+	node := simulationNode(`"result":"0x"`, `"result":"0x5208"`)
+	defer node.Close()
+	if _, err := newTestRPC(node.URL).Simulated(opts).Signer(types.HomesteadSigner{}, ethcommon.Address{}, tx); err != nil {
+		t.Fatal(err)
+	}
+	if signed == nil || signed.Gas() != 25200 || signed.Nonce() != 1 || *signed.To() != to {
+		t.Errorf("Expected TX with estimated gas, got %v", signed)
+	}
+
+	// Reverted TX is not signed
+	signed = nil
+	reverting := simulationNode(`"result":"`+testRevertData+`"`, `"result":"0x5208"`)
+	defer reverting.Close()
+	if _, err := newTestRPC(reverting.URL).Simulated(opts).Signer(types.HomesteadSigner{}, ethcommon.Address{}, tx); err == nil || signed != nil {
+		t.Errorf("Expected revert without signing, got %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/log"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// Timeout to dial and subscribe to node
+	wsTimeout = time.Second * httpTimeout
+	// Backoff range to reconnect to node
+	wsMinBackoff = time.Second
+	wsMaxBackoff = time.Second * 30
+
+	// For singleton
+	subManager     *SubscriptionManager
+	subManagerOnce sync.Once
+)
+
+// NotifyFunc delivers a subscription result to a client.
+// It must not block, results are forwarded to every client of an upstream in turn
+type NotifyFunc func(subID string, result json.RawMessage)
+
+// SubscriptionManager multiplexes client subscriptions onto upstream node subscriptions.
+// Clients subscribing with the same params share one upstream subscription,
+// which is re-established on another node when the connection drops.
+// Nodes are dialed and subscribed to without holding mu, so a slow node doesn't block other clients
+type SubscriptionManager struct {
+	urls []string
+
+	// dialMu serializes dialing, so one connection is shared
+	dialMu sync.Mutex
+
+	mu           sync.Mutex
+	conn         *wsConn
+	urlIdx       int
+	reconnecting bool
+	closed       bool
+	// params JSON => upstream subscription
+	upstreams map[string]*upstream
+	// client subscription ID => params JSON
+	subs map[string]string
+}
+
+// wsConn is a connection to node, done is closed with the connection
+type wsConn struct {
+	client *ethrpc.Client
+	done   chan struct{}
+}
+
+type upstream struct {
+	params  []interface{}
+	conn    *wsConn
+	sub     *ethrpc.ClientSubscription
+	clients map[string]NotifyFunc
+	// ready is closed once subscribed to node, err is set if failed
+	ready chan struct{}
+	err   error
+	// removed is set with its last client gone
+	removed bool
+}
+
+// subscribed returns true if the upstream is subscribed to node, mu must be held
+func (up *upstream) subscribed() bool {
+	select {
+	case <-up.ready:
+		return up.err == nil
+	default:
+		return false
+	}
+}
+
+// GetSubscriptionManager returns the subscription manager for NetType
+func GetSubscriptionManager() *SubscriptionManager {
+	subManagerOnce.Do(func() {
+		switch NetType {
+		case Mainnet:
+			subManager = NewSubscriptionManager(MainnetWsUrls)
+		default:
+			subManager = NewSubscriptionManager(TestnetWsUrls)
+		}
+	})
+	return subManager
+}
+
+// NewSubscriptionManager returns a subscription manager using given node WebSocket URLs
+func NewSubscriptionManager(urls []string) *SubscriptionManager {
+	return &SubscriptionManager{
+		urls:      urls,
+		upstreams: make(map[string]*upstream),
+		subs:      make(map[string]string),
+	}
+}
+
+func newSubscriptionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hexutil.Encode(b)
+}
+
+// Subscribe subscribes to node with eth_subscribe params such as ["newHeads"]
+// and returns a subscription ID owned by the delegator, not by the node
+func (m *SubscriptionManager) Subscribe(params []interface{}, notify NotifyFunc) (string, error) {
+	if len(params) == 0 {
+		return "", fmt.Errorf("Subscription name is missing")
+	}
+	key, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return "", fmt.Errorf("Subscription manager is closed")
+		}
+		up := m.upstreams[string(key)]
+		if up == nil {
+			// Other clients of the params wait until subscribed
+			up = &upstream{
+				params:  params,
+				clients: make(map[string]NotifyFunc),
+				ready:   make(chan struct{}),
+			}
+			m.upstreams[string(key)] = up
+			m.mu.Unlock()
+
+			err := m.subscribe(up)
+			m.mu.Lock()
+			if up.err = err; err != nil && m.upstreams[string(key)] == up {
+				delete(m.upstreams, string(key))
+			}
+			close(up.ready)
+		}
+		m.mu.Unlock()
+
+		<-up.ready
+		if up.err != nil {
+			return "", up.err
+		}
+		m.mu.Lock()
+		if up.removed {
+			// Its last client is gone while waiting, subscribe again
+			m.mu.Unlock()
+			continue
+		}
+		subID := newSubscriptionID()
+		up.clients[subID] = notify
+		m.subs[subID] = string(key)
+		m.mu.Unlock()
+		return subID, nil
+	}
+}
+
+// Unsubscribe removes a client subscription,
+// upstream subscription is removed with its last client
+func (m *SubscriptionManager) Unsubscribe(subID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.subs[subID]
+	if !ok {
+		return false
+	}
+	delete(m.subs, subID)
+
+	up := m.upstreams[key]
+	delete(up.clients, subID)
+	if len(up.clients) == 0 {
+		delete(m.upstreams, key)
+		up.removed = true
+		if up.sub != nil {
+			go up.sub.Unsubscribe()
+		}
+	}
+	return true
+}
+
+// Close closes the node connection and all subscriptions
+func (m *SubscriptionManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.closeConnLocked()
+}
+
+// connect returns the node connection, dialing nodes in turn if there is none
+func (m *SubscriptionManager) connect() (*wsConn, error) {
+	if len(m.urls) == 0 {
+		return nil, fmt.Errorf("No node WebSocket URL")
+	}
+	m.dialMu.Lock()
+	defer m.dialMu.Unlock()
+
+	var err error
+	for i := 0; i < len(m.urls); i++ {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("Subscription manager is closed")
+		}
+		if m.conn != nil {
+			conn := m.conn
+			m.mu.Unlock()
+			return conn, nil
+		}
+		url := m.urls[m.urlIdx%len(m.urls)]
+		m.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), wsTimeout)
+		var client *ethrpc.Client
+		client, err = ethrpc.DialContext(ctx, url)
+		cancel()
+		if err == nil {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.closed {
+				client.Close()
+				return nil, fmt.Errorf("Subscription manager is closed")
+			}
+			m.conn = &wsConn{client: client, done: make(chan struct{})}
+			return m.conn, nil
+		}
+		log.Errorf("Failed to dial %s: %v", url, err)
+		m.mu.Lock()
+		m.urlIdx++
+		m.mu.Unlock()
+	}
+	return nil, err
+}
+
+func (m *SubscriptionManager) closeConnLocked() {
+	if m.conn == nil {
+		return
+	}
+	close(m.conn.done)
+	m.conn.client.Close()
+	m.conn = nil
+}
+
+// subscribe subscribes the upstream to node, mu must not be held
+func (m *SubscriptionManager) subscribe(up *upstream) error {
+	conn, err := m.connect()
+	if err != nil {
+		return err
+	}
+	ch := make(chan json.RawMessage)
+	ctx, cancel := context.WithTimeout(context.Background(), wsTimeout)
+	defer cancel()
+	sub, err := conn.client.EthSubscribe(ctx, ch, up.params...)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if up.removed {
+		go sub.Unsubscribe()
+		return nil
+	}
+	up.conn, up.sub = conn, sub
+	go m.forward(conn, up, sub, ch)
+	return nil
+}
+
+// forward delivers results of an upstream subscription to its clients
+func (m *SubscriptionManager) forward(conn *wsConn, up *upstream, sub *ethrpc.ClientSubscription, ch chan json.RawMessage) {
+	for {
+		select {
+		case result := <-ch:
+			m.mu.Lock()
+			notifies := make(map[string]NotifyFunc, len(up.clients))
+			for subID, notify := range up.clients {
+				notifies[subID] = notify
+			}
+			m.mu.Unlock()
+			for subID, notify := range notifies {
+				notify(subID, result)
+			}
+		case err := <-sub.Err():
+			// nil error means unsubscribed by us
+			if err != nil {
+				log.Errorf("Upstream subscription dropped: %v", err)
+				m.reconnect(conn)
+			}
+			return
+		case <-conn.done:
+			return
+		}
+	}
+}
+
+// reconnect replaces a failed connection and re-subscribes in background
+func (m *SubscriptionManager) reconnect(failed *wsConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || m.conn != failed {
+		return
+	}
+	m.closeConnLocked()
+	// Try next node first
+	m.urlIdx++
+	if m.reconnecting {
+		// Re-subscribing finds the connection closed
+		return
+	}
+	m.reconnecting = true
+	go m.resubscribe()
+}
+
+func (m *SubscriptionManager) resubscribe() {
+	backoff := wsMinBackoff
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.reconnecting = false
+			m.mu.Unlock()
+			return
+		}
+		// Upstreams being subscribed by new clients are left to them
+		var stale []*upstream
+		for _, up := range m.upstreams {
+			if up.subscribed() && (m.conn == nil || up.conn != m.conn) {
+				stale = append(stale, up)
+			}
+		}
+		if len(stale) == 0 && m.conn != nil {
+			log.Infof("Re-subscribed %d subscriptions", len(m.upstreams))
+			m.reconnecting = false
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+
+		// New subscription may have connected already
+		_, err := m.connect()
+		for _, up := range stale {
+			if err != nil {
+				break
+			}
+			err = m.subscribe(up)
+		}
+		if err == nil {
+			// Check again for upstreams of the connection dropped meanwhile
+			continue
+		}
+		m.mu.Lock()
+		m.closeConnLocked()
+		m.mu.Unlock()
+
+		log.Errorf("Failed to re-subscribe: %v, retry after %v", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > wsMaxBackoff {
+			backoff = wsMaxBackoff
+		}
+	}
+}
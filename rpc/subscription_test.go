@@ -0,0 +1,165 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+// FakeEth emits an increasing number as "newHeads" every 10ms
+type FakeEth struct {
+	subscribed int32
+}
+
+func (f *FakeEth) NewHeads(ctx context.Context) (*ethrpc.Subscription, error) {
+	notifier, _ := ethrpc.NotifierFromContext(ctx)
+	sub := notifier.CreateSubscription()
+	atomic.AddInt32(&f.subscribed, 1)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-sub.Err():
+				return
+			case <-time.After(time.Millisecond * 10):
+				notifier.Notify(sub.ID, i)
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func startFakeWsNode(t *testing.T, addr string) (*ethrpc.Server, net.Listener, *FakeEth) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	eth := &FakeEth{}
+	srv := ethrpc.NewServer()
+	if err := srv.RegisterName("eth", eth); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	go http.Serve(ln, srv.WebsocketHandler([]string{"*"}))
+	return srv, ln, eth
+}
+
+func receive(t *testing.T, ch chan string, subID string) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case id := <-ch:
+			if id == subID {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("No notification for %s", subID)
+		}
+	}
+}
+
+func TestSubscriptionManager(t *testing.T) {
+	wsMinBackoff = time.Millisecond * 50
+	srv, ln, eth := startFakeWsNode(t, "127.0.0.1:0")
+	addr := ln.Addr().String()
+
+	m := NewSubscriptionManager([]string{"ws://" + addr})
+	defer m.Close()
+
+	ch := make(chan string, 100)
+	notify := func(subID string, result json.RawMessage) {
+		select {
+		case ch <- subID:
+		default:
+		}
+	}
+	sub1, err := m.Subscribe([]interface{}{"newHeads"}, notify)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	sub2, err := m.Subscribe([]interface{}{"newHeads"}, notify)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if sub1 == sub2 {
+		t.Fatalf("Client subscription IDs must be unique")
+	}
+	receive(t, ch, sub1)
+	receive(t, ch, sub2)
+	if n := atomic.LoadInt32(&eth.subscribed); n != 1 {
+		t.Errorf("Same params must share upstream subscription, got %d", n)
+	}
+
+	// Drop node and bring it back on the same address
+	srv.Stop()
+	ln.Close()
+	srv, ln, eth = startFakeWsNode(t, addr)
+	defer srv.Stop()
+	defer ln.Close()
+
+	// Client subscription IDs survive reconnect
+	receive(t, ch, sub1)
+	receive(t, ch, sub2)
+	if n := atomic.LoadInt32(&eth.subscribed); n != 1 {
+		t.Errorf("Upstream subscription must be re-established once, got %d", n)
+	}
+
+	if !m.Unsubscribe(sub1) || m.Unsubscribe(sub1) {
+		t.Errorf("Unsubscribe must succeed only once")
+	}
+	if len(m.upstreams) != 1 {
+		t.Errorf("Upstream subscription must remain for other client")
+	}
+	m.Unsubscribe(sub2)
+	if len(m.upstreams) != 0 {
+		t.Errorf("Upstream subscription must be removed with its last client")
+	}
+}
+
+func TestSubscriptionManagerSlowNode(t *testing.T) {
+	// Node accepting connections without handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	m := NewSubscriptionManager([]string{"ws://" + ln.Addr().String()})
+	defer m.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Subscribe([]interface{}{"newHeads"}, func(string, json.RawMessage) {})
+		done <- err
+	}()
+
+	// Other clients are not blocked while dialing
+	time.Sleep(time.Millisecond * 50)
+	unsubscribed := make(chan bool)
+	go func() { unsubscribed <- m.Unsubscribe("0x00") }()
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Errorf("Unsubscribe must not wait for dialing")
+	}
+	(<-accepted).Close()
+	if err := <-done; err == nil {
+		t.Errorf("Expected subscribing to fail")
+	}
+	if len(m.upstreams) != 0 {
+		t.Errorf("Upstream failed to subscribe must be removed")
+	}
+}
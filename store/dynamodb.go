@@ -0,0 +1,195 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/metadium/go-delegator/db"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDB attribute names
+//
+//	----------------------------------------
+//	|  Key (hash)  |  Value  |  Version    |
+//	----------------------------------------
+//	|  string      |  binary |  number     |
+//	----------------------------------------
+const (
+	dynamoKeyName     = "Key"
+	dynamoValueName   = "Value"
+	dynamoVersionName = "Version"
+)
+
+// Update retries when other process wrote the key in between
+var dynamoUpdateRetry = 10
+
+// DynamoDBStore is a Store on a DynamoDB table.
+// Update is a conditional write on version, so the store can be shared by processes
+type DynamoDBStore struct {
+	table  string
+	client *dynamodb.DynamoDB
+}
+
+// NewDynamoDBStore returns a store on the table, in the region of db.GetInstance
+func NewDynamoDBStore(table string) (*DynamoDBStore, error) {
+	helper := db.GetInstance("")
+	if helper == nil {
+		return nil, fmt.Errorf("store: DynamoDB is not available")
+	}
+	return &DynamoDBStore{table: table, client: helper.Client()}, nil
+}
+
+func (s *DynamoDBStore) key(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		dynamoKeyName: {S: aws.String(key)},
+	}
+}
+
+// get returns value and version of the key, version is 0 if not found
+func (s *DynamoDBStore) get(key string) ([]byte, int64, error) {
+	out, err := s.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            s.key(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if out.Item == nil || out.Item[dynamoValueName] == nil {
+		return nil, 0, ErrNotFound
+	}
+	var version int64
+	if v := out.Item[dynamoVersionName]; v != nil && v.N != nil {
+		version, _ = strconv.ParseInt(*v.N, 10, 64)
+	}
+	return out.Item[dynamoValueName].B, version, nil
+}
+
+// Get returns value of the key
+func (s *DynamoDBStore) Get(key string) ([]byte, error) {
+	value, _, err := s.get(key)
+	return value, err
+}
+
+func (s *DynamoDBStore) item(key string, value []byte, version int64) map[string]*dynamodb.AttributeValue {
+	item := s.key(key)
+	item[dynamoValueName] = &dynamodb.AttributeValue{B: value}
+	item[dynamoVersionName] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(version, 10))}
+	return item
+}
+
+// Put sets value of the key, and increases version so that Update in progress retries
+func (s *DynamoDBStore) Put(key string, value []byte) error {
+	_, err := s.client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:        aws.String(s.table),
+		Key:              s.key(key),
+		UpdateExpression: aws.String("SET #val = :value ADD #v :one"),
+		ExpressionAttributeNames: map[string]*string{
+			"#val": aws.String(dynamoValueName),
+			"#v":   aws.String(dynamoVersionName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":value": {B: value},
+			":one":   {N: aws.String("1")},
+		},
+	})
+	return err
+}
+
+// Delete removes the key
+func (s *DynamoDBStore) Delete(key string) error {
+	_, err := s.client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key:       s.key(key),
+	})
+	return err
+}
+
+// Iterate calls fn for keys having the prefix in key order
+func (s *DynamoDBStore) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	type kv struct {
+		key   string
+		value []byte
+	}
+	var items []kv
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(s.table),
+		FilterExpression:         aws.String("begins_with(#k, :prefix)"),
+		ExpressionAttributeNames: map[string]*string{"#k": aws.String(dynamoKeyName)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(prefix)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+	if prefix == "" {
+		input.FilterExpression = nil
+		input.ExpressionAttributeNames = nil
+		input.ExpressionAttributeValues = nil
+	}
+	err := s.client.ScanPages(input, func(out *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range out.Items {
+			if item[dynamoKeyName] == nil || item[dynamoKeyName].S == nil || item[dynamoValueName] == nil {
+				continue
+			}
+			items = append(items, kv{*item[dynamoKeyName].S, item[dynamoValueName].B})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+	for _, item := range items {
+		if !fn(item.key, item.value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Update replaces value of the key with the result of fn.
+// It writes only if version is unchanged since read, and retries otherwise
+func (s *DynamoDBStore) Update(key string, fn func(old []byte) ([]byte, error)) error {
+	for i := 0; i < dynamoUpdateRetry; i++ {
+		old, version, err := s.get(key)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		value, err := fn(old)
+		if err != nil {
+			return err
+		}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item:      s.item(key, value, version+1),
+		}
+		if version == 0 {
+			input.ConditionExpression = aws.String("attribute_not_exists(#k)")
+			input.ExpressionAttributeNames = map[string]*string{"#k": aws.String(dynamoKeyName)}
+		} else {
+			input.ConditionExpression = aws.String("#v = :version")
+			input.ExpressionAttributeNames = map[string]*string{"#v": aws.String(dynamoVersionName)}
+			input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+				":version": {N: aws.String(strconv.FormatInt(version, 10))},
+			}
+		}
+		_, err = s.client.PutItem(input)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("store: too many concurrent updates on %s", key)
+}
+
+// Close does nothing
+func (s *DynamoDBStore) Close() error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDBStore is a Store on LevelDB.
+// LevelDB locks its directory, so it can't be shared by processes
+type LevelDBStore struct {
+	db *leveldb.DB
+	// mu makes Update atomic in the process
+	mu sync.Mutex
+}
+
+// NewLevelDBStore opens or creates LevelDB at the path
+func NewLevelDBStore(path string) (*LevelDBStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBStore{db: db}, nil
+}
+
+// Get returns value of the key
+func (s *LevelDBStore) Get(key string) ([]byte, error) {
+	value, err := s.db.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+// Put sets value of the key
+func (s *LevelDBStore) Put(key string, value []byte) error {
+	return s.db.Put([]byte(key), value, nil)
+}
+
+// Delete removes the key
+func (s *LevelDBStore) Delete(key string) error {
+	return s.db.Delete([]byte(key), nil)
+}
+
+// Iterate calls fn for keys having the prefix in key order
+func (s *LevelDBStore) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	it := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer it.Release()
+	for it.Next() {
+		value := append([]byte(nil), it.Value()...)
+		if !fn(string(it.Key()), value) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+// Update replaces value of the key with the result of fn atomically
+func (s *LevelDBStore) Update(key string, fn func(old []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.Get(key)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	value, err := fn(old)
+	if err != nil {
+		return err
+	}
+	return s.Put(key, value)
+}
+
+// Close closes LevelDB
+func (s *LevelDBStore) Close() error {
+	return s.db.Close()
+}
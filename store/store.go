@@ -0,0 +1,48 @@
+// Package store is a key-value store keeping delegator state such as TX nonces
+package store
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNotFound is returned when the key doesn't exist
+var ErrNotFound = errors.New("store: not found")
+
+// Store is a key-value store
+type Store interface {
+	// Get returns value of the key, or ErrNotFound
+	Get(key string) ([]byte, error)
+	// Put sets value of the key
+	Put(key string, value []byte) error
+	// Delete removes the key, deleting a missing key is not an error
+	Delete(key string) error
+	// Iterate calls fn for keys having the prefix in key order until fn returns false
+	Iterate(prefix string, fn func(key string, value []byte) bool) error
+	// Update replaces value of the key with the result of fn atomically.
+	// old is nil if the key doesn't exist, and nothing is written if fn fails.
+	// A store shared by processes keeps it atomic across the processes
+	Update(key string, fn func(old []byte) ([]byte, error)) error
+	// Close releases the store
+	Close() error
+}
+
+// DynamoDBScheme is a prefix of Open location for DynamoDB table
+const DynamoDBScheme = "dynamodb://"
+
+// Open opens a store by location
+//
+//	memory              in-memory store, for test
+//	dynamodb://<table>  DynamoDB table, can be shared by several processes
+//	<path>              LevelDB at the path, only one process can open it
+func Open(location string) (Store, error) {
+	switch {
+	case location == "memory":
+		return NewMemoryStore(), nil
+	case strings.HasPrefix(location, DynamoDBScheme):
+		return NewDynamoDBStore(strings.TrimPrefix(location, DynamoDBScheme))
+	case location == "":
+		return nil, errors.New("store: location is empty")
+	}
+	return NewLevelDBStore(location)
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func testStore(t *testing.T, s Store) {
+	if _, err := s.Get("a"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	for _, key := range []string{"tx/2", "tx/1", "tx/3", "txx", "a"} {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if value, err := s.Get("tx/1"); err != nil || string(value) != "tx/1" {
+		t.Errorf("Failed to get: %s, %v", value, err)
+	}
+	s.Delete("tx/3")
+	s.Delete("missing")
+
+	var keys []string
+	s.Iterate("tx/", func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if fmt.Sprint(keys) != "[tx/1 tx/2]" {
+		t.Errorf("Expected keys in order with prefix, got %v", keys)
+	}
+
+	// Failed update writes nothing
+	s.Update("a", func(old []byte) ([]byte, error) {
+		return []byte("b"), fmt.Errorf("failed")
+	})
+	if value, _ := s.Get("a"); string(value) != "a" {
+		t.Errorf("Failed update must not write, got %s", value)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Update("cnt", func(old []byte) ([]byte, error) {
+				cnt, _ := strconv.Atoi(string(old))
+				return []byte(strconv.Itoa(cnt + 1)), nil
+			})
+		}()
+	}
+	wg.Wait()
+	if value, _ := s.Get("cnt"); string(value) != "50" {
+		t.Errorf("Update must be atomic, got %s", value)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestLevelDBStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open LevelDB: %v", err)
+	}
+	testStore(t, s)
+	s.Close()
+
+	// Persisted across open
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open LevelDB: %v", err)
+	}
+	defer s.Close()
+	if value, err := s.Get("cnt"); err != nil || string(value) != "50" {
+		t.Errorf("Value must persist, got %s, %v", value, err)
+	}
+}
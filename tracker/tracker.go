@@ -0,0 +1,525 @@
+// Package tracker records TXs sent by delegator and follows them until mined or dropped
+package tracker
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/rpc"
+	"github.com/metadium/go-delegator/store"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Status of a TX
+const (
+	// StatusPending means the TX is not mined yet
+	StatusPending = "pending"
+	// StatusMined means the TX is mined and succeeded
+	StatusMined = "mined"
+	// StatusReverted means the TX is mined and reverted
+	StatusReverted = "reverted"
+	// StatusDropped means the TX will never be mined, its nonce is used by other TX
+	StatusDropped = "dropped"
+)
+
+// For environment arguments
+const (
+	// TxStore is a location of store for TX records, refer to store.Open
+	TxStore = "TX_STORE"
+)
+
+// For TX store
+const (
+	// DefaultTxStorePath is a LevelDB path for TX records
+	DefaultTxStorePath = "txs"
+	// DefaultTxStoreTable is a DynamoDB table for TX records used in AWS lambda
+	DefaultTxStoreTable = "Transactions"
+)
+
+var (
+	// Interval to poll receipts of pending TXs
+	pollInterval = 5 * time.Second
+	// A TX unknown to the node for this long is dropped
+	dropTimeout = 30 * time.Minute
+	// A TX unknown while its nonce is used is dropped if it lasts this long,
+	// since each call can hit a node lagging behind the one found the nonce used
+	dropConfirmation = 30 * time.Second
+	// Records are removed after this long since the TX was sent
+	retention = 7 * 24 * time.Hour
+)
+
+// Backend is a node to follow TXs, rpc.RPC implements it
+type Backend interface {
+	GetTransactionReceipt(hash string) (*rpc.Receipt, error)
+	GetTransactionByHash(hash string) (*rpc.Transaction, error)
+	GetTransactionCountAt(addr, block string) (uint64, error)
+	CallAt(args rpc.CallArgs, block string) ([]byte, error)
+}
+
+// LogDecoder fills fields of the record, such as EIN, from logs of the mined TX
+type LogDecoder func(rec *Record, logs []*types.Log)
+
+// Record is a TX sent by delegator
+type Record struct {
+	Hash      string `json:"hash"`
+	RequestID uint64 `json:"request_id"`
+	Method    string `json:"method"`
+	EIN       string `json:"ein,omitempty"`
+	MetaID    string `json:"meta_id,omitempty"`
+	From      string `json:"from"`
+	Nonce     uint64 `json:"nonce"`
+	Gas       uint64 `json:"gas"`
+	GasPrice  string `json:"gas_price"`
+	// Replacements is hashes of TXs replacing this one with higher gas price, the latest last
+	Replacements []string `json:"replacements,omitempty"`
+
+	// MinedHash is the hash of the TX mined, this one or a replacement
+	MinedHash    string `json:"mined_hash,omitempty"`
+	Status       string `json:"status"`
+	BlockNumber  uint64 `json:"block_number,omitempty"`
+	GasUsed      uint64 `json:"gas_used,omitempty"`
+	RevertReason string `json:"revert_reason,omitempty"`
+	// ExecutionID is the ID of the MetaID execution requested by the TX mined, to be approved if not executed
+	ExecutionID string `json:"execution_id,omitempty"`
+	// ExecutionStatus is pending, executed or failed by events of the execution in the TX
+	ExecutionStatus string `json:"execution_status,omitempty"`
+	// NonceUsedAt is unix time none of TXs was found while the nonce was used, reset once any is found
+	NonceUsedAt int64 `json:"nonce_used_at,omitempty"`
+
+	SentAt    int64 `json:"sent_at"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// Final returns true if the status of the TX doesn't change any more
+func (r *Record) Final() bool {
+	return r.Status != StatusPending
+}
+
+// Tracker keeps records of TXs in a store and polls their receipts
+type Tracker struct {
+	store    store.Store
+	backend  Backend
+	decoders map[string]LogDecoder
+
+	mu   sync.Mutex
+	stop chan struct{}
+	once sync.Once
+}
+
+var (
+	// For singleton
+	instance *Tracker
+	once     sync.Once
+	decoders = make(map[string]LogDecoder)
+)
+
+func init() {
+	crypto.OnReplace(func(old, replacement *types.Transaction) {
+		// Nonce manager replaces TXs while rpc is initialized, which the tracker waits for
+		go func() {
+			if t := GetInstance(); t != nil {
+				if err := t.Replace(old.Hash().Hex(), replacement); err != nil {
+					log.Errorf("Failed to track replacement %s: %v", replacement.Hash().Hex(), err)
+				}
+			}
+		}()
+	})
+}
+
+// trackHooks are called when a TX starts to be tracked
+var trackHooks []func(rec *Record)
+
+// finalHooks are called when a TX gets its final status
+var finalHooks []func(rec *Record)
+
+// OnTrack registers fn called with the record of a TX sent, while the request sending it is handled.
+// It should be called in init, fn should not block
+func OnTrack(fn func(rec *Record)) {
+	trackHooks = append(trackHooks, fn)
+}
+
+// OnFinal registers fn called with the record of a TX mined, reverted or dropped.
+// It should be called in init, fn is called while polling and should not block
+func OnFinal(fn func(rec *Record)) {
+	finalHooks = append(finalHooks, fn)
+}
+
+// RegisterLogDecoder sets the decoder of logs for TXs of the method
+// It should be called in init of the package serving the method
+func RegisterLogDecoder(method string, decoder LogDecoder) {
+	decoders[method] = decoder
+}
+
+// GetInstance returns the tracker with the store given by TX_STORE,
+// or DynamoDB in AWS lambda and LevelDB otherwise
+// It returns nil if the store is not available
+func GetInstance() *Tracker {
+	once.Do(func() {
+		location := os.Getenv(TxStore)
+		if location == "" {
+			if os.Getenv(crypto.IsAwsLambda) != "" {
+				location = store.DynamoDBScheme + DefaultTxStoreTable
+			} else {
+				location = DefaultTxStorePath
+			}
+		}
+		s, err := store.Open(location)
+		if err != nil {
+			log.Error("Failed to open TX store, TXs are not tracked: ", err)
+			return
+		}
+		log.Info("TX store is set to ", location)
+		instance = NewTracker(s, rpc.GetInstance())
+		if os.Getenv(crypto.IsAwsLambda) == "" {
+			// Lambda polls a TX when its status is requested
+			instance.Start()
+		}
+	})
+	return instance
+}
+
+// Track records the TX sent by delegator for the request
+// ein or metaID is given if known before the TX is mined
+func Track(reqID uint64, method string, tx *types.Transaction, ein, metaID string) {
+	t := GetInstance()
+	if t == nil {
+		return
+	}
+	if _, err := t.Track(reqID, method, tx, ein, metaID); err != nil {
+		log.Errorfd(reqID, "Failed to track TX %s: %v", tx.Hash().Hex(), err)
+	}
+}
+
+// NewTracker returns a tracker keeping records in the store
+func NewTracker(s store.Store, backend Backend) *Tracker {
+	return &Tracker{
+		store:    s,
+		backend:  backend,
+		decoders: decoders,
+		stop:     make(chan struct{}),
+	}
+}
+
+func recordKey(hash string) string {
+	return "tx/" + strings.ToLower(hash)
+}
+
+func pendingKey(hash string) string {
+	return "pending/" + strings.ToLower(hash)
+}
+
+// replacementKey indexes a replacement TX to the hash of the record
+func replacementKey(hash string) string {
+	return "replacement/" + strings.ToLower(hash)
+}
+
+// sender returns the signer of the TX, homestead or EIP155 of any chain
+func sender(tx *types.Transaction) (string, error) {
+	signer := types.NewEIP155Signer(tx.ChainId())
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return "", err
+	}
+	return from.Hex(), nil
+}
+
+// Track records the signed TX as pending
+func (t *Tracker) Track(reqID uint64, method string, tx *types.Transaction, ein, metaID string) (*Record, error) {
+	from, err := sender(tx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	rec := &Record{
+		Hash:      tx.Hash().Hex(),
+		RequestID: reqID,
+		Method:    method,
+		EIN:       ein,
+		MetaID:    metaID,
+		From:      from,
+		Nonce:     tx.Nonce(),
+		Gas:       tx.Gas(),
+		GasPrice:  tx.GasPrice().String(),
+		Status:    StatusPending,
+		SentAt:    now,
+		UpdatedAt: now,
+	}
+	if err := t.put(rec); err != nil {
+		return nil, err
+	}
+	log.Debugfd(reqID, "Tracking TX %s of %s", rec.Hash, method)
+	for _, fn := range trackHooks {
+		fn(rec)
+	}
+	return rec, nil
+}
+
+func (t *Tracker) put(rec *Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := t.store.Put(recordKey(rec.Hash), b); err != nil {
+		return err
+	}
+	if rec.Final() {
+		return t.store.Delete(pendingKey(rec.Hash))
+	}
+	return t.store.Put(pendingKey(rec.Hash), []byte(rec.Hash))
+}
+
+func (t *Tracker) get(hash string) (*Record, error) {
+	b, err := t.store.Get(recordKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	rec := new(Record)
+	if err := json.Unmarshal(b, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// root returns the hash of the record the TX belongs to, itself unless it's a replacement
+func (t *Tracker) root(hash string) string {
+	if b, err := t.store.Get(replacementKey(hash)); err == nil {
+		return string(b)
+	}
+	return hash
+}
+
+// Replace adds the replacement of the TX with the same nonce to its record
+// A TX not tracked, such as no-op, is ignored
+func (t *Tracker) Replace(hash string, replacement *types.Transaction) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec, err := t.get(t.root(hash))
+	if err == store.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if rec.Final() {
+		return nil
+	}
+	if err := t.store.Put(replacementKey(replacement.Hash().Hex()), []byte(rec.Hash)); err != nil {
+		return err
+	}
+	rec.Replacements = append(rec.Replacements, replacement.Hash().Hex())
+	rec.GasPrice = replacement.GasPrice().String()
+	log.Infof("TX %s of %s is replaced by %s", hash, rec.Method, replacement.Hash().Hex())
+	return t.update(rec)
+}
+
+// Get returns the record of the TX, a pending TX is polled first
+// A replacement TX resolves to the record of the original one
+// It returns store.ErrNotFound if the TX is not sent by delegator
+func (t *Tracker) Get(hash string) (*Record, error) {
+	rec, err := t.get(t.root(hash))
+	if err != nil {
+		return nil, err
+	}
+	if !rec.Final() {
+		if err := t.poll(rec); err != nil {
+			log.Warnf("Failed to poll TX %s: %v", hash, err)
+		}
+	}
+	return rec, nil
+}
+
+// Start polls pending TXs periodically
+func (t *Tracker) Start() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.Poll()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops polling
+func (t *Tracker) Stop() {
+	t.once.Do(func() { close(t.stop) })
+}
+
+// Poll updates every pending TX, and removes old records
+func (t *Tracker) Poll() {
+	var hashes []string
+	t.store.Iterate("pending/", func(key string, value []byte) bool {
+		hashes = append(hashes, string(value))
+		return true
+	})
+	for _, hash := range hashes {
+		rec, err := t.get(hash)
+		if err == store.ErrNotFound {
+			t.store.Delete(pendingKey(hash))
+			continue
+		} else if err != nil {
+			log.Warnf("Failed to load TX %s: %v", hash, err)
+			continue
+		}
+		if err := t.poll(rec); err != nil {
+			log.Warnf("Failed to poll TX %s: %v", hash, err)
+		}
+	}
+	t.prune()
+}
+
+// prune removes final records sent before the retention
+func (t *Tracker) prune() {
+	expired := time.Now().Add(-retention).Unix()
+	var keys []string
+	t.store.Iterate("tx/", func(key string, value []byte) bool {
+		var rec Record
+		if json.Unmarshal(value, &rec) == nil && rec.Final() && rec.SentAt < expired {
+			keys = append(keys, key)
+			for _, hash := range rec.Replacements {
+				keys = append(keys, replacementKey(hash))
+			}
+		}
+		return true
+	})
+	for _, key := range keys {
+		t.store.Delete(key)
+	}
+}
+
+// poll updates the record with the receipt of the TX or its replacement
+func (t *Tracker) poll(rec *Record) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Replaced since loaded
+	if cur, err := t.get(rec.Hash); err == nil {
+		*rec = *cur
+	}
+	hashes := append([]string{rec.Hash}, rec.Replacements...)
+	var receipt *rpc.Receipt
+	for _, hash := range hashes {
+		r, err := t.backend.GetTransactionReceipt(hash)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			receipt = r
+			rec.MinedHash = hash
+			break
+		}
+	}
+	if receipt != nil {
+		rec.NonceUsedAt = 0
+		rec.BlockNumber = uint64(receipt.BlockNumber)
+		rec.GasUsed = uint64(receipt.GasUsed)
+		if receipt.Failed() {
+			rec.Status = StatusReverted
+			rec.RevertReason = t.revertReason(rec)
+		} else {
+			rec.Status = StatusMined
+			if decoder := t.decoders[rec.Method]; decoder != nil {
+				decoder(rec, receipt.Logs)
+			}
+		}
+		log.Infof("TX %s of %s is %s at block %d", rec.MinedHash, rec.Method, rec.Status, rec.BlockNumber)
+		return t.finalize(rec)
+	}
+
+	// Not mined, check if any of TXs can be mined any more
+	for _, hash := range hashes {
+		tx, err := t.backend.GetTransactionByHash(hash)
+		if err != nil {
+			return err
+		}
+		if tx != nil {
+			return t.nonceFound(rec)
+		}
+	}
+	latest, err := t.backend.GetTransactionCountAt(rec.From, "latest")
+	if err != nil {
+		return err
+	}
+	if latest > rec.Nonce {
+		// Nonce is used by other TX such as no-op, or the TX is mined but the node polled lags
+		if rec.NonceUsedAt == 0 {
+			rec.NonceUsedAt = time.Now().Unix()
+			return t.update(rec)
+		}
+		if time.Since(time.Unix(rec.NonceUsedAt, 0)) < dropConfirmation {
+			return nil
+		}
+		rec.Status = StatusDropped
+	} else if time.Since(time.Unix(rec.SentAt, 0)) > dropTimeout {
+		rec.Status = StatusDropped
+	} else {
+		// Nonce manager sends it again
+		return t.nonceFound(rec)
+	}
+	log.Warnf("TX %s of %s is dropped, nonce %d", rec.Hash, rec.Method, rec.Nonce)
+	return t.finalize(rec)
+}
+
+// nonceFound resets the nonce found used, as the TX or its nonce turns out pending
+func (t *Tracker) nonceFound(rec *Record) error {
+	if rec.NonceUsedAt == 0 {
+		return nil
+	}
+	rec.NonceUsedAt = 0
+	return t.update(rec)
+}
+
+// finalize stores the record with its final status and calls hooks
+func (t *Tracker) finalize(rec *Record) error {
+	if err := t.update(rec); err != nil {
+		return err
+	}
+	for _, fn := range finalHooks {
+		fn(rec)
+	}
+	return nil
+}
+
+func (t *Tracker) update(rec *Record) error {
+	rec.UpdatedAt = time.Now().Unix()
+	return t.put(rec)
+}
+
+// revertReason replays the reverted TX at its parent block
+func (t *Tracker) revertReason(rec *Record) string {
+	tx, err := t.backend.GetTransactionByHash(rec.MinedHash)
+	if err != nil || tx == nil {
+		return ""
+	}
+	args := rpc.CallArgs{
+		From:     tx.From,
+		To:       tx.To,
+		Gas:      tx.Gas,
+		GasPrice: tx.GasPrice,
+		Value:    tx.Value,
+		Data:     tx.Input,
+	}
+	block := "latest"
+	if rec.BlockNumber > 0 {
+		block = hexutil.EncodeUint64(rec.BlockNumber - 1)
+	}
+	_, err = t.backend.CallAt(args, block)
+	if revertErr, ok := err.(*rpc.RevertError); ok {
+		return revertErr.Reason
+	}
+	if rec.GasUsed == rec.Gas {
+		return "out of gas"
+	}
+	return ""
+}
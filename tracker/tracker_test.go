@@ -0,0 +1,297 @@
+package tracker
+
+import (
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metadium/go-delegator/rpc"
+	"github.com/metadium/go-delegator/store"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// fakeNode keeps TXs in the pool until mined by mine()
+type fakeNode struct {
+	mu       sync.Mutex
+	pool     map[string]*rpc.Transaction
+	receipts map[string]*rpc.Receipt
+	nonce    uint64
+	revert   []byte
+	calls    []string
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{pool: make(map[string]*rpc.Transaction), receipts: make(map[string]*rpc.Receipt)}
+}
+
+func (n *fakeNode) GetTransactionReceipt(hash string) (*rpc.Receipt, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.receipts[strings.ToLower(hash)], nil
+}
+
+func (n *fakeNode) GetTransactionByHash(hash string) (*rpc.Transaction, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.pool[strings.ToLower(hash)], nil
+}
+
+func (n *fakeNode) GetTransactionCountAt(addr, block string) (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.nonce, nil
+}
+
+func (n *fakeNode) CallAt(args rpc.CallArgs, block string) ([]byte, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls = append(n.calls, block)
+	if n.revert != nil {
+		return nil, rpc.NewRevertError(n.revert)
+	}
+	return nil, nil
+}
+
+func (n *fakeNode) send(tx *types.Transaction) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pool[strings.ToLower(tx.Hash().Hex())] = &rpc.Transaction{
+		Hash:  tx.Hash(),
+		To:    tx.To(),
+		Input: tx.Data(),
+		Gas:   hexutil.Uint64(tx.Gas()),
+		Nonce: hexutil.Uint64(tx.Nonce()),
+	}
+}
+
+func (n *fakeNode) mine(tx *types.Transaction, block, gasUsed, status uint64, logs ...*types.Log) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.receipts[strings.ToLower(tx.Hash().Hex())] = &rpc.Receipt{
+		TxHash:      tx.Hash(),
+		BlockNumber: hexutil.Uint64(block),
+		GasUsed:     hexutil.Uint64(gasUsed),
+		Status:      (*hexutil.Uint64)(&status),
+		Logs:        logs,
+	}
+	n.nonce = tx.Nonce() + 1
+}
+
+// revertData encodes Error(string)
+func revertData(reason string) []byte {
+	data := hexutil.MustDecode("0x08c379a0")
+	data = append(data, common.LeftPadBytes([]byte{0x20}, 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(reason))).Bytes(), 32)...)
+	return append(data, common.RightPadBytes([]byte(reason), (len(reason)+31)/32*32)...)
+}
+
+func newTestTx(t *testing.T, nonce uint64) *types.Transaction {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress("0x084f8293f1b047d3a217025b24cd7b5ace8fc657")
+	tx := types.NewTransaction(nonce, to, big.NewInt(0), 100000, big.NewInt(1000), []byte{1})
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(11)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestTracker(node *fakeNode) *Tracker {
+	return NewTracker(store.NewMemoryStore(), node)
+}
+
+func status(t *testing.T, tr *Tracker, tx *types.Transaction) *Record {
+	rec, err := tr.Get(tx.Hash().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestTrackerMined(t *testing.T) {
+	node := newFakeNode()
+	tr := newTestTracker(node)
+	tr.decoders = map[string]LogDecoder{
+		"create_identity": func(rec *Record, logs []*types.Log) {
+			rec.EIN = new(big.Int).SetBytes(logs[0].Topics[2].Bytes()).String()
+		},
+	}
+	var tracked, finals []*Record
+	defer func(hooks []func(*Record)) { trackHooks = hooks }(trackHooks)
+	defer func(hooks []func(*Record)) { finalHooks = hooks }(finalHooks)
+	OnTrack(func(rec *Record) { tracked = append(tracked, rec) })
+	OnFinal(func(rec *Record) { finals = append(finals, rec) })
+
+	tx := newTestTx(t, 3)
+	node.send(tx)
+	rec, err := tr.Track(7, "create_identity", tx, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := types.Sender(types.NewEIP155Signer(big.NewInt(11)), tx)
+	if rec.From != key.Hex() || rec.Nonce != 3 || rec.Gas != 100000 || rec.RequestID != 7 {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+	if len(tracked) != 1 || tracked[0].RequestID != 7 || tracked[0].Status != StatusPending {
+		t.Errorf("Expected track hook called once with the pending record, got %v", tracked)
+	}
+	if rec = status(t, tr, tx); rec.Status != StatusPending {
+		t.Errorf("Expected %s, got %s", StatusPending, rec.Status)
+	}
+
+	ein := common.BigToHash(big.NewInt(42))
+	node.mine(tx, 100, 50000, 1, &types.Log{Topics: []common.Hash{{}, {}, ein}})
+	tr.Poll()
+	rec = status(t, tr, tx)
+	if rec.Status != StatusMined || rec.BlockNumber != 100 || rec.GasUsed != 50000 || rec.EIN != "42" {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+	if len(finals) != 1 || finals[0].Status != StatusMined || finals[0].EIN != "42" {
+		t.Errorf("Expected final hook called once with the mined record, got %v", finals)
+	}
+
+	// Final record is not pending any more
+	pending := 0
+	tr.store.Iterate("pending/", func(key string, value []byte) bool {
+		pending++
+		return true
+	})
+	if pending != 0 {
+		t.Errorf("Expected no pending TX, got %d", pending)
+	}
+}
+
+func TestTrackerReverted(t *testing.T) {
+	node := newFakeNode()
+	tr := newTestTracker(node)
+
+	tx := newTestTx(t, 0)
+	node.send(tx)
+	tr.Track(1, "add_key_delegated", tx, "5", "")
+	node.revert = revertData("Must be a provider for the EIN.")
+	node.mine(tx, 16, 30000, 0)
+
+	rec := status(t, tr, tx)
+	if rec.Status != StatusReverted || rec.RevertReason != "Must be a provider for the EIN." || rec.EIN != "5" {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+	// Reverted TX is replayed at its parent block
+	if len(node.calls) != 1 || node.calls[0] != "0xf" {
+		t.Errorf("Expected call at 0xf, got %v", node.calls)
+	}
+
+	// TX without revert reason used up its gas
+	tx = newTestTx(t, 1)
+	node.send(tx)
+	tr.Track(2, "add_key_delegated", tx, "", "")
+	node.revert = nil
+	node.mine(tx, 17, tx.Gas(), 0)
+	if rec := status(t, tr, tx); rec.Status != StatusReverted || rec.RevertReason != "out of gas" {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+}
+
+func TestTrackerDropped(t *testing.T) {
+	node := newFakeNode()
+	tr := newTestTracker(node)
+
+	// Unknown TX of a nonce not used yet is still pending
+	tx := newTestTx(t, 2)
+	tr.Track(1, "delegated_execute", tx, "", "0x1234")
+	if rec := status(t, tr, tx); rec.Status != StatusPending {
+		t.Errorf("Expected %s, got %s", StatusPending, rec.Status)
+	}
+
+	// Nonce used by other TX, dropped only if it lasts
+	node.nonce = 3
+	for i := 0; i < 2; i++ {
+		if rec := status(t, tr, tx); rec.Status != StatusPending || rec.NonceUsedAt == 0 {
+			t.Errorf("Expected %s until confirmed, got %+v", StatusPending, rec)
+		}
+	}
+	defer func(d time.Duration) { dropConfirmation = d }(dropConfirmation)
+	dropConfirmation = 0
+	if rec := status(t, tr, tx); rec.Status != StatusDropped || rec.MetaID != "0x1234" {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+
+	// Mined TX missed by a lagging node is not dropped
+	tx = newTestTx(t, 3)
+	tr.Track(3, "delegated_execute", tx, "", "")
+	node.nonce = 4
+	if rec := status(t, tr, tx); rec.Status != StatusPending {
+		t.Errorf("Expected %s, got %s", StatusPending, rec.Status)
+	}
+	node.mine(tx, 10, 21000, 1)
+	if rec := status(t, tr, tx); rec.Status != StatusMined || rec.NonceUsedAt != 0 {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+
+	// Found again before confirmed
+	tx = newTestTx(t, 4)
+	tr.Track(4, "delegated_execute", tx, "", "")
+	node.nonce = 5
+	status(t, tr, tx)
+	node.send(tx)
+	if rec := status(t, tr, tx); rec.Status != StatusPending || rec.NonceUsedAt != 0 {
+		t.Errorf("Expected %s and reset, got %+v", StatusPending, rec)
+	}
+
+	// Unknown for too long
+	tx = newTestTx(t, 5)
+	tr.Track(2, "delegated_execute", tx, "", "")
+	defer func(d time.Duration) { dropTimeout = d }(dropTimeout)
+	dropTimeout = -time.Second
+	if rec := status(t, tr, tx); rec.Status != StatusDropped {
+		t.Errorf("Expected %s, got %s", StatusDropped, rec.Status)
+	}
+
+	if _, err := tr.Get("0x00"); err != store.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTrackerReplaced(t *testing.T) {
+	node := newFakeNode()
+	tr := newTestTracker(node)
+
+	tx := newTestTx(t, 4)
+	tr.Track(1, "create_identity", tx, "", "")
+	// The original TX is gone from the pool, replacement is pending
+	replacements := []*types.Transaction{newTestTx(t, 4), newTestTx(t, 4)}
+	for _, r := range replacements {
+		if err := tr.Replace(tx.Hash().Hex(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node.send(replacements[1])
+	node.nonce = 4
+	rec := status(t, tr, replacements[0])
+	if rec.Hash != tx.Hash().Hex() || rec.Status != StatusPending || len(rec.Replacements) != 2 {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+
+	// Replacement mined, lookups by any hash resolve to it
+	node.mine(replacements[1], 20, 21000, 1)
+	tr.Poll()
+	for _, hash := range []string{tx.Hash().Hex(), replacements[0].Hash().Hex(), replacements[1].Hash().Hex()} {
+		rec, err := tr.Get(hash)
+		if err != nil || rec.Status != StatusMined || rec.MinedHash != replacements[1].Hash().Hex() || rec.BlockNumber != 20 {
+			t.Errorf("Unexpected record of %s: %+v, %v", hash, rec, err)
+		}
+	}
+
+	// Untracked TX such as no-op is ignored
+	if err := tr.Replace("0x00", tx); err != nil {
+		t.Error(err)
+	}
+}
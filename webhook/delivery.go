@@ -0,0 +1,294 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+)
+
+// Headers of a delivery
+const (
+	// EventHeader is the type of notification
+	EventHeader = "X-Delegator-Event"
+	// DeliveryHeader is the ID of the delivery, the same across attempts
+	DeliveryHeader = "X-Delegator-Delivery"
+	// TimestampHeader is the unix time of the attempt, signed with the body
+	TimestampHeader = "X-Delegator-Timestamp"
+	// SignatureHeader is "sha256=" and the hex HMAC of the timestamp, "." and the body
+	SignatureHeader = "X-Delegator-Signature"
+)
+
+// Statuses of a delivery
+const (
+	// StatusPending is a delivery to be attempted
+	StatusPending = "pending"
+	// StatusDead is a delivery of which every attempt failed, until replayed
+	StatusDead = "dead"
+)
+
+var (
+	// Interval to send deliveries due
+	deliverInterval = time.Second
+	// Timeout of an attempt
+	deliveryTimeout = 10 * time.Second
+	// Attempts before a delivery is dead
+	maxAttempts = 8
+	// Wait after the first failure, doubled after each failure up to maxBackoff
+	minBackoff = 30 * time.Second
+	maxBackoff = time.Hour
+)
+
+// Notification is the body of a delivery
+type Notification struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	// Removed is true if a reorg dropped the event notified before
+	Removed   bool        `json:"removed,omitempty"`
+	CreatedAt int64       `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Delivery is a notification to a subscription, kept until sent
+type Delivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Body           json.RawMessage `json:"body"`
+	Attempts       int             `json:"attempts"`
+	// NextAttempt is unix time a pending delivery is attempted after
+	NextAttempt int64  `json:"next_attempt"`
+	LastError   string `json:"last_error,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+func deliveryKey(status, id string) string {
+	return status + "/" + id
+}
+
+// Sign returns the signature of the body sent at the timestamp with the secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the wait after the attempts failed
+func backoff(attempts int) time.Duration {
+	wait := minBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// queue keeps a pending delivery of the event to the subscription
+func (d *Dispatcher) queue(s *Subscription, event string, removed bool, data interface{}) error {
+	now := time.Now()
+	// IDs are ordered by time, so deliveries are attempted in order
+	id := fmt.Sprintf("%016x%s", now.UnixNano(), randomHex(8))
+	body, err := json.Marshal(&Notification{
+		ID:        id,
+		Event:     event,
+		Removed:   removed,
+		CreatedAt: now.Unix(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+	return d.put(&Delivery{
+		ID:             id,
+		SubscriptionID: s.ID,
+		Event:          event,
+		Status:         StatusPending,
+		Body:           body,
+		NextAttempt:    now.Unix(),
+		CreatedAt:      now.Unix(),
+	})
+}
+
+func (d *Dispatcher) put(dl *Delivery) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return d.store.Put(deliveryKey(dl.Status, dl.ID), b)
+}
+
+// Deliveries returns deliveries of the status, StatusPending or StatusDead, oldest first
+func (d *Dispatcher) Deliveries(status string) ([]*Delivery, error) {
+	if status != StatusPending && status != StatusDead {
+		return nil, fmt.Errorf("webhook: unknown status %q", status)
+	}
+	deliveries := []*Delivery{}
+	var errRet error
+	err := d.store.Iterate(status+"/", func(key string, value []byte) bool {
+		dl := new(Delivery)
+		if errRet = json.Unmarshal(value, dl); errRet != nil {
+			return false
+		}
+		deliveries = append(deliveries, dl)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, errRet
+}
+
+// Replay moves the dead delivery back to pending, attempted again from the start.
+// It returns store.ErrNotFound if the delivery is not dead
+func (d *Dispatcher) Replay(id string) (*Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b, err := d.store.Get(deliveryKey(StatusDead, id))
+	if err != nil {
+		return nil, err
+	}
+	dl := new(Delivery)
+	if err := json.Unmarshal(b, dl); err != nil {
+		return nil, err
+	}
+	if _, err := d.subscription(dl.SubscriptionID); err != nil {
+		return nil, fmt.Errorf("webhook: subscription %s of the delivery: %v", dl.SubscriptionID, err)
+	}
+	dl.Status = StatusPending
+	dl.Attempts = 0
+	dl.NextAttempt = time.Now().Unix()
+	if err := d.put(dl); err != nil {
+		return nil, err
+	}
+	return dl, d.store.Delete(deliveryKey(StatusDead, id))
+}
+
+// Start sends deliveries due periodically
+func (d *Dispatcher) Start() {
+	go func() {
+		ticker := time.NewTicker(deliverInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.Deliver()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sending
+func (d *Dispatcher) Stop() {
+	d.once.Do(func() { close(d.stop) })
+}
+
+// Deliver attempts pending deliveries due, and returns after every attempt.
+// Subscriptions are sent to in parallel, deliveries of one subscription in order,
+// a delivery waiting to be attempted again holds back later ones until it is sent or dead
+func (d *Dispatcher) Deliver() {
+	deliveries, err := d.Deliveries(StatusPending)
+	if err != nil {
+		log.Warn("Failed to read webhook deliveries: ", err)
+		return
+	}
+	queued := make(map[string][]*Delivery)
+	for _, dl := range deliveries {
+		queued[dl.SubscriptionID] = append(queued[dl.SubscriptionID], dl)
+	}
+	var wg sync.WaitGroup
+	for _, deliveries := range queued {
+		wg.Add(1)
+		go func(deliveries []*Delivery) {
+			defer wg.Done()
+			for _, dl := range deliveries {
+				if dl.NextAttempt > time.Now().Unix() || !d.attempt(dl) {
+					return
+				}
+			}
+		}(deliveries)
+	}
+	wg.Wait()
+}
+
+// attempt sends the delivery, and keeps it pending or dead if failed.
+// It returns false if the delivery is still pending
+func (d *Dispatcher) attempt(dl *Delivery) bool {
+	s, err := d.subscription(dl.SubscriptionID)
+	if err == store.ErrNotFound {
+		log.Infof("Webhook delivery %s is dropped, subscription %s is removed", dl.ID, dl.SubscriptionID)
+		d.store.Delete(deliveryKey(StatusPending, dl.ID))
+		return true
+	} else if err != nil {
+		log.Warn("Failed to read webhook subscription: ", err)
+		return false
+	}
+	err = d.send(s, dl)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		d.store.Delete(deliveryKey(StatusPending, dl.ID))
+		return true
+	}
+	dl.Attempts++
+	dl.LastError = err.Error()
+	if dl.Attempts < maxAttempts {
+		dl.NextAttempt = time.Now().Add(backoff(dl.Attempts)).Unix()
+		if err := d.put(dl); err != nil {
+			log.Error("Failed to keep webhook delivery: ", err)
+		}
+		return false
+	}
+	log.Warnf("Webhook delivery %s to %s is dead after %d attempts: %v", dl.ID, s.URL, dl.Attempts, err)
+	dl.Status = StatusDead
+	if err := d.put(dl); err != nil {
+		log.Error("Failed to keep dead webhook delivery: ", err)
+		return false
+	}
+	d.store.Delete(deliveryKey(StatusPending, dl.ID))
+	return true
+}
+
+// send posts the body of the delivery, a response other than 2xx is an error
+func (d *Dispatcher) send(s *Subscription, dl *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.Event)
+	req.Header.Set(DeliveryHeader, dl.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(s.Secret, timestamp, dl.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s responded %s", s.URL, resp.Status)
+	}
+	return nil
+}
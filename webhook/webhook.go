@@ -0,0 +1,252 @@
+// Package webhook notifies operators of identity events and TX outcomes by HTTP POST.
+// Deliveries are signed with HMAC-SHA256, retried with backoff and kept as dead letters
+// when every attempt fails
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/metadium/go-delegator/crypto"
+	"github.com/metadium/go-delegator/indexer"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/store"
+	"github.com/metadium/go-delegator/tracker"
+)
+
+// For environment arguments
+const (
+	// WebhookStore is a location of store for subscriptions and deliveries, refer to store.Open
+	WebhookStore = "WEBHOOK_STORE"
+)
+
+// DefaultWebhookStorePath is a LevelDB path for subscriptions and deliveries
+const DefaultWebhookStorePath = "webhooks"
+
+// Notification types besides events of indexed contracts
+const (
+	// TxOutcome is the type of notification of a TX sent by delegator mined, reverted or dropped
+	TxOutcome = "TxOutcome"
+)
+
+// Subscription is an endpoint notified of events
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret signs deliveries, it is shown only when subscribed
+	Secret string `json:"secret,omitempty"`
+	// Events are names of contract events, or TxOutcome
+	Events []string `json:"events"`
+	// EIN limits notifications to the EIN, every EIN if empty
+	EIN       string `json:"ein,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Validate returns an error if the URL or events are not valid
+func (s *Subscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook: invalid URL %q", s.URL)
+	}
+	if len(s.Events) == 0 {
+		return errors.New("webhook: no events")
+	}
+	known := map[string]bool{TxOutcome: true}
+	for _, name := range indexer.EventNames() {
+		known[name] = true
+	}
+	for _, name := range s.Events {
+		if !known[name] {
+			return fmt.Errorf("webhook: unknown event %q", name)
+		}
+	}
+	return nil
+}
+
+// matches returns true if the subscription is notified of the event of the EIN
+func (s *Subscription) matches(event, ein string) bool {
+	if s.EIN != "" && s.EIN != ein {
+		return false
+	}
+	for _, name := range s.Events {
+		if name == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher keeps subscriptions and deliveries in a store, and sends deliveries due
+//
+//	subscription/<id>  subscription
+//	pending/<id>       delivery to be attempted
+//	dead/<id>          delivery of which every attempt failed
+type Dispatcher struct {
+	store  store.Store
+	client *http.Client
+
+	// mu keeps a delivery from moving between pending and dead concurrently
+	mu   sync.Mutex
+	stop chan struct{}
+	once sync.Once
+}
+
+var (
+	// For singleton
+	instance *Dispatcher
+	once     sync.Once
+)
+
+func init() {
+	indexer.OnEvent(func(ev *indexer.Event, removed bool) {
+		notify(ev.Event, ev.EIN, removed, ev)
+	})
+	tracker.OnFinal(func(rec *tracker.Record) {
+		notify(TxOutcome, rec.EIN, false, rec)
+	})
+}
+
+func notify(event, ein string, removed bool, data interface{}) {
+	d := GetInstance()
+	if d == nil {
+		return
+	}
+	if err := d.Notify(event, ein, removed, data); err != nil {
+		log.Errorf("Failed to queue webhook of %s: %v", event, err)
+	}
+}
+
+// GetInstance returns the dispatcher with the store given by WEBHOOK_STORE, LevelDB by default.
+// It returns nil in AWS lambda, or if the store is not available
+func GetInstance() *Dispatcher {
+	once.Do(func() {
+		if os.Getenv(crypto.IsAwsLambda) != "" {
+			log.Info("Webhook is not available in AWS lambda")
+			return
+		}
+		location := os.Getenv(WebhookStore)
+		if location == "" {
+			location = DefaultWebhookStorePath
+		}
+		s, err := store.Open(location)
+		if err != nil {
+			log.Error("Failed to open webhook store, webhooks are not sent: ", err)
+			return
+		}
+		log.Info("Webhook store is set to ", location)
+		instance = NewDispatcher(s, &http.Client{Timeout: deliveryTimeout})
+		instance.Start()
+	})
+	return instance
+}
+
+// NewDispatcher returns a dispatcher keeping subscriptions in the store
+func NewDispatcher(s store.Store, client *http.Client) *Dispatcher {
+	return &Dispatcher{
+		store:  s,
+		client: client,
+		stop:   make(chan struct{}),
+	}
+}
+
+func subscriptionKey(id string) string {
+	return "subscription/" + id
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Subscribe keeps the subscription with a new ID, and a secret if not given
+func (d *Dispatcher) Subscribe(sub *Subscription) (*Subscription, error) {
+	if err := sub.Validate(); err != nil {
+		return nil, err
+	}
+	s := *sub
+	s.ID = randomHex(16)
+	if s.Secret == "" {
+		s.Secret = randomHex(32)
+	}
+	s.CreatedAt = time.Now().Unix()
+	b, err := json.Marshal(&s)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.store.Put(subscriptionKey(s.ID), b); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Unsubscribe removes the subscription, its deliveries are dropped at the next attempt
+func (d *Dispatcher) Unsubscribe(id string) error {
+	if _, err := d.subscription(id); err != nil {
+		return err
+	}
+	return d.store.Delete(subscriptionKey(id))
+}
+
+// Subscriptions returns every subscription without secrets
+func (d *Dispatcher) Subscriptions() ([]*Subscription, error) {
+	subs, err := d.subscriptions()
+	for _, s := range subs {
+		s.Secret = ""
+	}
+	return subs, err
+}
+
+func (d *Dispatcher) subscriptions() ([]*Subscription, error) {
+	subs := []*Subscription{}
+	var errRet error
+	err := d.store.Iterate("subscription/", func(key string, value []byte) bool {
+		s := new(Subscription)
+		if errRet = json.Unmarshal(value, s); errRet != nil {
+			return false
+		}
+		subs = append(subs, s)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subs, errRet
+}
+
+// subscription returns the subscription of the ID, or store.ErrNotFound
+func (d *Dispatcher) subscription(id string) (*Subscription, error) {
+	b, err := d.store.Get(subscriptionKey(id))
+	if err != nil {
+		return nil, err
+	}
+	s := new(Subscription)
+	return s, json.Unmarshal(b, s)
+}
+
+// Notify queues deliveries of the event to subscriptions of it.
+// removed is true if a reorg dropped the event notified before
+func (d *Dispatcher) Notify(event, ein string, removed bool, data interface{}) error {
+	subs, err := d.subscriptions()
+	if err != nil {
+		return err
+	}
+	for _, s := range subs {
+		if !s.matches(event, ein) {
+			continue
+		}
+		if err := d.queue(s, event, removed, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metadium/go-delegator/store"
+)
+
+// receiver records notifications, failing while fail is set
+type receiver struct {
+	mu            sync.Mutex
+	secret        string
+	fail          bool
+	notifications []*Notification
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	timestamp, _ := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+	if req.Header.Get(SignatureHeader) != Sign(r.secret, timestamp, body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	n := new(Notification)
+	json.Unmarshal(body, n)
+	if req.Header.Get(EventHeader) != n.Event || req.Header.Get(DeliveryHeader) != n.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.notifications = append(r.notifications, n)
+}
+
+func count(t *testing.T, d *Dispatcher, status string) int {
+	deliveries, err := d.Deliveries(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(deliveries)
+}
+
+func TestDispatcher(t *testing.T) {
+	defer func(wait time.Duration) { minBackoff = wait }(minBackoff)
+	minBackoff = 0
+
+	r := &receiver{secret: "secret"}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	d := NewDispatcher(store.NewMemoryStore(), server.Client())
+	if _, err := d.Subscribe(&Subscription{URL: server.URL, Events: []string{"Unknown"}}); err == nil {
+		t.Error("Unknown event must not be subscribed")
+	}
+	if err := (&Subscription{URL: server.URL, Events: []string{"ClaimAdded"}}).Validate(); err != nil {
+		t.Error("Claims of MetaIDs must be subscribed: ", err)
+	}
+	if _, err := d.Subscribe(&Subscription{URL: "ftp://example.com", Events: []string{TxOutcome}}); err == nil {
+		t.Error("URL not HTTP must not be subscribed")
+	}
+	sub, err := d.Subscribe(&Subscription{URL: server.URL, Secret: "secret", Events: []string{"IdentityCreated", TxOutcome}, EIN: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subs, _ := d.Subscriptions(); len(subs) != 1 || subs[0].ID != sub.ID || subs[0].Secret != "" {
+		t.Errorf("Unexpected subscriptions %+v", subs)
+	}
+
+	d.Notify("IdentityCreated", "2", false, map[string]string{"ein": "2"})
+	d.Notify("KeyAdded", "1", false, map[string]string{"ein": "1"})
+	if n := count(t, d, StatusPending); n != 0 {
+		t.Errorf("Notifications not subscribed must not be queued, got %d", n)
+	}
+	d.Notify("IdentityCreated", "1", false, map[string]string{"ein": "1"})
+	d.Notify(TxOutcome, "1", false, map[string]string{"status": "mined"})
+	d.Deliver()
+	if len(r.notifications) != 2 || r.notifications[0].Event != "IdentityCreated" || r.notifications[1].Event != TxOutcome {
+		t.Errorf("Unexpected notifications %+v", r.notifications)
+	}
+	if n := count(t, d, StatusPending); n != 0 {
+		t.Errorf("Deliveries sent must be removed, got %d", n)
+	}
+
+	// Failed deliveries are retried, then kept dead
+	r.fail = true
+	d.Notify("IdentityCreated", "1", true, map[string]string{"ein": "1"})
+	for i := 0; i < maxAttempts-1; i++ {
+		d.Deliver()
+	}
+	if deliveries, _ := d.Deliveries(StatusPending); len(deliveries) != 1 || deliveries[0].Attempts != maxAttempts-1 || deliveries[0].LastError == "" {
+		t.Errorf("Unexpected pending deliveries %+v", deliveries)
+	}
+	d.Deliver()
+	dead, _ := d.Deliveries(StatusDead)
+	if len(dead) != 1 || count(t, d, StatusPending) != 0 {
+		t.Fatalf("Expected a dead delivery, got %+v", dead)
+	}
+
+	r.fail = false
+	if _, err := d.Replay(dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Replay(dead[0].ID); err != store.ErrNotFound {
+		t.Errorf("Expected not found for replayed delivery, got %v", err)
+	}
+	d.Deliver()
+	if len(r.notifications) != 3 || !r.notifications[2].Removed || r.notifications[2].ID != dead[0].ID {
+		t.Errorf("Unexpected notifications after replay %+v", r.notifications)
+	}
+	if count(t, d, StatusDead) != 0 || count(t, d, StatusPending) != 0 {
+		t.Error("Replayed delivery must be removed after sent")
+	}
+
+	// A failed delivery holds back later ones of the subscription
+	r.fail = true
+	d.Notify("IdentityCreated", "1", false, map[string]string{"ein": "1"})
+	d.Notify(TxOutcome, "1", false, map[string]string{"status": "mined"})
+	d.Deliver()
+	if deliveries, _ := d.Deliveries(StatusPending); len(deliveries) != 2 || deliveries[0].Attempts != 1 || deliveries[1].Attempts != 0 {
+		t.Errorf("Expected only the first delivery attempted, got %+v", deliveries)
+	}
+	r.fail = false
+	d.Deliver()
+	if len(r.notifications) != 5 || r.notifications[3].Event != "IdentityCreated" || r.notifications[4].Event != TxOutcome {
+		t.Errorf("Expected deliveries in order, got %+v", r.notifications)
+	}
+
+	// Deliveries of a subscription removed are dropped
+	d.Notify(TxOutcome, "1", false, nil)
+	if err := d.Unsubscribe(sub.ID); err != nil {
+		t.Fatal(err)
+	}
+	d.Deliver()
+	if len(r.notifications) != 5 || count(t, d, StatusPending) != 0 {
+		t.Error("Delivery of subscription removed must be dropped")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		20: time.Hour,
+	} {
+		if wait := backoff(attempts); wait != expected {
+			t.Errorf("Expected %v after %d attempts, got %v", expected, attempts, wait)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/metadium/go-delegator/json"
+	"github.com/metadium/go-delegator/log"
+	"github.com/metadium/go-delegator/rpc"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// wsMaxHandling is the number of messages of a connection handled at once,
+	// reading stops until one is done
+	wsMaxHandling = 16
+	// wsQueueSize is the number of messages queued to a connection,
+	// a client not reading notifications is disconnected when it is full
+	wsQueueSize = 256
+)
+
+// wsSession is a WebSocket client connection
+type wsSession struct {
+	conn *websocket.Conn
+	// ctx is cancelled when the connection is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	// out queues responses and notifications to be sent in order
+	out    chan string
+	subMu  sync.Mutex
+	subs   map[string]bool
+	closed bool
+	// apiKey is given by the header of the handshake
+	apiKey string
+}
+
+// wsServer accepts WebSocket connection from any origin like HTTP endpoint
+func wsServer() http.Handler {
+	return websocket.Server{
+		Handler: wsHandler,
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			return nil
+		},
+	}
+}
+
+// wsHandler handles JSON-RPC messages from a WebSocket connection
+// It serves same methods as handler, and eth_subscribe/eth_unsubscribe
+func wsHandler(conn *websocket.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &wsSession{
+		conn:   conn,
+		ctx:    ctx,
+		cancel: cancel,
+		out:    make(chan string, wsQueueSize),
+		subs:   make(map[string]bool),
+		apiKey: conn.Request().Header.Get(APIKeyHeader),
+	}
+	defer s.close()
+	go s.sendLoop()
+
+	log.Info("ws connected:", conn.Request().RemoteAddr)
+	handling := make(chan struct{}, wsMaxHandling)
+	for {
+		var msg string
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			if err != io.EOF {
+				log.Error("ws receive:", err)
+			}
+			return
+		}
+		// Delegated writes can take long, so don't block following messages
+		handling <- struct{}{}
+		go func() {
+			defer func() { <-handling }()
+			s.handle(msg)
+		}()
+	}
+}
+
+func (s *wsSession) handle(msg string) {
+	if json.IsBatch(msg) {
+		body, _ := batchHandler(s.ctx, msg, s.apiKey)
+		s.write(body)
+		return
+	}
+	req, rpcErr := json.GetRPCRequestFromJSON(msg)
+	if rpcErr != nil {
+		body, _ := invalidResponse(req.ID, rpcErr)
+		s.write(body)
+		return
+	}
+	req.APIKey = s.apiKey
+
+	switch req.Method {
+	case "eth_subscribe":
+		s.subscribe(req)
+	case "eth_unsubscribe":
+		s.unsubscribe(req)
+	default:
+		body, _ := handler(s.ctx, req)
+		s.write(body)
+	}
+}
+
+func (s *wsSession) subscribe(req json.RPCRequest) {
+	resp := json.RPCResponse{
+		Jsonrpc: json.Version,
+		ID:      req.ID,
+	}
+	// Notifications are held until the response is queued,
+	// so that no notification goes ahead of the subscription ID
+	var heldMu sync.Mutex
+	var held []string
+	queued := false
+	notify := func(subID string, result stdjson.RawMessage) {
+		heldMu.Lock()
+		defer heldMu.Unlock()
+		if !queued {
+			held = append(held, notification(subID, result))
+			return
+		}
+		s.notify(subID, result)
+	}
+	defer func() {
+		heldMu.Lock()
+		defer heldMu.Unlock()
+		queued = true
+		for _, body := range held {
+			s.push(body)
+		}
+	}()
+
+	if len(req.Params) == 0 {
+		resp.Error = json.NewRPCError(json.ErrCodeInvalidParams, "Subscription name is missing")
+	} else if subID, err := rpc.GetSubscriptionManager().Subscribe(req.Params, notify); err != nil {
+		log.Error("eth_subscribe:", err)
+		resp.Error = json.NewRPCError(json.ErrCodeServer, err.Error())
+	} else {
+		s.subMu.Lock()
+		if s.closed {
+			rpc.GetSubscriptionManager().Unsubscribe(subID)
+		} else {
+			s.subs[subID] = true
+		}
+		s.subMu.Unlock()
+		resp.Result = subID
+	}
+	if !req.IsNotification() {
+		s.write(resp.String())
+	}
+}
+
+func (s *wsSession) unsubscribe(req json.RPCRequest) {
+	resp := json.RPCResponse{
+		Jsonrpc: json.Version,
+		ID:      req.ID,
+	}
+	var subID string
+	if len(req.Params) == 1 {
+		subID, _ = req.Params[0].(string)
+	}
+
+	// Only subscriptions of this connection can be removed
+	s.subMu.Lock()
+	owned := s.subs[subID]
+	delete(s.subs, subID)
+	s.subMu.Unlock()
+	if subID == "" {
+		resp.Error = json.NewRPCError(json.ErrCodeInvalidParams, "Subscription ID is missing")
+	} else {
+		resp.Result = owned && rpc.GetSubscriptionManager().Unsubscribe(subID)
+	}
+	if !req.IsNotification() {
+		s.write(resp.String())
+	}
+}
+
+func notification(subID string, result stdjson.RawMessage) string {
+	n := json.RPCNotification{
+		Jsonrpc: json.Version,
+		Method:  "eth_subscription",
+		Params: json.SubscriptionResult{
+			Subscription: subID,
+			Result:       result,
+		},
+	}
+	return n.String()
+}
+
+// notify queues a notification without blocking the upstream shared with other clients
+func (s *wsSession) notify(subID string, result stdjson.RawMessage) {
+	s.push(notification(subID, result))
+}
+
+// push queues the body, and disconnects the client if the queue is full
+func (s *wsSession) push(body string) {
+	select {
+	case s.out <- body:
+	case <-s.ctx.Done():
+	default:
+		log.Error("ws queue is full, disconnecting:", s.conn.Request().RemoteAddr)
+		s.conn.Close()
+	}
+}
+
+// write queues the response, waiting for room in the queue
+func (s *wsSession) write(body string) {
+	if body == "" {
+		return
+	}
+	select {
+	case s.out <- body:
+	case <-s.ctx.Done():
+	}
+}
+
+// sendLoop sends queued messages in order until the connection is closed
+func (s *wsSession) sendLoop() {
+	for {
+		select {
+		case body := <-s.out:
+			if err := websocket.Message.Send(s.conn, body); err != nil {
+				log.Error("ws send:", err)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// close removes all subscriptions of the connection
+func (s *wsSession) close() {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for subID := range s.subs {
+		rpc.GetSubscriptionManager().Unsubscribe(subID)
+	}
+	s.subs = make(map[string]bool)
+	s.closed = true
+	s.cancel()
+	s.conn.Close()
+	log.Info("ws disconnected:", s.conn.Request().RemoteAddr)
+}